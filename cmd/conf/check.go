@@ -20,6 +20,7 @@ import (
 	"fmt"
 
 	config "github.com/MocaccinoOS/mos-cli/pkg/configfile"
+	"github.com/MocaccinoOS/mos-cli/pkg/utils"
 	"github.com/spf13/cobra"
 )
 
@@ -30,6 +31,7 @@ func NewCheckCommand() *cobra.Command {
 		Run: func(cmd *cobra.Command, args []string) {
 
 			path, _ := cmd.Flags().GetString("path")
+			path = utils.RootPath(path)
 			res := config.Scan(path)
 
 			if len(res.Files()) == 0 {
@@ -38,6 +38,7 @@ Cleans up all the unmerged config diff files in /etc.
 `,
 		Run: func(cmd *cobra.Command, args []string) {
 			path, _ := cmd.Flags().GetString("path")
+			path = utils.RootPath(path)
 			interactive, _ := cmd.Flags().GetBool("interactive")
 			res := config.Scan(path)
 
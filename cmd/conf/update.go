@@ -101,6 +101,7 @@ $ mos update --all
 `,
 		Run: func(cmd *cobra.Command, args []string) {
 			path, _ := cmd.Flags().GetString("path")
+			path = utils.RootPath(path)
 			interactive, _ := cmd.Flags().GetBool("interactive")
 			all, _ := cmd.Flags().GetBool("all")
 
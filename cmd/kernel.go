@@ -32,5 +32,17 @@ func init() {
 		cmdkernel.NewListcommand(),
 		cmdkernel.NewGeninitrdCommand(),
 		cmdkernel.NewProfilesCommand(),
+		cmdkernel.NewInitrdCommand(),
+		cmdkernel.NewVerifyCommand(),
+		cmdkernel.NewPruneCommand(),
+		cmdkernel.NewPinCommand(),
+		cmdkernel.NewUnpinCommand(),
+		cmdkernel.NewSetDefaultCommand(),
+		cmdkernel.NewRollbackCommand(),
+		cmdkernel.NewBootOnceCommand(),
+		cmdkernel.NewUpdateBootloaderCommand(),
+		cmdkernel.NewUkiCommand(),
+		cmdkernel.NewSignCommand(),
+		cmdkernel.NewCmdlineCommand(),
 	)
 }
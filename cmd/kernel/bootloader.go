@@ -0,0 +1,224 @@
+// Copyright © 2021 Daniele Rondina <geaaru@sabayonlinux.org>
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package cmdkernel
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/MocaccinoOS/mos-cli/pkg/kernel"
+	kernelspecs "github.com/MocaccinoOS/mos-cli/pkg/kernel/specs"
+	"github.com/MocaccinoOS/mos-cli/pkg/profile"
+	"github.com/MocaccinoOS/mos-cli/pkg/utils"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+func addBootloaderFlags(flags *pflag.FlagSet) {
+	flags.String("bootloader", "",
+		`Bootloader to update: grub, systemd-boot or extlinux.
+Default is the bootloader option of the config file or grub
+(extlinux on micro-embedded).`)
+	flags.String("esp", "",
+		`Mount point of the EFI System Partition used by systemd-boot.
+Default is the esp_dir option of the config file or /boot/efi.`)
+	flags.Int("bootloader-timeout", -1,
+		"Menu timeout in seconds. A negative value doesn't change the timeout.")
+	flags.String("append", "",
+		`Kernel parameters of the extlinux entries.
+Default is the extlinux_append option of the config file or /etc/kernel/cmdline.`)
+	flags.String("fdt-dir", "",
+		`Devicetree directory of the extlinux entries. Default is the extlinux_fdt_dir
+option of the config file or the dtbs directory of the boot dir.`)
+	flags.String("fdt", "", "Devicetree file of the extlinux entries used instead of the directory.")
+	flags.Bool("grub-native", false,
+		`Generate the GRUB menu entries on <bootdir>/grub/mos.cfg without grub-mkconfig.
+Default is the grub_native option of the config file.`)
+	flags.String("root-uuid", "",
+		`Filesystem UUID of the root partition of the GRUB entries.
+Default is the grub_root_uuid option of the config file or the UUID of /.`)
+}
+
+// getFlagOrConfig returns the value of the flag or of the config
+// file option.
+func getFlagOrConfig(cmd *cobra.Command, flag, option string) string {
+	ans, _ := cmd.Flags().GetString(flag)
+	if ans == "" {
+		ans = viper.GetString(option)
+	}
+	return ans
+}
+
+// getPathFlag returns the path of the flag on the system root.
+func getPathFlag(cmd *cobra.Command, flag string) (string, error) {
+	ans, err := cmd.Flags().GetString(flag)
+	return utils.RootPath(ans), err
+}
+
+// getPathFlagOrConfig returns the path of the flag or of the config
+// file option on the system root.
+func getPathFlagOrConfig(cmd *cobra.Command, flag, option string) string {
+	return utils.RootPath(getFlagOrConfig(cmd, flag, option))
+}
+
+// getBootloader returns the bootloader of the flag or of the
+// config file. The micro-embedded release uses extlinux by default.
+func getBootloader(cmd *cobra.Command) string {
+	bootloader := getFlagOrConfig(cmd, "bootloader", "bootloader")
+	if bootloader == "" {
+		bootloader = kernel.BootloaderGrub
+		if release, _ := utils.OsRelease(); release == "micro-embedded" {
+			bootloader = kernel.BootloaderExtlinux
+		}
+	}
+	return bootloader
+}
+
+// updateBootloader updates the configuration of the selected
+// bootloader with the kernels of the boot dir. The boot dir is read
+// again to see the files generated or removed by the command.
+func updateBootloader(cmd *cobra.Command, bootDir string, types []kernelspecs.KernelType,
+	bootloader string, dryRun bool) error {
+
+	bootFiles, err := kernel.ReadBootDir(bootDir, types)
+	if err != nil {
+		return err
+	}
+
+	timeout, _ := cmd.Flags().GetInt("bootloader-timeout")
+	if timeout < 0 && viper.IsSet("bootloader_timeout") {
+		timeout = viper.GetInt("bootloader_timeout")
+	}
+
+	switch bootloader {
+	case kernel.BootloaderGrub:
+		native, _ := cmd.Flags().GetBool("grub-native")
+		if !native && !viper.GetBool("grub_native") {
+			return kernel.GrubMkconfig(filepath.Join(bootFiles.Dir, "/grub/grub.cfg"), dryRun)
+		}
+
+		opts := kernel.NewGrubCfgOptions(bootFiles.Dir)
+		if uuid := getFlagOrConfig(cmd, "root-uuid", "grub_root_uuid"); uuid != "" {
+			opts.RootUuid = uuid
+		}
+		if timeout >= 0 {
+			opts.Timeout = timeout
+		}
+		opts.DryRun = dryRun
+
+		return kernel.UpdateGrubCfg(bootFiles, opts)
+
+	case kernel.BootloaderSystemdBoot:
+		opts := kernel.NewSystemdBootOptions(getPathFlagOrConfig(cmd, "esp", "esp_dir"))
+		opts.Timeout = timeout
+		opts.DryRun = dryRun
+
+		return kernel.UpdateSystemdBoot(bootFiles, opts)
+
+	case kernel.BootloaderExtlinux:
+		opts := kernel.NewExtlinuxOptions()
+		opts.File = utils.RootPath(viper.GetString("extlinux_file"))
+		opts.PathPrefix = viper.GetString("extlinux_path_prefix")
+		opts.FdtDir = getFlagOrConfig(cmd, "fdt-dir", "extlinux_fdt_dir")
+		opts.Fdt = getFlagOrConfig(cmd, "fdt", "extlinux_fdt")
+		if params := getFlagOrConfig(cmd, "append", "extlinux_append"); params != "" {
+			opts.Append = params
+		}
+		if timeout >= 0 {
+			opts.Timeout = timeout
+		}
+		opts.DryRun = dryRun
+
+		return kernel.UpdateExtlinuxConf(bootFiles, opts)
+
+	default:
+		return errors.New(fmt.Sprintf("Unsupported bootloader %s", bootloader))
+	}
+}
+
+func NewUpdateBootloaderCommand() *cobra.Command {
+	c := &cobra.Command{
+		Use:     "update-bootloader",
+		Aliases: []string{"ub"},
+		Short:   "Update the bootloader configuration.",
+		Long: `Update the bootloader configuration with the kernels of the boot dir.
+
+With grub the grub.cfg is generated with grub-mkconfig. With --grub-native
+the menu entries are generated without grub-mkconfig on <bootdir>/grub/mos.cfg.
+If grub.cfg doesn't exist, a grub.cfg that sources mos.cfg is generated too.
+The root partition is the UUID of / or the UUID of --root-uuid.
+
+With systemd-boot a Boot Loader Specification entry mos-<kernel file>.conf
+is written under <esp>/loader/entries/ for every kernel. The kernel and initrd images are
+copied on the ESP if the boot dir is not the ESP. The entries of the
+kernels no more available are removed and loader.conf is updated with
+the default entry of the bzImage link.
+
+With extlinux the extlinux.conf file is generated under <bootdir>/extlinux/
+with a label for every kernel. The default label is the kernel of the
+bzImage link. The devicetree directory is searched on the boot dir
+if it's not configured. With --dry-run the differences with the
+existing file are printed.
+
+The bootloader could be configured in the config file:
+
+  bootloader: systemd-boot
+  esp_dir: /efi
+  bootloader_timeout: 3
+
+$> mos kernel update-bootloader --bootloader systemd-boot --esp /efi
+
+$> mos kernel update-bootloader --dry-run
+
+$> mos kernel update-bootloader --grub-native --root-uuid 4f1c3a5e-8d2b-4e7a-9c61-0b5d2e8f7a13
+
+$> mos kernel update-bootloader --bootloader extlinux --append "root=/dev/mmcblk0p2 rw"
+
+`,
+		Run: func(cmd *cobra.Command, args []string) {
+
+			bootDir, _ := getPathFlag(cmd, "bootdir")
+			dryRun, _ := cmd.Flags().GetBool("dry-run")
+			kernelProfilesDir, _ := getPathFlag(cmd, "kernel-profiles-dir")
+
+			types := []kernelspecs.KernelType{}
+			if kernelProfilesDir != "" {
+				types, _ = profile.LoadKernelProfiles(kernelProfilesDir)
+			}
+			if len(types) == 0 {
+				types = profile.GetDefaultKernelProfiles()
+			}
+
+			err := updateBootloader(cmd, bootDir, types, getBootloader(cmd), dryRun)
+			if err != nil {
+				fmt.Println(fmt.Sprintf("Error on update bootloader: %s", err.Error()))
+				os.Exit(1)
+			}
+		},
+	}
+
+	flags := c.Flags()
+	flags.Bool("dry-run", false, "Show the changes without write them.")
+	flags.String("bootdir", "/boot", "Directory where analyze kernel files.")
+	flags.String("kernel-profiles-dir", "/etc/mocaccino/kernels-profiles/",
+		"Specify the directory where read the kernel types profiles supported.")
+	addBootloaderFlags(flags)
+
+	return c
+}
@@ -0,0 +1,171 @@
+// Copyright © 2021 Daniele Rondina <geaaru@sabayonlinux.org>
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package cmdkernel
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/MocaccinoOS/mos-cli/pkg/grub"
+	"github.com/MocaccinoOS/mos-cli/pkg/kernel"
+	kernelspecs "github.com/MocaccinoOS/mos-cli/pkg/kernel/specs"
+	"github.com/MocaccinoOS/mos-cli/pkg/profile"
+
+	"github.com/spf13/cobra"
+)
+
+func NewBootOnceCommand() *cobra.Command {
+	c := &cobra.Command{
+		Use:     "boot-once [version]",
+		Aliases: []string{"bo"},
+		Short:   "Boot a kernel only on the next boot.",
+		Long: `Set the GRUB next_entry variable with the menu entry of the
+selected kernel. GRUB boots the kernel once and then it returns
+to the default entry.
+
+Without arguments shows the saved_entry and next_entry variables.
+
+$> mos kernel boot-once 5.15.0 --ktype vanilla
+
+$> # Show the current status
+$> mos kernel boot-once
+
+$> # Remove the next_entry variable
+$> mos kernel boot-once --clear
+
+`,
+		Args: cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+
+			jsonOutput, _ := cmd.Flags().GetBool("json")
+			clear, _ := cmd.Flags().GetBool("clear")
+			bootDir, _ := getPathFlag(cmd, "bootdir")
+			ktype, _ := cmd.Flags().GetString("ktype")
+			kernelProfilesDir, _ := getPathFlag(cmd, "kernel-profiles-dir")
+			grubEnvFile, _ := getPathFlag(cmd, "grubenv")
+			grubCfgFile, _ := getPathFlag(cmd, "grub-cfg")
+
+			if grubEnvFile == "" {
+				grubEnvFile = filepath.Join(bootDir, "grub", "grubenv")
+			}
+			if grubCfgFile == "" {
+				grubCfgFile = filepath.Join(bootDir, "grub", "grub.cfg")
+			}
+
+			env, err := grub.ReadGrubEnv(grubEnvFile)
+			if err != nil {
+				fmt.Println("Error on read GRUB environment: " + err.Error())
+				os.Exit(1)
+			}
+
+			if clear {
+				env.Unset(grub.GrubEnvNextEntry)
+				err = env.Write()
+				if err != nil {
+					fmt.Println("Error on write GRUB environment: " + err.Error())
+					os.Exit(1)
+				}
+				fmt.Println("Next boot entry removed.")
+				return
+			}
+
+			if len(args) == 0 {
+				status := map[string]string{
+					grub.GrubEnvSavedEntry: env.Get(grub.GrubEnvSavedEntry),
+					grub.GrubEnvNextEntry:  env.Get(grub.GrubEnvNextEntry),
+				}
+
+				if jsonOutput {
+					data, err := json.Marshal(status)
+					if err != nil {
+						fmt.Println(fmt.Errorf("Error on convert data to json: %s", err.Error()))
+						os.Exit(1)
+					}
+					fmt.Println(string(data))
+				} else {
+					fmt.Println(fmt.Sprintf("%s: %s", grub.GrubEnvSavedEntry,
+						status[grub.GrubEnvSavedEntry]))
+					fmt.Println(fmt.Sprintf("%s: %s", grub.GrubEnvNextEntry,
+						status[grub.GrubEnvNextEntry]))
+				}
+				return
+			}
+
+			types := []kernelspecs.KernelType{}
+			if kernelProfilesDir != "" {
+				types, _ = profile.LoadKernelProfiles(kernelProfilesDir)
+			}
+			if len(types) == 0 {
+				types = profile.GetDefaultKernelProfiles()
+			}
+
+			bootFiles, err := kernel.ReadBootDir(bootDir, types)
+			if err != nil {
+				fmt.Println("Error on read boot directory: " + err.Error())
+				os.Exit(1)
+			}
+
+			kf, err := bootFiles.GetFile(args[0], ktype)
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+
+			entries, err := grub.ReadMenuEntries(grubCfgFile)
+			if err != nil {
+				fmt.Println("Error on read GRUB menu entries: " + err.Error())
+				os.Exit(1)
+			}
+
+			// Menu entries of the native generator sourced by grub.cfg.
+			mosCfgFile := filepath.Join(filepath.Dir(grubCfgFile), kernel.GrubMosCfgFile)
+			if mosEntries, err := grub.ReadMenuEntries(mosCfgFile); err == nil {
+				entries = append(entries, mosEntries...)
+			}
+
+			entry := grub.FindMenuEntryForKernel(entries, kf.Kernel.GetFilename())
+			if entry == nil {
+				fmt.Println(fmt.Sprintf(
+					"No menu entry found for kernel %s. Update grub.cfg with mos kernel geninitrd --grub.",
+					kf.Kernel.GetFilename()))
+				os.Exit(1)
+			}
+
+			env.Set(grub.GrubEnvNextEntry, entry.GetReference())
+			err = env.Write()
+			if err != nil {
+				fmt.Println("Error on write GRUB environment: " + err.Error())
+				os.Exit(1)
+			}
+
+			fmt.Println(fmt.Sprintf("Kernel %s will be booted on next boot (%s).",
+				kf.Kernel.GetFilename(), entry.Title))
+		},
+	}
+
+	flags := c.Flags()
+	flags.Bool("json", false, "JSON output")
+	flags.Bool("clear", false, "Remove the next boot entry.")
+	flags.String("bootdir", "/boot", "Directory where analyze kernel files.")
+	flags.String("ktype", "", "Specify the kernel type of the kernel.")
+	flags.String("kernel-profiles-dir", "/etc/mocaccino/kernels-profiles/",
+		"Specify the directory where read the kernel types profiles supported.")
+	flags.String("grubenv", "", "Path of the GRUB environment block. Default is <bootdir>/grub/grubenv.")
+	flags.String("grub-cfg", "", "Path of grub.cfg. Default is <bootdir>/grub/grub.cfg.")
+
+	return c
+}
@@ -0,0 +1,273 @@
+// Copyright © 2021 Daniele Rondina <geaaru@sabayonlinux.org>
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package cmdkernel
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/MocaccinoOS/mos-cli/pkg/initrd"
+	kernelspecs "github.com/MocaccinoOS/mos-cli/pkg/kernel/specs"
+	. "github.com/MocaccinoOS/mos-cli/pkg/logger"
+	"github.com/MocaccinoOS/mos-cli/pkg/utils"
+
+	tablewriter "github.com/olekukonko/tablewriter"
+)
+
+// initrdBuilders resolves the initrd builder to use for a kernel.
+// The builder is selected by the --builder flag or by the kernel
+// profile and the instances are shared between kernels.
+type initrdBuilders struct {
+	Forced     string
+	Opts       string
+	DracutOpts string
+	DryRun     bool
+
+	// Cache contains the fingerprints of the generated images.
+	// If it's nil the images are always generated.
+	Cache      *initrd.BuildCache
+	Force      bool
+	ModulesDir string
+
+	builders map[string]initrd.Builder
+}
+
+func newInitrdBuilders(forced, opts, dracutOpts string, dryRun bool) *initrdBuilders {
+	return &initrdBuilders{
+		Forced:     forced,
+		Opts:       opts,
+		DracutOpts: dracutOpts,
+		DryRun:     dryRun,
+		builders:   make(map[string]initrd.Builder),
+	}
+}
+
+func (ib *initrdBuilders) Get(kf *kernelspecs.KernelFiles) (initrd.Builder, error) {
+	name := ib.Forced
+	if name == "" && kf != nil && kf.Type != nil {
+		name = kf.Type.GetInitrdBuilder()
+	}
+	if name == "" {
+		name = initrd.DefaultBuilderName
+	}
+
+	if b, ok := ib.builders[name]; ok {
+		return b, nil
+	}
+
+	opts := ib.Opts
+	if name == initrd.DracutBuilderName && ib.DracutOpts != "" {
+		opts = ib.DracutOpts
+	}
+
+	b, err := initrd.NewBuilder(name, opts, ib.DryRun)
+	if err != nil {
+		return nil, err
+	}
+	ib.builders[name] = b
+
+	return b, nil
+}
+
+// checkCache returns true if the initrd image of the kernel is up to
+// date and the fingerprint of the current inputs.
+func (ib *initrdBuilders) checkCache(b initrd.Builder, kf *kernelspecs.KernelFiles,
+	bootDir string) (bool, *initrd.Fingerprint) {
+
+	if ib.Cache == nil {
+		return false, nil
+	}
+
+	fp, err := initrd.NewFingerprint(b, kf, bootDir, ib.ModulesDir)
+	if err != nil {
+		DebugC("Error on compute fingerprint of kernel",
+			kf.Kernel.GetFilename(), ":", err.Error())
+		return false, nil
+	}
+
+	if ib.Force {
+		return false, fp
+	}
+
+	return ib.Cache.IsUpToDate(initrd.GetInitrdFile(kf, bootDir), fp), fp
+}
+
+func (ib *initrdBuilders) updateCache(kf *kernelspecs.KernelFiles, bootDir string,
+	fp *initrd.Fingerprint) {
+	if ib.Cache == nil || ib.DryRun {
+		return
+	}
+
+	initrdFile := initrd.GetInitrdFile(kf, bootDir)
+	if fp == nil {
+		ib.Cache.Remove(initrdFile)
+	} else {
+		ib.Cache.Update(initrdFile, fp)
+	}
+}
+
+// WriteCache stores the fingerprints of the generated images.
+func (ib *initrdBuilders) WriteCache() error {
+	if ib.Cache == nil || ib.DryRun {
+		return nil
+	}
+	return ib.Cache.Write()
+}
+
+func (ib *initrdBuilders) Build(kf *kernelspecs.KernelFiles, bootDir string) error {
+	b, err := ib.Get(kf)
+	if err != nil {
+		return err
+	}
+
+	upToDate, fp := ib.checkCache(b, kf, bootDir)
+	if upToDate {
+		fmt.Println(fmt.Sprintf(
+			"Initrd image of kernel %s is up to date. Use --force to rebuild it.",
+			kf.Kernel.GetFilename()))
+		return nil
+	}
+
+	err = b.Build(kf, bootDir)
+	if err != nil {
+		return err
+	}
+	ib.updateCache(kf, bootDir, fp)
+
+	return nil
+}
+
+// buildInitrdsParallel generates the initrd images of all kernels
+// with a pool of workers and prints a summary of the builds.
+// The kernels without a valid builder are reported as failed
+// builds. It returns false if one of the builds is failed.
+func buildInitrdsParallel(bootFiles *kernelspecs.BootFiles, builders *initrdBuilders,
+	jobs int, logDir string) bool {
+
+	buildJobs := []*initrd.BuildJob{}
+	fingerprints := []*initrd.Fingerprint{}
+	skipped := []*kernelspecs.KernelFiles{}
+	failed := []*initrd.BuildResult{}
+	for idx, f := range bootFiles.Files {
+		if f.Kernel == nil {
+			// Ignore initrd without kernel image.
+			continue
+		}
+
+		b, err := builders.Get(f)
+		if err != nil {
+			failed = append(failed, &initrd.BuildResult{
+				Job:   initrd.NewBuildJob(bootFiles.Files[idx], nil),
+				Error: err,
+			})
+			continue
+		}
+
+		upToDate, fp := builders.checkCache(b, f, bootFiles.Dir)
+		if upToDate {
+			skipped = append(skipped, f)
+			continue
+		}
+
+		buildJobs = append(buildJobs, initrd.NewBuildJob(bootFiles.Files[idx], b))
+		fingerprints = append(fingerprints, fp)
+	}
+
+	if len(buildJobs) == 0 && len(skipped) == 0 && len(failed) == 0 {
+		fmt.Println("No kernels available. Nothing to do.")
+		return true
+	}
+
+	results := []*initrd.BuildResult{}
+	if len(buildJobs) > 0 {
+		fmt.Println(fmt.Sprintf("Generating %d initrd images with %d jobs...",
+			len(buildJobs), jobs))
+
+		pool := initrd.NewBuildPool(jobs, bootFiles.Dir, logDir)
+		results = pool.Run(buildJobs)
+	}
+	results = append(results, failed...)
+
+	ans := true
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetBorders(tablewriter.Border{
+		Left: true, Top: false, Right: true, Bottom: false,
+	})
+	table.SetCenterSeparator("|")
+	table.SetHeader([]string{
+		"Kernel",
+		"Builder",
+		"Status",
+		"Duration",
+		"Size",
+	})
+
+	for idx, r := range results {
+		size := ""
+		if r.Size > 0 {
+			size = utils.HumanSize(r.Size)
+		}
+
+		builder := ""
+		if r.Job.Builder != nil {
+			builder = r.Job.Builder.GetName()
+		}
+
+		table.Append([]string{
+			r.Job.Files.Kernel.GetFilename(),
+			builder,
+			r.GetStatus(),
+			r.Duration.Round(time.Millisecond).String(),
+			size,
+		})
+
+		if r.Failed() {
+			ans = false
+		} else {
+			builders.updateCache(r.Job.Files, bootFiles.Dir, fingerprints[idx])
+		}
+	}
+
+	for _, kf := range skipped {
+		table.Append([]string{
+			kf.Kernel.GetFilename(),
+			"",
+			"up-to-date",
+			"",
+			"",
+		})
+	}
+
+	// Print the output of the failed builds or of all
+	// the builds on dry-run mode.
+	for _, r := range results {
+		if r.Job.Builder == nil {
+			fmt.Println(fmt.Sprintf("Error on generate initrd image for kernel %s: %s",
+				r.Job.Files.Kernel.GetFilename(), r.Error.Error()))
+		} else if r.Failed() || r.Job.Builder.IsDryRun() {
+			fmt.Println(fmt.Sprintf("Output of %s for kernel %s:",
+				r.Job.Builder.GetName(), r.Job.Files.Kernel.GetFilename()))
+			fmt.Print(r.Output.String())
+			if r.Failed() {
+				fmt.Println("Error: " + r.Error.Error())
+			}
+		}
+	}
+
+	table.Render()
+
+	return ans
+}
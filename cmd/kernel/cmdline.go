@@ -0,0 +1,334 @@
+// Copyright © 2021 Daniele Rondina <geaaru@sabayonlinux.org>
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package cmdkernel
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/MocaccinoOS/mos-cli/pkg/kernel"
+	kernelspecs "github.com/MocaccinoOS/mos-cli/pkg/kernel/specs"
+	"github.com/MocaccinoOS/mos-cli/pkg/profile"
+	"github.com/MocaccinoOS/mos-cli/pkg/utils"
+
+	"github.com/spf13/cobra"
+)
+
+func NewCmdlineCommand() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "cmdline",
+		Short: "Manage the kernel command line.",
+		Long: `Manage the kernel command line used by the boot entries.
+
+The command line is stored in /etc/kernel/cmdline and it's used by
+the grub, systemd-boot and extlinux entries and by the UKIs. If the
+file doesn't exist the command line of the running kernel is used.
+
+After the first change with set, add or remove, the command line
+is also written on a block at the end of /etc/default/grub used by
+grub-mkconfig. The block overrides GRUB_CMDLINE_LINUX and empties
+GRUB_CMDLINE_LINUX_DEFAULT. The block is updated by
+mos kernel update-bootloader and it's removed with /etc/kernel/cmdline.
+A /etc/kernel/cmdline created by other tools doesn't change
+/etc/default/grub.
+
+A kernel type profile could override the parameters with the cmdline
+option. A parameter of the profile replaces the parameter with the
+same key and the - prefix removes it:
+
+  cmdline: "quiet splash -nomodeset"
+
+The overrides of the kernel types aren't applied by grub-mkconfig:
+they require the native grub entries (mos.cfg) generated with
+mos kernel update-bootloader --grub-native, systemd-boot or extlinux.
+
+The parameters could be present only one time except the repeatable
+parameters (console, ip, rd.luks.uuid, etc.).
+
+After a change the bootloader is updated with
+mos kernel update-bootloader.
+`,
+	}
+
+	c.PersistentFlags().String("file", kernel.DefaultKernelCmdlineFile,
+		"File of the kernel command line.")
+
+	c.AddCommand(
+		newCmdlineGetCommand(),
+		newCmdlineSetCommand(),
+		newCmdlineAddCommand(),
+		newCmdlineRemoveCommand(),
+	)
+
+	return c
+}
+
+// getKernelType returns the kernel type profile of the type in input.
+func getKernelType(kernelProfilesDir, ktype string) (*kernelspecs.KernelType, error) {
+	types := []kernelspecs.KernelType{}
+	if kernelProfilesDir != "" {
+		types, _ = profile.LoadKernelProfiles(kernelProfilesDir)
+	}
+	if len(types) == 0 {
+		types = profile.GetDefaultKernelProfiles()
+	}
+
+	for idx := range types {
+		if types[idx].GetType() == ktype {
+			return &types[idx], nil
+		}
+	}
+
+	return nil, errors.New(fmt.Sprintf("No kernel profile found for type %s", ktype))
+}
+
+func parseCmdlineParams(args []string) ([]*kernelspecs.CmdlineParam, error) {
+	ans := []*kernelspecs.CmdlineParam{}
+	for _, arg := range args {
+		p, err := kernelspecs.ParseCmdlineParam(arg)
+		if err != nil {
+			return nil, err
+		}
+		ans = append(ans, p)
+	}
+	return ans, nil
+}
+
+// updateCmdline applies the function to the kernel command line
+// of the file and writes the result.
+func updateCmdline(cmd *cobra.Command, args []string,
+	f func(*kernelspecs.KernelCmdline, *kernelspecs.CmdlineParam) error) {
+
+	file, _ := getPathFlag(cmd, "file")
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+	params, err := parseCmdlineParams(args)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	cmdline, err := kernel.LoadKernelCmdline(file)
+	if err != nil {
+		fmt.Println("Error on read kernel command line: " + err.Error())
+		os.Exit(1)
+	}
+
+	for _, p := range params {
+		if err := f(cmdline, p); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+	}
+
+	if err := cmdline.Validate(); err != nil {
+		fmt.Println("Invalid kernel command line: " + err.Error())
+		os.Exit(1)
+	}
+
+	if dryRun {
+		fmt.Println("[dry-run mode] " + file + ": " + cmdline.String())
+		return
+	}
+
+	err = kernel.WriteKernelCmdline(file, cmdline)
+	if err != nil {
+		fmt.Println("Error on write kernel command line: " + err.Error())
+		os.Exit(1)
+	}
+
+	fmt.Println(cmdline.String())
+
+	// grub-mkconfig uses the command line managed by mos
+	// after the first change.
+	if file == utils.RootPath(kernel.DefaultKernelCmdlineFile) {
+		err = kernel.UpdateGrubDefaultCmdline("", true, false)
+		if err != nil {
+			fmt.Println("Error on update grub defaults: " + err.Error())
+			os.Exit(1)
+		}
+	}
+
+	fmt.Println("Run mos kernel update-bootloader to update the boot entries.")
+}
+
+func newCmdlineGetCommand() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "get [key]",
+		Short: "Show the kernel command line or the values of a parameter.",
+		Long: `Show the kernel command line or the values of a parameter.
+
+$> mos kernel cmdline get
+
+$> mos kernel cmdline get console
+
+$> # Show the command line with the overrides of the kernel type
+$> mos kernel cmdline get --ktype vanilla
+
+`,
+		Args: cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			file, _ := getPathFlag(cmd, "file")
+			ktype, _ := cmd.Flags().GetString("ktype")
+			kernelProfilesDir, _ := getPathFlag(cmd, "kernel-profiles-dir")
+			jsonOutput, _ := cmd.Flags().GetBool("json")
+
+			cmdline, err := kernel.LoadKernelCmdline(file)
+			if err != nil {
+				fmt.Println("Error on read kernel command line: " + err.Error())
+				os.Exit(1)
+			}
+
+			if ktype != "" {
+				t, err := getKernelType(kernelProfilesDir, ktype)
+				if err != nil {
+					fmt.Println(err.Error())
+					os.Exit(1)
+				}
+				merged, err := t.MergeCmdline(cmdline.String())
+				if err == nil {
+					cmdline, err = kernelspecs.ParseKernelCmdline(merged)
+				}
+				if err != nil {
+					fmt.Println(err.Error())
+					os.Exit(1)
+				}
+			}
+
+			params := cmdline.Params
+			if len(args) > 0 {
+				params = cmdline.Get(args[0])
+				if len(params) == 0 {
+					fmt.Println(fmt.Sprintf("Parameter %s not found", args[0]))
+					os.Exit(1)
+				}
+			}
+
+			if jsonOutput {
+				var data []byte
+				if len(args) > 0 {
+					data, _ = json.Marshal(params)
+				} else {
+					data, _ = json.Marshal(cmdline)
+				}
+				fmt.Println(string(data))
+				return
+			}
+
+			if len(args) > 0 {
+				for _, p := range params {
+					fmt.Println(p.Value)
+				}
+			} else {
+				fmt.Println(cmdline.String())
+			}
+		},
+	}
+
+	flags := c.Flags()
+	flags.String("ktype", "", "Show the command line with the overrides of the kernel type.")
+	flags.String("kernel-profiles-dir", "/etc/mocaccino/kernels-profiles/",
+		"Specify the directory where read the kernel types profiles supported.")
+	flags.Bool("json", false, "JSON output")
+
+	return c
+}
+
+func newCmdlineSetCommand() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "set <param> [<param>...]",
+		Short: "Set parameters of the kernel command line.",
+		Long: `Set parameters of the kernel command line replacing the
+existing values of the same keys.
+
+$> mos kernel cmdline set root=/dev/sda2 quiet
+
+$> mos kernel cmdline set 'rootflags=subvol=@ compress=zstd'
+
+`,
+		Args: cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			updateCmdline(cmd, args,
+				func(c *kernelspecs.KernelCmdline, p *kernelspecs.CmdlineParam) error {
+					c.Set(p)
+					return nil
+				})
+		},
+	}
+
+	c.Flags().Bool("dry-run", false, "Show the new command line without write it.")
+
+	return c
+}
+
+func newCmdlineAddCommand() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "add <param> [<param>...]",
+		Short: "Add parameters to the kernel command line.",
+		Long: `Add parameters to the kernel command line.
+
+Only the repeatable parameters (console, ip, rd.luks.uuid, etc.)
+could be added multiple times. Use set to change a parameter.
+
+$> mos kernel cmdline add console=ttyS0,115200 console=tty0
+
+`,
+		Args: cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			updateCmdline(cmd, args,
+				func(c *kernelspecs.KernelCmdline, p *kernelspecs.CmdlineParam) error {
+					return c.Add(p)
+				})
+		},
+	}
+
+	c.Flags().Bool("dry-run", false, "Show the new command line without write it.")
+
+	return c
+}
+
+func newCmdlineRemoveCommand() *cobra.Command {
+	c := &cobra.Command{
+		Use:     "remove <key|param> [<key|param>...]",
+		Aliases: []string{"rm"},
+		Short:   "Remove parameters from the kernel command line.",
+		Long: `Remove parameters from the kernel command line.
+
+A key removes all the parameters with the key, key=value removes
+only the parameter with the value.
+
+$> mos kernel cmdline remove quiet
+
+$> mos kernel cmdline remove console=ttyS0,115200
+
+`,
+		Args: cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			updateCmdline(cmd, args,
+				func(c *kernelspecs.KernelCmdline, p *kernelspecs.CmdlineParam) error {
+					if c.Remove(p) == 0 {
+						return errors.New(fmt.Sprintf("Parameter %s not found", p.String()))
+					}
+					return nil
+				})
+		},
+	}
+
+	c.Flags().Bool("dry-run", false, "Show the new command line without write it.")
+
+	return c
+}
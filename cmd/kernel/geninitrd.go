@@ -17,31 +17,20 @@ package cmdkernel
 import (
 	"fmt"
 	"os"
-	"path/filepath"
 
 	"github.com/MocaccinoOS/mos-cli/pkg/initrd"
 	"github.com/MocaccinoOS/mos-cli/pkg/kernel"
 	kernelspecs "github.com/MocaccinoOS/mos-cli/pkg/kernel/specs"
-	. "github.com/MocaccinoOS/mos-cli/pkg/logger"
 	"github.com/MocaccinoOS/mos-cli/pkg/profile"
+	"github.com/MocaccinoOS/mos-cli/pkg/secureboot"
 	"github.com/MocaccinoOS/mos-cli/pkg/utils"
 
 	"github.com/spf13/cobra"
 )
 
-func setFilesLinks(kf *kernelspecs.KernelFiles, bootDir, release string) error {
+func setFilesLinks(kf *kernelspecs.KernelFiles, bootDir, release, historyFile string) error {
 
-	// Ignoring errors
-	os.Remove(filepath.Join(bootDir, "bzImage"))
-	os.Remove(filepath.Join(bootDir, "Initrd"))
-
-	err := os.Chdir(bootDir)
-	if err != nil {
-		return err
-	}
-
-	DebugC("Creating link bzImage to", kf.Kernel.GetFilename())
-	err = os.Symlink(kf.Kernel.GetFilename(), filepath.Join(bootDir, "bzImage"))
+	entry, err := kernel.SetDefaultKernel(kf, bootDir)
 	if err != nil {
 		return err
 	}
@@ -57,15 +46,9 @@ func setFilesLinks(kf *kernelspecs.KernelFiles, bootDir, release string) error {
 				"For micro release you need to install kernel/mocaccino-initramfs or kernel/mocaccino-initramfs-lts.",
 			)
 		}
-	} else {
-		DebugC("Creating link Initrd to", kf.Initrd.GenerateFilename())
-		err = os.Symlink(kf.Initrd.GenerateFilename(), filepath.Join(bootDir, "Initrd"))
-		if err != nil {
-			return err
-		}
 	}
 
-	return nil
+	return kernel.RecordLinksChange(historyFile, entry)
 }
 
 func NewGeninitrdCommand() *cobra.Command {
@@ -73,7 +56,15 @@ func NewGeninitrdCommand() *cobra.Command {
 		Use:     "geninitrd",
 		Aliases: []string{"gi"},
 		Short:   "Generate initrd image and set default kernel/initrd links.",
-		Long: `Rebuild Dracut initrd images or for Mocaccino Micro fix links.
+		Long: `Rebuild initrd images or for Mocaccino Micro fix links.
+
+The initrd images are generated with dracut by default. The builder
+could be selected with the initrd_builder option of the kernel profile
+or with the --builder flag. Supported builders are: dracut, mkinitcpio,
+booster and genkernel.
+
+Every image is generated on a temporary file and it replaces the
+existing image only if the build is completed and the new image is valid.
 
 $> # Generate all initrd images of the kernels available on boot dir.
 $> mos kernel geninitrd --all
@@ -90,9 +81,28 @@ $> # if not present or to the next release of the same kernel after the
 $> # upgrade. In addition, it purges old initrd images and update grub.cfg.
 $> mos kernel geninitrd --all --set-links --purge --grub
 
+$> # Generate all initrd images and update the systemd-boot entries
+$> # of the EFI System Partition mounted on /efi.
+$> mos kernel geninitrd --all --update-bootloader --bootloader systemd-boot --esp /efi
+
+$> # Generate all initrd images and sign the kernel images with the
+$> # Secure Boot key of the config file.
+$> mos kernel geninitrd --all --sign
+
 $> # Just show what dracut commands will be executed for every initrd images.
 $> mos kernel geninitrd --all --dry-run
 
+$> # Generate all initrd images with mkinitcpio.
+$> mos kernel geninitrd --all --builder mkinitcpio
+
+$> # Generate all initrd images running 4 builds at the same time
+$> # and store the output of every build under /var/log/mos.
+$> mos kernel geninitrd --all --jobs 4 --log-dir /var/log/mos
+
+$> # Regenerate all initrd images also if the kernels, the modules and
+$> # the builder configuration are not changed since the last build.
+$> mos kernel geninitrd --all --force
+
 $> # Generate the initrd image for the kernel 5.10.42
 $> mos kernel geninitrd --version 5.10.42
 
@@ -113,16 +123,28 @@ $> mos kernel geninitrd --version 5.10.42 --ktype vanilla
 		},
 		Run: func(cmd *cobra.Command, args []string) {
 
-			bootDir, _ := cmd.Flags().GetString("bootDir")
+			bootDir, _ := getPathFlag(cmd, "bootdir")
 			all, _ := cmd.Flags().GetBool("all")
 			setLinks, _ := cmd.Flags().GetBool("set-links")
 			version, _ := cmd.Flags().GetString("version")
 			ktype, _ := cmd.Flags().GetString("ktype")
 			dryRun, _ := cmd.Flags().GetBool("dry-run")
 			dracutOpts, _ := cmd.Flags().GetString("dracut-opts")
+			builder, _ := cmd.Flags().GetString("builder")
+			builderOpts, _ := cmd.Flags().GetString("builder-opts")
+			jobs, _ := cmd.Flags().GetInt("jobs")
+			logDir, _ := getPathFlag(cmd, "log-dir")
+			force, _ := cmd.Flags().GetBool("force")
+			noCache, _ := cmd.Flags().GetBool("no-cache")
+			cacheFile, _ := getPathFlag(cmd, "cache-file")
+			buildsFailed := false
 			purge, _ := cmd.Flags().GetBool("purge")
 			grub, _ := cmd.Flags().GetBool("grub")
-			kernelProfilesDir, _ := cmd.Flags().GetString("kernel-profiles-dir")
+			updateBl, _ := cmd.Flags().GetBool("update-bootloader")
+			kernelProfilesDir, _ := getPathFlag(cmd, "kernel-profiles-dir")
+			pinsFile, _ := getPathFlag(cmd, "pins-file")
+			historyFile, _ := getPathFlag(cmd, "history-file")
+			sign, _ := cmd.Flags().GetBool("sign")
 
 			types := []kernelspecs.KernelType{}
 
@@ -139,36 +161,67 @@ $> mos kernel geninitrd --version 5.10.42 --ktype vanilla
 				os.Exit(1)
 			}
 
+			_, err = kernel.LoadPinnedBootFiles(bootFiles, pinsFile)
+			if err != nil {
+				fmt.Println("Error on read pinned kernels: " + err.Error())
+				os.Exit(1)
+			}
+
 			release, err := utils.OsRelease()
 			if err != nil {
 				fmt.Println("Error on retrieve os release: " + err.Error())
 				os.Exit(1)
 			}
 
-			// TODO: default dracut options will be read from configuration.
+			var kp *secureboot.KeyPair
+			if sign {
+				kp, err = loadSecureBootKeyPair(cmd)
+				if err != nil {
+					fmt.Println("Error on load Secure Boot keys: " + err.Error())
+					os.Exit(1)
+				}
+			}
+			signFiles := bootFiles.Files
 
-			defaultDracutOpts :=
-				"-H -q -f -o systemd -o systemd-initrd -o systemd-networkd -o dracut-systemd"
-			if dracutOpts != "" {
-				defaultDracutOpts = dracutOpts
+			// TODO: default builders options will be read from configuration.
+			builders := newInitrdBuilders(builder, builderOpts, dracutOpts, dryRun)
+			if builder != "" {
+				if _, err := builders.Get(nil); err != nil {
+					fmt.Println(err.Error())
+					os.Exit(1)
+				}
+			}
+
+			if !noCache {
+				builders.Force = force
+				builders.Cache, err = initrd.LoadBuildCache(cacheFile)
+				if err != nil {
+					fmt.Println(fmt.Sprintf(
+						"WARN: %s. The initrd images will be rebuilt.", err.Error()))
+					builders.Cache = initrd.NewBuildCache(cacheFile)
+				}
 			}
-			dracutBuilder := initrd.NewDracutBuilder(defaultDracutOpts, dryRun)
 
 			if all {
 				if release != "micro" && release != "micro-embedded" {
 
-					for idx, f := range bootFiles.Files {
-						if f.Kernel == nil {
-							// Ignore initrd without kernel image.
-							continue
-						}
+					if jobs > 0 {
+						buildsFailed = !buildInitrdsParallel(bootFiles, builders, jobs, logDir)
+					} else {
+						for idx, f := range bootFiles.Files {
+							if f.Kernel == nil {
+								// Ignore initrd without kernel image.
+								continue
+							}
 
-						err := dracutBuilder.Build(bootFiles.Files[idx], bootFiles.Dir)
-						if err != nil {
-							fmt.Println(fmt.Sprintf("Error on generate initrd image for kernel %s: %s. I go ahead.",
-								f.Kernel.GetFilename(),
-								err.Error(),
-							))
+							err := builders.Build(bootFiles.Files[idx], bootFiles.Dir)
+							if err != nil {
+								fmt.Println(fmt.Sprintf("Error on generate initrd image for kernel %s: %s. I go ahead.",
+									f.Kernel.GetFilename(),
+									err.Error(),
+								))
+								buildsFailed = true
+							}
 						}
 					}
 				} else {
@@ -182,11 +235,7 @@ $> mos kernel geninitrd --version 5.10.42 --ktype vanilla
 					// Retrieve the kernel matching for type, prefix and suffix
 					kf = bootFiles.RetrieveBzImageSelectedKernel()
 					if kf == nil {
-						for _, file := range bootFiles.Files {
-							if file.Kernel != nil {
-								kf = file
-							}
-						}
+						kf = bootFiles.GetLatestKernel("", "")
 
 						if kf != nil {
 							fmt.Println(fmt.Sprintf(
@@ -197,7 +246,7 @@ $> mos kernel geninitrd --version 5.10.42 --ktype vanilla
 					}
 
 					if kf != nil {
-						err := setFilesLinks(kf, bootFiles.Dir, release)
+						err := setFilesLinks(kf, bootFiles.Dir, release, historyFile)
 						if err != nil {
 							fmt.Println(fmt.Sprintf("Error on set links for kernel %s: %s",
 								kf.Kernel.GetVersion(),
@@ -214,23 +263,31 @@ $> mos kernel geninitrd --version 5.10.42 --ktype vanilla
 					fmt.Println(err.Error())
 					os.Exit(1)
 				}
+				signFiles = []*kernelspecs.KernelFiles{file}
 
 				if release != "micro" {
 
-					err = dracutBuilder.Build(file, bootFiles.Dir)
+					err = builders.Build(file, bootFiles.Dir)
 					if err != nil {
 						fmt.Println(fmt.Sprintf("Error on generate initrd image for kernel %s: %s. I go ahead.",
 							file.Kernel.GetFilename(),
 							err.Error(),
 						))
+						buildsFailed = true
 					}
 				} else {
 					fmt.Println("Micro release uses initrd packages. Nothing to do for initrd images generation.")
 				}
 
-				if setLinks {
+				current := bootFiles.GetBzImageKernel()
+				if setLinks && current != nil && current.Pinned && current != file {
+					fmt.Println(fmt.Sprintf(
+						"WARN: bzImage links the pinned kernel %s. Links are not changed.",
+						current.Kernel.GetVersion(),
+					))
+				} else if setLinks {
 
-					err := setFilesLinks(file, bootFiles.Dir, release)
+					err := setFilesLinks(file, bootFiles.Dir, release, historyFile)
 					if err != nil {
 						fmt.Println(fmt.Sprintf("Error on set links for kernel %s: %s",
 							file.Kernel.GetVersion(),
@@ -241,6 +298,16 @@ $> mos kernel geninitrd --version 5.10.42 --ktype vanilla
 
 			}
 
+			err = builders.WriteCache()
+			if err != nil {
+				fmt.Println(fmt.Sprintf("Error on write build cache: %s", err.Error()))
+			}
+
+			// Sign the kernel images for Secure Boot
+			if sign && !signKernelFiles(signFiles, bootFiles.Dir, kp, false, dryRun) {
+				buildsFailed = true
+			}
+
 			// Purge orphan initrd
 			if purge {
 				if release == "micro" || release == "micro-embedded" {
@@ -253,16 +320,23 @@ $> mos kernel geninitrd --version 5.10.42 --ktype vanilla
 				}
 			}
 
-			// Update grub config
-			if grub {
-				err = kernel.GrubMkconfig(filepath.Join(bootFiles.Dir, "/grub/grub.cfg"), dryRun)
+			// Update bootloader config
+			if grub || updateBl {
+				bootloader := getBootloader(cmd)
+				if grub {
+					bootloader = kernel.BootloaderGrub
+				}
+				err = updateBootloader(cmd, bootFiles.Dir, types, bootloader, dryRun)
 				if err != nil {
-					fmt.Println(fmt.Sprintf("Error on update grub.cfg: %s", err.Error()))
-					// TODO: We need ignore it?
+					fmt.Println(fmt.Sprintf("Error on update %s config: %s", bootloader, err.Error()))
 					os.Exit(1)
 				}
 			}
 
+			if buildsFailed {
+				os.Exit(1)
+			}
+
 		},
 	}
 
@@ -272,14 +346,39 @@ $> mos kernel geninitrd --version 5.10.42 --ktype vanilla
 	flags.Bool("set-links", false, "Set bzImage and Initrd links for the selected kernel or update links of the upgraded kernel.")
 	flags.Bool("purge", false, "Clean orphan initrd images without kernel.")
 	flags.Bool("grub", false, "Update grub.cfg.")
+	flags.Bool("update-bootloader", false,
+		"Update the config of the bootloader selected with --bootloader or with the config file.")
+	addBootloaderFlags(flags)
+	flags.Bool("sign", false, "Sign the kernel images for Secure Boot.")
+	addSecureBootFlags(flags)
 	flags.String("bootdir", "/boot", "Directory where analyze kernel files.")
 	flags.String("version", "", "Specify the kernel version of the initrd image to build.")
 	flags.String("ktype", "", "Specify the kernel type of the initrd image to build.")
 	flags.String("dracut-opts", "",
 		`Override the default dracut options used on the initrd image generation.
 Set the MOS_DRACUT_ARGS env in alternative.`)
+	flags.String("builder", "",
+		`Override the initrd builder defined in the kernel profiles.
+Supported builders: dracut, mkinitcpio, booster, genkernel.`)
+	flags.Int("jobs", 0,
+		`Number of initrd images generated in parallel with --all.
+The output of the builders is captured and a summary is printed at the end.`)
+	flags.Bool("force", false,
+		"Rebuild the initrd images also if their inputs are not changed.")
+	flags.Bool("no-cache", false,
+		"Disable the build cache. The initrd images are always rebuilt.")
+	flags.String("cache-file", initrd.DefaultBuildCacheFile,
+		"State file where store the fingerprints of the generated initrd images.")
+	flags.String("log-dir", "",
+		"Directory where write the output of every build when --jobs is used.")
+	flags.String("builder-opts", "",
+		`Override the default options of the selected initrd builder.
+Set the MOS_<BUILDER>_ARGS env in alternative.`)
 	flags.String("kernel-profiles-dir", "/etc/mocaccino/kernels-profiles/",
 		"Specify the directory where read the kernel types profiles supported.")
+	flags.String("pins-file", kernel.DefaultPinsFile, "State file of the pinned kernels.")
+	flags.String("history-file", kernel.DefaultLinksHistoryFile,
+		"History file of the changes of bzImage and Initrd links.")
 
 	return c
 }
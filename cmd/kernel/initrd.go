@@ -0,0 +1,205 @@
+// Copyright © 2021 Daniele Rondina <geaaru@sabayonlinux.org>
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package cmdkernel
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/MocaccinoOS/mos-cli/pkg/initrd"
+	"github.com/MocaccinoOS/mos-cli/pkg/kernel"
+	kernelspecs "github.com/MocaccinoOS/mos-cli/pkg/kernel/specs"
+	"github.com/MocaccinoOS/mos-cli/pkg/profile"
+	"github.com/MocaccinoOS/mos-cli/pkg/utils"
+
+	tablewriter "github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+func NewInitrdCommand() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "initrd",
+		Short: "Inspect initrd images.",
+		Long:  `Analyze the initrd images of your system.`,
+	}
+
+	c.AddCommand(
+		newInitrdInspectCommand(),
+	)
+
+	return c
+}
+
+func newInitrdInspectCommand() *cobra.Command {
+	c := &cobra.Command{
+		Use:     "inspect [initrd-file]",
+		Aliases: []string{"i"},
+		Short:   "Show the content of an initrd image.",
+		Long: `Shows the compression, the kernel modules, the dracut modules
+and the kernel version of an initrd image.
+
+$> # Inspect the initrd of the kernel 5.10.42 available on boot dir.
+$> mos kernel initrd inspect --version 5.10.42
+
+$> # Inspect an initrd file and list all files.
+$> mos kernel initrd inspect /boot/initramfs-vanilla-x86_64-5.10.42-mocaccino --files
+
+`,
+		Args: cobra.MaximumNArgs(1),
+		PreRun: func(cmd *cobra.Command, args []string) {
+			version, _ := cmd.Flags().GetString("version")
+			if len(args) == 0 && version == "" {
+				fmt.Println("You need to supply the initrd file or --version")
+				os.Exit(1)
+			}
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+
+			jsonOutput, _ := cmd.Flags().GetBool("json")
+			withFiles, _ := cmd.Flags().GetBool("files")
+			bootDir, _ := getPathFlag(cmd, "bootdir")
+			version, _ := cmd.Flags().GetString("version")
+			ktype, _ := cmd.Flags().GetString("ktype")
+			kernelProfilesDir, _ := getPathFlag(cmd, "kernel-profiles-dir")
+
+			var kf *kernelspecs.KernelFiles
+			initrdFile := ""
+
+			if len(args) > 0 {
+				initrdFile = args[0]
+			} else {
+				types := []kernelspecs.KernelType{}
+				if kernelProfilesDir != "" {
+					types, _ = profile.LoadKernelProfiles(kernelProfilesDir)
+				}
+				if len(types) == 0 {
+					types = profile.GetDefaultKernelProfiles()
+				}
+
+				bootFiles, err := kernel.ReadBootDir(bootDir, types)
+				if err != nil {
+					fmt.Println("Error on read boot directory: " + err.Error())
+					os.Exit(1)
+				}
+
+				kf, err = bootFiles.GetFile(version, ktype)
+				if err != nil {
+					fmt.Println(err.Error())
+					os.Exit(1)
+				}
+
+				if kf.Initrd == nil {
+					fmt.Println(fmt.Sprintf("No initrd image found for kernel %s.",
+						kf.Kernel.GetVersion()))
+					os.Exit(1)
+				}
+
+				initrdFile = filepath.Join(bootFiles.Dir, kf.Initrd.GetFilename())
+			}
+
+			info, err := initrd.InspectImage(initrdFile, withFiles)
+			if err != nil {
+				fmt.Println(fmt.Sprintf("Error on read initrd image %s: %s",
+					initrdFile, err.Error()))
+				os.Exit(1)
+			}
+
+			if jsonOutput {
+				data, err := json.Marshal(info)
+				if err != nil {
+					fmt.Println(fmt.Errorf("Error on convert data to json: %s", err.Error()))
+					os.Exit(1)
+				}
+				fmt.Println(string(data))
+				return
+			}
+
+			formats := []string{}
+			for _, a := range info.Archives {
+				formats = append(formats, a.Format)
+			}
+
+			fmt.Println(fmt.Sprintf("File:            %s", info.File))
+			fmt.Println(fmt.Sprintf("Size:            %s", utils.HumanSize(info.Size)))
+			fmt.Println(fmt.Sprintf("Archives:        %s", strings.Join(formats, ", ")))
+			fmt.Println(fmt.Sprintf("Early microcode: %v", info.EarlyMicrocode))
+			fmt.Println(fmt.Sprintf("Kernel versions: %s", strings.Join(info.KernelVersions, ", ")))
+			if kf != nil {
+				fmt.Println(fmt.Sprintf("Matches kernel:  %v (%s)",
+					info.HasKernelVersion(kf.Kernel.GetKernelRelease()),
+					kf.Kernel.GetKernelRelease()))
+			}
+			if len(info.DracutModules) > 0 {
+				fmt.Println(fmt.Sprintf("Dracut modules:  %s", strings.Join(info.DracutModules, " ")))
+			}
+			fmt.Println(fmt.Sprintf("Kernel modules:  %d", len(info.Modules)))
+			fmt.Println()
+
+			table := tablewriter.NewWriter(os.Stdout)
+			table.SetBorders(tablewriter.Border{
+				Left: true, Top: false, Right: true, Bottom: false,
+			})
+			table.SetCenterSeparator("|")
+
+			if withFiles {
+				table.SetHeader([]string{
+					"Archive",
+					"Mode",
+					"Size",
+					"Name",
+				})
+
+				for _, e := range info.Files {
+					name := e.Name
+					if e.IsSymlink() {
+						name += " -> " + e.Link
+					}
+					table.Append([]string{
+						fmt.Sprintf("%d", e.Archive),
+						e.GetFileMode().String(),
+						fmt.Sprintf("%d", e.Size),
+						name,
+					})
+				}
+			} else {
+				table.SetHeader([]string{
+					"Module",
+					"Path",
+				})
+
+				names := info.GetModuleNames()
+				for idx, m := range info.Modules {
+					table.Append([]string{names[idx], m})
+				}
+			}
+
+			table.Render()
+		},
+	}
+
+	flags := c.Flags()
+	flags.Bool("json", false, "JSON output")
+	flags.Bool("files", false, "List all files of the initrd image.")
+	flags.String("bootdir", "/boot", "Directory where analyze kernel files.")
+	flags.String("version", "", "Specify the kernel version of the initrd image to inspect.")
+	flags.String("ktype", "", "Specify the kernel type of the initrd image to inspect.")
+	flags.String("kernel-profiles-dir", "/etc/mocaccino/kernels-profiles/",
+		"Specify the directory where read the kernel types profiles supported.")
+
+	return c
+}
@@ -39,8 +39,10 @@ $ mos kernel list
 		Run: func(cmd *cobra.Command, args []string) {
 
 			jsonOutput, _ := cmd.Flags().GetBool("json")
-			bootDir, _ := cmd.Flags().GetString("bootDir")
-			kernelProfilesDir, _ := cmd.Flags().GetString("kernel-profiles-dir")
+			bootDir, _ := getPathFlag(cmd, "bootdir")
+			kernelProfilesDir, _ := getPathFlag(cmd, "kernel-profiles-dir")
+			pinsFile, _ := getPathFlag(cmd, "pins-file")
+			ukiDir := getUkiDir(cmd)
 
 			types := []kernelspecs.KernelType{}
 			if kernelProfilesDir != "" {
@@ -56,6 +58,25 @@ $ mos kernel list
 				os.Exit(1)
 			}
 
+			_, err = kernel.LoadPinnedBootFiles(bootFiles, pinsFile)
+			if err != nil {
+				fmt.Println("Error on read pinned kernels: " + err.Error())
+				os.Exit(1)
+			}
+
+			err = kernel.LoadUkiImages(bootFiles, ukiDir)
+			if err != nil {
+				fmt.Println("Error on read UKIs: " + err.Error())
+				os.Exit(1)
+			}
+
+			trusted, err := loadTrustedCerts(cmd)
+			if err != nil {
+				fmt.Println("Error on load Secure Boot certificate: " + err.Error())
+				os.Exit(1)
+			}
+			kernel.LoadSignatures(bootFiles, trusted)
+
 			if jsonOutput {
 				fmt.Println(bootFiles)
 			} else {
@@ -77,6 +98,9 @@ $ mos kernel list
 					"Has Initrd",
 					"Has Kernel Image",
 					"Has bzImage,Initrd links",
+					"Pinned",
+					"Has UKI",
+					"Signature",
 				})
 
 				for _, kf := range bootFiles.Files {
@@ -114,12 +138,26 @@ $ mos kernel list
 						fmt.Sprintf("%v", hasInitrd),
 						fmt.Sprintf("%v", hasKernel),
 						fmt.Sprintf("%v", hasLinks),
+						fmt.Sprintf("%v", kf.Pinned),
+						fmt.Sprintf("%v", kf.Uki != nil),
+						getSignatureSummary(kf),
 					}...)
 
 					table.Append(row)
 				}
 
 				table.Render()
+
+				for _, kf := range bootFiles.Files {
+					if kf.Kernel != nil && kf.Kernel.HasVersionMismatch() {
+						fmt.Println(fmt.Sprintf(
+							"WARN: Kernel image %s contains the kernel %s and not %s.",
+							kf.Kernel.GetFilename(),
+							kf.Kernel.GetBinaryRelease(),
+							kf.Kernel.GetKernelRelease(),
+						))
+					}
+				}
 			}
 		},
 	}
@@ -129,6 +167,27 @@ $ mos kernel list
 	flags.String("bootdir", "/boot", "Directory where analyze kernel files.")
 	flags.String("kernel-profiles-dir", "/etc/mocaccino/kernels-profiles/",
 		"Specify the directory where read the kernel types profiles supported.")
+	flags.String("pins-file", kernel.DefaultPinsFile, "State file of the pinned kernels.")
+	flags.String("esp", "",
+		`Mount point of the EFI System Partition.
+Default is the esp_dir option of the config file or /boot/efi.`)
+	flags.String("uki-dir", "", "Directory of the UKIs. Default is <esp>/EFI/Linux.")
+	flags.String("cert", "",
+		`Certificate used to check the Secure Boot signatures.
+Default is the secureboot_cert option of the config file.`)
 
 	return c
 }
+
+// getSignatureSummary returns the signature status of the kernel
+// image and of the UKI.
+func getSignatureSummary(kf *kernelspecs.KernelFiles) string {
+	ans := ""
+	if kf.Kernel != nil {
+		ans = kf.Kernel.Signature
+	}
+	if kf.Uki != nil {
+		ans += fmt.Sprintf(" (uki: %s)", kf.Uki.Signature)
+	}
+	return ans
+}
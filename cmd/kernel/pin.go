@@ -0,0 +1,170 @@
+// Copyright © 2021 Daniele Rondina <geaaru@sabayonlinux.org>
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package cmdkernel
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/MocaccinoOS/mos-cli/pkg/kernel"
+	kernelspecs "github.com/MocaccinoOS/mos-cli/pkg/kernel/specs"
+	"github.com/MocaccinoOS/mos-cli/pkg/profile"
+
+	"github.com/spf13/cobra"
+)
+
+func updatePin(cmd *cobra.Command, version string, pin bool) {
+	bootDir, _ := getPathFlag(cmd, "bootdir")
+	ktype, _ := cmd.Flags().GetString("ktype")
+	kernelProfilesDir, _ := getPathFlag(cmd, "kernel-profiles-dir")
+	pinsFile, _ := getPathFlag(cmd, "pins-file")
+
+	types := []kernelspecs.KernelType{}
+	if kernelProfilesDir != "" {
+		types, _ = profile.LoadKernelProfiles(kernelProfilesDir)
+	}
+	if len(types) == 0 {
+		types = profile.GetDefaultKernelProfiles()
+	}
+
+	bootFiles, err := kernel.ReadBootDir(bootDir, types)
+	if err != nil {
+		fmt.Println("Error on read boot directory: " + err.Error())
+		os.Exit(1)
+	}
+
+	state, err := kernel.LoadPinsState(pinsFile)
+	if err != nil {
+		fmt.Println("Error on read pinned kernels: " + err.Error())
+		os.Exit(1)
+	}
+
+	kf, err := bootFiles.GetFile(version, ktype)
+	if err == nil && kf.Kernel == nil {
+		err = errors.New(fmt.Sprintf("No kernel image found for version %s.", version))
+	}
+	if err != nil {
+		if !pin {
+			// The kernel could be already removed from the boot dir.
+			unpinByName(state, version, ktype)
+			return
+		}
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	if pin {
+		if !state.Add(kf.Kernel) {
+			fmt.Println(fmt.Sprintf("Kernel %s already pinned.", kf.Kernel.GetFilename()))
+			return
+		}
+	} else {
+		if !state.Remove(kf.Kernel) {
+			fmt.Println(fmt.Sprintf("Kernel %s is not pinned.", kf.Kernel.GetFilename()))
+			return
+		}
+	}
+
+	err = state.Write()
+	if err != nil {
+		fmt.Println("Error on write pinned kernels: " + err.Error())
+		os.Exit(1)
+	}
+
+	if pin {
+		fmt.Println(fmt.Sprintf("Kernel %s pinned.", kf.Kernel.GetFilename()))
+	} else {
+		fmt.Println(fmt.Sprintf("Kernel %s unpinned.", kf.Kernel.GetFilename()))
+	}
+}
+
+// unpinByName removes the pins with the filename, the release or
+// the version in input.
+func unpinByName(state *kernel.PinsState, name, ktype string) {
+	pins := state.RemoveByName(name, ktype)
+	if len(pins) == 0 {
+		fmt.Println(fmt.Sprintf("Kernel %s is not pinned.", name))
+		return
+	}
+
+	err := state.Write()
+	if err != nil {
+		fmt.Println("Error on write pinned kernels: " + err.Error())
+		os.Exit(1)
+	}
+
+	for _, p := range pins {
+		name := p.Filename
+		if name == "" {
+			name = p.GetRelease()
+		}
+		fmt.Println(fmt.Sprintf("Kernel %s unpinned.", name))
+	}
+}
+
+func setPinFlags(c *cobra.Command) {
+	flags := c.Flags()
+	flags.String("bootdir", "/boot", "Directory where analyze kernel files.")
+	flags.String("ktype", "", "Specify the kernel type of the kernel.")
+	flags.String("kernel-profiles-dir", "/etc/mocaccino/kernels-profiles/",
+		"Specify the directory where read the kernel types profiles supported.")
+	flags.String("pins-file", kernel.DefaultPinsFile, "State file of the pinned kernels.")
+}
+
+func NewPinCommand() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "pin <version>",
+		Short: "Pin a kernel.",
+		Long: `Pinned kernels are never removed by prune and purge operations
+and the bzImage and Initrd links are not moved away from them.
+
+$> mos kernel pin 5.10.42 --ktype vanilla
+
+`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			updatePin(cmd, args[0], true)
+		},
+	}
+
+	setPinFlags(c)
+
+	return c
+}
+
+func NewUnpinCommand() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "unpin <version|release|filename>",
+		Short: "Unpin a kernel.",
+		Long: `Remove the pin of a kernel. The kernels not available on
+boot dir are unpinned through the filename, the release or the
+version of the pins file.
+
+$> mos kernel unpin 5.10.42 --ktype vanilla
+
+$> mos kernel unpin kernel-vanilla-x86_64-5.10.42-mocaccino
+
+`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			updatePin(cmd, args[0], false)
+		},
+	}
+
+	setPinFlags(c)
+
+	return c
+}
@@ -39,7 +39,7 @@ $ mos kernel profiles
 		Run: func(cmd *cobra.Command, args []string) {
 
 			jsonOutput, _ := cmd.Flags().GetBool("json")
-			kernelProfilesDir, _ := cmd.Flags().GetString("kernel-profiles-dir")
+			kernelProfilesDir, _ := getPathFlag(cmd, "kernel-profiles-dir")
 
 			types := []kernelspecs.KernelType{}
 			if kernelProfilesDir != "" {
@@ -70,22 +70,33 @@ $ mos kernel profiles
 				table.SetCenterSeparator("|")
 				table.SetHeader([]string{
 					"Name",
-					"Kernel Prefix",
-					"Initrd Prefix",
+					"Kernel Prefix/Pattern",
+					"Initrd Prefix/Pattern",
 					"Suffix",
 					"Type",
 					"With Arch",
+					"Initrd Builder",
 				})
 
 				for _, kt := range types {
 
+					kernelName := kt.GetKernelPrefixSanitized()
+					if kt.GetKernelPattern() != "" {
+						kernelName = kt.GetKernelPattern()
+					}
+					initrdName := kt.GetInitrdPrefixSanitized()
+					if kt.GetInitrdPattern() != "" {
+						initrdName = kt.GetInitrdPattern()
+					}
+
 					table.Append([]string{
 						kt.GetName(),
-						kt.GetKernelPrefixSanitized(),
-						kt.GetInitrdPrefixSanitized(),
+						kernelName,
+						initrdName,
 						kt.GetSuffix(),
 						kt.GetType(),
 						fmt.Sprintf("%v", kt.WithArch),
+						kt.GetInitrdBuilder(),
 					})
 				}
 
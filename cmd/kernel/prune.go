@@ -0,0 +1,203 @@
+// Copyright © 2021 Daniele Rondina <geaaru@sabayonlinux.org>
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package cmdkernel
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/MocaccinoOS/mos-cli/pkg/initrd"
+	"github.com/MocaccinoOS/mos-cli/pkg/kernel"
+	kernelspecs "github.com/MocaccinoOS/mos-cli/pkg/kernel/specs"
+	"github.com/MocaccinoOS/mos-cli/pkg/profile"
+	"github.com/MocaccinoOS/mos-cli/pkg/utils"
+
+	tablewriter "github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+func NewPruneCommand() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "prune",
+		Short: "Remove old kernels from boot dir.",
+		Long: `Removes the old kernels keeping the newest kernels of every
+kernel type. The running kernel, the kernels of the bzImage and
+bzImage.old links, the pinned kernels (see mos kernel pin) and the
+kernels not matched by the kernel profiles are never removed.
+
+For every removed kernel are deleted the kernel image, the initrd
+image, the System.map and config files and the modules tree.
+
+$> # Keep the two newest kernels of every type
+$> mos kernel prune --keep 2
+
+$> # Show the files that will be removed
+$> mos kernel prune --dry-run
+
+$> # Keep also the kernel 5.10.42 and update grub.cfg
+$> mos kernel prune --pin 5.10.42 --grub
+
+`,
+		Run: func(cmd *cobra.Command, args []string) {
+
+			jsonOutput, _ := cmd.Flags().GetBool("json")
+			bootDir, _ := getPathFlag(cmd, "bootdir")
+			modulesDir, _ := getPathFlag(cmd, "modules-dir")
+			keep, _ := cmd.Flags().GetInt("keep")
+			pinned, _ := cmd.Flags().GetStringSlice("pin")
+			dryRun, _ := cmd.Flags().GetBool("dry-run")
+			grub, _ := cmd.Flags().GetBool("grub")
+			updateBl, _ := cmd.Flags().GetBool("update-bootloader")
+			kernelProfilesDir, _ := getPathFlag(cmd, "kernel-profiles-dir")
+			pinsFile, _ := getPathFlag(cmd, "pins-file")
+
+			if keep < 1 {
+				fmt.Println("Invalid --keep value. At least one kernel must be kept.")
+				os.Exit(1)
+			}
+
+			types := []kernelspecs.KernelType{}
+			if kernelProfilesDir != "" {
+				types, _ = profile.LoadKernelProfiles(kernelProfilesDir)
+			}
+			if len(types) == 0 {
+				types = profile.GetDefaultKernelProfiles()
+			}
+
+			bootFiles, err := kernel.ReadBootDir(bootDir, types)
+			if err != nil {
+				fmt.Println("Error on read boot directory: " + err.Error())
+				os.Exit(1)
+			}
+
+			_, err = kernel.LoadPinnedBootFiles(bootFiles, pinsFile)
+			if err != nil {
+				fmt.Println("Error on read pinned kernels: " + err.Error())
+				os.Exit(1)
+			}
+
+			err = kernel.LoadUkiImages(bootFiles, getUkiDir(cmd))
+			if err != nil {
+				fmt.Println("Error on read UKIs: " + err.Error())
+				os.Exit(1)
+			}
+
+			// The running kernel isn't of the system root.
+			running := ""
+			if !utils.HasRootDir() {
+				running, err = kernel.GetRunningRelease()
+				if err != nil {
+					fmt.Println("WARN: " + err.Error())
+				}
+			}
+
+			policy := &kernel.PrunePolicy{
+				Keep:           keep,
+				RunningRelease: running,
+				Pinned:         pinned,
+			}
+
+			entries := policy.Plan(bootFiles, modulesDir)
+
+			if jsonOutput {
+				data, err := json.Marshal(entries)
+				if err != nil {
+					fmt.Println(fmt.Errorf("Error on convert data to json: %s", err.Error()))
+					os.Exit(1)
+				}
+				fmt.Println(string(data))
+			} else {
+
+				if len(entries) == 0 {
+					fmt.Println("No kernel files available.")
+					os.Exit(0)
+				}
+
+				table := tablewriter.NewWriter(os.Stdout)
+				table.SetBorders(tablewriter.Border{
+					Left: true, Top: false, Right: true, Bottom: false,
+				})
+				table.SetCenterSeparator("|")
+				table.SetHeader([]string{
+					"Kernel",
+					"Release",
+					"Action",
+					"Reason",
+				})
+
+				for _, e := range entries {
+					action := "keep"
+					if e.Remove {
+						action = "remove"
+					}
+
+					table.Append([]string{
+						e.Kernel,
+						e.Release,
+						action,
+						e.Reason,
+					})
+				}
+
+				table.Render()
+			}
+
+			// The JSON output is kept valid.
+			out := os.Stdout
+			if jsonOutput {
+				out = os.Stderr
+			}
+
+			err = kernel.Prune(entries, dryRun, out)
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+
+			// Update bootloader config
+			if grub || updateBl {
+				bootloader := getBootloader(cmd)
+				if grub {
+					bootloader = kernel.BootloaderGrub
+				}
+				err = updateBootloader(cmd, bootFiles.Dir, types, bootloader, dryRun)
+				if err != nil {
+					fmt.Println(fmt.Sprintf("Error on update %s config: %s", bootloader, err.Error()))
+					os.Exit(1)
+				}
+			}
+		},
+	}
+
+	flags := c.Flags()
+	flags.Bool("json", false, "JSON output")
+	flags.Bool("dry-run", false, "Show the files to remove without remove them.")
+	flags.Bool("grub", false, "Update grub.cfg.")
+	flags.Bool("update-bootloader", false,
+		"Update the config of the bootloader selected with --bootloader or with the config file.")
+	addBootloaderFlags(flags)
+	flags.Int("keep", 2, "Number of newest kernels to keep for every kernel type.")
+	flags.StringSlice("pin", []string{}, "Kernel versions or releases to keep.")
+	flags.String("bootdir", "/boot", "Directory where analyze kernel files.")
+	flags.String("modules-dir", initrd.DefaultModulesDir,
+		"Directory where the kernel modules are installed.")
+	flags.String("kernel-profiles-dir", "/etc/mocaccino/kernels-profiles/",
+		"Specify the directory where read the kernel types profiles supported.")
+	flags.String("pins-file", kernel.DefaultPinsFile, "State file of the pinned kernels.")
+	flags.String("uki-dir", "", "Directory of the UKIs. Default is <esp>/EFI/Linux.")
+
+	return c
+}
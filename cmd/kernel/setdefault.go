@@ -0,0 +1,139 @@
+// Copyright © 2021 Daniele Rondina <geaaru@sabayonlinux.org>
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package cmdkernel
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/MocaccinoOS/mos-cli/pkg/kernel"
+	kernelspecs "github.com/MocaccinoOS/mos-cli/pkg/kernel/specs"
+	"github.com/MocaccinoOS/mos-cli/pkg/profile"
+	"github.com/MocaccinoOS/mos-cli/pkg/utils"
+
+	"github.com/spf13/cobra"
+)
+
+func NewSetDefaultCommand() *cobra.Command {
+	c := &cobra.Command{
+		Use:     "set-default <version>",
+		Aliases: []string{"sd"},
+		Short:   "Set the default kernel.",
+		Long: `Set the bzImage and Initrd links to the selected kernel.
+
+The links are replaced atomically and the previous kernel is
+stored on the bzImage.old and Initrd.old links. Use the rollback
+command to restore the previous kernel.
+
+$> mos kernel set-default 5.10.42 --ktype vanilla
+
+`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+
+			bootDir, _ := getPathFlag(cmd, "bootdir")
+			ktype, _ := cmd.Flags().GetString("ktype")
+			kernelProfilesDir, _ := getPathFlag(cmd, "kernel-profiles-dir")
+			historyFile, _ := getPathFlag(cmd, "history-file")
+
+			types := []kernelspecs.KernelType{}
+			if kernelProfilesDir != "" {
+				types, _ = profile.LoadKernelProfiles(kernelProfilesDir)
+			}
+			if len(types) == 0 {
+				types = profile.GetDefaultKernelProfiles()
+			}
+
+			bootFiles, err := kernel.ReadBootDir(bootDir, types)
+			if err != nil {
+				fmt.Println("Error on read boot directory: " + err.Error())
+				os.Exit(1)
+			}
+
+			kf, err := bootFiles.GetFile(args[0], ktype)
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+
+			release, err := utils.OsRelease()
+			if err != nil {
+				fmt.Println("Error on retrieve os release: " + err.Error())
+				os.Exit(1)
+			}
+
+			err = setFilesLinks(kf, bootFiles.Dir, release, historyFile)
+			if err != nil {
+				fmt.Println(fmt.Sprintf("Error on set links for kernel %s: %s",
+					kf.Kernel.GetVersion(),
+					err.Error(),
+				))
+				os.Exit(1)
+			}
+
+			fmt.Println(fmt.Sprintf("Default kernel set to %s.", kf.Kernel.GetFilename()))
+		},
+	}
+
+	flags := c.Flags()
+	flags.String("bootdir", "/boot", "Directory where analyze kernel files.")
+	flags.String("ktype", "", "Specify the kernel type of the kernel.")
+	flags.String("kernel-profiles-dir", "/etc/mocaccino/kernels-profiles/",
+		"Specify the directory where read the kernel types profiles supported.")
+	flags.String("history-file", kernel.DefaultLinksHistoryFile,
+		"History file of the changes of bzImage and Initrd links.")
+
+	return c
+}
+
+func NewRollbackCommand() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "rollback",
+		Short: "Restore the previous default kernel.",
+		Long: `Set the bzImage and Initrd links to the kernel of the
+bzImage.old and Initrd.old links. The current kernel becomes
+the previous kernel.
+
+$> mos kernel rollback
+
+`,
+		Run: func(cmd *cobra.Command, args []string) {
+
+			bootDir, _ := getPathFlag(cmd, "bootdir")
+			historyFile, _ := getPathFlag(cmd, "history-file")
+
+			entry, err := kernel.RollbackDefaultKernel(bootDir)
+			if err != nil {
+				fmt.Println("Error on rollback: " + err.Error())
+				os.Exit(1)
+			}
+
+			err = kernel.RecordLinksChange(historyFile, entry)
+			if err != nil {
+				fmt.Println("Error on write links history: " + err.Error())
+				os.Exit(1)
+			}
+
+			fmt.Println(fmt.Sprintf("Default kernel restored to %s.", entry.Kernel))
+		},
+	}
+
+	flags := c.Flags()
+	flags.String("bootdir", "/boot", "Directory where analyze kernel files.")
+	flags.String("history-file", kernel.DefaultLinksHistoryFile,
+		"History file of the changes of bzImage and Initrd links.")
+
+	return c
+}
@@ -0,0 +1,204 @@
+// Copyright © 2021 Daniele Rondina <geaaru@sabayonlinux.org>
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package cmdkernel
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/MocaccinoOS/mos-cli/pkg/kernel"
+	kernelspecs "github.com/MocaccinoOS/mos-cli/pkg/kernel/specs"
+	"github.com/MocaccinoOS/mos-cli/pkg/profile"
+	"github.com/MocaccinoOS/mos-cli/pkg/secureboot"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+func addSecureBootFlags(flags *pflag.FlagSet) {
+	flags.String("key", "",
+		"PEM private key of the db key. Default is the secureboot_key option of the config file.")
+	flags.String("cert", "",
+		"PEM certificate of the db key. Default is the secureboot_cert option of the config file.")
+}
+
+// loadSecureBootKeyPair returns the key pair of the flags or of
+// the config file.
+func loadSecureBootKeyPair(cmd *cobra.Command) (*secureboot.KeyPair, error) {
+	keyFile := getFlagOrConfig(cmd, "key", "secureboot_key")
+	certFile := getFlagOrConfig(cmd, "cert", "secureboot_cert")
+	if keyFile == "" || certFile == "" {
+		return nil, errors.New(
+			"Secure Boot key and certificate not configured. Use --key and --cert or the config file.")
+	}
+
+	return secureboot.LoadKeyPair(keyFile, certFile)
+}
+
+// loadTrustedCerts returns the certificate of the flag or of the
+// config file. Without certificate only the signatures integrity
+// is checked.
+func loadTrustedCerts(cmd *cobra.Command) ([]*x509.Certificate, error) {
+	certFile := getFlagOrConfig(cmd, "cert", "secureboot_cert")
+	if certFile == "" {
+		return []*x509.Certificate{}, nil
+	}
+
+	cert, err := secureboot.LoadCertificate(certFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return []*x509.Certificate{cert}, nil
+}
+
+// signKernelFiles signs the kernel images and the UKIs of the
+// kernel files. It returns false if one of the signatures fails.
+func signKernelFiles(files []*kernelspecs.KernelFiles, bootDir string,
+	kp *secureboot.KeyPair, force, dryRun bool) bool {
+
+	ans := true
+	for _, kf := range files {
+		paths := []string{}
+		if kf.Kernel != nil {
+			paths = append(paths, filepath.Join(bootDir, kf.Kernel.GetFilename()))
+		}
+		if kf.Uki != nil {
+			paths = append(paths, kf.Uki.GetPath())
+		}
+
+		for _, p := range paths {
+			var err error
+			signed := true
+			if force {
+				err = kernel.SignFile(p, kp, true, dryRun)
+			} else {
+				signed, err = kernel.SignIfNeeded(p, kp, dryRun)
+			}
+
+			if err != nil {
+				fmt.Println(fmt.Sprintf("Error on sign %s: %s", p, err.Error()))
+				ans = false
+			} else if signed && !dryRun {
+				fmt.Println(fmt.Sprintf("%s signed.", p))
+			}
+		}
+	}
+
+	return ans
+}
+
+func NewSignCommand() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "sign [version]",
+		Short: "Sign kernel images and UKIs for Secure Boot.",
+		Long: `Sign the kernel images and the Unified Kernel Images with the
+db key and certificate for Secure Boot.
+
+The key and the certificate are PEM files configured with the
+secureboot_key and secureboot_cert options of the config file:
+
+  secureboot_key: /etc/mocaccino/secureboot/db.key
+  secureboot_cert: /etc/mocaccino/secureboot/db.crt
+
+The files already signed with the certificate are not signed
+again without --force. The files signed with other certificates
+(for example by the distribution) are refused: with --force
+their signatures are replaced. The signatures are checked with
+mos kernel list and mos kernel verify.
+
+$> mos kernel sign --all
+
+$> mos kernel sign 5.15.0 --ktype vanilla --key db.key --cert db.crt
+
+`,
+		Args: cobra.MaximumNArgs(1),
+		PreRun: func(cmd *cobra.Command, args []string) {
+			all, _ := cmd.Flags().GetBool("all")
+			if !all && len(args) == 0 {
+				fmt.Println("You need to use --all or a kernel version")
+				os.Exit(1)
+			}
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+
+			all, _ := cmd.Flags().GetBool("all")
+			force, _ := cmd.Flags().GetBool("force")
+			dryRun, _ := cmd.Flags().GetBool("dry-run")
+			bootDir, _ := getPathFlag(cmd, "bootdir")
+			ktype, _ := cmd.Flags().GetString("ktype")
+			kernelProfilesDir, _ := getPathFlag(cmd, "kernel-profiles-dir")
+
+			kp, err := loadSecureBootKeyPair(cmd)
+			if err != nil {
+				fmt.Println("Error on load Secure Boot keys: " + err.Error())
+				os.Exit(1)
+			}
+
+			types := []kernelspecs.KernelType{}
+			if kernelProfilesDir != "" {
+				types, _ = profile.LoadKernelProfiles(kernelProfilesDir)
+			}
+			if len(types) == 0 {
+				types = profile.GetDefaultKernelProfiles()
+			}
+
+			bootFiles, err := kernel.ReadBootDir(bootDir, types)
+			if err != nil {
+				fmt.Println("Error on read boot directory: " + err.Error())
+				os.Exit(1)
+			}
+
+			err = kernel.LoadUkiImages(bootFiles, getUkiDir(cmd))
+			if err != nil {
+				fmt.Println("Error on read UKIs: " + err.Error())
+				os.Exit(1)
+			}
+
+			files := bootFiles.Files
+			if !all {
+				kf, err := bootFiles.GetFile(args[0], ktype)
+				if err != nil {
+					fmt.Println(err.Error())
+					os.Exit(1)
+				}
+				files = []*kernelspecs.KernelFiles{kf}
+			}
+
+			if !signKernelFiles(files, bootFiles.Dir, kp, force, dryRun) {
+				os.Exit(1)
+			}
+		},
+	}
+
+	flags := c.Flags()
+	flags.Bool("all", false, "Sign all kernel images and UKIs.")
+	flags.Bool("force", false, "Sign also the files already signed replacing their signatures.")
+	flags.Bool("dry-run", false, "Show the files to sign without sign them.")
+	flags.String("bootdir", "/boot", "Directory where analyze kernel files.")
+	flags.String("ktype", "", "Specify the kernel type of the kernel.")
+	flags.String("kernel-profiles-dir", "/etc/mocaccino/kernels-profiles/",
+		"Specify the directory where read the kernel types profiles supported.")
+	flags.String("esp", "",
+		`Mount point of the EFI System Partition.
+Default is the esp_dir option of the config file or /boot/efi.`)
+	flags.String("uki-dir", "", "Directory of the UKIs. Default is <esp>/EFI/Linux.")
+	addSecureBootFlags(flags)
+
+	return c
+}
@@ -0,0 +1,206 @@
+// Copyright © 2021 Daniele Rondina <geaaru@sabayonlinux.org>
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package cmdkernel
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/MocaccinoOS/mos-cli/pkg/kernel"
+	kernelspecs "github.com/MocaccinoOS/mos-cli/pkg/kernel/specs"
+	"github.com/MocaccinoOS/mos-cli/pkg/profile"
+	"github.com/MocaccinoOS/mos-cli/pkg/secureboot"
+	"github.com/MocaccinoOS/mos-cli/pkg/uki"
+
+	"github.com/spf13/cobra"
+)
+
+// getUkiDir returns the directory of the UKIs of the flag or
+// the EFI/Linux directory of the ESP.
+func getUkiDir(cmd *cobra.Command) string {
+	ukiDir, _ := getPathFlag(cmd, "uki-dir")
+	if ukiDir == "" {
+		ukiDir = kernel.GetUkiDir(getPathFlagOrConfig(cmd, "esp", "esp_dir"))
+	}
+	return ukiDir
+}
+
+func NewUkiCommand() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "uki",
+		Short: "Manage Unified Kernel Images.",
+		Long: `Manage the Unified Kernel Images (UKI) of the kernels.
+
+A UKI is a single EFI binary that contains the EFI stub, the kernel,
+the initrd image, the kernel command line and the os-release.
+`,
+	}
+
+	c.AddCommand(newUkiBuildCommand())
+
+	return c
+}
+
+func newUkiBuildCommand() *cobra.Command {
+	c := &cobra.Command{
+		Use:     "build [version]",
+		Aliases: []string{"b"},
+		Short:   "Build the Unified Kernel Image of a kernel.",
+		Long: `Build the Unified Kernel Image of the kernel with the systemd EFI stub.
+
+The UKI is written under <esp>/EFI/Linux/ with the name of the kernel
+image and the .efi extension. systemd-boot finds it automatically.
+
+The kernel command line is read from /etc/kernel/cmdline. The stub
+could be configured with the uki_stub option of the config file.
+
+$> mos kernel uki build 5.15.0 --ktype vanilla
+
+$> # Build the UKIs of all kernels with a splash image
+$> mos kernel uki build --all --splash /usr/share/mocaccino/splash.bmp
+
+$> mos kernel uki build 5.15.0 --cmdline "root=/dev/sda2 quiet" --dry-run
+
+$> # Build and sign the UKI with the Secure Boot key of the config file
+$> mos kernel uki build 5.15.0 --sign
+
+`,
+		Args: cobra.MaximumNArgs(1),
+		PreRun: func(cmd *cobra.Command, args []string) {
+			all, _ := cmd.Flags().GetBool("all")
+			if !all && len(args) == 0 {
+				fmt.Println("You need to use --all or a kernel version")
+				os.Exit(1)
+			}
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+
+			all, _ := cmd.Flags().GetBool("all")
+			bootDir, _ := getPathFlag(cmd, "bootdir")
+			ktype, _ := cmd.Flags().GetString("ktype")
+			kernelProfilesDir, _ := getPathFlag(cmd, "kernel-profiles-dir")
+			dryRun, _ := cmd.Flags().GetBool("dry-run")
+			cmdline, _ := cmd.Flags().GetString("cmdline")
+			osRelease, _ := getPathFlag(cmd, "os-release")
+			splash, _ := getPathFlag(cmd, "splash")
+			sign, _ := cmd.Flags().GetBool("sign")
+
+			types := []kernelspecs.KernelType{}
+			if kernelProfilesDir != "" {
+				types, _ = profile.LoadKernelProfiles(kernelProfilesDir)
+			}
+			if len(types) == 0 {
+				types = profile.GetDefaultKernelProfiles()
+			}
+
+			bootFiles, err := kernel.ReadBootDir(bootDir, types)
+			if err != nil {
+				fmt.Println("Error on read boot directory: " + err.Error())
+				os.Exit(1)
+			}
+
+			var kp *secureboot.KeyPair
+			if sign {
+				kp, err = loadSecureBootKeyPair(cmd)
+				if err != nil {
+					fmt.Println("Error on load Secure Boot keys: " + err.Error())
+					os.Exit(1)
+				}
+			}
+
+			opts := kernel.NewUkiOptions(getPathFlagOrConfig(cmd, "esp", "esp_dir"))
+			opts.Dir = getUkiDir(cmd)
+			opts.DryRun = dryRun
+			opts.OsRelease = osRelease
+			opts.Splash = splash
+			if stub := getPathFlagOrConfig(cmd, "stub", "uki_stub"); stub != "" {
+				opts.Stub = stub
+			}
+			if cmdline != "" {
+				opts.Cmdline = cmdline
+			}
+
+			files := []*kernelspecs.KernelFiles{}
+			if all {
+				for _, kf := range bootFiles.Files {
+					if kf.Kernel != nil {
+						files = append(files, kf)
+					}
+				}
+			} else {
+				kf, err := bootFiles.GetFile(args[0], ktype)
+				if err != nil {
+					fmt.Println(err.Error())
+					os.Exit(1)
+				}
+				files = append(files, kf)
+			}
+
+			failed := false
+			for _, kf := range files {
+				if kf.Initrd == nil {
+					fmt.Println(fmt.Sprintf("WARN: Kernel %s without initrd image.",
+						kf.Kernel.GetFilename()))
+				}
+
+				u, err := kernel.BuildUki(kf, bootFiles.Dir, opts)
+				if err != nil {
+					fmt.Println(fmt.Sprintf("Error on build UKI for kernel %s: %s",
+						kf.Kernel.GetFilename(), err.Error()))
+					failed = true
+					continue
+				}
+
+				if !dryRun {
+					fmt.Println(fmt.Sprintf("UKI %s created.", u.GetPath()))
+				}
+
+				if sign {
+					err = kernel.SignFile(u.GetPath(), kp, false, dryRun)
+					if err != nil {
+						fmt.Println(fmt.Sprintf("Error on sign UKI %s: %s", u.GetPath(), err.Error()))
+						failed = true
+					}
+				}
+			}
+
+			if failed {
+				os.Exit(1)
+			}
+		},
+	}
+
+	flags := c.Flags()
+	flags.Bool("all", false, "Build the UKIs of all kernels.")
+	flags.Bool("dry-run", false, "Show the UKIs to build without write them.")
+	flags.String("bootdir", "/boot", "Directory where analyze kernel files.")
+	flags.String("ktype", "", "Specify the kernel type of the kernel.")
+	flags.String("kernel-profiles-dir", "/etc/mocaccino/kernels-profiles/",
+		"Specify the directory where read the kernel types profiles supported.")
+	flags.String("esp", "",
+		`Mount point of the EFI System Partition.
+Default is the esp_dir option of the config file or /boot/efi.`)
+	flags.String("uki-dir", "", "Directory where write the UKIs. Default is <esp>/EFI/Linux.")
+	flags.String("stub", "",
+		fmt.Sprintf("EFI stub of the UKI. Default is the uki_stub option of the config file or %s.",
+			uki.GetDefaultStub()))
+	flags.String("cmdline", "", "Kernel command line. Default is the content of /etc/kernel/cmdline.")
+	flags.String("os-release", uki.DefaultOsReleaseFile, "os-release file embedded on the UKI.")
+	flags.String("splash", "", "BMP image showed by the stub on boot.")
+	flags.Bool("sign", false, "Sign the UKI for Secure Boot.")
+	addSecureBootFlags(flags)
+
+	return c
+}
@@ -0,0 +1,185 @@
+// Copyright © 2021 Daniele Rondina <geaaru@sabayonlinux.org>
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package cmdkernel
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/MocaccinoOS/mos-cli/pkg/initrd"
+	"github.com/MocaccinoOS/mos-cli/pkg/kernel"
+	kernelspecs "github.com/MocaccinoOS/mos-cli/pkg/kernel/specs"
+	"github.com/MocaccinoOS/mos-cli/pkg/profile"
+
+	tablewriter "github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+func NewVerifyCommand() *cobra.Command {
+	c := &cobra.Command{
+		Use:     "verify",
+		Aliases: []string{"v"},
+		Short:   "Verify that kernels, initrd images and modules match.",
+		Long: `Checks for every kernel available on boot dir that the modules
+directory exists, that the modules inside the initrd image have the
+same vermagic of the kernel and that the version embedded in the
+kernel image matches with the version of the filename.
+
+If a Secure Boot certificate is configured, the kernel images and
+the UKIs must be signed with the certificate.
+
+The command exits with a non-zero exit code if one of the checks fails.
+
+$> mos kernel verify
+
+$> # Verify only the kernel 5.10.42
+$> mos kernel verify --version 5.10.42 --ktype vanilla
+
+`,
+		Run: func(cmd *cobra.Command, args []string) {
+
+			jsonOutput, _ := cmd.Flags().GetBool("json")
+			bootDir, _ := getPathFlag(cmd, "bootdir")
+			modulesDir, _ := getPathFlag(cmd, "modules-dir")
+			version, _ := cmd.Flags().GetString("version")
+			ktype, _ := cmd.Flags().GetString("ktype")
+			kernelProfilesDir, _ := getPathFlag(cmd, "kernel-profiles-dir")
+
+			types := []kernelspecs.KernelType{}
+			if kernelProfilesDir != "" {
+				types, _ = profile.LoadKernelProfiles(kernelProfilesDir)
+			}
+			if len(types) == 0 {
+				types = profile.GetDefaultKernelProfiles()
+			}
+
+			bootFiles, err := kernel.ReadBootDir(bootDir, types)
+			if err != nil {
+				fmt.Println("Error on read boot directory: " + err.Error())
+				os.Exit(1)
+			}
+
+			files := bootFiles.Files
+			if version != "" {
+				kf, err := bootFiles.GetFile(version, ktype)
+				if err != nil {
+					fmt.Println(err.Error())
+					os.Exit(1)
+				}
+				files = []*kernelspecs.KernelFiles{kf}
+			}
+
+			trusted, err := loadTrustedCerts(cmd)
+			if err != nil {
+				fmt.Println("Error on load Secure Boot certificate: " + err.Error())
+				os.Exit(1)
+			}
+			if len(trusted) > 0 {
+				err = kernel.LoadUkiImages(bootFiles, getUkiDir(cmd))
+				if err != nil {
+					fmt.Println("Error on read UKIs: " + err.Error())
+					os.Exit(1)
+				}
+			}
+
+			results := []*kernel.VerifyResult{}
+			valid := true
+			for _, kf := range files {
+				r := kernel.VerifyKernelFiles(kf, bootFiles.Dir, modulesDir)
+				if len(trusted) > 0 {
+					r.VerifySignatures(kf, bootFiles.Dir, trusted)
+				}
+				if !r.IsValid() {
+					valid = false
+				}
+				results = append(results, r)
+			}
+
+			if jsonOutput {
+				data, err := json.Marshal(results)
+				if err != nil {
+					fmt.Println(fmt.Errorf("Error on convert data to json: %s", err.Error()))
+					os.Exit(1)
+				}
+				fmt.Println(string(data))
+			} else {
+
+				if len(results) == 0 {
+					fmt.Println("No kernel files available.")
+					os.Exit(0)
+				}
+
+				table := tablewriter.NewWriter(os.Stdout)
+				table.SetBorders(tablewriter.Border{
+					Left: true, Top: false, Right: true, Bottom: false,
+				})
+				table.SetCenterSeparator("|")
+				table.SetAutoWrapText(false)
+				table.SetHeader([]string{
+					"Kernel",
+					"Initrd",
+					"Status",
+					"Details",
+				})
+
+				for _, r := range results {
+					status := "ok"
+					if !r.IsValid() {
+						status = "error"
+					}
+
+					details := []string{}
+					for _, e := range r.Errors {
+						details = append(details, "ERROR: "+e)
+					}
+					for _, w := range r.Warnings {
+						details = append(details, "WARN: "+w)
+					}
+
+					table.Append([]string{
+						r.Kernel,
+						r.Initrd,
+						status,
+						strings.Join(details, "\n"),
+					})
+				}
+
+				table.Render()
+			}
+
+			if !valid {
+				os.Exit(1)
+			}
+		},
+	}
+
+	flags := c.Flags()
+	flags.Bool("json", false, "JSON output")
+	flags.String("bootdir", "/boot", "Directory where analyze kernel files.")
+	flags.String("modules-dir", initrd.DefaultModulesDir,
+		"Directory where the kernel modules are installed.")
+	flags.String("version", "", "Specify the kernel version to verify.")
+	flags.String("ktype", "", "Specify the kernel type to verify.")
+	flags.String("kernel-profiles-dir", "/etc/mocaccino/kernels-profiles/",
+		"Specify the directory where read the kernel types profiles supported.")
+	flags.String("cert", "",
+		`Certificate used to check the Secure Boot signatures.
+Default is the secureboot_cert option of the config file.`)
+	flags.String("uki-dir", "", "Directory of the UKIs. Default is <esp>/EFI/Linux.")
+
+	return c
+}
@@ -34,5 +34,6 @@ func init() {
 	kernelSwitcherCmd.AddCommand(
 		kernelswitcher.NewSwitchcommand(),
 		kernelswitcher.NewListcommand(),
+		kernelswitcher.NewFinalizecommand(),
 	)
 }
@@ -0,0 +1,53 @@
+/*
+Copyright © 2021 Ettore Di Giacinto <mudler@sabayon.org>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kernelswitcher
+
+import (
+	kernelspecs "github.com/MocaccinoOS/mos-cli/pkg/kernel/specs"
+	"github.com/MocaccinoOS/mos-cli/pkg/profile"
+	"github.com/MocaccinoOS/mos-cli/pkg/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+func addKernelFamiliesFlags(flags *pflag.FlagSet) {
+	flags.String("kernel-families-dir", "/etc/mocaccino/kernels-families/",
+		"Specify the directory where read the kernel families with the packages of every kernel.")
+}
+
+// getKernelFamilies returns the kernel families of the directory
+// or the default families if the directory is empty.
+func getKernelFamilies(cmd *cobra.Command) []kernelspecs.KernelFamily {
+	dir, _ := cmd.Flags().GetString("kernel-families-dir")
+
+	families := []kernelspecs.KernelFamily{}
+	if dir != "" {
+		families, _ = profile.LoadKernelFamilies(utils.RootPath(dir))
+	}
+	if len(families) == 0 {
+		families = profile.GetDefaultKernelFamilies()
+	}
+
+	return families
+}
+
+// isMicroRelease returns true if the system uses the initramfs
+// packages.
+func isMicroRelease() bool {
+	release, _ := utils.OsRelease()
+	return release == "micro" || release == "micro-embedded"
+}
@@ -0,0 +1,94 @@
+/*
+Copyright © 2021 Ettore Di Giacinto <mudler@sabayon.org>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kernelswitcher
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/MocaccinoOS/mos-cli/pkg/kernel"
+	"github.com/MocaccinoOS/mos-cli/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+func NewFinalizecommand() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "finalize",
+		Short: "Remove the kernels kept by switch --keep-current",
+		Long: `Remove the packages of the previous kernels kept by a switch
+with --keep-current and update the bootloader configuration.
+
+The new kernel must be the running kernel: reboot with the new
+kernel before the finalize or use --force. The previous kernels
+must not be pinned: unpin them with mos kernel unpin.
+
+$ mos kernel-switcher switch kernel/mocaccino-full --keep-current
+
+$ mos kernel-switcher finalize
+`,
+		Run: func(cmd *cobra.Command, args []string) {
+
+			force, _ := cmd.Flags().GetBool("force")
+			bootDir, _ := cmd.Flags().GetString("bootdir")
+			kernelProfilesDir, _ := cmd.Flags().GetString("kernel-profiles-dir")
+			pinsFile, _ := cmd.Flags().GetString("pins-file")
+			stateFile, _ := cmd.Flags().GetString("state-file")
+
+			state, err := kernel.LoadSwitchState(utils.RootPath(stateFile))
+			if err != nil {
+				log.Fatal(err)
+			}
+			if state == nil || state.GetStep(kernel.SwitchStepFinalize) == nil {
+				log.Fatal("No kernel switch to finalize.")
+			}
+
+			pm, err := getPackageManager(cmd)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			runner := &switchRunner{
+				cmd:               cmd,
+				pm:                pm,
+				bootDir:           utils.RootPath(bootDir),
+				kernelProfilesDir: utils.RootPath(kernelProfilesDir),
+				pinsFile:          utils.RootPath(pinsFile),
+				out:               os.Stdout,
+			}
+
+			err = runner.Finalize(state, force)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			fmt.Println("Kernel switched to", state.Plan.Kernel)
+		},
+	}
+
+	flags := c.Flags()
+	flags.Bool("force", false, "Finalize also if the new kernel is not the running kernel.")
+	flags.String("bootdir", "/boot", "Directory where analyze kernel files.")
+	flags.String("kernel-profiles-dir", "/etc/mocaccino/kernels-profiles/",
+		"Specify the directory where read the kernel types profiles supported.")
+	flags.String("pins-file", kernel.DefaultPinsFile, "State file of the pinned kernels.")
+	flags.String("state-file", kernel.DefaultSwitchStateFile,
+		"State file of the steps of the kernel switch.")
+	addPackageManagerFlags(flags)
+
+	return c
+}
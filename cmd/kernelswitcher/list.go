@@ -21,6 +21,7 @@ import (
 	"log"
 
 	"github.com/MocaccinoOS/mos-cli/pkg/kernel"
+	kernelspecs "github.com/MocaccinoOS/mos-cli/pkg/kernel/specs"
 	"github.com/spf13/cobra"
 )
 
@@ -38,17 +39,26 @@ $ mos kernel-switcher switch kernel/mocaccino-full
 `,
 		Run: func(cmd *cobra.Command, args []string) {
 
-			allKernelsPackages, err := kernel.All()
+			pm, err := getPackageManager(cmd)
 			if err != nil {
 				log.Fatal(err)
 			}
-			installed, err := kernel.Installed()
+
+			allKernelsPackages, err := kernel.All(pm)
+			if err != nil {
+				log.Fatal(err)
+			}
+			installed, err := kernel.Installed(pm)
 			if err != nil {
 				log.Fatal(err)
 			}
-			kernels := allKernelsPackages.FilterByName("firmware").FilterByName("initramfs").FilterByName("minimal").FilterByName("modules").FilterByName("sources")
+			// Only the kernel packages of the families are showed.
+			families := getKernelFamilies(cmd)
+			for _, j := range allKernelsPackages.Packages {
+				if kernelspecs.GetKernelFamily(families, j.String()) == nil {
+					continue
+				}
 
-			for _, j := range kernels.Packages {
 				install := ""
 				for _, k := range installed.Packages {
 					if k.EqualNoV(j) {
@@ -60,5 +70,8 @@ $ mos kernel-switcher switch kernel/mocaccino-full
 		},
 	}
 
+	addPackageManagerFlags(c.Flags())
+	addKernelFamiliesFlags(c.Flags())
+
 	return c
 }
@@ -0,0 +1,45 @@
+/*
+Copyright © 2021 Ettore Di Giacinto <mudler@sabayon.org>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kernelswitcher
+
+import (
+	"github.com/MocaccinoOS/mos-cli/pkg/pkgmanager"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+func addPackageManagerFlags(flags *pflag.FlagSet) {
+	flags.String("package-manager", "",
+		`Package manager used to search and install the kernels: luet.
+Default is the package_manager option of the config file or luet.`)
+}
+
+func getFlagOrConfig(cmd *cobra.Command, flag, option string) string {
+	ans, _ := cmd.Flags().GetString(flag)
+	if ans == "" {
+		ans = viper.GetString(option)
+	}
+	return ans
+}
+
+// getPackageManager returns the package manager of the flags or of
+// the config file.
+func getPackageManager(cmd *cobra.Command) (pkgmanager.PackageManager, error) {
+	return pkgmanager.NewPackageManager(
+		getFlagOrConfig(cmd, "package-manager", "package_manager"))
+}
@@ -0,0 +1,278 @@
+/*
+Copyright © 2021 Ettore Di Giacinto <mudler@sabayon.org>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kernelswitcher
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/MocaccinoOS/mos-cli/pkg/kernel"
+	kernelspecs "github.com/MocaccinoOS/mos-cli/pkg/kernel/specs"
+	"github.com/MocaccinoOS/mos-cli/pkg/pkgmanager"
+	"github.com/MocaccinoOS/mos-cli/pkg/profile"
+	"github.com/MocaccinoOS/mos-cli/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+// switchRunner executes the steps of a kernel switch. The steps
+// after the packages replace use the mos kernel commands.
+type switchRunner struct {
+	cmd               *cobra.Command
+	pm                pkgmanager.PackageManager
+	bootDir           string
+	kernelProfilesDir string
+	pinsFile          string
+	// Output of the steps and of the commands.
+	out io.Writer
+}
+
+// runMosCommand runs a mos command as child process with the
+// global options of the current command.
+func (r *switchRunner) runMosCommand(args ...string) error {
+	binary, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	globals := []string{}
+	if f := r.cmd.Flag("config"); f != nil && f.Changed {
+		globals = append(globals, "--config", f.Value.String())
+	}
+	if f := r.cmd.Flag("debug"); f != nil && f.Changed {
+		globals = append(globals, "--debug")
+	}
+	if utils.HasRootDir() {
+		globals = append(globals, "--root", utils.GetRootDir())
+	}
+
+	c := exec.Command(binary, append(globals, args...)...)
+	c.Stdin = os.Stdin
+	c.Stdout = r.out
+	c.Stderr = os.Stderr
+
+	return c.Run()
+}
+
+// getKernelFiles returns the kernel files of the new kernel read
+// from the boot dir.
+func (r *switchRunner) getKernelFiles(plan *kernel.SwitchPlan) (*kernelspecs.KernelFiles, error) {
+	types, _ := profile.LoadKernelProfiles(r.kernelProfilesDir)
+	if len(types) == 0 {
+		types = profile.GetDefaultKernelProfiles()
+	}
+
+	bootFiles, err := kernel.ReadBootDir(r.bootDir, types)
+	if err != nil {
+		return nil, err
+	}
+
+	return plan.GetKernelFiles(bootFiles)
+}
+
+// getPackages returns the packages in input that are installed or
+// not installed.
+func (r *switchRunner) getPackages(packages []*kernel.SwitchPackage, installed bool) ([]string, error) {
+	ans := []string{}
+
+	res, err := kernel.Installed(r.pm)
+	if err != nil {
+		return ans, err
+	}
+
+	for _, p := range packages {
+		found := false
+		for _, i := range res.Packages {
+			if i.EqualS(p.Package) {
+				found = true
+				break
+			}
+		}
+		if found == installed {
+			ans = append(ans, p.Package)
+		}
+	}
+
+	return ans, nil
+}
+
+// replacePackages replaces the packages of the plan not yet
+// replaced by a previous run of the step. With keep current the
+// packages are only installed.
+func (r *switchRunner) replacePackages(plan *kernel.SwitchPlan) (string, error) {
+	install, err := r.getPackages(plan.Install, false)
+	if err != nil {
+		return "", err
+	}
+
+	remove := []string{}
+	if !plan.KeepCurrent {
+		remove, err = r.getPackages(plan.Remove, true)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if len(remove) == 0 && len(install) == 0 {
+		return kernel.SwitchStepDone, nil
+	} else if len(remove) == 0 {
+		return kernel.SwitchStepDone, r.pm.Install(install)
+	}
+
+	return kernel.SwitchStepDone, r.pm.Replace(remove, install)
+}
+
+func (r *switchRunner) runStep(plan *kernel.SwitchPlan, step string) (string, error) {
+	if step == kernel.SwitchStepPackages {
+		return r.replacePackages(plan)
+	}
+
+	if step == kernel.SwitchStepBootloader {
+		return kernel.SwitchStepDone, r.runMosCommand("kernel", "update-bootloader",
+			"--bootdir", r.bootDir, "--kernel-profiles-dir", r.kernelProfilesDir)
+	}
+
+	// Micro releases use the initrd of the initramfs package.
+	if step == kernel.SwitchStepInitrd && isMicroRelease() {
+		return kernel.SwitchStepSkipped, nil
+	}
+
+	kf, err := r.getKernelFiles(plan)
+	if err != nil {
+		return "", err
+	}
+	version := kf.Kernel.GetVersion()
+	ktype := kf.Kernel.GetType()
+
+	switch step {
+	case kernel.SwitchStepInitrd:
+		return kernel.SwitchStepDone, r.runMosCommand("kernel", "geninitrd",
+			"--version", version, "--ktype", ktype,
+			"--bootdir", r.bootDir, "--kernel-profiles-dir", r.kernelProfilesDir)
+	case kernel.SwitchStepLinks:
+		return kernel.SwitchStepDone, r.runMosCommand("kernel", "set-default", version,
+			"--ktype", ktype, "--bootdir", r.bootDir, "--kernel-profiles-dir", r.kernelProfilesDir)
+	default:
+		return "", errors.New(fmt.Sprintf("Unsupported switch step %s", step))
+	}
+}
+
+// Run executes the pending steps of the switch. The state file is
+// updated after every step and removed when all steps are completed.
+// The finalize step is executed only by the finalize command.
+func (r *switchRunner) Run(state *kernel.SwitchState) error {
+	for _, step := range state.GetPendingSteps() {
+		if step.Name == kernel.SwitchStepFinalize {
+			fmt.Fprintln(r.out, fmt.Sprintf(
+				">> [%s] waiting the boot of the new kernel. Then run mos kernel-switcher finalize.",
+				step.Name))
+			return nil
+		}
+
+		fmt.Fprintln(r.out, fmt.Sprintf(">> [%s] running...", step.Name))
+
+		status, err := r.runStep(state.Plan, step.Name)
+		if err != nil {
+			if werr := state.SetStepStatus(step, kernel.SwitchStepFailed, err); werr != nil {
+				fmt.Fprintln(os.Stderr, "Error on write switch state: "+werr.Error())
+			}
+			return errors.New(fmt.Sprintf("Step %s failed: %s", step.Name, err.Error()))
+		}
+
+		if err := state.SetStepStatus(step, status, nil); err != nil {
+			return err
+		}
+		fmt.Fprintln(r.out, fmt.Sprintf(">> [%s] %s", step.Name, status))
+	}
+
+	return state.Remove()
+}
+
+// Finalize removes the packages of the previous kernels kept by the
+// switch. The new kernel must be the running kernel unless force
+// is used.
+func (r *switchRunner) Finalize(state *kernel.SwitchState, force bool) error {
+	step := state.GetStep(kernel.SwitchStepFinalize)
+	if step == nil || !state.IsFinalizePending() {
+		return errors.New("The kernel switch is not completed. Resume it with mos kernel-switcher switch --resume.")
+	}
+
+	kf, err := r.getKernelFiles(state.Plan)
+	if err != nil {
+		return err
+	}
+
+	if !force {
+		running, err := kernel.GetRunningRelease()
+		if err != nil {
+			return err
+		}
+
+		release := kf.Kernel.GetKernelRelease()
+		if running != release && running != kf.Kernel.GetBinaryRelease() {
+			return errors.New(fmt.Sprintf(
+				"The running kernel %s is not the new kernel %s. Reboot with the new kernel or use --force.",
+				running, release))
+		}
+	}
+
+	// The pins could be added after the switch. The pin of the
+	// new kernel doesn't block the removal of the previous kernels.
+	pinned, err := getPinnedKernels(r.bootDir, r.kernelProfilesDir, r.pinsFile)
+	if err != nil {
+		return err
+	}
+	previous := []string{}
+	for _, p := range pinned {
+		if p != kf.Kernel.GetFilename() {
+			previous = append(previous, p)
+		}
+	}
+	if len(previous) > 0 {
+		return errors.New(fmt.Sprintf(
+			"Kernels %s are pinned. Unpin them with mos kernel unpin before the finalize.",
+			strings.Join(previous, ", ")))
+	}
+
+	fmt.Fprintln(r.out, fmt.Sprintf(">> [%s] running...", step.Name))
+
+	remove, err := r.getPackages(state.Plan.Remove, true)
+	if err == nil && len(remove) > 0 {
+		err = r.pm.Uninstall(remove)
+	}
+	if err == nil {
+		// The entries of the removed kernels are dropped.
+		err = r.runMosCommand("kernel", "update-bootloader",
+			"--bootdir", r.bootDir, "--kernel-profiles-dir", r.kernelProfilesDir)
+	}
+	if err != nil {
+		if werr := state.SetStepStatus(step, kernel.SwitchStepFailed, err); werr != nil {
+			fmt.Fprintln(os.Stderr, "Error on write switch state: "+werr.Error())
+		}
+		return errors.New(fmt.Sprintf("Step %s failed: %s", step.Name, err.Error()))
+	}
+
+	if err := state.SetStepStatus(step, kernel.SwitchStepDone, nil); err != nil {
+		return err
+	}
+	fmt.Fprintln(r.out, fmt.Sprintf(">> [%s] %s", step.Name, kernel.SwitchStepDone))
+
+	return state.Remove()
+}
@@ -17,14 +17,16 @@ limitations under the License.
 package kernelswitcher
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
 	"strings"
-	"syscall"
 
 	"github.com/MocaccinoOS/mos-cli/pkg/kernel"
+	kernelspecs "github.com/MocaccinoOS/mos-cli/pkg/kernel/specs"
+	"github.com/MocaccinoOS/mos-cli/pkg/profile"
+	"github.com/MocaccinoOS/mos-cli/pkg/utils"
 	"github.com/spf13/cobra"
 )
 
@@ -35,18 +37,138 @@ func NewSwitchcommand() *cobra.Command {
 		Long: `Switch to a kernel and prompt for confirmation
 
 $ mos kernel-switcher switch kernel/mocaccino-lts-full
+
+The plan of the switch with the packages to remove and to install,
+the expected files on boot dir and the new default kernel is
+printed before the confirmation. Use --dry-run to only show the
+plan and --yes to skip the confirmation:
+
+$ mos kernel-switcher switch kernel/mocaccino-full --dry-run --json
+
+$ mos kernel-switcher switch kernel/mocaccino-full --yes
+
+The kernel could be the package or the name of a kernel family.
+The families define the companion packages (modules, initramfs,
+sources and firmware) installed with the kernel and are read from
+the kernel families directory:
+
+  name: mocaccino-lts
+  package: kernel/mocaccino-lts-full
+  modules: kernel/mocaccino-lts-modules
+  initramfs: kernel/mocaccino-lts-initramfs
+  sources: kernel/mocaccino-lts-sources
+  kernel_type: vanilla
+
+$ mos kernel-switcher switch mocaccino
+
+Examples of families are available on contrib/kernel-families. A
+package without family is switched without companion packages.
+
+After the packages replace, the initrd image of the new kernel is
+generated (except on micro releases), the bzImage and Initrd links
+are set to the new kernel and the bootloader configuration is
+updated. The status of every step is stored on the state file.
+If a step fails, fix the error and resume the switch from the
+failed step:
+
+$ mos kernel-switcher switch --resume
+
+With --keep-current the new kernel is installed alongside the current
+kernels and it's set as default. The current kernels stay bootable
+as fallback and their packages are removed with the finalize command
+after the boot of the new kernel:
+
+$ mos kernel-switcher switch kernel/mocaccino-full --keep-current
+
+$ mos kernel-switcher finalize
 `,
+		Args: cobra.MaximumNArgs(1),
+		PreRun: func(cmd *cobra.Command, args []string) {
+			resume, _ := cmd.Flags().GetBool("resume")
+			if !resume && len(args) == 0 {
+				log.Fatal("You need to supply the kernel or --resume")
+			}
+		},
 		Run: func(cmd *cobra.Command, args []string) {
 
-			argkernel := args[0]
+			resume, _ := cmd.Flags().GetBool("resume")
+			jsonOutput, _ := cmd.Flags().GetBool("json")
+			dryRun, _ := cmd.Flags().GetBool("dry-run")
+			yes, _ := cmd.Flags().GetBool("yes")
+			keepCurrent, _ := cmd.Flags().GetBool("keep-current")
+			bootDir, _ := cmd.Flags().GetString("bootdir")
+			pinsFile, _ := cmd.Flags().GetString("pins-file")
+			kernelProfilesDir, _ := cmd.Flags().GetString("kernel-profiles-dir")
+			stateFile, _ := cmd.Flags().GetString("state-file")
+			bootDir = utils.RootPath(bootDir)
+			pinsFile = utils.RootPath(pinsFile)
+			kernelProfilesDir = utils.RootPath(kernelProfilesDir)
+			stateFile = utils.RootPath(stateFile)
+
+			if jsonOutput && !dryRun && !yes {
+				log.Fatal("JSON output requires --dry-run or --yes")
+			}
+
+			pm, err := getPackageManager(cmd)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			runner := &switchRunner{
+				cmd:               cmd,
+				pm:                pm,
+				bootDir:           bootDir,
+				kernelProfilesDir: kernelProfilesDir,
+				pinsFile:          pinsFile,
+				out:               os.Stdout,
+			}
+			// The JSON output contains only the plan.
+			if jsonOutput {
+				runner.out = os.Stderr
+			}
+
+			state, err := kernel.LoadSwitchState(stateFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			if resume {
+				if state == nil {
+					log.Fatal("No kernel switch to resume.")
+				}
+				fmt.Fprintln(runner.out, "Resuming switch to kernel", state.Plan.Kernel)
+				if dryRun {
+					return
+				}
+				if err := runner.Run(state); err != nil {
+					log.Fatal(err)
+				}
+				printSwitchCompleted(runner, state)
+				return
+			} else if state != nil && state.IsFinalizePending() {
+				log.Fatal(fmt.Sprintf(
+					"The switch to kernel %s is waiting the finalize. Run mos kernel-switcher finalize after the boot of the new kernel.",
+					state.Plan.Kernel))
+			} else if state != nil {
+				log.Fatal(fmt.Sprintf(
+					"The switch to kernel %s is not completed. Resume it with mos kernel-switcher switch --resume.",
+					state.Plan.Kernel))
+			}
 
-			fmt.Println("Switching to kernel", argkernel)
+			families := getKernelFamilies(cmd)
+			family := kernelspecs.GetKernelFamily(families, args[0])
+			if family == nil {
+				fmt.Fprintln(runner.out, fmt.Sprintf(
+					"No kernel family defined for %s: only the kernel package is installed.", args[0]))
+				family = kernelspecs.NewPackageKernelFamily(args[0])
+			}
+			argkernel := family.Package
 
-			allKernelsPackages, err := kernel.All()
+			allKernelsPackages, err := kernel.All(pm)
 			if err != nil {
 				log.Fatal(err)
 			}
-			installed, err := kernel.Installed()
+			installed, err := kernel.Installed(pm)
 			if err != nil {
 				log.Fatal(err)
 			}
@@ -67,24 +189,151 @@ $ mos kernel-switcher switch kernel/mocaccino-lts-full
 				log.Fatal("Provided kernel not found")
 			}
 
-			binary, lookErr := exec.LookPath("luet")
-			if lookErr != nil {
-				panic(lookErr)
+			// The switch replaces all installed kernels. The pinned
+			// kernels must be preserved. With keep current the kernels
+			// are removed by the finalize that checks the pins.
+			if !keepCurrent {
+				pinned, err := getPinnedKernels(bootDir, kernelProfilesDir, pinsFile)
+				if err != nil {
+					log.Fatal(err)
+				}
+				if len(pinned) > 0 {
+					log.Fatal(fmt.Sprintf(
+						"Kernels %s are pinned. Unpin them with mos kernel unpin before switching.",
+						strings.Join(pinned, ", ")))
+				}
 			}
 
-			cmdargs := []string{"luet", "replace", "--nodeps", "--for", argkernel, "--for", fmt.Sprintf("%smodules", strings.ReplaceAll(argkernel, "full", ""))}
-			for _, i := range installed.Packages {
-				cmdargs = append(cmdargs, fmt.Sprintf("%s/%s", i.Category, i.Name))
+			types, _ := profile.LoadKernelProfiles(kernelProfilesDir)
+			if len(types) == 0 {
+				types = profile.GetDefaultKernelProfiles()
 			}
 
-			env := os.Environ()
+			plan := kernel.NewSwitchPlan(families, allKernelsPackages, installed,
+				family, types, isMicroRelease())
+			plan.KeepCurrent = keepCurrent
 
-			execErr := syscall.Exec(binary, cmdargs, env)
-			if execErr != nil {
-				panic(execErr)
+			if jsonOutput {
+				data, err := json.Marshal(plan)
+				if err != nil {
+					log.Fatal(fmt.Sprintf("Error on convert data to json: %s", err.Error()))
+				}
+				fmt.Println(string(data))
+			} else {
+				printSwitchPlan(plan)
+			}
+
+			if dryRun {
+				return
+			}
+
+			if !yes && !utils.Ask("Do you want to switch to kernel "+argkernel) {
+				fmt.Println("Kernel switch cancelled.")
+				return
+			}
+
+			state = kernel.NewSwitchState(stateFile, plan)
+			err = state.Write()
+			if err != nil {
+				log.Fatal(fmt.Sprintf("Error on write switch state: %s", err.Error()))
+			}
+
+			err = runner.Run(state)
+			if err != nil {
+				log.Fatal(fmt.Sprintf(
+					"%s. Fix the error and resume the switch with mos kernel-switcher switch --resume.",
+					err.Error()))
 			}
+
+			printSwitchCompleted(runner, state)
 		},
 	}
 
+	flags := c.Flags()
+	flags.String("bootdir", "/boot", "Directory where analyze kernel files.")
+	flags.String("kernel-profiles-dir", "/etc/mocaccino/kernels-profiles/",
+		"Specify the directory where read the kernel types profiles supported.")
+	flags.String("pins-file", kernel.DefaultPinsFile, "State file of the pinned kernels.")
+	flags.Bool("json", false, "JSON output of the switch plan.")
+	flags.Bool("dry-run", false, "Show the switch plan without switch the kernel.")
+	flags.BoolP("yes", "y", false, "Switch the kernel without confirmation.")
+	flags.Bool("keep-current", false,
+		"Install the new kernel alongside the current kernels. Remove them later with finalize.")
+	flags.Bool("resume", false, "Resume a kernel switch not completed from the failed step.")
+	flags.String("state-file", kernel.DefaultSwitchStateFile,
+		"State file of the steps of the kernel switch.")
+	addPackageManagerFlags(flags)
+	addKernelFamiliesFlags(flags)
+
 	return c
 }
+
+func getPinnedKernels(bootDir, kernelProfilesDir, pinsFile string) ([]string, error) {
+	ans := []string{}
+
+	types, _ := profile.LoadKernelProfiles(kernelProfilesDir)
+	if len(types) == 0 {
+		types = profile.GetDefaultKernelProfiles()
+	}
+
+	bootFiles, err := kernel.ReadBootDir(bootDir, types)
+	if err != nil {
+		return ans, err
+	}
+
+	_, err = kernel.LoadPinnedBootFiles(bootFiles, pinsFile)
+	if err != nil {
+		return ans, err
+	}
+
+	for _, kf := range bootFiles.Files {
+		if kf.Pinned && kf.Kernel != nil {
+			ans = append(ans, kf.Kernel.GetFilename())
+		}
+	}
+
+	return ans, nil
+}
+
+func printSwitchPackages(title, sign string, packages []*kernel.SwitchPackage) {
+	if len(packages) == 0 {
+		return
+	}
+	fmt.Println(title)
+	for _, p := range packages {
+		if p.Version != "" {
+			fmt.Println(fmt.Sprintf("  %s %s (%s)", sign, p.Package, p.Version))
+		} else {
+			fmt.Println(fmt.Sprintf("  %s %s", sign, p.Package))
+		}
+	}
+}
+
+func printSwitchPlan(plan *kernel.SwitchPlan) {
+	fmt.Println(fmt.Sprintf("Switching to kernel %s (family %s)", plan.Kernel, plan.Family))
+	if plan.KeepCurrent {
+		printSwitchPackages("Packages to remove on finalize:", "-", plan.Remove)
+	} else {
+		printSwitchPackages("Packages to remove:", "-", plan.Remove)
+	}
+	printSwitchPackages("Packages to install:", "+", plan.Install)
+	if len(plan.BootFiles) > 0 {
+		fmt.Println("Expected boot files:")
+		for _, f := range plan.BootFiles {
+			fmt.Println("  " + f)
+		}
+	}
+	if plan.Default != "" {
+		fmt.Println("Default kernel: " + plan.Default)
+	}
+}
+
+func printSwitchCompleted(r *switchRunner, state *kernel.SwitchState) {
+	if state.IsFinalizePending() {
+		fmt.Fprintln(r.out, fmt.Sprintf(
+			"Kernel %s installed alongside the current kernels. Run mos kernel-switcher finalize after the boot of the new kernel.",
+			state.Plan.Kernel))
+	} else {
+		fmt.Fprintln(r.out, "Kernel switched to", state.Plan.Kernel)
+	}
+}
@@ -18,6 +18,7 @@ package profile
 
 import (
 	profile "github.com/MocaccinoOS/mos-cli/pkg/profile"
+	"github.com/MocaccinoOS/mos-cli/pkg/utils"
 	"github.com/spf13/cobra"
 )
 
@@ -25,7 +26,10 @@ func profileHandler(cmd *cobra.Command) profile.ProfileHandler {
 	activeDirectory, _ := cmd.Flags().GetString("active-directory")
 	profileDirectory, _ := cmd.Flags().GetString("profile-directory")
 
-	return profile.ProfileHandler{ActiveDirectory: activeDirectory, ProfileDirectory: profileDirectory}
+	return profile.ProfileHandler{
+		ActiveDirectory:  utils.RootPath(activeDirectory),
+		ProfileDirectory: utils.RootPath(profileDirectory),
+	}
 }
 
 func profileHandlerFlags(cmd *cobra.Command) {
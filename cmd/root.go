@@ -19,6 +19,7 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 
 	. "github.com/MocaccinoOS/mos-cli/pkg/logger"
@@ -66,6 +67,16 @@ $ mos profile disable profile1
 		if debug {
 			viper.Set("debug", debug)
 		}
+
+		root, _ := cmd.Flags().GetString("root")
+		if root != "" {
+			root, err := filepath.Abs(root)
+			if err != nil {
+				fmt.Println("Invalid root directory: " + err.Error())
+				os.Exit(1)
+			}
+			viper.Set("root", root)
+		}
 	},
 }
 
@@ -82,6 +93,10 @@ func init() {
 
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.mos.yaml)")
 	rootCmd.PersistentFlags().BoolP("debug", "d", false, "Enable debug logging.")
+	rootCmd.PersistentFlags().String("root", "",
+		`System root where mos works. All the paths are relative to the system root
+and the external tools are executed inside it. The Secure Boot keys are
+read from the host.`)
 
 }
 
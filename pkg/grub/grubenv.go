@@ -0,0 +1,183 @@
+// Copyright © 2021 Daniele Rondina, geaaru@sabayonlinux.org
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package grub
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const (
+	// The GRUB environment block is a file of 1024 bytes with
+	// the header, the key=value lines and a padding of '#'.
+	GrubEnvSize   = 1024
+	GrubEnvHeader = "# GRUB Environment Block\n"
+
+	GrubEnvSavedEntry = "saved_entry"
+	GrubEnvNextEntry  = "next_entry"
+)
+
+// GrubEnv is the GRUB environment block.
+type GrubEnv struct {
+	File string
+	// Keys contains the variables in the order of the file.
+	Keys   []string
+	Values map[string]string
+}
+
+func NewGrubEnv(file string) *GrubEnv {
+	return &GrubEnv{
+		File:   file,
+		Keys:   []string{},
+		Values: make(map[string]string),
+	}
+}
+
+// ReadGrubEnv reads the environment block file. A not existing
+// file returns an empty environment.
+func ReadGrubEnv(file string) (*GrubEnv, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return NewGrubEnv(file), nil
+		}
+		return nil, err
+	}
+
+	ans, err := ParseGrubEnv(data)
+	if err != nil {
+		return nil, errors.New(
+			fmt.Sprintf("Error on parse %s: %s", file, err.Error()))
+	}
+	ans.File = file
+
+	return ans, nil
+}
+
+// ParseGrubEnv parses the content of an environment block.
+func ParseGrubEnv(data []byte) (*GrubEnv, error) {
+	ans := NewGrubEnv("")
+
+	if !bytes.HasPrefix(data, []byte(GrubEnvHeader)) {
+		return nil, errors.New("Invalid environment block header")
+	}
+	if len(data) != GrubEnvSize {
+		return nil, errors.New(
+			fmt.Sprintf("Invalid environment block size %d", len(data)))
+	}
+
+	data = data[len(GrubEnvHeader):]
+
+	for len(data) > 0 {
+		// Read a line with the escaped characters
+		line := []byte{}
+		i := 0
+		for ; i < len(data) && data[i] != '\n'; i++ {
+			if data[i] == '\\' && i+1 < len(data) {
+				i++
+			}
+			line = append(line, data[i])
+		}
+		data = data[min(i+1, len(data)):]
+
+		if len(line) == 0 || line[0] == '#' {
+			// Padding or comments
+			continue
+		}
+
+		kv := strings.SplitN(string(line), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		ans.Set(kv[0], kv[1])
+	}
+
+	return ans, nil
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func (e *GrubEnv) Get(key string) string { return e.Values[key] }
+
+func (e *GrubEnv) Set(key, value string) {
+	if _, ok := e.Values[key]; !ok {
+		e.Keys = append(e.Keys, key)
+	}
+	e.Values[key] = value
+}
+
+func (e *GrubEnv) Unset(key string) {
+	if _, ok := e.Values[key]; !ok {
+		return
+	}
+	delete(e.Values, key)
+	for idx, k := range e.Keys {
+		if k == key {
+			e.Keys = append(e.Keys[:idx], e.Keys[idx+1:]...)
+			break
+		}
+	}
+}
+
+// Bytes returns the environment block padded to 1024 bytes.
+func (e *GrubEnv) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(GrubEnvHeader)
+
+	for _, k := range e.Keys {
+		buf.WriteString(k)
+		buf.WriteByte('=')
+		for _, c := range []byte(e.Values[k]) {
+			if c == '\\' || c == '\n' {
+				buf.WriteByte('\\')
+			}
+			buf.WriteByte(c)
+		}
+		buf.WriteByte('\n')
+	}
+
+	if buf.Len() > GrubEnvSize {
+		return nil, errors.New("Environment block too big")
+	}
+
+	buf.Write(bytes.Repeat([]byte{'#'}, GrubEnvSize-buf.Len()))
+
+	return buf.Bytes(), nil
+}
+
+func (e *GrubEnv) Write() error {
+	data, err := e.Bytes()
+	if err != nil {
+		return err
+	}
+
+	tmpFile := e.File + ".tmp"
+	err = ioutil.WriteFile(tmpFile, data, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile, e.File)
+}
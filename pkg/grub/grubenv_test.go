@@ -0,0 +1,169 @@
+// Copyright © 2021 Daniele Rondina, geaaru@sabayonlinux.org
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package grub
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGrubEnvRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		keys   []string
+		values map[string]string
+	}{
+		{"empty", []string{}, map[string]string{}},
+		{
+			"saved entry",
+			[]string{GrubEnvSavedEntry},
+			map[string]string{GrubEnvSavedEntry: "gnulinux-advanced>gnulinux-5.10.42"},
+		},
+		{
+			"escaped characters",
+			[]string{"a", "b", "c"},
+			map[string]string{"a": `C:\boot`, "b": "line1\nline2", "c": "x=y"},
+		},
+		{"empty value", []string{"boot_success"}, map[string]string{"boot_success": ""}},
+	}
+
+	for _, tt := range tests {
+		env := NewGrubEnv("")
+		for _, k := range tt.keys {
+			env.Set(k, tt.values[k])
+		}
+
+		data, err := env.Bytes()
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		if len(data) != GrubEnvSize || !bytes.HasPrefix(data, []byte(GrubEnvHeader)) {
+			t.Errorf("%s: invalid environment block %q", tt.name, data)
+			continue
+		}
+
+		parsed, err := ParseGrubEnv(data)
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		if strings.Join(parsed.Keys, ",") != strings.Join(tt.keys, ",") {
+			t.Errorf("%s: expected keys %v, got %v", tt.name, tt.keys, parsed.Keys)
+		}
+		for _, k := range tt.keys {
+			if parsed.Get(k) != tt.values[k] {
+				t.Errorf("%s: expected %s=%q, got %q", tt.name, k, tt.values[k], parsed.Get(k))
+			}
+		}
+	}
+}
+
+func TestGrubEnvEscapes(t *testing.T) {
+	env := NewGrubEnv("")
+	env.Set("a", "x\\y\nz")
+
+	data, err := env.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Same escapes of grub-editenv.
+	if !bytes.HasPrefix(data, []byte(GrubEnvHeader+"a=x\\\\y\\\nz\n#")) {
+		t.Errorf("Unexpected environment block %q", data[:40])
+	}
+}
+
+func TestParseGrubEnvInvalid(t *testing.T) {
+	valid, _ := NewGrubEnv("").Bytes()
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", []byte{}},
+		{"without header", bytes.Repeat([]byte{'#'}, GrubEnvSize)},
+		{"short", valid[:GrubEnvSize-1]},
+		{"long", append(append([]byte{}, valid...), '#')},
+	}
+
+	for _, tt := range tests {
+		if _, err := ParseGrubEnv(tt.data); err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+	}
+}
+
+func TestGrubEnvSetUnset(t *testing.T) {
+	env := NewGrubEnv("")
+	env.Set("a", "1")
+	env.Set("b", "2")
+	env.Set("a", "3")
+	env.Unset("a")
+	env.Unset("c")
+
+	if strings.Join(env.Keys, ",") != "b" || env.Get("a") != "" || env.Get("b") != "2" {
+		t.Errorf("Unexpected environment %v %v", env.Keys, env.Values)
+	}
+
+	env.Set("big", strings.Repeat("x", GrubEnvSize))
+	if _, err := env.Bytes(); err == nil {
+		t.Error("Expected error for a too big environment")
+	}
+}
+
+func TestGrubEnvFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mos-grubenv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "grubenv")
+
+	// A not existing file is an empty environment.
+	env, err := ReadGrubEnv(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(env.Keys) != 0 {
+		t.Errorf("Unexpected keys %v", env.Keys)
+	}
+
+	env.Set(GrubEnvNextEntry, "Mocaccino")
+	if err := env.Write(); err != nil {
+		t.Fatal(err)
+	}
+
+	env, err = ReadGrubEnv(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.Get(GrubEnvNextEntry) != "Mocaccino" || env.File != file {
+		t.Errorf("Unexpected environment %v", env.Values)
+	}
+
+	if err := ioutil.WriteFile(file, []byte("invalid"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadGrubEnv(file); err == nil {
+		t.Error("Expected error for an invalid file")
+	}
+}
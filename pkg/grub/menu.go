@@ -0,0 +1,212 @@
+// Copyright © 2021 Daniele Rondina, geaaru@sabayonlinux.org
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package grub
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MenuEntry is a menuentry of grub.cfg.
+type MenuEntry struct {
+	Title string `json:"title"`
+	Id    string `json:"id,omitempty"`
+	// Title or id of the parent submenus.
+	Parents []string `json:"parents,omitempty"`
+	Linux   string   `json:"linux,omitempty"`
+	Initrd  string   `json:"initrd,omitempty"`
+}
+
+// GetReference returns the value used by GRUB to select the
+// entry through default, saved_entry and next_entry.
+func (m *MenuEntry) GetReference() string {
+	ref := m.Id
+	if ref == "" {
+		ref = m.Title
+	}
+	return strings.Join(append(append([]string{}, m.Parents...), ref), ">")
+}
+
+// GetKernel returns the kernel image path of the linux command.
+func (m *MenuEntry) GetKernel() string {
+	fields := strings.Fields(m.Linux)
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
+// splitWords splits the line in words handling the quoted strings.
+func splitWords(line string) []string {
+	ans := []string{}
+	var word strings.Builder
+	var quote rune
+	inWord := false
+
+	for _, c := range line {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				word.WriteRune(c)
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			inWord = true
+		case c == ' ' || c == '\t':
+			if inWord {
+				ans = append(ans, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+	if inWord {
+		ans = append(ans, word.String())
+	}
+
+	return ans
+}
+
+// parseEntryHeader returns the title and the id of a menuentry
+// or submenu line.
+func parseEntryHeader(words []string) (string, string) {
+	title, id := "", ""
+	if len(words) > 1 {
+		title = words[1]
+	}
+	for idx, w := range words {
+		if (w == "$menuentry_id_option" || w == "--id") && idx+1 < len(words) {
+			id = words[idx+1]
+		}
+	}
+	return title, id
+}
+
+// ReadMenuEntries parses the menu entries of grub.cfg.
+func ReadMenuEntries(file string) ([]*MenuEntry, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ans := []*MenuEntry{}
+	// Stack of the open blocks. Submenus contain the reference
+	// used by the children; other blocks (if, function) are empty.
+	type block struct {
+		submenu bool
+		ref     string
+		entry   *MenuEntry
+	}
+	stack := []*block{}
+
+	parents := func() []string {
+		p := []string{}
+		for _, b := range stack {
+			if b.submenu {
+				p = append(p, b.ref)
+			}
+		}
+		return p
+	}
+
+	var current *MenuEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		words := splitWords(line)
+		if len(words) == 0 {
+			continue
+		}
+
+		opens := strings.HasSuffix(line, "{")
+
+		switch words[0] {
+		case "menuentry":
+			title, id := parseEntryHeader(words)
+			current = &MenuEntry{
+				Title:   title,
+				Id:      id,
+				Parents: parents(),
+			}
+			ans = append(ans, current)
+			stack = append(stack, &block{entry: current})
+			continue
+		case "submenu":
+			title, id := parseEntryHeader(words)
+			ref := id
+			if ref == "" {
+				ref = title
+			}
+			stack = append(stack, &block{submenu: true, ref: ref})
+			continue
+		case "linux", "linux16", "linuxefi":
+			if current != nil && len(words) > 1 {
+				current.Linux = strings.Join(words[1:], " ")
+			}
+		case "initrd", "initrd16", "initrdefi":
+			if current != nil && len(words) > 1 {
+				current.Initrd = strings.Join(words[1:], " ")
+			}
+		case "}":
+			if len(stack) == 0 {
+				return nil, errors.New(
+					fmt.Sprintf("Unexpected } on file %s", file))
+			}
+			b := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if b.entry != nil {
+				current = nil
+			}
+			continue
+		}
+
+		// Other blocks with braces (function)
+		if opens {
+			stack = append(stack, &block{})
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return ans, nil
+}
+
+// FindMenuEntryForKernel returns the first entry that boots the
+// kernel image in input.
+func FindMenuEntryForKernel(entries []*MenuEntry, kernelFile string) *MenuEntry {
+	for _, e := range entries {
+		if filepath.Base(e.GetKernel()) == kernelFile {
+			return e
+		}
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+// Copyright © 2021 Daniele Rondina, geaaru@sabayonlinux.org
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package grub
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testGrubCfg = `#
+# DO NOT EDIT THIS FILE
+#
+function load_video {
+  if [ x$feature_all_video_module = xy ]; then
+    insmod all_video
+  fi
+}
+
+menuentry 'Mocaccino' --class mocaccino --class gnu-linux $menuentry_id_option 'gnulinux-simple-uuid' {
+	load_video
+	linux	/boot/bzImage root=UUID=uuid ro quiet
+	initrd	/boot/Initrd
+}
+submenu 'Advanced options for Mocaccino' $menuentry_id_option 'gnulinux-advanced-uuid' {
+	menuentry 'Mocaccino, with Linux 5.10.42' --class mocaccino $menuentry_id_option 'gnulinux-5.10.42-advanced-uuid' {
+		linux	/boot/kernel-vanilla-x86_64-5.10.42-mocaccino root=UUID=uuid ro
+		initrd	/boot/initramfs-vanilla-x86_64-5.10.42-mocaccino
+	}
+	menuentry "Mocaccino, with Linux 5.4.100 (recovery mode)" {
+		linux16	/boot/kernel-vanilla-x86_64-5.4.100-mocaccino root=UUID=uuid ro single
+	}
+}
+menuentry "UEFI Firmware Settings" --id "uefi-firmware" {
+	fwsetup
+}
+`
+
+func TestReadMenuEntries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mos-grubcfg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "grub.cfg")
+	if err := ioutil.WriteFile(file, []byte(testGrubCfg), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := ReadMenuEntries(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct {
+		title     string
+		reference string
+		kernel    string
+		initrd    string
+	}{
+		{"Mocaccino", "gnulinux-simple-uuid", "/boot/bzImage", "/boot/Initrd"},
+		{
+			"Mocaccino, with Linux 5.10.42",
+			"gnulinux-advanced-uuid>gnulinux-5.10.42-advanced-uuid",
+			"/boot/kernel-vanilla-x86_64-5.10.42-mocaccino",
+			"/boot/initramfs-vanilla-x86_64-5.10.42-mocaccino",
+		},
+		{
+			"Mocaccino, with Linux 5.4.100 (recovery mode)",
+			"gnulinux-advanced-uuid>Mocaccino, with Linux 5.4.100 (recovery mode)",
+			"/boot/kernel-vanilla-x86_64-5.4.100-mocaccino",
+			"",
+		},
+		{"UEFI Firmware Settings", "uefi-firmware", "", ""},
+	}
+
+	if len(entries) != len(expected) {
+		t.Fatalf("Expected %d entries, got %d", len(expected), len(entries))
+	}
+
+	for idx, e := range expected {
+		got := entries[idx]
+		if got.Title != e.title || got.GetReference() != e.reference ||
+			got.GetKernel() != e.kernel || got.Initrd != e.initrd {
+			t.Errorf("Entry %d: expected %+v, got %+v (%s)", idx, e, got, got.GetReference())
+		}
+	}
+
+	if e := FindMenuEntryForKernel(entries, "kernel-vanilla-x86_64-5.4.100-mocaccino"); e == nil ||
+		e.Title != expected[2].title {
+		t.Errorf("Unexpected entry for the kernel 5.4.100: %+v", e)
+	}
+	if e := FindMenuEntryForKernel(entries, "kernel-vanilla-x86_64-5.15.0-mocaccino"); e != nil {
+		t.Errorf("Unexpected entry for the kernel 5.15.0: %+v", e)
+	}
+}
+
+func TestReadMenuEntriesInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mos-grubcfg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "grub.cfg")
+	if err := ioutil.WriteFile(file, []byte("menuentry 'a' {\n}\n}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadMenuEntries(file); err == nil {
+		t.Error("Expected error for an unexpected }")
+	}
+
+	if _, err := ReadMenuEntries(filepath.Join(dir, "missing.cfg")); err == nil {
+		t.Error("Expected error for a missing file")
+	}
+}
+
+func TestSplitWords(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected []string
+	}{
+		{"linux /boot/bzImage ro", []string{"linux", "/boot/bzImage", "ro"}},
+		{"menuentry 'A title' {", []string{"menuentry", "A title", "{"}},
+		{`menuentry "It's here" --id x`, []string{"menuentry", "It's here", "--id", "x"}},
+		{"linux\t/boot/bzImage  quiet", []string{"linux", "/boot/bzImage", "quiet"}},
+		{"''", []string{""}},
+		{"", []string{}},
+	}
+
+	for _, tt := range tests {
+		got := splitWords(tt.line)
+		if strings.Join(got, "|") != strings.Join(tt.expected, "|") || len(got) != len(tt.expected) {
+			t.Errorf("splitWords(%q): expected %q, got %q", tt.line, tt.expected, got)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright © 2021 Daniele Rondina <geaaru@sabayonlinux.org>
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package initrd
+
+import (
+	"io"
+	"os"
+
+	kernelspecs "github.com/MocaccinoOS/mos-cli/pkg/kernel/specs"
+)
+
+const DefaultBoosterArgs = "--force"
+
+type BoosterBuilder struct {
+	DryRun bool
+	Args   string
+}
+
+func NewBoosterBuilder(args string, dryRun bool) *BoosterBuilder {
+	return &BoosterBuilder{
+		Args:   argsFromEnv(BoosterBuilderName, args),
+		DryRun: dryRun,
+	}
+}
+
+func (b *BoosterBuilder) GetName() string   { return BoosterBuilderName }
+func (b *BoosterBuilder) GetBinary() string { return "booster" }
+func (b *BoosterBuilder) GetArgs() string   { return b.Args }
+func (b *BoosterBuilder) IsDryRun() bool    { return b.DryRun }
+
+func (b *BoosterBuilder) GetVersion() (string, error) { return builderVersion(b.GetBinary(), true) }
+
+func (b *BoosterBuilder) GetConfigFiles() []string {
+	return []string{"/etc/booster.yaml"}
+}
+
+func (b *BoosterBuilder) GetCommandArgs(kver, initrdFile string) []string {
+	// booster build [opts] --kernel-version <kver> <image>
+	args := []string{"build"}
+	args = append(args, splitArgs(b.Args)...)
+	return append(args, []string{
+		"--kernel-version", kver, initrdFile,
+	}...)
+}
+
+func (b *BoosterBuilder) Build(kf *kernelspecs.KernelFiles, bootDir string) error {
+	return buildInitrd(b, kf, bootDir, os.Stdout)
+}
+
+func (b *BoosterBuilder) BuildWithWriter(kf *kernelspecs.KernelFiles, bootDir string, w io.Writer) error {
+	return buildInitrd(b, kf, bootDir, w)
+}
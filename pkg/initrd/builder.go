@@ -0,0 +1,273 @@
+// Copyright © 2021 Daniele Rondina <geaaru@sabayonlinux.org>
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package initrd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	kernelspecs "github.com/MocaccinoOS/mos-cli/pkg/kernel/specs"
+	"github.com/MocaccinoOS/mos-cli/pkg/utils"
+)
+
+const (
+	DracutBuilderName     = "dracut"
+	MkinitcpioBuilderName = "mkinitcpio"
+	BoosterBuilderName    = "booster"
+	GenkernelBuilderName  = "genkernel"
+
+	DefaultBuilderName = DracutBuilderName
+)
+
+// Builder is the interface implemented by the tools able to
+// generate an initrd image for a kernel.
+type Builder interface {
+	GetName() string
+	GetBinary() string
+	GetArgs() string
+	IsDryRun() bool
+	// GetVersion returns the version of the builder tool.
+	GetVersion() (string, error)
+	// GetConfigFiles returns the configuration files and directories
+	// read by the builder tool.
+	GetConfigFiles() []string
+
+	// GetCommandArgs returns the arguments of the builder binary
+	// to generate the initrd image initrdFile for the kernel
+	// release kver.
+	GetCommandArgs(kver, initrdFile string) []string
+
+	Build(kf *kernelspecs.KernelFiles, bootDir string) error
+	// BuildWithWriter generates the initrd image writing the
+	// output of the builder to w instead of the stdout.
+	BuildWithWriter(kf *kernelspecs.KernelFiles, bootDir string, w io.Writer) error
+}
+
+// NewBuilder returns the builder with the name in input. If args is
+// empty the default options of the builder are used.
+func NewBuilder(name, args string, dryRun bool) (Builder, error) {
+	switch name {
+	case "", DracutBuilderName:
+		if args == "" {
+			args = DefaultDracutArgs
+		}
+		return NewDracutBuilder(args, dryRun), nil
+	case MkinitcpioBuilderName:
+		return NewMkinitcpioBuilder(args, dryRun), nil
+	case BoosterBuilderName:
+		if args == "" {
+			args = DefaultBoosterArgs
+		}
+		return NewBoosterBuilder(args, dryRun), nil
+	case GenkernelBuilderName:
+		if args == "" {
+			args = DefaultGenkernelArgs
+		}
+		return NewGenkernelBuilder(args, dryRun), nil
+	default:
+		return nil, errors.New(
+			fmt.Sprintf("Unsupported initrd builder %s", name))
+	}
+}
+
+// GetSupportedBuilders returns the names of the available builders.
+func GetSupportedBuilders() []string {
+	return []string{
+		DracutBuilderName,
+		MkinitcpioBuilderName,
+		BoosterBuilderName,
+		GenkernelBuilderName,
+	}
+}
+
+func argsFromEnv(builder, args string) string {
+	env := fmt.Sprintf("MOS_%s_ARGS", strings.ToUpper(builder))
+	if os.Getenv(env) != "" {
+		return os.Getenv(env)
+	}
+	return args
+}
+
+func splitArgs(args string) []string {
+	return strings.Fields(args)
+}
+
+// prepareInitrdImage sets the initrd image of the kernel files
+// if not present and returns it. The kernel type must be already
+// compiled: the type is shared between the builds of the pool.
+func prepareInitrdImage(kf *kernelspecs.KernelFiles) *kernelspecs.InitrdImage {
+	initrd := kf.Initrd
+	if kf.Initrd == nil {
+		initrd = kernelspecs.NewInitrdImage()
+		initrd.SetPrefix(kf.Type.GetInitrdPrefixSanitized())
+		initrd.SetVersion(kf.Kernel.GetVersion())
+		initrd.SetSuffix(kf.Kernel.GetSuffix())
+		initrd.SetKernelType(kf.Kernel.GetType())
+		initrd.SetArch(kf.Kernel.GetArch())
+		initrd.Pattern = kf.Type.InitrdRegex
+	}
+
+	kf.Initrd = initrd
+
+	return initrd
+}
+
+// buildInitrd contains the common logic used by the builders to
+// generate the initrd image of a kernel.
+// The image is generated on a temporary file of the boot directory
+// and it replaces the existing image only if it's valid.
+func buildInitrd(b Builder, kf *kernelspecs.KernelFiles, bootDir string, w io.Writer) error {
+	if kf == nil || kf.Kernel == nil || kf.Type == nil {
+		return errors.New("Invalid kernel file")
+	}
+	if kf.Type.Regex == nil {
+		return errors.New(fmt.Sprintf("Kernel type %s not compiled", kf.Type.GetName()))
+	}
+
+	kverstr := kf.Kernel.GetKernelRelease()
+	initrd := prepareInitrdImage(kf)
+	initrdFile := filepath.Join(bootDir, initrd.GenerateFilename())
+	tmpFile := filepath.Join(bootDir, "."+initrd.GenerateFilename()+".tmp")
+	binary, args := builderCommand(b, kverstr, tmpFile)
+
+	if b.IsDryRun() {
+		fmt.Fprintln(w, fmt.Sprintf("[dry-run mode] command: %s %s",
+			binary, strings.Join(args, " ")))
+		return nil
+	}
+
+	fmt.Fprint(w, fmt.Sprintf("Creating initrd image %s with %s...",
+		initrdFile, b.GetName()))
+
+	// Ignoring errors. Cleanup of a previous interrupted build.
+	os.Remove(tmpFile)
+
+	err := runBuilder(binary, args, w)
+	if err == nil {
+		err = ValidateImage(tmpFile)
+	}
+	if err != nil {
+		os.Remove(tmpFile)
+		return err
+	}
+
+	err = ReplaceImage(tmpFile, initrdFile)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w, "DONE")
+
+	return nil
+}
+
+// builderCommand returns the binary and the arguments executed to
+// generate the initrd image. With a system root dracut uses the
+// --sysroot option and the other builders are executed with chroot.
+func builderCommand(b Builder, kver, initrdFile string) (string, []string) {
+	if !utils.HasRootDir() {
+		return b.GetBinary(), b.GetCommandArgs(kver, initrdFile)
+	}
+
+	if b.GetName() == DracutBuilderName {
+		return b.GetBinary(), append(
+			[]string{"--sysroot", utils.GetRootDir()},
+			b.GetCommandArgs(kver, initrdFile)...)
+	}
+
+	return "chroot", append(
+		[]string{utils.GetRootDir(), b.GetBinary()},
+		b.GetCommandArgs(kver, utils.ChrootPath(initrdFile))...)
+}
+
+func runBuilder(binary string, args []string, w io.Writer) error {
+	command := exec.Command(binary, args...)
+	command.Stdout = w
+	command.Stderr = w
+	if w == os.Stdout {
+		command.Stderr = os.Stderr
+	}
+
+	err := command.Start()
+	if err != nil {
+		return errors.New(
+			fmt.Sprintf("Error on start %s command: %s", binary, err.Error()))
+	}
+
+	err = command.Wait()
+	if err != nil {
+		return errors.New(
+			fmt.Sprintf("Error on waiting %s command: %s", binary, err.Error()))
+	}
+
+	if command.ProcessState.ExitCode() != 0 {
+		return errors.New(
+			fmt.Sprintf("%s command exiting with %d",
+				binary, command.ProcessState.ExitCode()))
+	}
+
+	return nil
+}
+
+// ReplaceImage renames the new image over the target file.
+// The previous image is kept as .bak until the new image is
+// in place and it's restored on failure.
+func ReplaceImage(newFile, target string) error {
+	bakFile := target + ".bak"
+	hasBackup := false
+
+	if _, err := os.Stat(target); err == nil {
+		os.Remove(bakFile)
+		err = os.Link(target, bakFile)
+		if err != nil {
+			err = utils.CopyFile(target, bakFile)
+		}
+		if err != nil {
+			os.Remove(newFile)
+			return errors.New(
+				fmt.Sprintf("Error on backup initrd image %s: %s", target, err.Error()))
+		}
+		hasBackup = true
+	}
+
+	err := os.Rename(newFile, target)
+	if err == nil {
+		err = ValidateImage(target)
+	}
+	if err != nil {
+		os.Remove(newFile)
+		if hasBackup {
+			// Restore the previous image
+			if rerr := os.Rename(bakFile, target); rerr != nil {
+				return errors.New(
+					fmt.Sprintf("Error on replace initrd image %s: %s. Previous image available on %s",
+						target, err.Error(), bakFile))
+			}
+		}
+		return errors.New(
+			fmt.Sprintf("Error on replace initrd image %s: %s", target, err.Error()))
+	}
+
+	if hasBackup {
+		os.Remove(bakFile)
+	}
+
+	return nil
+}
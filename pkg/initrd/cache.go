@@ -0,0 +1,293 @@
+// Copyright © 2021 Daniele Rondina <geaaru@sabayonlinux.org>
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package initrd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	kernelspecs "github.com/MocaccinoOS/mos-cli/pkg/kernel/specs"
+	"github.com/MocaccinoOS/mos-cli/pkg/utils"
+)
+
+const (
+	DefaultBuildCacheFile = "/var/lib/mos/initrd-cache.json"
+	DefaultModulesDir     = "/lib/modules"
+)
+
+// Fingerprint contains the hashes of the inputs used to generate
+// an initrd image.
+type Fingerprint struct {
+	Kernel         string `json:"kernel"`
+	Modules        string `json:"modules"`
+	Builder        string `json:"builder"`
+	BuilderVersion string `json:"builder_version,omitempty"`
+	Args           string `json:"args,omitempty"`
+	Config         string `json:"config"`
+}
+
+// BuildCache stores the fingerprints of the generated initrd
+// images for initrd file path.
+type BuildCache struct {
+	File    string                  `json:"-"`
+	Entries map[string]*Fingerprint `json:"entries"`
+}
+
+func NewBuildCache(file string) *BuildCache {
+	return &BuildCache{
+		File:    file,
+		Entries: make(map[string]*Fingerprint),
+	}
+}
+
+// LoadBuildCache reads the state file. A not existing file
+// returns an empty cache.
+func LoadBuildCache(file string) (*BuildCache, error) {
+	ans := NewBuildCache(file)
+
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ans, nil
+		}
+		return nil, err
+	}
+
+	if err = json.Unmarshal(content, ans); err != nil {
+		return nil, errors.New(
+			fmt.Sprintf("Error on parse cache file %s: %s", file, err.Error()))
+	}
+
+	if ans.Entries == nil {
+		ans.Entries = make(map[string]*Fingerprint)
+	}
+
+	return ans, nil
+}
+
+func (c *BuildCache) Write() error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(c.File), 0755)
+	if err != nil {
+		return err
+	}
+
+	tmpFile := c.File + ".tmp"
+	err = ioutil.WriteFile(tmpFile, data, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile, c.File)
+}
+
+// IsUpToDate returns true if the initrd image exists and it's been
+// generated with the same inputs of the fingerprint in input.
+func (c *BuildCache) IsUpToDate(initrdFile string, fp *Fingerprint) bool {
+	if _, err := os.Stat(initrdFile); err != nil {
+		return false
+	}
+
+	f, ok := c.Entries[initrdFile]
+	if !ok {
+		return false
+	}
+
+	return f.EqualTo(fp)
+}
+
+func (c *BuildCache) Update(initrdFile string, fp *Fingerprint) {
+	c.Entries[initrdFile] = fp
+}
+
+func (c *BuildCache) Remove(initrdFile string) {
+	delete(c.Entries, initrdFile)
+}
+
+func (f *Fingerprint) EqualTo(fp *Fingerprint) bool {
+	return *f == *fp
+}
+
+// GetInitrdFile returns the path of the initrd image of the kernel
+// that will be generated by the builders.
+func GetInitrdFile(kf *kernelspecs.KernelFiles, bootDir string) string {
+	return filepath.Join(bootDir, prepareInitrdImage(kf).GenerateFilename())
+}
+
+// NewFingerprint computes the fingerprint of the inputs used by the
+// builder to generate the initrd image of the kernel.
+func NewFingerprint(b Builder, kf *kernelspecs.KernelFiles, bootDir, modulesDir string) (*Fingerprint, error) {
+	if kf == nil || kf.Kernel == nil {
+		return nil, errors.New("Invalid kernel file")
+	}
+
+	if modulesDir == "" {
+		modulesDir = utils.RootPath(DefaultModulesDir)
+	}
+
+	ans := &Fingerprint{
+		Builder: b.GetName(),
+		Args:    b.GetArgs(),
+	}
+
+	var err error
+	ans.Kernel, err = hashFile(filepath.Join(bootDir, kf.Kernel.GetFilename()))
+	if err != nil {
+		return nil, errors.New(
+			fmt.Sprintf("Error on hash kernel image: %s", err.Error()))
+	}
+
+	ans.Modules, err = hashTree(filepath.Join(modulesDir, kf.Kernel.GetKernelRelease()))
+	if err != nil {
+		return nil, errors.New(
+			fmt.Sprintf("Error on hash modules tree: %s", err.Error()))
+	}
+
+	ans.Config, err = hashConfigFiles(b.GetConfigFiles())
+	if err != nil {
+		return nil, errors.New(
+			fmt.Sprintf("Error on hash %s configuration: %s", b.GetName(), err.Error()))
+	}
+
+	// The builder version is optional.
+	ans.BuilderVersion, _ = b.GetVersion()
+
+	return ans, nil
+}
+
+func hashFile(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashTree returns an hash of the path, mode, size and mtime of
+// every entry of the directory. A not existing directory returns an
+// empty string.
+func hashTree(dir string) (string, error) {
+	if _, err := os.Stat(dir); err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	h := sha256.New()
+	err := filepath.Walk(dir,
+		func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			rel, _ := filepath.Rel(dir, path)
+			fmt.Fprintf(h, "%s %s %d %d\n",
+				rel, info.Mode().String(), info.Size(), info.ModTime().UnixNano())
+
+			return nil
+		})
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashConfigFiles returns an hash of the content of the files in
+// input. Directories are expanded with the files they contain.
+func hashConfigFiles(paths []string) (string, error) {
+	files := []string{}
+
+	for _, p := range paths {
+		p = utils.RootPath(p)
+		info, err := os.Stat(p)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return "", err
+		}
+
+		if info.IsDir() {
+			err = filepath.Walk(p,
+				func(path string, info os.FileInfo, err error) error {
+					if err != nil {
+						return err
+					}
+					if !info.IsDir() {
+						files = append(files, path)
+					}
+					return nil
+				})
+			if err != nil {
+				return "", err
+			}
+		} else {
+			files = append(files, p)
+		}
+	}
+
+	sort.Strings(files)
+
+	h := sha256.New()
+	for _, f := range files {
+		fh, err := hashFile(f)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s %s\n", f, fh)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// builderVersion returns the first line of the output of
+// the builder binary with the --version option. With inRoot the
+// binary of the system root is executed.
+func builderVersion(binary string, inRoot bool) (string, error) {
+	command := exec.Command(binary, "--version")
+	if inRoot {
+		command = utils.RootCommand(binary, "--version")
+	}
+
+	out, err := command.CombinedOutput()
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(strings.Split(string(out), "\n")[0]), nil
+}
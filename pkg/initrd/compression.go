@@ -0,0 +1,77 @@
+// Copyright © 2021 Daniele Rondina <geaaru@sabayonlinux.org>
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package initrd
+
+import (
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// ErrLzoUnsupported is returned for the images compressed with lzo.
+// The lzo format is detected but there isn't a decompressor.
+var ErrLzoUnsupported = errors.New("lzo unsupported: decompress the image with lzop to read it")
+
+// NewDecompressor returns a reader that decompresses the stream
+// in input with the format supplied. The returned function must
+// be called to release the resources of the decompressor.
+func NewDecompressor(format string, r io.Reader) (io.Reader, func(), error) {
+	noop := func() {}
+
+	switch format {
+	case FormatCpio:
+		return r, noop, nil
+	case FormatGzip:
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, noop, err
+		}
+		return gr, func() { gr.Close() }, nil
+	case FormatXz:
+		xr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, noop, err
+		}
+		return xr, noop, nil
+	case FormatZstd:
+		zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, noop, err
+		}
+		return zr, zr.Close, nil
+	case FormatLz4, FormatLz4Legacy:
+		return lz4.NewReader(r), noop, nil
+	case FormatBzip2:
+		return bzip2.NewReader(r), noop, nil
+	case FormatLzma:
+		lr, err := lzma.NewReader(r)
+		if err != nil {
+			return nil, noop, err
+		}
+		return lr, noop, nil
+	case FormatLzo:
+		return nil, noop, ErrLzoUnsupported
+	default:
+		return nil, noop, errors.New(
+			fmt.Sprintf("Unsupported compression format '%s'", format))
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright © 2021 Daniele Rondina <geaaru@sabayonlinux.org>
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package initrd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	cpioHeaderSize = 110
+	cpioTrailer    = "TRAILER!!!"
+
+	modeTypeMask = 0170000
+	modeDir      = 0040000
+	modeRegular  = 0100000
+	modeSymlink  = 0120000
+)
+
+// CpioEntry is a file of a cpio archive of an initrd image.
+type CpioEntry struct {
+	Name    string `json:"name"`
+	Mode    uint32 `json:"mode"`
+	Size    int64  `json:"size"`
+	Link    string `json:"link,omitempty"`
+	Archive int    `json:"archive"`
+}
+
+func (e *CpioEntry) IsDir() bool     { return e.Mode&modeTypeMask == modeDir }
+func (e *CpioEntry) IsRegular() bool { return e.Mode&modeTypeMask == modeRegular }
+func (e *CpioEntry) IsSymlink() bool { return e.Mode&modeTypeMask == modeSymlink }
+
+// GetFileMode returns the mode of the entry as os.FileMode.
+func (e *CpioEntry) GetFileMode() os.FileMode {
+	ans := os.FileMode(e.Mode & 0777)
+	switch {
+	case e.IsDir():
+		ans |= os.ModeDir
+	case e.IsSymlink():
+		ans |= os.ModeSymlink
+	case !e.IsRegular():
+		ans |= os.ModeIrregular
+	}
+	return ans
+}
+
+// cpioReader reads the entries of a newc cpio stream.
+// The offset is used to respect the 4 bytes alignment of
+// headers and data.
+type cpioReader struct {
+	r      io.Reader
+	offset int64
+	// Remaining data of the current entry.
+	remaining int64
+}
+
+func newCpioReader(r io.Reader) *cpioReader {
+	return &cpioReader{r: r}
+}
+
+func (c *cpioReader) Read(p []byte) (int, error) {
+	if c.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.r.Read(p)
+	c.offset += int64(n)
+	c.remaining -= int64(n)
+	if err == io.EOF && c.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (c *cpioReader) readFull(n int) ([]byte, error) {
+	buf := make([]byte, n)
+	_, err := io.ReadFull(c.r, buf)
+	c.offset += int64(n)
+	return buf, err
+}
+
+func (c *cpioReader) skip(n int64) error {
+	if n <= 0 {
+		return nil
+	}
+	m, err := io.CopyN(ioutil.Discard, c.r, n)
+	c.offset += m
+	return err
+}
+
+func (c *cpioReader) align() error {
+	return c.skip((4 - c.offset%4) % 4)
+}
+
+// Next returns the next entry of the archive. It returns
+// io.EOF when the trailer of the archive is reached.
+func (c *cpioReader) Next() (*CpioEntry, error) {
+	// Skip the data not consumed of the previous entry.
+	if err := c.skip(c.remaining); err != nil {
+		return nil, err
+	}
+	c.remaining = 0
+	if err := c.align(); err != nil {
+		return nil, err
+	}
+
+	header, err := c.readFull(cpioHeaderSize)
+	if err != nil {
+		return nil, err
+	}
+
+	magic := string(header[0:6])
+	if magic != "070701" && magic != "070702" {
+		return nil, errors.New(
+			fmt.Sprintf("Unsupported cpio header with magic '%s'", magic))
+	}
+
+	fields := make([]uint64, 13)
+	for i := range fields {
+		fields[i], err = strconv.ParseUint(string(header[6+i*8:14+i*8]), 16, 32)
+		if err != nil {
+			return nil, errors.New("Invalid cpio header: " + err.Error())
+		}
+	}
+
+	entry := &CpioEntry{
+		Mode: uint32(fields[1]),
+		Size: int64(fields[6]),
+	}
+
+	name, err := c.readFull(int(fields[11]))
+	if err != nil {
+		return nil, err
+	}
+	entry.Name = strings.TrimRight(string(name), "\x00")
+
+	if err = c.align(); err != nil {
+		return nil, err
+	}
+
+	c.remaining = entry.Size
+
+	if entry.Name == cpioTrailer {
+		if err := c.skip(c.remaining); err != nil {
+			return nil, err
+		}
+		c.remaining = 0
+		if err := c.align(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+
+	if entry.IsSymlink() {
+		link, err := ioutil.ReadAll(c)
+		if err != nil {
+			return nil, err
+		}
+		entry.Link = string(link)
+	}
+
+	return entry, nil
+}
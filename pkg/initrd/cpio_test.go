@@ -0,0 +1,248 @@
+// Copyright © 2021 Daniele Rondina <geaaru@sabayonlinux.org>
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package initrd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type testCpioEntry struct {
+	Name string
+	Mode uint32
+	Data string
+}
+
+func cpioPad(buf *bytes.Buffer) {
+	for buf.Len()%4 != 0 {
+		buf.WriteByte(0)
+	}
+}
+
+// newTestCpio returns a newc cpio archive with the entries in input
+// and the trailer.
+func newTestCpio(entries []testCpioEntry) []byte {
+	var buf bytes.Buffer
+
+	write := func(name string, mode uint32, data string) {
+		fields := []uint32{
+			0, mode, 0, 0, 1, 0, uint32(len(data)),
+			0, 0, 0, 0, uint32(len(name) + 1), 0,
+		}
+		buf.WriteString("070701")
+		for _, f := range fields {
+			buf.WriteString(fmt.Sprintf("%08X", f))
+		}
+		buf.WriteString(name)
+		buf.WriteByte(0)
+		cpioPad(&buf)
+		buf.WriteString(data)
+		cpioPad(&buf)
+	}
+
+	for _, e := range entries {
+		write(e.Name, e.Mode, e.Data)
+	}
+	write(cpioTrailer, 0, "")
+
+	return buf.Bytes()
+}
+
+func TestCpioReader(t *testing.T) {
+	archive := newTestCpio([]testCpioEntry{
+		{Name: "usr", Mode: modeDir | 0755},
+		{Name: "usr/hello", Mode: modeRegular | 0644, Data: "hello"},
+		{Name: "usr/skipped", Mode: modeRegular | 0644, Data: "data not read"},
+		{Name: "usr/link", Mode: modeSymlink | 0777, Data: "hello"},
+	})
+
+	cr := newCpioReader(bytes.NewReader(archive))
+
+	expected := []struct {
+		name    string
+		size    int64
+		dir     bool
+		regular bool
+		link    string
+	}{
+		{"usr", 0, true, false, ""},
+		{"usr/hello", 5, false, true, ""},
+		{"usr/skipped", 13, false, true, ""},
+		{"usr/link", 5, false, false, "hello"},
+	}
+
+	for _, e := range expected {
+		entry, err := cr.Next()
+		if err != nil {
+			t.Fatalf("unexpected error on entry %s: %s", e.name, err)
+		}
+		if entry.Name != e.name || entry.Size != e.size ||
+			entry.IsDir() != e.dir || entry.IsRegular() != e.regular ||
+			entry.Link != e.link {
+			t.Errorf("unexpected entry %+v for %s", entry, e.name)
+		}
+
+		if entry.Name == "usr/hello" {
+			data, err := ioutil.ReadAll(cr)
+			if err != nil || string(data) != "hello" {
+				t.Errorf("unexpected data %q (%v)", data, err)
+			}
+		}
+	}
+
+	if _, err := cr.Next(); err != io.EOF {
+		t.Errorf("expected io.EOF after the trailer, got %v", err)
+	}
+}
+
+func TestCpioReaderOldFormat(t *testing.T) {
+	archive := newTestCpio([]testCpioEntry{{Name: "init", Mode: modeRegular | 0755}})
+	copy(archive, "070707")
+
+	_, err := newCpioReader(bytes.NewReader(archive)).Next()
+	if err == nil || !strings.Contains(err.Error(), "070707") {
+		t.Errorf("expected unsupported magic error, got %v", err)
+	}
+}
+
+func TestCpioReaderTruncated(t *testing.T) {
+	archive := newTestCpio([]testCpioEntry{{Name: "init", Mode: modeRegular | 0755, Data: "#!/bin/sh"}})
+
+	cr := newCpioReader(bytes.NewReader(archive[:cpioHeaderSize+8+4]))
+	if _, err := cr.Next(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := ioutil.ReadAll(cr); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+}
+
+func TestGetFileMode(t *testing.T) {
+	tests := []struct {
+		mode     uint32
+		expected string
+	}{
+		{modeDir | 0755, "drwxr-xr-x"},
+		{modeRegular | 0644, "-rw-r--r--"},
+		{modeSymlink | 0777, "Lrwxrwxrwx"},
+		{0020000 | 0600, "?rw-------"},
+	}
+
+	for _, tt := range tests {
+		e := &CpioEntry{Mode: tt.mode}
+		if got := e.GetFileMode().String(); got != tt.expected {
+			t.Errorf("mode %o: expected %s, got %s", tt.mode, tt.expected, got)
+		}
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		header   []byte
+		expected string
+	}{
+		{[]byte("070701000"), FormatCpio},
+		{[]byte{0x1f, 0x8b, 0x08}, FormatGzip},
+		{[]byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, FormatXz},
+		{[]byte{0x28, 0xb5, 0x2f, 0xfd}, FormatZstd},
+		{[]byte{0x04, 0x22, 0x4d, 0x18}, FormatLz4},
+		{[]byte{0x02, 0x21, 0x4c, 0x18}, FormatLz4Legacy},
+		{[]byte("BZh91AY"), FormatBzip2},
+		{[]byte{0x89, 'L', 'Z', 'O', 0x00}, FormatLzo},
+		{[]byte{0x5d, 0x00, 0x00, 0x80}, FormatLzma},
+		{[]byte("MZ"), FormatUnknown},
+		{[]byte{}, FormatUnknown},
+	}
+
+	for _, tt := range tests {
+		if got := DetectFormat(tt.header); got != tt.expected {
+			t.Errorf("header %x: expected %q, got %q", tt.header, tt.expected, got)
+		}
+	}
+}
+
+// An image with an uncompressed early cpio for the microcode and
+// a gzip compressed cpio with the kernel modules.
+func TestInspectImageConcatenated(t *testing.T) {
+	var image bytes.Buffer
+
+	image.Write(newTestCpio([]testCpioEntry{
+		{Name: "kernel", Mode: modeDir | 0755},
+		{Name: "kernel/x86/microcode/GenuineIntel.bin", Mode: modeRegular | 0644, Data: "ucode"},
+	}))
+	image.Write(make([]byte, 512))
+
+	gw := gzip.NewWriter(&image)
+	gw.Write(newTestCpio([]testCpioEntry{
+		{Name: "usr/lib/modules/5.10.9-mocaccino", Mode: modeDir | 0755},
+		{Name: "usr/lib/modules/5.10.9-mocaccino/kernel/fs/ext4.ko.xz", Mode: modeRegular | 0644, Data: "ko"},
+		{Name: dracutModulesFile, Mode: modeRegular | 0644, Data: "base\nbtrfs\n"},
+	}))
+	gw.Close()
+
+	file := filepath.Join(t.TempDir(), "initrd")
+	if err := ioutil.WriteFile(file, image.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := InspectImage(file, true)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(info.Archives) != 2 || info.Archives[0].Format != FormatCpio ||
+		info.Archives[1].Format != FormatGzip {
+		t.Fatalf("unexpected archives %+v", info.Archives)
+	}
+	if !info.EarlyMicrocode {
+		t.Error("expected early microcode")
+	}
+	if strings.Join(info.KernelVersions, ",") != "5.10.9-mocaccino" {
+		t.Errorf("unexpected kernel versions %v", info.KernelVersions)
+	}
+	if len(info.Modules) != 1 || strings.Join(info.GetModuleNames(), ",") != "ext4" {
+		t.Errorf("unexpected modules %v", info.Modules)
+	}
+	if strings.Join(info.DracutModules, ",") != "base,btrfs" {
+		t.Errorf("unexpected dracut modules %v", info.DracutModules)
+	}
+	if len(info.Files) != 5 {
+		t.Errorf("expected 5 files, got %d", len(info.Files))
+	}
+}
+
+func TestInspectImageLzo(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "initrd")
+	data := append([]byte{0x89, 'L', 'Z', 'O', 0x00, 0x0d, 0x0a}, make([]byte, 64)...)
+	if err := ioutil.WriteFile(file, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := InspectImage(file, false)
+	if err == nil || !strings.Contains(err.Error(), "lzo unsupported") {
+		t.Errorf("expected lzo unsupported error, got %v", err)
+	}
+
+	_, err = ReadModulesVermagic(file)
+	if err == nil || !strings.Contains(err.Error(), "lzo unsupported") {
+		t.Errorf("expected lzo unsupported error, got %v", err)
+	}
+}
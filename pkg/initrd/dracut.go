@@ -15,93 +15,48 @@
 package initrd
 
 import (
-	"errors"
-	"fmt"
+	"io"
 	"os"
-	"os/exec"
-	"path/filepath"
-	"strings"
 
 	kernelspecs "github.com/MocaccinoOS/mos-cli/pkg/kernel/specs"
 )
 
+const DefaultDracutArgs = "-H -q -f -o systemd -o systemd-initrd -o systemd-networkd -o dracut-systemd"
+
 type DracutBuilder struct {
 	DryRun bool
 	Args   string
 }
 
 func NewDracutBuilder(args string, dryRun bool) *DracutBuilder {
-	ans := &DracutBuilder{
-		Args:   args,
+	return &DracutBuilder{
+		Args:   argsFromEnv(DracutBuilderName, args),
 		DryRun: dryRun,
 	}
-
-	if os.Getenv("MOS_DRACUT_ARGS") != "" {
-		ans.Args = os.Getenv("MOS_DRACUT_ARGS")
-	}
-
-	return ans
 }
 
-func (d *DracutBuilder) Build(kf *kernelspecs.KernelFiles, bootDir string) error {
-	if kf == nil || kf.Kernel == nil || kf.Type == nil {
-		return errors.New("Invalid kernel file")
-	}
+func (d *DracutBuilder) GetName() string   { return DracutBuilderName }
+func (d *DracutBuilder) GetBinary() string { return "dracut" }
+func (d *DracutBuilder) GetArgs() string   { return d.Args }
+func (d *DracutBuilder) IsDryRun() bool    { return d.DryRun }
 
-	kverstr := kf.Kernel.GetVersion()
-	if kf.Kernel.GetSuffix() != "" {
-		kverstr += "-" + kf.Kernel.GetSuffix()
-	}
-
-	// Convert args in array
-	args := strings.Split(d.Args, " ")
-
-	initrd := kf.Initrd
-	if kf.Initrd == nil {
-		initrd = kernelspecs.NewInitrdImage()
-		initrd.SetPrefix(kf.Type.GetInitrdPrefixSanitized())
-		initrd.SetVersion(kf.Kernel.GetVersion())
-		initrd.SetSuffix(kf.Type.GetSuffix())
-		initrd.SetKernelType(kf.Kernel.GetType())
-		initrd.SetArch(kf.Kernel.GetArch())
-	}
-
-	kf.Initrd = initrd
-
-	if d.DryRun {
-		fmt.Println("[dry-run mode] command: dracut " + d.Args)
-		return nil
-	}
+// dracut is executed on the host also with a system root.
+func (d *DracutBuilder) GetVersion() (string, error) { return builderVersion(d.GetBinary(), false) }
 
-	initrdFile := filepath.Join(bootDir, initrd.GenerateFilename())
+func (d *DracutBuilder) GetConfigFiles() []string {
+	return []string{"/etc/dracut.conf", "/etc/dracut.conf.d"}
+}
 
-	args = append(args, []string{
-		"--kver", kverstr, initrdFile,
+func (d *DracutBuilder) GetCommandArgs(kver, initrdFile string) []string {
+	return append(splitArgs(d.Args), []string{
+		"--kver", kver, initrdFile,
 	}...)
+}
 
-	fmt.Print(fmt.Sprintf("Creating initrd image %s...", initrdFile))
-
-	dracutCommand := exec.Command("dracut", args...)
-	dracutCommand.Stdout = os.Stdout
-	dracutCommand.Stderr = os.Stderr
-
-	err := dracutCommand.Start()
-	if err != nil {
-		return errors.New("Error on start dracut command: " + err.Error())
-	}
-
-	err = dracutCommand.Wait()
-	if err != nil {
-		return errors.New("Error on waiting dracut command: " + err.Error())
-	}
-
-	if dracutCommand.ProcessState.ExitCode() != 0 {
-		return errors.New(
-			fmt.Sprintf("dracut command exiting with %d",
-				dracutCommand.ProcessState.ExitCode()))
-	}
-
-	fmt.Println("DONE")
+func (d *DracutBuilder) Build(kf *kernelspecs.KernelFiles, bootDir string) error {
+	return buildInitrd(d, kf, bootDir, os.Stdout)
+}
 
-	return nil
+func (d *DracutBuilder) BuildWithWriter(kf *kernelspecs.KernelFiles, bootDir string, w io.Writer) error {
+	return buildInitrd(d, kf, bootDir, w)
 }
@@ -0,0 +1,105 @@
+// Copyright © 2021 Daniele Rondina <geaaru@sabayonlinux.org>
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package initrd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	FormatUnknown   = ""
+	FormatCpio      = "cpio"
+	FormatGzip      = "gzip"
+	FormatXz        = "xz"
+	FormatZstd      = "zstd"
+	FormatLz4       = "lz4"
+	FormatLz4Legacy = "lz4-legacy"
+	FormatBzip2     = "bzip2"
+	FormatLzma      = "lzma"
+	FormatLzo       = "lzo"
+)
+
+var formatMagics = []struct {
+	Format string
+	Magic  []byte
+}{
+	{FormatCpio, []byte("070701")},
+	{FormatCpio, []byte("070702")},
+	{FormatCpio, []byte("070707")},
+	{FormatGzip, []byte{0x1f, 0x8b}},
+	{FormatXz, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+	{FormatZstd, []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	{FormatLz4, []byte{0x04, 0x22, 0x4d, 0x18}},
+	{FormatLz4Legacy, []byte{0x02, 0x21, 0x4c, 0x18}},
+	{FormatBzip2, []byte("BZh")},
+	{FormatLzo, []byte{0x89, 'L', 'Z', 'O', 0x00}},
+	{FormatLzma, []byte{0x5d, 0x00, 0x00}},
+}
+
+// DetectFormat returns the compression format or the cpio format
+// of the data in input from its magic bytes.
+func DetectFormat(header []byte) string {
+	for _, m := range formatMagics {
+		if bytes.HasPrefix(header, m.Magic) {
+			return m.Format
+		}
+	}
+	return FormatUnknown
+}
+
+func DetectFileFormat(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return FormatUnknown, err
+	}
+	defer f.Close()
+
+	header := make([]byte, 8)
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return FormatUnknown, err
+	}
+
+	return DetectFormat(header[:n]), nil
+}
+
+// ValidateImage checks that the file is a not empty initrd image
+// with a recognizable format.
+func ValidateImage(file string) error {
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+
+	if info.Size() == 0 {
+		return errors.New(fmt.Sprintf("Initrd image %s is empty", file))
+	}
+
+	format, err := DetectFileFormat(file)
+	if err != nil {
+		return err
+	}
+
+	if format == FormatUnknown {
+		return errors.New(
+			fmt.Sprintf("Initrd image %s has an unknown format", file))
+	}
+
+	return nil
+}
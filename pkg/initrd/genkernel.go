@@ -0,0 +1,68 @@
+// Copyright © 2021 Daniele Rondina <geaaru@sabayonlinux.org>
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package initrd
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+
+	kernelspecs "github.com/MocaccinoOS/mos-cli/pkg/kernel/specs"
+)
+
+const DefaultGenkernelArgs = "--no-mountboot --no-clean --no-mrproper"
+
+type GenkernelBuilder struct {
+	DryRun bool
+	Args   string
+}
+
+func NewGenkernelBuilder(args string, dryRun bool) *GenkernelBuilder {
+	return &GenkernelBuilder{
+		Args:   argsFromEnv(GenkernelBuilderName, args),
+		DryRun: dryRun,
+	}
+}
+
+func (g *GenkernelBuilder) GetName() string   { return GenkernelBuilderName }
+func (g *GenkernelBuilder) GetBinary() string { return "genkernel" }
+func (g *GenkernelBuilder) GetArgs() string   { return g.Args }
+func (g *GenkernelBuilder) IsDryRun() bool    { return g.DryRun }
+
+func (g *GenkernelBuilder) GetVersion() (string, error) { return builderVersion(g.GetBinary(), true) }
+
+func (g *GenkernelBuilder) GetConfigFiles() []string {
+	return []string{"/etc/genkernel.conf"}
+}
+
+func (g *GenkernelBuilder) GetCommandArgs(kver, initrdFile string) []string {
+	// genkernel doesn't accept the kernel version directly but
+	// it reads it from the kernel build tree of the modules.
+	args := splitArgs(g.Args)
+	return append(args, []string{
+		"--kerneldir=" + filepath.Join("/lib/modules", kver, "build"),
+		"--bootdir=" + filepath.Dir(initrdFile),
+		"--initramfs-filename=" + filepath.Base(initrdFile),
+		"initramfs",
+	}...)
+}
+
+func (g *GenkernelBuilder) Build(kf *kernelspecs.KernelFiles, bootDir string) error {
+	return buildInitrd(g, kf, bootDir, os.Stdout)
+}
+
+func (g *GenkernelBuilder) BuildWithWriter(kf *kernelspecs.KernelFiles, bootDir string, w io.Writer) error {
+	return buildInitrd(g, kf, bootDir, w)
+}
@@ -0,0 +1,60 @@
+// Copyright © 2021 Daniele Rondina <geaaru@sabayonlinux.org>
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package initrd
+
+import (
+	"io"
+	"os"
+
+	kernelspecs "github.com/MocaccinoOS/mos-cli/pkg/kernel/specs"
+)
+
+type MkinitcpioBuilder struct {
+	DryRun bool
+	Args   string
+}
+
+func NewMkinitcpioBuilder(args string, dryRun bool) *MkinitcpioBuilder {
+	return &MkinitcpioBuilder{
+		Args:   argsFromEnv(MkinitcpioBuilderName, args),
+		DryRun: dryRun,
+	}
+}
+
+func (m *MkinitcpioBuilder) GetName() string   { return MkinitcpioBuilderName }
+func (m *MkinitcpioBuilder) GetBinary() string { return "mkinitcpio" }
+func (m *MkinitcpioBuilder) GetArgs() string   { return m.Args }
+func (m *MkinitcpioBuilder) IsDryRun() bool    { return m.DryRun }
+
+func (m *MkinitcpioBuilder) GetVersion() (string, error) { return builderVersion(m.GetBinary(), true) }
+
+func (m *MkinitcpioBuilder) GetConfigFiles() []string {
+	return []string{"/etc/mkinitcpio.conf", "/etc/mkinitcpio.d"}
+}
+
+func (m *MkinitcpioBuilder) GetCommandArgs(kver, initrdFile string) []string {
+	// mkinitcpio -k <kver> -g <image>
+	return append(splitArgs(m.Args), []string{
+		"-k", kver, "-g", initrdFile,
+	}...)
+}
+
+func (m *MkinitcpioBuilder) Build(kf *kernelspecs.KernelFiles, bootDir string) error {
+	return buildInitrd(m, kf, bootDir, os.Stdout)
+}
+
+func (m *MkinitcpioBuilder) BuildWithWriter(kf *kernelspecs.KernelFiles, bootDir string, w io.Writer) error {
+	return buildInitrd(m, kf, bootDir, w)
+}
@@ -0,0 +1,103 @@
+// Copyright © 2021 Daniele Rondina <geaaru@sabayonlinux.org>
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package initrd
+
+import (
+	"bytes"
+	"debug/elf"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// ReadModinfo returns the key/values of the .modinfo section of a
+// kernel module. Compressed modules are supported.
+func ReadModinfo(r io.Reader) (map[string]string, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	format := DetectFormat(data)
+	if format != FormatUnknown && format != FormatCpio {
+		dr, closer, err := NewDecompressor(format, bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		data, err = ioutil.ReadAll(dr)
+		closer()
+		if err != nil {
+			return nil, errors.New(
+				fmt.Sprintf("Error on decompress module: %s", err.Error()))
+		}
+	}
+
+	ef, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer ef.Close()
+
+	section := ef.Section(".modinfo")
+	if section == nil {
+		return nil, errors.New("Section .modinfo not found")
+	}
+
+	content, err := section.Data()
+	if err != nil {
+		return nil, err
+	}
+
+	ans := make(map[string]string)
+	for _, entry := range bytes.Split(content, []byte{0}) {
+		kv := strings.SplitN(string(entry), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		// Keys like alias are repeated. We keep the first value.
+		if _, ok := ans[kv[0]]; !ok {
+			ans[kv[0]] = kv[1]
+		}
+	}
+
+	return ans, nil
+}
+
+// ReadModulesVermagic reads the vermagic of all kernel modules
+// available on the initrd image. It returns a map with the
+// module path as key.
+func ReadModulesVermagic(file string) (map[string]string, error) {
+	ans := make(map[string]string)
+
+	_, err := WalkImage(file, func(a *ImageArchive, e *CpioEntry, r io.Reader) error {
+		name := strings.TrimPrefix(e.Name, "./")
+		if !e.IsRegular() || !moduleRegex.MatchString(name) {
+			return nil
+		}
+
+		info, err := ReadModinfo(r)
+		if err != nil {
+			return errors.New(
+				fmt.Sprintf("Error on read module %s: %s", name, err.Error()))
+		}
+		ans[name] = info["vermagic"]
+
+		return nil
+	})
+
+	return ans, err
+}
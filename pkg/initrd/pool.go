@@ -0,0 +1,162 @@
+// Copyright © 2021 Daniele Rondina <geaaru@sabayonlinux.org>
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package initrd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	kernelspecs "github.com/MocaccinoOS/mos-cli/pkg/kernel/specs"
+)
+
+type BuildJob struct {
+	Files   *kernelspecs.KernelFiles
+	Builder Builder
+}
+
+type BuildResult struct {
+	Job      *BuildJob
+	Error    error
+	Duration time.Duration
+	Size     int64
+	Output   bytes.Buffer
+}
+
+func NewBuildJob(kf *kernelspecs.KernelFiles, b Builder) *BuildJob {
+	return &BuildJob{
+		Files:   kf,
+		Builder: b,
+	}
+}
+
+func (r *BuildResult) Failed() bool { return r.Error != nil }
+
+func (r *BuildResult) GetStatus() string {
+	if r.Error != nil {
+		return "failed"
+	}
+	if r.Job.Builder.IsDryRun() {
+		return "dry-run"
+	}
+	return "ok"
+}
+
+// BuildPool runs the initrd builds with a bounded number of workers.
+// The output of every build is captured on the result buffer and
+// optionally written to a log file under LogDir.
+type BuildPool struct {
+	Jobs    int
+	BootDir string
+	LogDir  string
+}
+
+func NewBuildPool(jobs int, bootDir, logDir string) *BuildPool {
+	if jobs <= 0 {
+		jobs = 1
+	}
+	return &BuildPool{
+		Jobs:    jobs,
+		BootDir: bootDir,
+		LogDir:  logDir,
+	}
+}
+
+// Run executes the jobs and returns the results in the same
+// order of the jobs in input. The kernel types of the jobs are
+// compiled before the start of the workers.
+func (p *BuildPool) Run(jobs []*BuildJob) []*BuildResult {
+	ans := make([]*BuildResult, len(jobs))
+	ch := make(chan int)
+	wg := sync.WaitGroup{}
+
+	queue := []int{}
+	for idx, job := range jobs {
+		if job.Files != nil && job.Files.Type != nil {
+			if err := job.Files.Type.Compile(); err != nil {
+				ans[idx] = &BuildResult{Job: job, Error: err}
+				continue
+			}
+		}
+		queue = append(queue, idx)
+	}
+
+	for w := 0; w < p.Jobs; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range ch {
+				ans[idx] = p.build(jobs[idx])
+			}
+		}()
+	}
+
+	for _, idx := range queue {
+		ch <- idx
+	}
+	close(ch)
+	wg.Wait()
+
+	return ans
+}
+
+func (p *BuildPool) build(job *BuildJob) *BuildResult {
+	ans := &BuildResult{Job: job}
+
+	start := time.Now()
+	ans.Error = job.Builder.BuildWithWriter(job.Files, p.BootDir, &ans.Output)
+	ans.Duration = time.Since(start)
+
+	if ans.Error == nil && job.Files.Initrd != nil && !job.Builder.IsDryRun() {
+		info, err := os.Stat(
+			filepath.Join(p.BootDir, job.Files.Initrd.GenerateFilename()))
+		if err != nil {
+			ans.Error = errors.New(
+				fmt.Sprintf("Error on stat generated initrd image: %s", err.Error()))
+		} else {
+			ans.Size = info.Size()
+		}
+	}
+
+	if p.LogDir != "" {
+		if err := p.writeLog(ans); err != nil {
+			fmt.Fprintln(&ans.Output, err.Error())
+		}
+	}
+
+	return ans
+}
+
+func (p *BuildPool) writeLog(r *BuildResult) error {
+	err := os.MkdirAll(p.LogDir, 0755)
+	if err != nil {
+		return errors.New(
+			fmt.Sprintf("Error on create log directory %s: %s", p.LogDir, err.Error()))
+	}
+
+	logFile := filepath.Join(p.LogDir, r.Job.Files.Kernel.GetFilename()+".log")
+	err = ioutil.WriteFile(logFile, r.Output.Bytes(), 0644)
+	if err != nil {
+		return errors.New(
+			fmt.Sprintf("Error on write log file %s: %s", logFile, err.Error()))
+	}
+
+	return nil
+}
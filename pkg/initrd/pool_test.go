@@ -0,0 +1,78 @@
+// Copyright © 2021 Daniele Rondina <geaaru@sabayonlinux.org>
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package initrd
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	kernelspecs "github.com/MocaccinoOS/mos-cli/pkg/kernel/specs"
+)
+
+// The jobs share the same kernel type not yet compiled. Run it with
+// go test -race to check the compile of the pool.
+func TestBuildPoolSharedKernelType(t *testing.T) {
+	ktype := &kernelspecs.KernelType{
+		Name:          "Debian",
+		Type:          "debian",
+		Suffix:        "amd64",
+		KernelPattern: "vmlinuz-{version}-{flavor}",
+		InitrdPattern: "initrd.img-{version}-{flavor}",
+	}
+
+	jobs := []*BuildJob{}
+	for i := 0; i < 8; i++ {
+		kf := kernelspecs.NewKernelFiles(ktype)
+		kf.Kernel = &kernelspecs.KernelImage{
+			Filename: fmt.Sprintf("vmlinuz-5.10.%d-amd64", i),
+			Type:     "debian",
+			Version:  fmt.Sprintf("5.10.%d", i),
+			Suffix:   "amd64",
+		}
+		jobs = append(jobs, NewBuildJob(kf, NewDracutBuilder(DefaultDracutArgs, true)))
+	}
+
+	results := NewBuildPool(4, t.TempDir(), "").Run(jobs)
+	if len(results) != len(jobs) {
+		t.Fatalf("expected %d results, got %d", len(jobs), len(results))
+	}
+
+	for idx, r := range results {
+		if r.Failed() {
+			t.Fatalf("job %d failed: %s", idx, r.Error)
+		}
+		expected := fmt.Sprintf("initrd.img-5.10.%d-amd64", idx)
+		if !strings.Contains(r.Output.String(), expected) {
+			t.Errorf("job %d: expected initrd %s on output %q", idx, expected, r.Output.String())
+		}
+	}
+}
+
+func TestBuildPoolInvalidKernelType(t *testing.T) {
+	ktype := &kernelspecs.KernelType{
+		Name:          "Broken",
+		Type:          "broken",
+		KernelPattern: "vmlinuz-{unknown}",
+	}
+	kf := kernelspecs.NewKernelFiles(ktype)
+	kf.Kernel = &kernelspecs.KernelImage{Filename: "vmlinuz-1", Version: "1"}
+
+	results := NewBuildPool(2, t.TempDir(), "").Run(
+		[]*BuildJob{NewBuildJob(kf, NewDracutBuilder(DefaultDracutArgs, true))})
+	if !results[0].Failed() {
+		t.Fatal("expected a failure for the invalid pattern")
+	}
+}
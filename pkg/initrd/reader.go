@@ -0,0 +1,283 @@
+// Copyright © 2021 Daniele Rondina <geaaru@sabayonlinux.org>
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package initrd
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ImageArchive describes one of the cpio archives concatenated
+// in the initrd image. The early microcode is normally stored in
+// an uncompressed archive before the main archive.
+type ImageArchive struct {
+	Index  int    `json:"index"`
+	Format string `json:"format"`
+	Offset int64  `json:"offset"`
+	Files  int    `json:"files"`
+}
+
+type ImageInfo struct {
+	File           string          `json:"file"`
+	Size           int64           `json:"size"`
+	Archives       []*ImageArchive `json:"archives"`
+	EarlyMicrocode bool            `json:"early_microcode"`
+	KernelVersions []string        `json:"kernel_versions"`
+	Modules        []string        `json:"modules"`
+	DracutModules  []string        `json:"dracut_modules,omitempty"`
+	Files          []*CpioEntry    `json:"files,omitempty"`
+}
+
+// WalkFunc is called for every entry of the initrd image. The reader
+// returns the content of the entry.
+type WalkFunc func(archive *ImageArchive, entry *CpioEntry, r io.Reader) error
+
+var (
+	modulesDirRegex = regexp.MustCompile(`^(usr/)?lib/modules/([^/]+)/`)
+	moduleRegex     = regexp.MustCompile(`\.ko(\.(gz|xz|zst))?$`)
+)
+
+const dracutModulesFile = "usr/lib/dracut/modules.txt"
+
+// countingReader keeps the number of bytes read from the file.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// skipZeros discards the padding between the archives.
+func skipZeros(br *bufio.Reader) error {
+	for {
+		b, err := br.Peek(1)
+		if err != nil {
+			return err
+		}
+		if b[0] != 0 {
+			return nil
+		}
+		br.Discard(1)
+	}
+}
+
+// walkArchives reads the cpio archive available on the uncompressed
+// stream. If concatenated is true it reads also the next cpio
+// archives of the stream.
+func walkArchives(br *bufio.Reader, archive *ImageArchive, fn WalkFunc, concatenated bool) error {
+	cr := newCpioReader(br)
+	for {
+		entry, err := cr.Next()
+		if err == io.EOF {
+			if !concatenated {
+				return nil
+			}
+			// Check if there is another archive concatenated.
+			if err = skipZeros(br); err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			magic, _ := br.Peek(6)
+			if DetectFormat(magic) != FormatCpio {
+				return nil
+			}
+			cr = newCpioReader(br)
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		entry.Archive = archive.Index
+		archive.Files++
+
+		if err = fn(archive, entry, cr); err != nil {
+			return err
+		}
+	}
+}
+
+// WalkImage reads the initrd image file and calls fn for every entry
+// of the cpio archives of the image.
+func WalkImage(file string, fn WalkFunc) ([]*ImageArchive, error) {
+	ans := []*ImageArchive{}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return ans, err
+	}
+	defer f.Close()
+
+	cr := &countingReader{r: f}
+	br := bufio.NewReaderSize(cr, 64*1024)
+
+	for {
+		if err = skipZeros(br); err == io.EOF {
+			break
+		} else if err != nil {
+			return ans, err
+		}
+
+		header, _ := br.Peek(8)
+		archive := &ImageArchive{
+			Index:  len(ans),
+			Format: DetectFormat(header),
+			Offset: cr.n - int64(br.Buffered()),
+		}
+
+		if archive.Format == FormatUnknown {
+			if len(ans) > 0 {
+				// Ignore trailing data
+				break
+			}
+			return ans, errors.New(
+				fmt.Sprintf("File %s has an unknown format", file))
+		}
+
+		ans = append(ans, archive)
+
+		if archive.Format == FormatCpio {
+			// Read only the first archive of the stream. The next
+			// data could be compressed.
+			err = walkArchives(br, archive, fn, false)
+			if err != nil {
+				return ans, err
+			}
+			continue
+		}
+
+		r, closer, err := NewDecompressor(archive.Format, br)
+		if err != nil {
+			return ans, errors.New(
+				fmt.Sprintf("Error on read %s archive at offset %d: %s",
+					archive.Format, archive.Offset, err.Error()))
+		}
+		err = walkArchives(bufio.NewReaderSize(r, 64*1024), archive, fn, true)
+		closer()
+		if err != nil {
+			return ans, errors.New(
+				fmt.Sprintf("Error on read %s archive at offset %d: %s",
+					archive.Format, archive.Offset, err.Error()))
+		}
+
+		// The compressed archive is the last one of the image.
+		break
+	}
+
+	return ans, nil
+}
+
+// InspectImage reads the initrd image and returns the kernel
+// modules, the kernel versions and the dracut modules available.
+func InspectImage(file string, withFiles bool) (*ImageInfo, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+
+	ans := &ImageInfo{
+		File:           file,
+		Size:           info.Size(),
+		KernelVersions: []string{},
+		Modules:        []string{},
+	}
+
+	versions := make(map[string]bool)
+
+	archives, err := WalkImage(file, func(a *ImageArchive, e *CpioEntry, r io.Reader) error {
+		name := strings.TrimPrefix(e.Name, "./")
+
+		if strings.HasPrefix(name, "kernel/x86/microcode/") ||
+			strings.HasPrefix(name, "kernel/firmware/") {
+			ans.EarlyMicrocode = true
+		}
+
+		if m := modulesDirRegex.FindStringSubmatch(name + "/"); m != nil {
+			versions[m[2]] = true
+			if e.IsRegular() && moduleRegex.MatchString(name) {
+				ans.Modules = append(ans.Modules, name)
+			}
+		}
+
+		if name == dracutModulesFile && e.IsRegular() {
+			data, err := ioutil.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			for _, l := range strings.Split(string(data), "\n") {
+				if strings.TrimSpace(l) != "" {
+					ans.DracutModules = append(ans.DracutModules, strings.TrimSpace(l))
+				}
+			}
+		}
+
+		if withFiles {
+			ans.Files = append(ans.Files, e)
+		}
+
+		return nil
+	})
+	ans.Archives = archives
+	if err != nil {
+		return ans, err
+	}
+
+	for v := range versions {
+		ans.KernelVersions = append(ans.KernelVersions, v)
+	}
+	sort.Strings(ans.KernelVersions)
+	sort.Strings(ans.Modules)
+
+	return ans, nil
+}
+
+// GetModuleNames returns the names of the kernel modules without
+// path and extension.
+func (i *ImageInfo) GetModuleNames() []string {
+	ans := []string{}
+	for _, m := range i.Modules {
+		ans = append(ans, moduleRegex.ReplaceAllString(filepath.Base(m), ""))
+	}
+	return ans
+}
+
+// HasKernelVersion returns true if the image contains the modules
+// directory of the kernel release in input.
+func (i *ImageInfo) HasKernelVersion(kver string) bool {
+	for _, v := range i.KernelVersions {
+		if v == kver {
+			return true
+		}
+	}
+	return false
+}
+
+func (i *ImageInfo) String() string {
+	data, _ := json.Marshal(i)
+	return string(data)
+}
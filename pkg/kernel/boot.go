@@ -20,17 +20,17 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 
 	kernelspecs "github.com/MocaccinoOS/mos-cli/pkg/kernel/specs"
 	. "github.com/MocaccinoOS/mos-cli/pkg/logger"
+	"github.com/MocaccinoOS/mos-cli/pkg/utils"
 )
 
 func ReadBootDir(bootdir string, supportedTypes []kernelspecs.KernelType) (*kernelspecs.BootFiles, error) {
 	if bootdir == "" {
-		bootdir = "/boot"
+		bootdir = utils.RootPath("/boot")
 	}
 
 	files, err := ioutil.ReadDir(bootdir)
@@ -40,12 +40,11 @@ func ReadBootDir(bootdir string, supportedTypes []kernelspecs.KernelType) (*kern
 
 	ans := kernelspecs.NewBootFiles(bootdir)
 
-	for _, t := range supportedTypes {
-		r := t.GetRegex()
-		if r == nil {
+	for idx := range supportedTypes {
+		if err := supportedTypes[idx].Compile(); err != nil {
 			return nil, errors.New(
 				fmt.Sprintf("Error on create regex for kernel type %s: %s",
-					t.GetType(), err.Error()),
+					supportedTypes[idx].GetType(), err.Error()),
 			)
 		}
 	}
@@ -60,7 +59,7 @@ func ReadBootDir(bootdir string, supportedTypes []kernelspecs.KernelType) (*kern
 
 		// Retrieve bzImage link
 		if file.Name() == "bzImage" && (file.Mode()&os.ModeSymlink != 0) {
-			linkedFile, err := os.Readlink(file.Name())
+			linkedFile, err := os.Readlink(filepath.Join(bootdir, file.Name()))
 			if err == nil {
 				ans.BzImageLink = linkedFile
 			}
@@ -68,13 +67,14 @@ func ReadBootDir(bootdir string, supportedTypes []kernelspecs.KernelType) (*kern
 
 		// Retrive Initrd link
 		if file.Name() == "Initrd" && (file.Mode()&os.ModeSymlink != 0) {
-			linkedFile, err := os.Readlink(file.Name())
+			linkedFile, err := os.Readlink(filepath.Join(bootdir, file.Name()))
 			if err == nil {
 				ans.InitrdLink = linkedFile
 			}
 		}
 
-		for _, t := range supportedTypes {
+		for idx := range supportedTypes {
+			t := &supportedTypes[idx]
 			if t.GetRegex().MatchString(file.Name()) {
 
 				DebugC("File", file.Name(), "match type", t.GetName())
@@ -89,30 +89,48 @@ func ReadBootDir(bootdir string, supportedTypes []kernelspecs.KernelType) (*kern
 
 				if isInirtd {
 					// Initrd image
-					iimage, err := kernelspecs.NewInitrdImageFromFile(&t, file.Name())
+					iimage, err := kernelspecs.NewInitrdImageFromFile(t, file.Name())
 					if err != nil {
-						return nil, errors.New(
-							fmt.Sprintf("Error on parse file %s: %s",
-								file.Name(), err.Error(),
-							))
+						// Ignore files with a not supported filename.
+						DebugC("Ignoring file", file.Name(), ":", err.Error())
+						goto nextFile
 					}
 
-					err = ans.AddInitrdImage(iimage, &t)
+					err = ans.AddInitrdImage(iimage, t)
 					if err != nil {
 						return nil, err
 					}
 
 				} else {
 					// Kernel image
-					kimage, err := kernelspecs.NewKernelImageFromFile(&t, file.Name())
+					kimage, err := kernelspecs.NewKernelImageFromFile(t, file.Name())
 					if err != nil {
-						return nil, errors.New(
-							fmt.Sprintf("Error on parse file %s: %s",
-								file.Name(), err.Error(),
-							))
+						// Ignore files with a not supported filename.
+						DebugC("Ignoring file", file.Name(), ":", err.Error())
+						goto nextFile
 					}
 
-					err = ans.AddKernelImage(kimage, &t)
+					// Read the kernel release from the binary to detect
+					// mismatch with the filename. The arm64 images are
+					// scanned only if the filename is without version.
+					header, err := kernelspecs.ProbeKernelImageHeader(
+						filepath.Join(bootdir, file.Name()))
+					if err == nil && header.Version == "" && kimage.GetVersion() == "" {
+						header, err = kernelspecs.ReadKernelImageHeader(
+							filepath.Join(bootdir, file.Name()))
+					}
+					if err == nil && header.Version != "" {
+						kimage.SetBinaryRelease(header.GetRelease())
+
+						// Patterns without version (for example vmlinuz-linux-lts)
+						// use the release of the binary. See version_from_image.
+						if kimage.GetVersion() == "" {
+							kimage.SetVersion(getVersionFromRelease(
+								header.GetRelease(), kimage.GetSuffix()))
+						}
+					}
+
+					err = ans.AddKernelImage(kimage, t)
 					if err != nil {
 						return nil, err
 					}
@@ -123,12 +141,111 @@ func ReadBootDir(bootdir string, supportedTypes []kernelspecs.KernelType) (*kern
 			}
 		}
 
+		// The file doesn't match with any kernel type. I check if
+		// it's a kernel image with a not supported filename.
+		if file.Mode().IsRegular() {
+			header, err := kernelspecs.ReadKernelImageHeader(
+				filepath.Join(bootdir, file.Name()))
+			if err == nil {
+				DebugC("File", file.Name(), "is a kernel image with release",
+					header.GetRelease())
+
+				kimage, t := classifyKernelImage(file.Name(), header, supportedTypes)
+				err = ans.AddKernelImage(kimage, t)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+
 	nextFile:
 	}
 
+	ans.Files = pairUnversionedInitrds(ans.Files)
+	ans.Sort()
+
 	return ans, nil
 }
 
+// pairUnversionedInitrds assigns the initrd images without version
+// in the filename to the kernel images of the same type that have
+// the version read from the binary.
+func pairUnversionedInitrds(files []*kernelspecs.KernelFiles) []*kernelspecs.KernelFiles {
+	ans := []*kernelspecs.KernelFiles{}
+
+	for _, kf := range files {
+		if kf.Kernel == nil && kf.Initrd != nil && kf.Initrd.GetVersion() == "" {
+			for _, k := range files {
+				if k.Kernel != nil && k.Initrd == nil &&
+					k.Kernel.Pattern != nil && k.Kernel.Pattern.SubexpIndex("version") < 0 &&
+					k.Kernel.GetType() == kf.Initrd.GetKernelType() &&
+					k.Kernel.GetSuffix() == kf.Initrd.GetSuffix() &&
+					k.Kernel.GetArch() == kf.Initrd.GetArch() {
+
+					kf.Initrd.SetVersion(k.Kernel.GetVersion())
+					k.Initrd = kf.Initrd
+					kf.Initrd = nil
+					break
+				}
+			}
+
+			if kf.Initrd == nil {
+				continue
+			}
+		}
+
+		ans = append(ans, kf)
+	}
+
+	return ans
+}
+
+// getVersionFromRelease returns the version of the kernel release
+// without the suffix.
+func getVersionFromRelease(release, suffix string) string {
+	if suffix != "" && strings.HasSuffix(release, "-"+suffix) {
+		return strings.TrimSuffix(release, "-"+suffix)
+	}
+	return release
+}
+
+// classifyKernelImage creates the kernel image of a file that doesn't
+// match with the kernel types through the release read from the
+// binary. The kernel type is selected by the suffix of the release.
+// The Unknown type of the other images is compiled as the supported
+// types: it's used to generate the initrd image.
+func classifyKernelImage(file string, header *kernelspecs.KernelImageHeader,
+	supportedTypes []kernelspecs.KernelType) (*kernelspecs.KernelImage, *kernelspecs.KernelType) {
+
+	release := header.GetRelease()
+
+	kimage := kernelspecs.NewKernelImage()
+	kimage.SetFilename(file)
+	kimage.SetPrefix(strings.Split(file, "-")[0])
+	kimage.SetVersion(release)
+	kimage.SetBinaryRelease(release)
+
+	for idx := range supportedTypes {
+		t := &supportedTypes[idx]
+		if t.GetSuffix() != "" && strings.HasSuffix(release, "-"+t.GetSuffix()) {
+			kimage.SetVersion(strings.TrimSuffix(release, "-"+t.GetSuffix()))
+			kimage.SetSuffix(t.GetSuffix())
+			kimage.SetType(t.GetType())
+			return kimage, t
+		}
+	}
+
+	t := &kernelspecs.KernelType{
+		Name:         "Unknown",
+		KernelPrefix: kimage.GetPrefix(),
+	}
+	if err := t.Compile(); err != nil {
+		DebugC("Error on compile kernel type of", file, ":", err.Error())
+	}
+
+	return kimage, t
+}
+
 func GrubMkconfig(grubCfgFile string, dryRun bool) error {
 	if grubCfgFile == "" {
 		return errors.New("Invalid grub config file path")
@@ -154,18 +271,27 @@ func GrubMkconfig(grubCfgFile string, dryRun bool) error {
 
 	//grub-mkconfig -o ${MOCACCINO_TARGET}/boot/grub/grub.cfg
 	grubBinary := "grub-mkconfig"
+	// With a system root grub-mkconfig is executed with chroot.
 	args := []string{
-		"-o", grubCfgFile,
+		"-o", utils.ChrootPath(grubCfgFile),
 	}
 
+	// The managed kernel command line is read by the grub scripts
+	// from /etc/default/grub.
+	err = UpdateGrubDefaultCmdline(utils.RootPath(DefaultGrubDefaultFile), false, dryRun)
+	if err != nil {
+		return err
+	}
+
+	grubCommand := utils.RootCommand(grubBinary, args...)
+
 	if dryRun {
-		fmt.Println("[dry-run mode] command: " + grubBinary + " " + strings.Join(args, " "))
+		fmt.Println("[dry-run mode] command: " + strings.Join(grubCommand.Args, " "))
 		return nil
 	}
 
 	fmt.Println(fmt.Sprintf("Creating grub config file %s...", grubCfgFile))
 
-	grubCommand := exec.Command(grubBinary, args...)
 	grubCommand.Stdout = os.Stdout
 	grubCommand.Stderr = os.Stderr
 
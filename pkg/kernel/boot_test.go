@@ -0,0 +1,100 @@
+// Copyright © 2021 Daniele Rondina, geaaru@sabayonlinux.org
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package kernel
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/MocaccinoOS/mos-cli/pkg/initrd"
+	kernelspecs "github.com/MocaccinoOS/mos-cli/pkg/kernel/specs"
+	"github.com/MocaccinoOS/mos-cli/pkg/profile"
+)
+
+// newTestBootDir creates the files and the directories (with the
+// trailing slash) in the boot dir and returns the boot files.
+func newTestBootDir(t *testing.T, bootDir string, files ...string) *kernelspecs.BootFiles {
+	for _, f := range files {
+		path := filepath.Join(bootDir, f)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if f[len(f)-1] == '/' {
+			if err := os.MkdirAll(path, 0755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := ioutil.WriteFile(path, []byte(f), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bootFiles, err := ReadBootDir(bootDir, profile.GetDefaultKernelProfiles())
+	if err != nil {
+		t.Fatal(err)
+	}
+	bootFiles.Sort()
+
+	return bootFiles
+}
+
+// writeTestX86Image writes a x86 kernel image with the version in
+// the setup header.
+func writeTestX86Image(t *testing.T, file, version string) {
+	data := make([]byte, 0x1000)
+	copy(data[0x202:], "HdrS")
+	binary.LittleEndian.PutUint16(data[0x20e:], 0x600)
+	copy(data[0x800:], version+" (root@builder) #1 SMP\x00")
+	if err := ioutil.WriteFile(file, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBuildInitrdUnknownKernel(t *testing.T) {
+	bootDir := t.TempDir()
+	writeTestX86Image(t, filepath.Join(bootDir, "vmlinuz-custom"), "5.4.0-custom")
+
+	bootFiles, err := ReadBootDir(bootDir, profile.GetDefaultKernelProfiles())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bootFiles.Files) != 1 || bootFiles.Files[0].Type.GetName() != "Unknown" {
+		t.Fatalf("got boot files %s, want the Unknown kernel", bootFiles)
+	}
+
+	// The initrd image is generated without the build pool that
+	// compiles the kernel types.
+	b, err := initrd.NewBuilder(initrd.DracutBuilderName, "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := b.BuildWithWriter(bootFiles.Files[0], bootDir, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "--kver 5.4.0-custom") {
+		t.Errorf("unexpected builder command: %s", out.String())
+	}
+	if f := bootFiles.Files[0].Initrd.GenerateFilename(); f != "initramfs-5.4.0-custom" {
+		t.Errorf("got initrd image %s, want initramfs-5.4.0-custom", f)
+	}
+}
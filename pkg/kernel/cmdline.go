@@ -0,0 +1,231 @@
+// Copyright © 2021 Daniele Rondina, geaaru@sabayonlinux.org
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package kernel
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	kernelspecs "github.com/MocaccinoOS/mos-cli/pkg/kernel/specs"
+	. "github.com/MocaccinoOS/mos-cli/pkg/logger"
+	"github.com/MocaccinoOS/mos-cli/pkg/utils"
+)
+
+const (
+	DefaultKernelCmdlineFile = "/etc/kernel/cmdline"
+	DefaultGrubDefaultFile   = "/etc/default/grub"
+	procCmdlineFile          = "/proc/cmdline"
+
+	// Lines of the block of /etc/default/grub managed by mos.
+	grubDefaultBlockBegin = "# BEGIN mos kernel cmdline"
+	grubDefaultBlockEnd   = "# END mos kernel cmdline"
+)
+
+// LoadKernelCmdline returns the kernel command line used by the boot
+// entries. If the file doesn't exist the command line of the running
+// kernel is used without the parameters set by the bootloader. With
+// a system root the command line is empty.
+func LoadKernelCmdline(file string) (*kernelspecs.KernelCmdline, error) {
+	if file == "" {
+		file = utils.RootPath(DefaultKernelCmdlineFile)
+	}
+
+	content, err := ioutil.ReadFile(file)
+	if err == nil {
+		ans, err := kernelspecs.ParseKernelCmdline(string(content))
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf(
+				"Error on parse file %s: %s", file, err.Error()))
+		}
+		return ans, nil
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	} else if utils.HasRootDir() {
+		// The running kernel isn't of the system root.
+		return kernelspecs.NewKernelCmdline(), nil
+	}
+
+	content, err = ioutil.ReadFile(procCmdlineFile)
+	if err != nil {
+		return nil, err
+	}
+
+	ans, err := kernelspecs.ParseKernelCmdline(string(content))
+	if err != nil {
+		return nil, err
+	}
+	ans.Remove(&kernelspecs.CmdlineParam{Key: "BOOT_IMAGE"})
+	ans.Remove(&kernelspecs.CmdlineParam{Key: "initrd"})
+
+	return ans, nil
+}
+
+// ReadKernelCmdline returns the kernel command line of the file
+// as string. See LoadKernelCmdline.
+func ReadKernelCmdline(file string) (string, error) {
+	c, err := LoadKernelCmdline(file)
+	if err != nil {
+		return "", err
+	}
+	return c.String(), nil
+}
+
+// WriteKernelCmdline validates and writes the kernel command line
+// to the file.
+func WriteKernelCmdline(file string, c *kernelspecs.KernelCmdline) error {
+	if file == "" {
+		file = utils.RootPath(DefaultKernelCmdlineFile)
+	}
+
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	err := os.MkdirAll(filepath.Dir(file), 0755)
+	if err != nil {
+		return errors.New(fmt.Sprintf(
+			"Error on create directory %s: %s", filepath.Dir(file), err.Error()))
+	}
+
+	tmpFile := file + ".tmp"
+	err = ioutil.WriteFile(tmpFile, []byte(c.String()+"\n"), 0644)
+	if err != nil {
+		return errors.New(fmt.Sprintf(
+			"Error on write file %s: %s", tmpFile, err.Error()))
+	}
+
+	return os.Rename(tmpFile, file)
+}
+
+// setGrubDefaultCmdline returns the content of /etc/default/grub with
+// the block that sets the kernel command line. The block is at the end
+// of the file to override the values of the previous lines: the managed
+// command line replaces also GRUB_CMDLINE_LINUX_DEFAULT. Without set
+// the block is removed.
+func setGrubDefaultCmdline(content, cmdline string, set bool) string {
+	lines := []string{}
+	inBlock := false
+	for _, line := range strings.Split(content, "\n") {
+		switch {
+		case line == grubDefaultBlockBegin:
+			inBlock = true
+		case line == grubDefaultBlockEnd:
+			inBlock = false
+		case !inBlock:
+			lines = append(lines, line)
+		}
+	}
+
+	ans := strings.TrimRight(strings.Join(lines, "\n"), "\n")
+	if ans != "" {
+		ans += "\n"
+	}
+
+	if set {
+		ans += strings.Join([]string{
+			grubDefaultBlockBegin,
+			"GRUB_CMDLINE_LINUX=" + grubQuote(cmdline),
+			"GRUB_CMDLINE_LINUX_DEFAULT=''",
+			grubDefaultBlockEnd,
+		}, "\n") + "\n"
+	}
+
+	return ans
+}
+
+// UpdateGrubDefaultCmdline writes the managed kernel command line on
+// the grub defaults file sourced by grub-mkconfig. The file is changed
+// only if it already contains the block of the command line or with
+// enable, used by mos kernel cmdline when the user changes the command
+// line. The /etc/kernel/cmdline created by other tools (for example
+// kernel-install) doesn't change the grub defaults. Without the kernel
+// command line file the block is removed and the values of the grub
+// defaults file are used. The overrides of the kernel types are
+// available only with the entries generated by mos (mos.cfg).
+func UpdateGrubDefaultCmdline(file string, enable, dryRun bool) error {
+	if file == "" {
+		file = utils.RootPath(DefaultGrubDefaultFile)
+	}
+
+	current, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// Grub isn't configured.
+			return nil
+		}
+		return errors.New(fmt.Sprintf(
+			"Error on read file %s: %s", file, err.Error()))
+	}
+
+	if !enable && !strings.Contains(string(current), grubDefaultBlockBegin) {
+		// The command line isn't managed by mos.
+		return nil
+	}
+
+	set := false
+	cmdline := ""
+	cmdlineFile := utils.RootPath(DefaultKernelCmdlineFile)
+	if _, err := os.Stat(cmdlineFile); err == nil {
+		cmdline, err = ReadKernelCmdline(cmdlineFile)
+		if err != nil {
+			return err
+		}
+		set = true
+	}
+
+	content := setGrubDefaultCmdline(string(current), cmdline, set)
+	if content == string(current) {
+		return nil
+	}
+
+	err = updateFile(file, content, dryRun)
+	if err != nil {
+		return errors.New(fmt.Sprintf(
+			"Error on update file %s: %s", file, err.Error()))
+	}
+
+	// With dry-run the changes are printed by updateFile.
+	if dryRun {
+		return nil
+	}
+
+	if set {
+		fmt.Println(fmt.Sprintf(
+			"Updated %s: GRUB_CMDLINE_LINUX=%s and GRUB_CMDLINE_LINUX_DEFAULT is empty.",
+			file, grubQuote(cmdline)))
+	} else {
+		fmt.Println(fmt.Sprintf(
+			"Removed the kernel command line of %s: %s is not available.",
+			file, cmdlineFile))
+	}
+
+	return nil
+}
+
+// GetKernelFilesCmdline returns the command line in input with the
+// overrides of the kernel type of the kernel files.
+func GetKernelFilesCmdline(cmdline string, kf *kernelspecs.KernelFiles) string {
+	ans, err := kf.Type.MergeCmdline(cmdline)
+	if err != nil {
+		Warning(fmt.Sprintf("Ignoring cmdline overrides: %s", err.Error()))
+		return cmdline
+	}
+	return ans
+}
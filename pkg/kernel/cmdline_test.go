@@ -0,0 +1,167 @@
+// Copyright © 2021 Daniele Rondina, geaaru@sabayonlinux.org
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package kernel
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestSetGrubDefaultCmdline(t *testing.T) {
+	block := grubDefaultBlockBegin + "\n" +
+		"GRUB_CMDLINE_LINUX='root=/dev/sda1 ro'\n" +
+		"GRUB_CMDLINE_LINUX_DEFAULT=''\n" +
+		grubDefaultBlockEnd + "\n"
+
+	tests := []struct {
+		name     string
+		content  string
+		cmdline  string
+		set      bool
+		expected string
+	}{
+		{"empty file", "", "root=/dev/sda1 ro", true, block},
+		{
+			"existing values",
+			"GRUB_TIMEOUT=5\nGRUB_CMDLINE_LINUX=\"quiet\"\n",
+			"root=/dev/sda1 ro", true,
+			"GRUB_TIMEOUT=5\nGRUB_CMDLINE_LINUX=\"quiet\"\n" + block,
+		},
+		{
+			"replace block",
+			"GRUB_TIMEOUT=5\n" + grubDefaultBlockBegin + "\nGRUB_CMDLINE_LINUX='old'\n" +
+				grubDefaultBlockEnd + "\nGRUB_DISABLE_RECOVERY=true\n",
+			"root=/dev/sda1 ro", true,
+			"GRUB_TIMEOUT=5\nGRUB_DISABLE_RECOVERY=true\n" + block,
+		},
+		{
+			"remove block",
+			"GRUB_TIMEOUT=5\n" + block,
+			"", false,
+			"GRUB_TIMEOUT=5\n",
+		},
+		{
+			"quotes",
+			"",
+			`acpi_osi="Windows 2020" opt='x'`, true,
+			grubDefaultBlockBegin + "\n" +
+				`GRUB_CMDLINE_LINUX='acpi_osi="Windows 2020" opt='\''x'\'''` + "\n" +
+				"GRUB_CMDLINE_LINUX_DEFAULT=''\n" +
+				grubDefaultBlockEnd + "\n",
+		},
+	}
+
+	for _, tt := range tests {
+		got := setGrubDefaultCmdline(tt.content, tt.cmdline, tt.set)
+		if got != tt.expected {
+			t.Errorf("%s: expected\n%s\ngot\n%s", tt.name, tt.expected, got)
+		}
+	}
+}
+
+func TestUpdateGrubDefaultCmdline(t *testing.T) {
+	defaults := "GRUB_TIMEOUT=5\nGRUB_CMDLINE_LINUX_DEFAULT=\"quiet\"\n"
+	managed := defaults + setGrubDefaultCmdline("", "root=/dev/sda1 ro", true)
+
+	tests := []struct {
+		name     string
+		content  string
+		noGrub   bool
+		cmdline  string
+		enable   bool
+		expected string
+	}{
+		{
+			// For example the file created by kernel-install.
+			name:     "cmdline not managed",
+			content:  defaults,
+			cmdline:  "root=/dev/sda1 ro",
+			expected: defaults,
+		},
+		{
+			name:     "enable",
+			content:  defaults,
+			cmdline:  "root=/dev/sda1 ro",
+			enable:   true,
+			expected: managed,
+		},
+		{
+			name:     "enable without grub",
+			noGrub:   true,
+			cmdline:  "root=/dev/sda1 ro",
+			enable:   true,
+			expected: "",
+		},
+		{
+			name:     "update managed block",
+			content:  defaults + setGrubDefaultCmdline("", "root=/dev/sda2", true),
+			cmdline:  "root=/dev/sda1 ro",
+			expected: managed,
+		},
+		{
+			name:     "remove managed block",
+			content:  managed,
+			expected: defaults,
+		},
+		{
+			name:     "enable without cmdline",
+			content:  defaults,
+			enable:   true,
+			expected: defaults,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			viper.Set("root", root)
+			defer viper.Set("root", "")
+
+			grubDefault := filepath.Join(root, DefaultGrubDefaultFile)
+			if !tt.noGrub {
+				writeTestCmdlineFile(t, grubDefault, tt.content)
+			}
+			if tt.cmdline != "" {
+				writeTestCmdlineFile(t, filepath.Join(root, DefaultKernelCmdlineFile), tt.cmdline+"\n")
+			}
+
+			if err := UpdateGrubDefaultCmdline("", tt.enable, false); err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := ioutil.ReadFile(grubDefault)
+			if err != nil && !(tt.noGrub && os.IsNotExist(err)) {
+				t.Fatal(err)
+			}
+			if string(data) != tt.expected {
+				t.Errorf("got\n%s\nwant\n%s", data, tt.expected)
+			}
+		})
+	}
+}
+
+func writeTestCmdlineFile(t *testing.T, file, content string) {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
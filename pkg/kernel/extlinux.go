@@ -0,0 +1,259 @@
+// Copyright © 2021 Daniele Rondina, geaaru@sabayonlinux.org
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package kernel
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	kernelspecs "github.com/MocaccinoOS/mos-cli/pkg/kernel/specs"
+	"github.com/MocaccinoOS/mos-cli/pkg/utils"
+)
+
+const (
+	BootloaderExtlinux = "extlinux"
+
+	// Prefix of the labels of the extlinux.conf entries.
+	ExtlinuxLabelPrefix = "mos-"
+)
+
+type ExtlinuxOptions struct {
+	// Path of extlinux.conf. Default is <bootdir>/extlinux/extlinux.conf.
+	File  string
+	Title string
+	// Kernel parameters of the append line.
+	Append string
+	// Directory with the devicetree files. If empty the directory
+	// is searched on the boot dir for every kernel.
+	FdtDir string
+	// Devicetree file used instead of fdtdir.
+	Fdt string
+	// Prefix of the paths seen by the bootloader. If empty it's /
+	// when the boot dir is a mount point of the system root or the
+	// path of the boot dir on the system root.
+	PathPrefix string
+	// Timeout in seconds. A negative value disables the menu timeout.
+	Timeout int
+	DryRun  bool
+}
+
+// ExtlinuxLabel is a label of extlinux.conf.
+type ExtlinuxLabel struct {
+	Label     string `json:"label"`
+	MenuLabel string `json:"menu_label"`
+	Kernel    string `json:"kernel"`
+	Initrd    string `json:"initrd,omitempty"`
+	FdtDir    string `json:"fdtdir,omitempty"`
+	Fdt       string `json:"fdt,omitempty"`
+	Append    string `json:"append,omitempty"`
+}
+
+func (l *ExtlinuxLabel) String() string {
+	ans := fmt.Sprintf("label %s\n\tmenu label %s\n\tkernel %s\n",
+		l.Label, l.MenuLabel, l.Kernel)
+	if l.Initrd != "" {
+		ans += fmt.Sprintf("\tinitrd %s\n", l.Initrd)
+	}
+	if l.Fdt != "" {
+		ans += fmt.Sprintf("\tfdt %s\n", l.Fdt)
+	} else if l.FdtDir != "" {
+		ans += fmt.Sprintf("\tfdtdir %s\n", l.FdtDir)
+	}
+	if l.Append != "" {
+		ans += fmt.Sprintf("\tappend %s\n", l.Append)
+	}
+	return ans
+}
+
+// NewExtlinuxOptions returns the options with the values of the
+// system: os-release name and kernel command line.
+func NewExtlinuxOptions() *ExtlinuxOptions {
+	ans := &ExtlinuxOptions{
+		Title:   utils.OsPrettyName(),
+		Timeout: 3,
+	}
+
+	if ans.Title == "" {
+		ans.Title = "MocaccinoOS"
+	}
+
+	if cmdline, err := ReadKernelCmdline(""); err == nil {
+		ans.Append = cmdline
+	}
+
+	return ans
+}
+
+// isMountPoint returns true if the directory is on a different
+// device of the parent directory.
+func isMountPoint(dir string) bool {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return false
+	}
+	parent, err := os.Stat(filepath.Join(dir, ".."))
+	if err != nil {
+		return false
+	}
+
+	s1, ok1 := info.Sys().(*syscall.Stat_t)
+	s2, ok2 := parent.Sys().(*syscall.Stat_t)
+	if !ok1 || !ok2 {
+		return false
+	}
+
+	return s1.Dev != s2.Dev || s1.Ino == s2.Ino
+}
+
+// getExtlinuxPathPrefix returns the prefix of the paths of the boot
+// dir seen by the bootloader. The mounts of the host are ignored with
+// a system root.
+func getExtlinuxPathPrefix(bootDir string) string {
+	if abs, err := filepath.Abs(bootDir); err == nil {
+		bootDir = abs
+	}
+
+	if utils.RootPath(bootDir) == bootDir && isMountPoint(bootDir) {
+		return "/"
+	}
+
+	return utils.ChrootPath(bootDir)
+}
+
+// getFdtDir returns the devicetree directory of the kernel
+// relative to the boot dir.
+func getFdtDir(bootDir, release string) string {
+	for _, d := range []string{
+		filepath.Join("dtbs", release),
+		"dtb-" + release,
+		"dtbs",
+		"dtb",
+	} {
+		if info, err := os.Stat(filepath.Join(bootDir, d)); err == nil && info.IsDir() {
+			return d
+		}
+	}
+	return ""
+}
+
+// GetExtlinuxLabels returns the labels of the kernels of the boot dir.
+func GetExtlinuxLabels(bootFiles *kernelspecs.BootFiles, opts *ExtlinuxOptions) []*ExtlinuxLabel {
+	ans := []*ExtlinuxLabel{}
+
+	prefix := opts.PathPrefix
+	if prefix == "" {
+		prefix = getExtlinuxPathPrefix(bootFiles.Dir)
+	}
+
+	for _, kf := range bootFiles.Files {
+		if kf.Kernel == nil {
+			continue
+		}
+
+		release := kf.Kernel.GetKernelRelease()
+		l := &ExtlinuxLabel{
+			Label:     ExtlinuxLabelPrefix + release,
+			MenuLabel: fmt.Sprintf("%s (%s)", opts.Title, release),
+			Kernel:    filepath.Join(prefix, kf.Kernel.GetFilename()),
+			Append:    GetKernelFilesCmdline(opts.Append, kf),
+		}
+
+		if kf.Initrd != nil {
+			l.Initrd = filepath.Join(prefix, kf.Initrd.GetFilename())
+		}
+
+		if opts.Fdt != "" {
+			l.Fdt = opts.Fdt
+		} else if opts.FdtDir != "" {
+			l.FdtDir = opts.FdtDir
+		} else if d := getFdtDir(bootFiles.Dir, release); d != "" {
+			l.FdtDir = filepath.Join(prefix, d)
+		}
+
+		ans = append(ans, l)
+	}
+
+	return ans
+}
+
+// GenerateExtlinuxConf returns the content of extlinux.conf with
+// a label for every kernel of the boot dir. The default label is
+// the kernel of the bzImage link or the newest kernel.
+func GenerateExtlinuxConf(bootFiles *kernelspecs.BootFiles, opts *ExtlinuxOptions) string {
+	var ans strings.Builder
+
+	ans.WriteString("# Generated by mos. Don't edit this file.\n")
+	ans.WriteString(fmt.Sprintf("menu title %s\n", opts.Title))
+
+	kf := bootFiles.GetBzImageKernel()
+	if kf == nil {
+		kf = bootFiles.GetLatestKernel("", "")
+	}
+	if kf != nil {
+		ans.WriteString(fmt.Sprintf("default %s%s\n",
+			ExtlinuxLabelPrefix, kf.Kernel.GetKernelRelease()))
+	}
+
+	if opts.Timeout >= 0 {
+		// The timeout of extlinux is in tenths of a second.
+		ans.WriteString(fmt.Sprintf("timeout %d\n", opts.Timeout*10))
+	}
+
+	// The labels are written from the newest kernel.
+	labels := GetExtlinuxLabels(bootFiles, opts)
+	for idx := len(labels) - 1; idx >= 0; idx-- {
+		ans.WriteString("\n" + labels[idx].String())
+	}
+
+	return ans.String()
+}
+
+// UpdateExtlinuxConf writes extlinux.conf. With dry-run the
+// differences with the existing file are printed.
+func UpdateExtlinuxConf(bootFiles *kernelspecs.BootFiles, opts *ExtlinuxOptions) error {
+	file := opts.File
+	if file == "" {
+		file = filepath.Join(bootFiles.Dir, "extlinux", "extlinux.conf")
+	}
+
+	content := GenerateExtlinuxConf(bootFiles, opts)
+
+	if opts.DryRun {
+		current, err := ioutil.ReadFile(file)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		diff := utils.LinesDiff(string(current), content)
+		if diff == "" {
+			fmt.Println(fmt.Sprintf("[dry-run mode] %s is already updated.", file))
+		} else {
+			fmt.Println(fmt.Sprintf("[dry-run mode] changes of %s:\n%s", file, diff))
+		}
+		return nil
+	}
+
+	err := os.MkdirAll(filepath.Dir(file), 0755)
+	if err != nil {
+		return err
+	}
+
+	return writeFile(file, content, false)
+}
@@ -0,0 +1,267 @@
+// Copyright © 2021 Daniele Rondina, geaaru@sabayonlinux.org
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package kernel
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestGetExtlinuxLabelsRootDir(t *testing.T) {
+	root := t.TempDir()
+	viper.Set("root", root)
+	defer viper.Set("root", "")
+
+	bootFiles := newTestBootDir(t, filepath.Join(root, "boot"),
+		"kernel-vanilla-x86_64-5.10.42-mocaccino",
+		"initramfs-vanilla-x86_64-5.10.42-mocaccino",
+		"dtbs/5.10.42-mocaccino/",
+	)
+
+	labels := GetExtlinuxLabels(bootFiles, &ExtlinuxOptions{Title: "MocaccinoOS", Append: "ro"})
+	want := []*ExtlinuxLabel{
+		{
+			Label:     "mos-5.10.42-mocaccino",
+			MenuLabel: "MocaccinoOS (5.10.42-mocaccino)",
+			Kernel:    "/boot/kernel-vanilla-x86_64-5.10.42-mocaccino",
+			Initrd:    "/boot/initramfs-vanilla-x86_64-5.10.42-mocaccino",
+			FdtDir:    "/boot/dtbs/5.10.42-mocaccino",
+			Append:    "ro",
+		},
+	}
+	if len(labels) != 1 {
+		t.Fatalf("got %d labels, want 1", len(labels))
+	}
+	if !reflect.DeepEqual(labels, want) {
+		t.Errorf("got label %+v, want %+v", labels[0], want[0])
+	}
+}
+
+func TestGetExtlinuxLabels(t *testing.T) {
+	const (
+		kernel = "kernel-vanilla-x86_64-5.10.42-mocaccino"
+		initrd = "initramfs-vanilla-x86_64-5.10.42-mocaccino"
+	)
+	newLabel := func(prefix string) *ExtlinuxLabel {
+		return &ExtlinuxLabel{
+			Label:     "mos-5.10.42-mocaccino",
+			MenuLabel: "MocaccinoOS (5.10.42-mocaccino)",
+			Kernel:    filepath.Join(prefix, kernel),
+			Initrd:    filepath.Join(prefix, initrd),
+			Append:    "ro",
+		}
+	}
+
+	tests := []struct {
+		name  string
+		files []string
+		opts  ExtlinuxOptions
+		// want returns the labels for the boot dir.
+		want func(bootDir string) []*ExtlinuxLabel
+	}{
+		{
+			name:  "boot dir path",
+			files: []string{kernel, initrd},
+			want: func(bootDir string) []*ExtlinuxLabel {
+				return []*ExtlinuxLabel{newLabel(bootDir)}
+			},
+		},
+		{
+			name:  "path prefix",
+			files: []string{kernel, initrd},
+			opts:  ExtlinuxOptions{PathPrefix: "/"},
+			want: func(bootDir string) []*ExtlinuxLabel {
+				return []*ExtlinuxLabel{newLabel("/")}
+			},
+		},
+		{
+			name:  "kernel without initrd",
+			files: []string{kernel},
+			opts:  ExtlinuxOptions{PathPrefix: "/"},
+			want: func(bootDir string) []*ExtlinuxLabel {
+				l := newLabel("/")
+				l.Initrd = ""
+				return []*ExtlinuxLabel{l}
+			},
+		},
+		{
+			name:  "dtb directory of the release",
+			files: []string{kernel, initrd, "dtb-5.10.42-mocaccino/", "dtbs/"},
+			opts:  ExtlinuxOptions{PathPrefix: "/"},
+			want: func(bootDir string) []*ExtlinuxLabel {
+				l := newLabel("/")
+				l.FdtDir = "/dtb-5.10.42-mocaccino"
+				return []*ExtlinuxLabel{l}
+			},
+		},
+		{
+			name:  "fdtdir option",
+			files: []string{kernel, initrd, "dtbs/5.10.42-mocaccino/"},
+			opts:  ExtlinuxOptions{PathPrefix: "/", FdtDir: "/custom/dtbs"},
+			want: func(bootDir string) []*ExtlinuxLabel {
+				l := newLabel("/")
+				l.FdtDir = "/custom/dtbs"
+				return []*ExtlinuxLabel{l}
+			},
+		},
+		{
+			name:  "fdt option",
+			files: []string{kernel, initrd, "dtbs/5.10.42-mocaccino/"},
+			opts:  ExtlinuxOptions{PathPrefix: "/", FdtDir: "/custom/dtbs", Fdt: "/custom/board.dtb"},
+			want: func(bootDir string) []*ExtlinuxLabel {
+				l := newLabel("/")
+				l.Fdt = "/custom/board.dtb"
+				return []*ExtlinuxLabel{l}
+			},
+		},
+		{
+			name:  "initrd without kernel",
+			files: []string{kernel, initrd, "initramfs-vanilla-x86_64-5.10.41-mocaccino"},
+			opts:  ExtlinuxOptions{PathPrefix: "/"},
+			want: func(bootDir string) []*ExtlinuxLabel {
+				return []*ExtlinuxLabel{newLabel("/")}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bootDir := t.TempDir()
+			bootFiles := newTestBootDir(t, bootDir, tt.files...)
+
+			opts := tt.opts
+			opts.Title = "MocaccinoOS"
+			opts.Append = "ro"
+
+			labels := GetExtlinuxLabels(bootFiles, &opts)
+			want := tt.want(bootDir)
+			if len(labels) != len(want) {
+				t.Fatalf("got %d labels, want %d", len(labels), len(want))
+			}
+			for idx := range want {
+				if !reflect.DeepEqual(labels[idx], want[idx]) {
+					t.Errorf("got label %+v, want %+v", labels[idx], want[idx])
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateExtlinuxConf(t *testing.T) {
+	files := []string{
+		"kernel-vanilla-x86_64-5.10.41-mocaccino",
+		"kernel-vanilla-x86_64-5.10.42-mocaccino",
+		"initramfs-vanilla-x86_64-5.10.42-mocaccino",
+	}
+	labels := `
+label mos-5.10.42-mocaccino
+	menu label MocaccinoOS (5.10.42-mocaccino)
+	kernel /kernel-vanilla-x86_64-5.10.42-mocaccino
+	initrd /initramfs-vanilla-x86_64-5.10.42-mocaccino
+	append ro
+
+label mos-5.10.41-mocaccino
+	menu label MocaccinoOS (5.10.41-mocaccino)
+	kernel /kernel-vanilla-x86_64-5.10.41-mocaccino
+	append ro
+`
+
+	tests := []struct {
+		name    string
+		bzImage string
+		timeout int
+		want    string
+	}{
+		{
+			name:    "newest kernel",
+			timeout: 3,
+			want: "# Generated by mos. Don't edit this file.\n" +
+				"menu title MocaccinoOS\n" +
+				"default mos-5.10.42-mocaccino\n" +
+				"timeout 30\n" + labels,
+		},
+		{
+			name:    "bzImage kernel",
+			bzImage: "kernel-vanilla-x86_64-5.10.41-mocaccino",
+			timeout: 0,
+			want: "# Generated by mos. Don't edit this file.\n" +
+				"menu title MocaccinoOS\n" +
+				"default mos-5.10.41-mocaccino\n" +
+				"timeout 0\n" + labels,
+		},
+		{
+			name:    "bzImage of a removed kernel",
+			bzImage: "kernel-vanilla-x86_64-5.10.40-mocaccino",
+			timeout: -1,
+			want: "# Generated by mos. Don't edit this file.\n" +
+				"menu title MocaccinoOS\n" +
+				"default mos-5.10.42-mocaccino\n" + labels,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bootDir := t.TempDir()
+			if tt.bzImage != "" {
+				if err := os.Symlink(tt.bzImage, filepath.Join(bootDir, BzImageLink)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			bootFiles := newTestBootDir(t, bootDir, files...)
+
+			got := GenerateExtlinuxConf(bootFiles, &ExtlinuxOptions{
+				Title:      "MocaccinoOS",
+				Append:     "ro",
+				PathPrefix: "/",
+				Timeout:    tt.timeout,
+			})
+			if got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateExtlinuxConf(t *testing.T) {
+	bootDir := t.TempDir()
+	bootFiles := newTestBootDir(t, bootDir, "kernel-vanilla-x86_64-5.10.42-mocaccino")
+	file := filepath.Join(bootDir, "extlinux", "extlinux.conf")
+	opts := &ExtlinuxOptions{Title: "MocaccinoOS", PathPrefix: "/", DryRun: true}
+
+	if err := UpdateExtlinuxConf(bootFiles, opts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Fatalf("%s written with dry-run", file)
+	}
+
+	opts.DryRun = false
+	if err := UpdateExtlinuxConf(bootFiles, opts); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := GenerateExtlinuxConf(bootFiles, opts); string(data) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", data, want)
+	}
+}
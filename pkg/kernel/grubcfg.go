@@ -0,0 +1,321 @@
+// Copyright © 2021 Daniele Rondina, geaaru@sabayonlinux.org
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package kernel
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	kernelspecs "github.com/MocaccinoOS/mos-cli/pkg/kernel/specs"
+	. "github.com/MocaccinoOS/mos-cli/pkg/logger"
+	"github.com/MocaccinoOS/mos-cli/pkg/utils"
+)
+
+const (
+	GrubMenuEntryPrefix = "mos-"
+	GrubMosCfgFile      = "mos.cfg"
+)
+
+// GrubCfgOptions are the options of the native generator of the
+// GRUB menu entries.
+type GrubCfgOptions struct {
+	// Directory of grub.cfg. Default is <bootdir>/grub.
+	Dir   string
+	Title string
+	// Kernel command line of the entries. The root parameter is
+	// added with RootUuid if it's not present.
+	Cmdline  string
+	RootUuid string
+	// Filesystem UUID and type of the boot dir filesystem used to
+	// search the GRUB root device.
+	BootUuid   string
+	BootFsType string
+	// Path of the boot dir on the boot filesystem.
+	PathPrefix string
+	Timeout    int
+	DryRun     bool
+}
+
+// GrubMenuEntry is a menu entry of the native generator.
+type GrubMenuEntry struct {
+	Id      string `json:"id"`
+	Title   string `json:"title"`
+	Linux   string `json:"linux"`
+	Initrd  string `json:"initrd,omitempty"`
+	Cmdline string `json:"cmdline,omitempty"`
+}
+
+// grubQuote returns the string as GRUB single quoted word.
+func grubQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// grubFsModule returns the GRUB module of the filesystem type.
+func grubFsModule(fstype string) string {
+	switch fstype {
+	case "ext2", "ext3", "ext4":
+		return "ext2"
+	case "vfat", "msdos":
+		return "fat"
+	case "btrfs", "xfs", "f2fs", "zfs":
+		return fstype
+	default:
+		return ""
+	}
+}
+
+func (e *GrubMenuEntry) String(opts *GrubCfgOptions) string {
+	var ans strings.Builder
+
+	ans.WriteString(fmt.Sprintf("menuentry %s --class mocaccino --class gnu-linux --class os --id %s {\n",
+		grubQuote(e.Title), grubQuote(e.Id)))
+	ans.WriteString("\tload_video\n")
+	ans.WriteString("\tinsmod gzio\n")
+	ans.WriteString("\tinsmod part_gpt\n")
+	ans.WriteString("\tinsmod part_msdos\n")
+	if m := grubFsModule(opts.BootFsType); m != "" {
+		ans.WriteString(fmt.Sprintf("\tinsmod %s\n", m))
+	}
+	if opts.BootUuid != "" {
+		ans.WriteString(fmt.Sprintf("\tsearch --no-floppy --fs-uuid --set=root %s\n", opts.BootUuid))
+	} else {
+		ans.WriteString(fmt.Sprintf("\tsearch --no-floppy --file --set=root %s\n", e.Linux))
+	}
+	ans.WriteString(fmt.Sprintf("\techo %s\n", grubQuote("Loading Linux "+e.Id+" ...")))
+	ans.WriteString(strings.TrimRight(fmt.Sprintf("\tlinux %s %s", e.Linux, e.Cmdline), " ") + "\n")
+	if e.Initrd != "" {
+		ans.WriteString(fmt.Sprintf("\techo %s\n", grubQuote("Loading initial ramdisk ...")))
+		ans.WriteString(fmt.Sprintf("\tinitrd %s\n", e.Initrd))
+	}
+	ans.WriteString("}\n")
+
+	return ans.String()
+}
+
+// NewGrubCfgOptions returns the options with the values of the
+// system. The root and boot filesystems are read from
+// /proc/self/mountinfo.
+func NewGrubCfgOptions(bootDir string) *GrubCfgOptions {
+	if abs, err := filepath.Abs(bootDir); err == nil {
+		bootDir = abs
+	}
+
+	ans := &GrubCfgOptions{
+		Dir:        filepath.Join(bootDir, "grub"),
+		Title:      utils.OsPrettyName(),
+		PathPrefix: utils.ChrootPath(bootDir),
+		Timeout:    5,
+	}
+
+	if ans.Title == "" {
+		ans.Title = "MocaccinoOS"
+	}
+
+	if cmdline, err := ReadKernelCmdline(""); err == nil {
+		ans.Cmdline = cmdline
+	}
+
+	mounts, err := utils.ReadMountInfo("")
+	if err != nil {
+		DebugC("Error on read mountinfo:", err.Error())
+		return ans
+	}
+
+	// The mounts of the host are ignored with a system root that
+	// isn't a mount point. The root UUID must be set by the user.
+	rootDir := utils.GetRootDir()
+	inRoot := func(m *utils.MountInfo) bool {
+		return m != nil && utils.RootPath(m.MountPoint) == m.MountPoint
+	}
+
+	if m := utils.GetMountForPath(mounts, rootDir); inRoot(m) {
+		ans.RootUuid, _ = utils.GetMountUuid(m)
+	}
+
+	if m := utils.GetMountForPath(mounts, bootDir); inRoot(m) {
+		ans.BootUuid, _ = utils.GetMountUuid(m)
+		ans.BootFsType = m.FsType
+		if rel, err := filepath.Rel(m.MountPoint, bootDir); err == nil {
+			ans.PathPrefix = filepath.Join("/", m.Root, rel)
+		}
+	}
+
+	return ans
+}
+
+// getGrubCmdline returns the command line of the kernel with the
+// root parameter.
+func getGrubCmdline(kf *kernelspecs.KernelFiles, opts *GrubCfgOptions) string {
+	cmdline := GetKernelFilesCmdline(opts.Cmdline, kf)
+	if opts.RootUuid == "" {
+		return cmdline
+	}
+
+	c, err := kernelspecs.ParseKernelCmdline(cmdline)
+	if err != nil || len(c.Get("root")) > 0 {
+		return cmdline
+	}
+
+	return strings.TrimSpace("root=UUID=" + opts.RootUuid + " " + cmdline)
+}
+
+// GetGrubMenuEntries returns the menu entries of the kernels of the
+// boot dir from the newest kernel.
+func GetGrubMenuEntries(bootFiles *kernelspecs.BootFiles, opts *GrubCfgOptions) []*GrubMenuEntry {
+	ans := []*GrubMenuEntry{}
+
+	for idx := len(bootFiles.Files) - 1; idx >= 0; idx-- {
+		kf := bootFiles.Files[idx]
+		if kf.Kernel == nil {
+			continue
+		}
+
+		release := kf.Kernel.GetKernelRelease()
+		e := &GrubMenuEntry{
+			Id:      GrubMenuEntryPrefix + release,
+			Title:   fmt.Sprintf("%s (%s)", opts.Title, release),
+			Linux:   filepath.Join("/", opts.PathPrefix, kf.Kernel.GetFilename()),
+			Cmdline: getGrubCmdline(kf, opts),
+		}
+
+		if kf.Initrd != nil {
+			e.Initrd = filepath.Join("/", opts.PathPrefix, kf.Initrd.GetFilename())
+		}
+
+		ans = append(ans, e)
+	}
+
+	return ans
+}
+
+// GenerateGrubMosCfg returns the content of mos.cfg with the menu
+// entries of the kernels. The default entry is the kernel of the
+// bzImage link if grub.cfg doesn't set the default.
+func GenerateGrubMosCfg(bootFiles *kernelspecs.BootFiles, opts *GrubCfgOptions) string {
+	var ans strings.Builder
+
+	ans.WriteString("# Generated by mos. Don't edit this file.\n")
+
+	kf := bootFiles.GetBzImageKernel()
+	if kf == nil {
+		kf = bootFiles.GetLatestKernel("", "")
+	}
+	if kf != nil {
+		ans.WriteString(fmt.Sprintf("if [ -z \"${default}\" ]; then\n\tset default=%s\nfi\n",
+			grubQuote(GrubMenuEntryPrefix+kf.Kernel.GetKernelRelease())))
+	}
+
+	for _, e := range GetGrubMenuEntries(bootFiles, opts) {
+		ans.WriteString("\n" + e.String(opts))
+	}
+
+	return ans.String()
+}
+
+// GenerateGrubCfg returns the content of a grub.cfg that loads the
+// GRUB environment and sources mos.cfg.
+func GenerateGrubCfg(opts *GrubCfgOptions) string {
+	var ans strings.Builder
+
+	ans.WriteString(`# Generated by mos. Don't edit this file.
+if [ -s $prefix/grubenv ]; then
+	load_env
+fi
+if [ "${next_entry}" ]; then
+	set default="${next_entry}"
+	set next_entry=
+	save_env next_entry
+elif [ "${saved_entry}" ]; then
+	set default="${saved_entry}"
+fi
+
+function load_video {
+	insmod all_video
+}
+
+if [ x$feature_timeout_style = xy ]; then
+	set timeout_style=menu
+fi
+`)
+	if opts.Timeout >= 0 {
+		ans.WriteString(fmt.Sprintf("set timeout=%d\n", opts.Timeout))
+	}
+	ans.WriteString(fmt.Sprintf("\nsource $prefix/%s\n", GrubMosCfgFile))
+
+	return ans.String()
+}
+
+// updateFile writes the file or prints the differences with the
+// existing file with dry-run.
+func updateFile(file, content string, dryRun bool) error {
+	if dryRun {
+		current, err := ioutil.ReadFile(file)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		diff := utils.LinesDiff(string(current), content)
+		if diff == "" {
+			fmt.Println(fmt.Sprintf("[dry-run mode] %s is already updated.", file))
+		} else {
+			fmt.Println(fmt.Sprintf("[dry-run mode] changes of %s:\n%s", file, diff))
+		}
+		return nil
+	}
+
+	err := os.MkdirAll(filepath.Dir(file), 0755)
+	if err != nil {
+		return err
+	}
+
+	return writeFile(file, content, false)
+}
+
+// UpdateGrubCfg writes the menu entries on <grubdir>/mos.cfg without
+// grub-mkconfig. If grub.cfg doesn't exist it's generated to source
+// mos.cfg, otherwise a warning is printed when grub.cfg doesn't
+// source it.
+func UpdateGrubCfg(bootFiles *kernelspecs.BootFiles, opts *GrubCfgOptions) error {
+	grubDir := opts.Dir
+	if grubDir == "" {
+		grubDir = filepath.Join(bootFiles.Dir, "grub")
+	}
+	mosCfg := filepath.Join(grubDir, GrubMosCfgFile)
+	grubCfg := filepath.Join(grubDir, "grub.cfg")
+
+	err := updateFile(mosCfg, GenerateGrubMosCfg(bootFiles, opts), opts.DryRun)
+	if err != nil {
+		return err
+	}
+
+	content, err := ioutil.ReadFile(grubCfg)
+	if os.IsNotExist(err) {
+		return updateFile(grubCfg, GenerateGrubCfg(opts), opts.DryRun)
+	} else if err != nil {
+		return err
+	}
+
+	if !strings.Contains(string(content), GrubMosCfgFile) {
+		Warning(fmt.Sprintf(
+			"%s doesn't source %s. Add the line: source $prefix/%s",
+			grubCfg, GrubMosCfgFile, GrubMosCfgFile))
+	}
+
+	return nil
+}
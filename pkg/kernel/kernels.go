@@ -1,41 +1,15 @@
 package kernel
 
 import (
-	"encoding/json"
-	"fmt"
 	"strings"
 
-	"github.com/MocaccinoOS/mos-cli/pkg/utils"
+	kernelspecs "github.com/MocaccinoOS/mos-cli/pkg/kernel/specs"
+	"github.com/MocaccinoOS/mos-cli/pkg/pkgmanager"
 )
 
-type SearchResult struct {
-	Packages []Package
-}
-
-type Package struct {
-	Name, Category, Version string
-}
-
-func (p Package) Equal(pp Package) bool {
-	if p.Name == pp.Name && p.Category == pp.Category && p.Version == pp.Version {
-		return true
-	}
-	return false
-}
+type SearchResult = pkgmanager.SearchResult
 
-func (p Package) EqualS(s string) bool {
-	if s == fmt.Sprintf("%s/%s", p.Category, p.Name) {
-		return true
-	}
-	return false
-}
-
-func (p Package) EqualNoV(pp Package) bool {
-	if p.Name == pp.Name && p.Category == pp.Category {
-		return true
-	}
-	return false
-}
+type Package = pkgmanager.Package
 
 type Kernel struct {
 	Type           string
@@ -43,46 +17,79 @@ type Kernel struct {
 	ModulesPackage string
 }
 
-func Installed() (searchResult SearchResult, err error) {
-	var res []byte
-	res, err = utils.RunSH("search", "luet search --installed kernel --output json")
+// Installed returns the installed packages of the kernel category.
+func Installed(pm pkgmanager.PackageManager) (SearchResult, error) {
+	res, err := pm.Installed("kernel")
 	if err != nil {
-		return
+		return SearchResult{}, err
 	}
-	json.Unmarshal(res, &searchResult)
-	searchResult = searchResult.FilterByCategory("kernel")
-	return
+	return res.FilterByCategory("kernel"), nil
 }
 
-func All() (searchResult SearchResult, err error) {
-	var res []byte
-	res, err = utils.RunSH("search", "luet search kernel --output json")
+// All returns the available packages of the kernel category.
+func All(pm pkgmanager.PackageManager) (SearchResult, error) {
+	res, err := pm.Search("kernel")
 	if err != nil {
-		return
+		return SearchResult{}, err
 	}
-	json.Unmarshal(res, &searchResult)
-	searchResult = searchResult.FilterByCategory("kernel")
-	return
+	return res.FilterByCategory("kernel"), nil
 }
 
-func (s SearchResult) FilterByCategory(cat string) SearchResult {
-	new := SearchResult{Packages: []Package{}}
+// GetSwitchPackages returns the packages to remove and to install
+// to switch to the kernel family in input. The installed kernel
+// packages that aren't part of the target family are removed. The
+// sources are installed if the sources of another kernel are
+// installed.
+func GetSwitchPackages(families []kernelspecs.KernelFamily, installed SearchResult,
+	target *kernelspecs.KernelFamily, micro bool) ([]string, []string) {
+
+	remove := []string{}
+	install := []string{}
+	withSources := false
 
-	for _, r := range s.Packages {
-		if r.Category == cat {
-			new.Packages = append(new.Packages, r)
+	targetPackages := target.GetInstallPackages(micro)
+	if target.Sources != "" {
+		targetPackages = append(targetPackages, target.Sources)
+	}
+
+	isInstalled := func(p string) bool {
+		for _, i := range installed.Packages {
+			if i.EqualS(p) {
+				return true
+			}
 		}
+		return false
 	}
-	return new
-}
 
-func (s SearchResult) FilterByName(name string) SearchResult {
-	new := SearchResult{Packages: []Package{}}
+	for _, i := range installed.Packages {
+		p := i.String()
+		keep := false
+		for _, t := range targetPackages {
+			keep = keep || t == p
+		}
+		if keep {
+			continue
+		}
 
-	for _, r := range s.Packages {
-		if !strings.Contains(r.Name, name) {
-			new.Packages = append(new.Packages, r)
+		remove = append(remove, p)
+		if strings.HasSuffix(i.Name, "-sources") {
+			withSources = true
+		}
+		for _, f := range families {
+			if f.Sources == p {
+				withSources = true
+			}
 		}
 	}
-	return new
+
+	for _, p := range target.GetInstallPackages(micro) {
+		if !isInstalled(p) {
+			install = append(install, p)
+		}
+	}
+	if withSources && target.Sources != "" && !isInstalled(target.Sources) {
+		install = append(install, target.Sources)
+	}
+
+	return remove, install
 }
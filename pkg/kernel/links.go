@@ -0,0 +1,253 @@
+// Copyright © 2021 Daniele Rondina, geaaru@sabayonlinux.org
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package kernel
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	kernelspecs "github.com/MocaccinoOS/mos-cli/pkg/kernel/specs"
+	. "github.com/MocaccinoOS/mos-cli/pkg/logger"
+	"github.com/MocaccinoOS/mos-cli/pkg/utils"
+)
+
+const (
+	BzImageLink = "bzImage"
+	InitrdLink  = "Initrd"
+	// Suffix of the links to the previous default kernel.
+	OldLinkSuffix = ".old"
+
+	DefaultLinksHistoryFile = "/var/lib/mos/kernel-links-history.json"
+	// Max number of entries stored on history file.
+	LinksHistoryMaxEntries = 50
+)
+
+// LinksHistoryEntry describes a change of the default kernel.
+type LinksHistoryEntry struct {
+	Date           string `json:"date"`
+	Kernel         string `json:"kernel"`
+	Initrd         string `json:"initrd,omitempty"`
+	PreviousKernel string `json:"previous_kernel,omitempty"`
+	PreviousInitrd string `json:"previous_initrd,omitempty"`
+	Rollback       bool   `json:"rollback,omitempty"`
+}
+
+type LinksHistory struct {
+	File    string               `json:"-"`
+	Entries []*LinksHistoryEntry `json:"entries"`
+}
+
+func NewLinksHistory(file string) *LinksHistory {
+	return &LinksHistory{
+		File:    file,
+		Entries: []*LinksHistoryEntry{},
+	}
+}
+
+// LoadLinksHistory reads the history file. A not existing file
+// returns an empty history.
+func LoadLinksHistory(file string) (*LinksHistory, error) {
+	ans := NewLinksHistory(file)
+
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ans, nil
+		}
+		return nil, err
+	}
+
+	if err = json.Unmarshal(content, ans); err != nil {
+		return nil, errors.New(
+			fmt.Sprintf("Error on parse history file %s: %s", file, err.Error()))
+	}
+
+	if ans.Entries == nil {
+		ans.Entries = []*LinksHistoryEntry{}
+	}
+
+	return ans, nil
+}
+
+func (h *LinksHistory) Add(e *LinksHistoryEntry) {
+	h.Entries = append(h.Entries, e)
+	if len(h.Entries) > LinksHistoryMaxEntries {
+		h.Entries = h.Entries[len(h.Entries)-LinksHistoryMaxEntries:]
+	}
+}
+
+func (h *LinksHistory) Write() error {
+	data, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(h.File), 0755)
+	if err != nil {
+		return err
+	}
+
+	tmpFile := h.File + ".tmp"
+	err = ioutil.WriteFile(tmpFile, data, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile, h.File)
+}
+
+// ReplaceLink atomically replaces the symbolic link of the directory
+// creating a temporary link renamed over the existing link.
+// An empty target removes the link.
+func ReplaceLink(dir, name, target string) error {
+	link := filepath.Join(dir, name)
+
+	if target == "" {
+		err := os.Remove(link)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	tmpLink := filepath.Join(dir, "."+name+".tmp")
+	// Ignoring errors
+	os.Remove(tmpLink)
+
+	DebugC("Creating link", name, "to", target)
+	err := os.Symlink(target, tmpLink)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmpLink, link)
+	if err != nil {
+		os.Remove(tmpLink)
+		return err
+	}
+
+	return nil
+}
+
+func readLink(dir, name string) string {
+	target, err := os.Readlink(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return target
+}
+
+// setLinks replaces the bzImage and Initrd links and stores the
+// previous targets on the .old links.
+func setLinks(bootDir, kernel, initrd string) (*LinksHistoryEntry, error) {
+	ans := &LinksHistoryEntry{
+		Date:           time.Now().UTC().Format(time.RFC3339),
+		Kernel:         kernel,
+		Initrd:         initrd,
+		PreviousKernel: readLink(bootDir, BzImageLink),
+		PreviousInitrd: readLink(bootDir, InitrdLink),
+	}
+
+	if ans.PreviousKernel != "" && ans.PreviousKernel != kernel {
+		err := ReplaceLink(bootDir, BzImageLink+OldLinkSuffix, ans.PreviousKernel)
+		if err != nil {
+			return nil, err
+		}
+		err = ReplaceLink(bootDir, InitrdLink+OldLinkSuffix, ans.PreviousInitrd)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := ReplaceLink(bootDir, BzImageLink, kernel)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ReplaceLink(bootDir, InitrdLink, initrd)
+	if err != nil {
+		return nil, err
+	}
+
+	return ans, nil
+}
+
+// SetDefaultKernel sets the bzImage and Initrd links to the kernel
+// files in input. The Initrd link is removed if the kernel doesn't
+// have an initrd image.
+func SetDefaultKernel(kf *kernelspecs.KernelFiles, bootDir string) (*LinksHistoryEntry, error) {
+	if kf == nil || kf.Kernel == nil {
+		return nil, errors.New("Invalid kernel file")
+	}
+
+	initrd := ""
+	if kf.Initrd != nil {
+		initrd = kf.Initrd.GenerateFilename()
+	}
+
+	return setLinks(bootDir, kf.Kernel.GetFilename(), initrd)
+}
+
+// RollbackDefaultKernel restores the links of the previous default
+// kernel stored on the .old links. The current targets become the
+// new .old links.
+func RollbackDefaultKernel(bootDir string) (*LinksHistoryEntry, error) {
+	kernel := readLink(bootDir, BzImageLink+OldLinkSuffix)
+	initrd := readLink(bootDir, InitrdLink+OldLinkSuffix)
+
+	if kernel == "" {
+		return nil, errors.New("No previous kernel available")
+	}
+
+	for _, f := range []string{kernel, initrd} {
+		if f == "" {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(bootDir, f)); err != nil {
+			return nil, errors.New(
+				fmt.Sprintf("File %s of the previous kernel not available", f))
+		}
+	}
+
+	ans, err := setLinks(bootDir, kernel, initrd)
+	if err != nil {
+		return nil, err
+	}
+	ans.Rollback = true
+
+	return ans, nil
+}
+
+// RecordLinksChange adds the entry to the history file.
+func RecordLinksChange(file string, e *LinksHistoryEntry) error {
+	if file == "" {
+		file = utils.RootPath(DefaultLinksHistoryFile)
+	}
+
+	h, err := LoadLinksHistory(file)
+	if err != nil {
+		return err
+	}
+
+	h.Add(e)
+
+	return h.Write()
+}
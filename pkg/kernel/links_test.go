@@ -0,0 +1,206 @@
+// Copyright © 2021 Daniele Rondina, geaaru@sabayonlinux.org
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package kernel
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReplaceLink(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing []string
+		target   string
+	}{
+		{name: "new link", target: "kernel-a"},
+		{name: "replace link", existing: []string{"bzImage"}, target: "kernel-b"},
+		{name: "stale temporary link", existing: []string{".bzImage.tmp"}, target: "kernel-b"},
+		{name: "remove link", existing: []string{"bzImage"}},
+		{name: "remove missing link"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, l := range tt.existing {
+				if err := os.Symlink("kernel-a", filepath.Join(dir, l)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := ReplaceLink(dir, BzImageLink, tt.target); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := readLink(dir, BzImageLink); got != tt.target {
+				t.Errorf("got link to %q, want %q", got, tt.target)
+			}
+			if _, err := os.Lstat(filepath.Join(dir, ".bzImage.tmp")); !os.IsNotExist(err) {
+				t.Errorf("temporary link not removed")
+			}
+		})
+	}
+}
+
+// linksState returns the targets of the links of the boot dir.
+func linksState(bootDir string) []string {
+	return []string{
+		readLink(bootDir, BzImageLink),
+		readLink(bootDir, InitrdLink),
+		readLink(bootDir, BzImageLink+OldLinkSuffix),
+		readLink(bootDir, InitrdLink+OldLinkSuffix),
+	}
+}
+
+func TestSetDefaultKernel(t *testing.T) {
+	bootDir := t.TempDir()
+	bootFiles := newTestBootDir(t, bootDir,
+		"kernel-vanilla-x86_64-5.10.1-mocaccino",
+		"initramfs-vanilla-x86_64-5.10.1-mocaccino",
+		"kernel-vanilla-x86_64-5.10.2-mocaccino",
+		"initramfs-vanilla-x86_64-5.10.2-mocaccino",
+		"kernel-vanilla-x86_64-5.10.3-mocaccino",
+	)
+	kernel := func(v string) string { return "kernel-vanilla-x86_64-" + v + "-mocaccino" }
+	initrd := func(v string) string { return "initramfs-vanilla-x86_64-" + v + "-mocaccino" }
+
+	// The steps are executed in sequence on the same boot dir.
+	// The state is bzImage, Initrd, bzImage.old and Initrd.old.
+	tests := []struct {
+		name     string
+		version  string
+		rollback bool
+		wantErr  bool
+		previous string
+		state    []string
+	}{
+		{
+			name:     "rollback without previous kernel",
+			rollback: true,
+			wantErr:  true,
+			state:    []string{"", "", "", ""},
+		},
+		{
+			name:    "first kernel",
+			version: "5.10.1",
+			state:   []string{kernel("5.10.1"), initrd("5.10.1"), "", ""},
+		},
+		{
+			name:     "new kernel",
+			version:  "5.10.2",
+			previous: kernel("5.10.1"),
+			state:    []string{kernel("5.10.2"), initrd("5.10.2"), kernel("5.10.1"), initrd("5.10.1")},
+		},
+		{
+			name:     "same kernel",
+			version:  "5.10.2",
+			previous: kernel("5.10.2"),
+			state:    []string{kernel("5.10.2"), initrd("5.10.2"), kernel("5.10.1"), initrd("5.10.1")},
+		},
+		{
+			name:     "kernel without initrd",
+			version:  "5.10.3",
+			previous: kernel("5.10.2"),
+			state:    []string{kernel("5.10.3"), "", kernel("5.10.2"), initrd("5.10.2")},
+		},
+		{
+			name:     "rollback",
+			rollback: true,
+			previous: kernel("5.10.3"),
+			state:    []string{kernel("5.10.2"), initrd("5.10.2"), kernel("5.10.3"), ""},
+		},
+		{
+			name:     "rollback of the rollback",
+			rollback: true,
+			previous: kernel("5.10.2"),
+			state:    []string{kernel("5.10.3"), "", kernel("5.10.2"), initrd("5.10.2")},
+		},
+	}
+
+	for _, tt := range tests {
+		var e *LinksHistoryEntry
+		var err error
+		if tt.rollback {
+			e, err = RollbackDefaultKernel(bootDir)
+		} else {
+			kf, ferr := bootFiles.GetFile(tt.version, "vanilla")
+			if ferr != nil {
+				t.Fatal(ferr)
+			}
+			e, err = SetDefaultKernel(kf, bootDir)
+		}
+
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("%s: got error %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if err == nil {
+			if e.PreviousKernel != tt.previous || e.Rollback != tt.rollback {
+				t.Errorf("%s: got entry %+v", tt.name, e)
+			}
+		}
+		if got := linksState(bootDir); !reflect.DeepEqual(got, tt.state) {
+			t.Errorf("%s: got links %q, want %q", tt.name, got, tt.state)
+		}
+	}
+}
+
+func TestRollbackMissingKernel(t *testing.T) {
+	bootDir := t.TempDir()
+	newTestBootDir(t, bootDir, "kernel-vanilla-x86_64-5.10.2-mocaccino")
+	for name, target := range map[string]string{
+		BzImageLink:                 "kernel-vanilla-x86_64-5.10.2-mocaccino",
+		BzImageLink + OldLinkSuffix: "kernel-vanilla-x86_64-5.10.1-mocaccino",
+	} {
+		if err := os.Symlink(target, filepath.Join(bootDir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := RollbackDefaultKernel(bootDir); err == nil {
+		t.Fatal("expected error for a removed previous kernel")
+	}
+	if got := readLink(bootDir, BzImageLink); got != "kernel-vanilla-x86_64-5.10.2-mocaccino" {
+		t.Errorf("bzImage link changed to %s", got)
+	}
+}
+
+func TestLinksHistory(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "lib", "history.json")
+
+	for i := 0; i < LinksHistoryMaxEntries+2; i++ {
+		err := RecordLinksChange(file, &LinksHistoryEntry{Kernel: fmt.Sprintf("kernel-%d", i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h, err := LoadLinksHistory(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(h.Entries) != LinksHistoryMaxEntries {
+		t.Fatalf("got %d entries, want %d", len(h.Entries), LinksHistoryMaxEntries)
+	}
+	// The oldest entries are removed.
+	if h.Entries[0].Kernel != "kernel-2" ||
+		h.Entries[len(h.Entries)-1].Kernel != fmt.Sprintf("kernel-%d", LinksHistoryMaxEntries+1) {
+		t.Errorf("got entries from %s to %s", h.Entries[0].Kernel, h.Entries[len(h.Entries)-1].Kernel)
+	}
+}
@@ -0,0 +1,223 @@
+// Copyright © 2021 Daniele Rondina, geaaru@sabayonlinux.org
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package kernel
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	kernelspecs "github.com/MocaccinoOS/mos-cli/pkg/kernel/specs"
+	"github.com/MocaccinoOS/mos-cli/pkg/utils"
+)
+
+const (
+	DefaultPinsFile = "/var/lib/mos/kernel-pins.json"
+)
+
+// Pin identifies a pinned kernel.
+type Pin struct {
+	Type    string `json:"type,omitempty"`
+	Arch    string `json:"arch,omitempty"`
+	Version string `json:"version"`
+	Suffix  string `json:"suffix,omitempty"`
+	// Filename of the kernel image when the kernel was pinned.
+	Filename string `json:"filename,omitempty"`
+}
+
+// PinsState is the state file of the pinned kernels.
+type PinsState struct {
+	File string `json:"-"`
+	Pins []*Pin `json:"pins"`
+}
+
+func NewPin(k *kernelspecs.KernelImage) *Pin {
+	return &Pin{
+		Type:     k.GetType(),
+		Arch:     k.GetArch(),
+		Version:  k.GetVersion(),
+		Suffix:   k.GetSuffix(),
+		Filename: k.GetFilename(),
+	}
+}
+
+func (p *Pin) Match(k *kernelspecs.KernelImage) bool {
+	return p.Type == k.GetType() && p.Arch == k.GetArch() &&
+		p.Version == k.GetVersion() && p.Suffix == k.GetSuffix()
+}
+
+// GetRelease returns the kernel release of the pinned kernel.
+func (p *Pin) GetRelease() string {
+	ans := p.Version
+	if p.Suffix != "" {
+		ans += "-" + p.Suffix
+	}
+	return ans
+}
+
+// MatchName returns true if the name is the filename, the release
+// or the version of the pinned kernel. An empty ktype matches any
+// kernel type.
+func (p *Pin) MatchName(name, ktype string) bool {
+	if ktype != "" && p.Type != ktype {
+		return false
+	}
+	return name != "" &&
+		(name == p.Filename || name == p.GetRelease() || name == p.Version)
+}
+
+func (p *Pin) MatchInitrd(i *kernelspecs.InitrdImage) bool {
+	return p.Type == i.GetKernelType() && p.Arch == i.GetArch() &&
+		p.Version == i.GetVersion() && p.Suffix == i.GetSuffix()
+}
+
+func NewPinsState(file string) *PinsState {
+	return &PinsState{
+		File: file,
+		Pins: []*Pin{},
+	}
+}
+
+// LoadPinsState reads the state file. A not existing file
+// returns an empty state.
+func LoadPinsState(file string) (*PinsState, error) {
+	ans := NewPinsState(file)
+
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ans, nil
+		}
+		return nil, err
+	}
+
+	if err = json.Unmarshal(content, ans); err != nil {
+		return nil, errors.New(
+			fmt.Sprintf("Error on parse pins file %s: %s", file, err.Error()))
+	}
+
+	if ans.Pins == nil {
+		ans.Pins = []*Pin{}
+	}
+
+	return ans, nil
+}
+
+func (s *PinsState) Write() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(s.File), 0755)
+	if err != nil {
+		return err
+	}
+
+	tmpFile := s.File + ".tmp"
+	err = ioutil.WriteFile(tmpFile, data, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile, s.File)
+}
+
+// IsPinned returns true if the kernel or the initrd image
+// of the kernel files is pinned.
+func (s *PinsState) IsPinned(kf *kernelspecs.KernelFiles) bool {
+	for _, p := range s.Pins {
+		if kf.Kernel != nil && p.Match(kf.Kernel) {
+			return true
+		}
+		if kf.Kernel == nil && kf.Initrd != nil && p.MatchInitrd(kf.Initrd) {
+			return true
+		}
+	}
+	return false
+}
+
+// Add pins the kernel. It returns false if the kernel
+// is already pinned.
+func (s *PinsState) Add(k *kernelspecs.KernelImage) bool {
+	for _, p := range s.Pins {
+		if p.Match(k) {
+			return false
+		}
+	}
+
+	s.Pins = append(s.Pins, NewPin(k))
+	return true
+}
+
+// Remove unpins the kernel. It returns false if the kernel
+// is not pinned.
+func (s *PinsState) Remove(k *kernelspecs.KernelImage) bool {
+	for idx, p := range s.Pins {
+		if p.Match(k) {
+			s.Pins = append(s.Pins[:idx], s.Pins[idx+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// RemoveByName unpins the kernels with the filename, the release
+// or the version in input. It's used for the kernels not available
+// on boot dir. It returns the removed pins.
+func (s *PinsState) RemoveByName(name, ktype string) []*Pin {
+	ans := []*Pin{}
+	pins := []*Pin{}
+
+	for _, p := range s.Pins {
+		if p.MatchName(name, ktype) {
+			ans = append(ans, p)
+		} else {
+			pins = append(pins, p)
+		}
+	}
+
+	s.Pins = pins
+
+	return ans
+}
+
+// Apply sets the pinned flag of the kernel files.
+func (s *PinsState) Apply(b *kernelspecs.BootFiles) {
+	for _, kf := range b.Files {
+		kf.Pinned = s.IsPinned(kf)
+	}
+}
+
+// LoadPinnedBootFiles reads the state file and sets the pinned
+// flag of the kernel files.
+func LoadPinnedBootFiles(b *kernelspecs.BootFiles, pinsFile string) (*PinsState, error) {
+	if pinsFile == "" {
+		pinsFile = utils.RootPath(DefaultPinsFile)
+	}
+
+	s, err := LoadPinsState(pinsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	s.Apply(b)
+
+	return s, nil
+}
@@ -0,0 +1,136 @@
+// Copyright © 2021 Daniele Rondina, geaaru@sabayonlinux.org
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package kernel
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	kernelspecs "github.com/MocaccinoOS/mos-cli/pkg/kernel/specs"
+)
+
+func newTestKernelImage(ktype, version, suffix string) *kernelspecs.KernelImage {
+	k := kernelspecs.NewKernelImage()
+	k.SetPrefix("kernel")
+	k.SetType(ktype)
+	k.SetArch("x86_64")
+	k.SetVersion(version)
+	k.SetSuffix(suffix)
+	k.SetFilename("kernel-" + ktype + "-x86_64-" + version + "-" + suffix)
+	return k
+}
+
+func TestPinsState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mos-pins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "pins.json")
+	k := newTestKernelImage("vanilla", "5.10.42", "mocaccino")
+
+	s, err := LoadPinsState(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.Add(k) {
+		t.Fatal("Kernel not pinned")
+	}
+	if s.Add(k) {
+		t.Error("Kernel pinned twice")
+	}
+	if err := s.Write(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = LoadPinsState(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.IsPinned(&kernelspecs.KernelFiles{Kernel: k}) {
+		t.Error("Kernel not pinned after reload")
+	}
+	if s.IsPinned(&kernelspecs.KernelFiles{
+		Kernel: newTestKernelImage("vanilla", "5.10.43", "mocaccino")}) {
+		t.Error("Unexpected pinned kernel")
+	}
+	if !s.Remove(k) || len(s.Pins) != 0 {
+		t.Error("Kernel not unpinned")
+	}
+	if s.Remove(k) {
+		t.Error("Kernel unpinned twice")
+	}
+}
+
+func TestPinsStateWithoutFilename(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mos-pins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Pins file written before the filename field.
+	file := filepath.Join(dir, "pins.json")
+	err = ioutil.WriteFile(file, []byte(
+		`{"pins":[{"type":"vanilla","arch":"x86_64","version":"5.10.42","suffix":"mocaccino"}]}`),
+		0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := LoadPinsState(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.IsPinned(&kernelspecs.KernelFiles{
+		Kernel: newTestKernelImage("vanilla", "5.10.42", "mocaccino")}) {
+		t.Error("Kernel not pinned")
+	}
+}
+
+func TestPinsStateRemoveByName(t *testing.T) {
+	tests := []struct {
+		name    string
+		ktype   string
+		removed int
+	}{
+		{"kernel-vanilla-x86_64-5.10.42-mocaccino", "", 1},
+		{"5.10.42-mocaccino", "", 1},
+		{"5.10.42", "", 2},
+		{"5.10.42", "genkernel", 1},
+		{"5.10.42", "other", 0},
+		{"5.10.43", "", 0},
+		{"", "", 0},
+	}
+
+	for _, tt := range tests {
+		s := NewPinsState("")
+		s.Add(newTestKernelImage("vanilla", "5.10.42", "mocaccino"))
+		s.Add(newTestKernelImage("genkernel", "5.10.42", "sabayon"))
+
+		pins := s.RemoveByName(tt.name, tt.ktype)
+		if len(pins) != tt.removed {
+			t.Errorf("%q (%s): expected %d removed pins, got %d",
+				tt.name, tt.ktype, tt.removed, len(pins))
+		}
+		if len(s.Pins) != 2-tt.removed {
+			t.Errorf("%q (%s): unexpected pins %d", tt.name, tt.ktype, len(s.Pins))
+		}
+	}
+}
@@ -0,0 +1,240 @@
+// Copyright © 2021 Daniele Rondina, geaaru@sabayonlinux.org
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package kernel
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/MocaccinoOS/mos-cli/pkg/initrd"
+	kernelspecs "github.com/MocaccinoOS/mos-cli/pkg/kernel/specs"
+	. "github.com/MocaccinoOS/mos-cli/pkg/logger"
+	"github.com/MocaccinoOS/mos-cli/pkg/utils"
+)
+
+const (
+	PruneReasonRunning  = "running"
+	PruneReasonDefault  = "default"
+	PruneReasonRollback = "rollback"
+	PruneReasonPinned   = "pinned"
+	PruneReasonNewest   = "newest"
+	PruneReasonOld      = "old"
+	PruneReasonUnknown  = "unknown"
+)
+
+// PrunePolicy defines the kernels to keep on boot dir.
+type PrunePolicy struct {
+	// Number of newest kernels to keep for every kernel type.
+	Keep int `json:"keep"`
+	// Release of the running kernel.
+	RunningRelease string `json:"running_release,omitempty"`
+	// Kernel releases or versions that are never removed in addition
+	// to the kernels pinned on the state file.
+	Pinned []string `json:"pinned,omitempty"`
+}
+
+type PruneEntry struct {
+	Files   *kernelspecs.KernelFiles `json:"-"`
+	Release string                   `json:"release"`
+	Kernel  string                   `json:"kernel,omitempty"`
+	Reason  string                   `json:"reason"`
+	Remove  bool                     `json:"remove"`
+	Paths   []string                 `json:"paths,omitempty"`
+}
+
+// GetRunningRelease returns the release of the running kernel.
+func GetRunningRelease() (string, error) {
+	out, err := exec.Command("uname", "-r").Output()
+	if err != nil {
+		return "", errors.New(
+			fmt.Sprintf("Error on retrieve running kernel: %s", err.Error()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func (p *PrunePolicy) isPinned(k *kernelspecs.KernelImage) bool {
+	for _, v := range p.Pinned {
+		if v == k.GetVersion() || v == k.GetKernelRelease() || v == k.GetFilename() {
+			return true
+		}
+	}
+	return false
+}
+
+// Plan returns the kernels of the boot dir with the reason why they
+// are kept or removed. Initrd images without kernel are ignored.
+// The kernels without a kernel type and the target of the .old link
+// used by the rollback are never removed.
+func (p *PrunePolicy) Plan(bootFiles *kernelspecs.BootFiles, modulesDir string) []*PruneEntry {
+	ans := []*PruneEntry{}
+	groups := make(map[string][]*PruneEntry)
+	keys := []string{}
+
+	if modulesDir == "" {
+		modulesDir = utils.RootPath(initrd.DefaultModulesDir)
+	}
+
+	rollbackKernel := readLink(bootFiles.Dir, BzImageLink+OldLinkSuffix)
+
+	for _, kf := range bootFiles.Files {
+		if kf.Kernel == nil {
+			continue
+		}
+
+		e := &PruneEntry{
+			Files:   kf,
+			Release: kf.Kernel.GetKernelRelease(),
+			Kernel:  kf.Kernel.GetFilename(),
+		}
+
+		switch {
+		case p.RunningRelease != "" && (e.Release == p.RunningRelease ||
+			kf.Kernel.GetBinaryRelease() == p.RunningRelease):
+			e.Reason = PruneReasonRunning
+		case bootFiles.BzImageLink != "" && bootFiles.BzImageLink == e.Kernel:
+			e.Reason = PruneReasonDefault
+		case rollbackKernel != "" && rollbackKernel == e.Kernel:
+			e.Reason = PruneReasonRollback
+		case kf.Pinned || p.isPinned(kf.Kernel):
+			e.Reason = PruneReasonPinned
+		case kf.Type == nil || kf.Type.GetType() == "":
+			// Kernels not matched by the kernel profiles.
+			e.Reason = PruneReasonUnknown
+		}
+
+		key := fmt.Sprintf("%s-%s-%s",
+			kf.Kernel.GetType(), kf.Kernel.GetArch(), kf.Kernel.GetSuffix())
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], e)
+		ans = append(ans, e)
+	}
+
+	for _, key := range keys {
+		entries := groups[key]
+		// Newest kernels first
+		sort.SliceStable(entries, func(i, j int) bool {
+			return kernelspecs.CompareKernelVersions(
+				entries[i].Files.Kernel.GetVersion(),
+				entries[j].Files.Kernel.GetVersion()) > 0
+		})
+
+		for idx, e := range entries {
+			if e.Reason != "" {
+				continue
+			}
+			if idx < p.Keep {
+				e.Reason = PruneReasonNewest
+			} else {
+				e.Reason = PruneReasonOld
+				e.Remove = true
+			}
+		}
+	}
+
+	// Modules directories shared with a kept kernel are not removed.
+	keptReleases := make(map[string]bool)
+	for _, e := range ans {
+		if !e.Remove {
+			keptReleases[e.Release] = true
+		}
+	}
+
+	for _, e := range ans {
+		if e.Remove {
+			e.Paths = getKernelFilesPaths(e.Files, bootFiles.Dir, modulesDir,
+				!keptReleases[e.Release])
+		}
+	}
+
+	return ans
+}
+
+// getKernelFilesPaths returns the existing files of the kernel:
+// kernel image, initrd image, System.map, config and modules tree.
+func getKernelFilesPaths(kf *kernelspecs.KernelFiles, bootDir, modulesDir string,
+	withModules bool) []string {
+	ans := []string{}
+	release := kf.Kernel.GetKernelRelease()
+
+	candidates := []string{
+		filepath.Join(bootDir, kf.Kernel.GetFilename()),
+	}
+	if kf.Initrd != nil {
+		candidates = append(candidates, filepath.Join(bootDir, kf.Initrd.GetFilename()))
+	}
+	if kf.Uki != nil {
+		candidates = append(candidates, kf.Uki.GetPath())
+	}
+
+	// System.map and config could have the release or the
+	// same name of the kernel image without the prefix.
+	names := []string{release}
+	if kname := strings.TrimPrefix(kf.Kernel.GetFilename(),
+		kf.Kernel.GetPrefix()+"-"); kname != kf.Kernel.GetFilename() && kname != release {
+		names = append(names, kname)
+	}
+	for _, n := range names {
+		candidates = append(candidates,
+			filepath.Join(bootDir, "System.map-"+n),
+			filepath.Join(bootDir, "config-"+n),
+		)
+	}
+
+	if withModules && release != "" {
+		candidates = append(candidates, filepath.Join(modulesDir, release))
+	}
+
+	for _, c := range candidates {
+		if _, err := os.Lstat(c); err == nil {
+			ans = append(ans, c)
+		}
+	}
+
+	return ans
+}
+
+// Prune removes the files of the entries marked to remove. In dry-run
+// mode the files are written to w.
+func Prune(entries []*PruneEntry, dryRun bool, w io.Writer) error {
+	for _, e := range entries {
+		if !e.Remove {
+			continue
+		}
+
+		for _, path := range e.Paths {
+			if dryRun {
+				fmt.Fprintln(w, "[dry-run mode] removing "+path)
+				continue
+			}
+
+			DebugC("Removing", path)
+			if err := os.RemoveAll(path); err != nil {
+				return errors.New(
+					fmt.Sprintf("Error on remove %s: %s", path, err.Error()))
+			}
+		}
+	}
+
+	return nil
+}
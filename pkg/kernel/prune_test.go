@@ -0,0 +1,111 @@
+// Copyright © 2021 Daniele Rondina, geaaru@sabayonlinux.org
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package kernel
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	kernelspecs "github.com/MocaccinoOS/mos-cli/pkg/kernel/specs"
+	"github.com/MocaccinoOS/mos-cli/pkg/profile"
+)
+
+func TestPrunePlan(t *testing.T) {
+	bootDir, err := ioutil.TempDir("", "mos-prune")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(bootDir)
+
+	for _, v := range []string{"5.10.1", "5.10.2", "5.10.3", "5.10.4", "5.10.5"} {
+		f := filepath.Join(bootDir, "kernel-vanilla-x86_64-"+v+"-mocaccino")
+		if err := ioutil.WriteFile(f, []byte{}, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("kernel-vanilla-x86_64-5.10.5-mocaccino",
+		filepath.Join(bootDir, BzImageLink)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("kernel-vanilla-x86_64-5.10.1-mocaccino",
+		filepath.Join(bootDir, BzImageLink+OldLinkSuffix)); err != nil {
+		t.Fatal(err)
+	}
+
+	bootFiles, err := ReadBootDir(bootDir, profile.GetDefaultKernelProfiles())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Kernel with a filename not matched by the kernel profiles.
+	unknown := kernelspecs.NewKernelImage()
+	unknown.SetFilename("vmlinuz-5.4.0-custom")
+	unknown.SetPrefix("vmlinuz")
+	unknown.SetVersion("5.4.0-custom")
+	err = bootFiles.AddKernelImage(unknown, &kernelspecs.KernelType{Name: "Unknown"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy := &PrunePolicy{Keep: 2, Pinned: []string{"5.10.3"}}
+	entries := policy.Plan(bootFiles, filepath.Join(bootDir, "modules"))
+
+	expected := map[string]string{
+		"kernel-vanilla-x86_64-5.10.5-mocaccino": PruneReasonDefault,
+		"kernel-vanilla-x86_64-5.10.4-mocaccino": PruneReasonNewest,
+		"kernel-vanilla-x86_64-5.10.3-mocaccino": PruneReasonPinned,
+		"kernel-vanilla-x86_64-5.10.2-mocaccino": PruneReasonOld,
+		"kernel-vanilla-x86_64-5.10.1-mocaccino": PruneReasonRollback,
+		"vmlinuz-5.4.0-custom":                   PruneReasonUnknown,
+	}
+
+	if len(entries) != len(expected) {
+		t.Fatalf("Expected %d entries, got %d", len(expected), len(entries))
+	}
+
+	for _, e := range entries {
+		if e.Reason != expected[e.Kernel] {
+			t.Errorf("%s: expected reason %s, got %s", e.Kernel, expected[e.Kernel], e.Reason)
+		}
+		if e.Remove != (e.Reason == PruneReasonOld) {
+			t.Errorf("%s: unexpected remove %v", e.Kernel, e.Remove)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := Prune(entries, true, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "[dry-run mode] removing "+
+		filepath.Join(bootDir, "kernel-vanilla-x86_64-5.10.2-mocaccino")+"\n" {
+		t.Errorf("Unexpected dry-run output %q", out.String())
+	}
+	if _, err := os.Stat(filepath.Join(bootDir, "kernel-vanilla-x86_64-5.10.2-mocaccino")); err != nil {
+		t.Errorf("Kernel removed in dry-run mode: %s", err)
+	}
+
+	if err := Prune(entries, false, &out); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := filepath.Glob(filepath.Join(bootDir, "kernel-*"))
+	if len(files) != 4 || strings.Contains(strings.Join(files, " "), "5.10.2") {
+		t.Errorf("Unexpected files after prune: %v", files)
+	}
+}
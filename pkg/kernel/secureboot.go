@@ -0,0 +1,150 @@
+// Copyright © 2021 Daniele Rondina, geaaru@sabayonlinux.org
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package kernel
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	kernelspecs "github.com/MocaccinoOS/mos-cli/pkg/kernel/specs"
+	. "github.com/MocaccinoOS/mos-cli/pkg/logger"
+	"github.com/MocaccinoOS/mos-cli/pkg/secureboot"
+)
+
+// GetFileSignature returns the Secure Boot signature status of
+// the file. The error describes an invalid signature.
+func GetFileSignature(file string, trusted []*x509.Certificate) (secureboot.SignatureStatus, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+
+	return secureboot.Verify(data, trusted)
+}
+
+// SignFile signs the PE image of the file with the key pair. The
+// file is replaced only when the signature is completed. The existing
+// signatures are replaced only with replace, otherwise the signed
+// files are refused.
+func SignFile(file string, kp *secureboot.KeyPair, replace, dryRun bool) error {
+	if dryRun {
+		fmt.Println(fmt.Sprintf("[dry-run mode] signing %s with %s",
+			file, kp.Cert.Subject.String()))
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	var signed []byte
+	if replace {
+		signed, err = secureboot.Resign(data, kp)
+	} else {
+		signed, err = secureboot.Sign(data, kp)
+	}
+	if err == secureboot.ErrImageSigned {
+		return errors.New(
+			fmt.Sprintf("%s is already signed by another certificate: use --force to replace the signatures", file))
+	}
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+
+	DebugC("Signing", file)
+	tmpFile := file + ".tmp"
+	err = ioutil.WriteFile(tmpFile, signed, info.Mode())
+	if err != nil {
+		os.Remove(tmpFile)
+		return err
+	}
+
+	return os.Rename(tmpFile, file)
+}
+
+// SignIfNeeded signs the file if it isn't signed with a trusted
+// certificate of the key pair. It returns true if the file is signed.
+// The files signed with other certificates are refused.
+func SignIfNeeded(file string, kp *secureboot.KeyPair, dryRun bool) (bool, error) {
+	status, _ := GetFileSignature(file, []*x509.Certificate{kp.Cert})
+	if status == secureboot.SignatureValid {
+		DebugC("File", file, "already signed.")
+		return false, nil
+	}
+
+	return true, SignFile(file, kp, false, dryRun)
+}
+
+// LoadSignatures sets the signature status of the kernel images
+// and of the UKIs.
+func LoadSignatures(b *kernelspecs.BootFiles, trusted []*x509.Certificate) {
+	for _, kf := range b.Files {
+		if kf.Kernel != nil {
+			status, err := GetFileSignature(filepath.Join(b.Dir, kf.Kernel.GetFilename()), trusted)
+			if err != nil {
+				DebugC("Signature of", kf.Kernel.GetFilename(), ":", err.Error())
+			}
+			kf.Kernel.Signature = string(status)
+		}
+
+		if kf.Uki != nil {
+			status, err := GetFileSignature(kf.Uki.GetPath(), trusted)
+			if err != nil {
+				DebugC("Signature of", kf.Uki.GetFilename(), ":", err.Error())
+			}
+			kf.Uki.Signature = string(status)
+		}
+	}
+}
+
+// VerifySignatures adds an error for every kernel image or UKI
+// that isn't signed with one of the trusted certificates.
+func (r *VerifyResult) VerifySignatures(kf *kernelspecs.KernelFiles, bootDir string,
+	trusted []*x509.Certificate) {
+
+	files := []string{}
+	if kf.Kernel != nil {
+		files = append(files, filepath.Join(bootDir, kf.Kernel.GetFilename()))
+	}
+	if kf.Uki != nil {
+		files = append(files, kf.Uki.GetPath())
+	}
+
+	for _, f := range files {
+		status, err := GetFileSignature(f, trusted)
+		switch status {
+		case "":
+			r.addError("Error on read %s: %v", filepath.Base(f), err)
+		case secureboot.SignatureValid:
+		case secureboot.SignatureUnsigned:
+			r.addError("%s is not signed", filepath.Base(f))
+		case secureboot.SignatureUnsupported:
+			r.addWarning("%s is not a PE image and it can't be signed", filepath.Base(f))
+		default:
+			r.addError("%s has an %s signature: %v", filepath.Base(f), status, err)
+		}
+	}
+}
@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
@@ -105,6 +106,14 @@ func (b *BootFiles) RetrieveBzImageSelectedKernel() *KernelFiles {
 			continue
 		}
 
+		if f.Type.GetKernelPattern() != "" {
+			if f.Kernel.GetFilename() == b.BzImageLink {
+				kernelImage = f.Kernel
+				break
+			}
+			continue
+		}
+
 		kPrefix := f.Kernel.GetPrefix()
 
 		if f.Kernel.GetType() != "" {
@@ -131,11 +140,31 @@ func (b *BootFiles) RetrieveBzImageSelectedKernel() *KernelFiles {
 	}
 
 	if kernelImage != nil {
+		// Retrieve the newest kernel of the same type.
+		var ans *KernelFiles = nil
 		for _, f := range b.Files {
 			if f.Kernel != nil && f.Kernel.GetPrefix() == kernelImage.GetPrefix() &&
 				f.Kernel.GetArch() == kernelImage.GetArch() &&
 				f.Kernel.GetSuffix() == kernelImage.GetSuffix() &&
 				f.Kernel.GetType() == kernelImage.GetType() {
+
+				if ans == nil || CompareKernelVersions(
+					ans.Kernel.GetVersion(), f.Kernel.GetVersion()) < 0 {
+					ans = f
+				}
+			}
+		}
+		return ans
+	}
+
+	return nil
+}
+
+// GetBzImageKernel returns the kernel of the bzImage link.
+func (b *BootFiles) GetBzImageKernel() *KernelFiles {
+	if b.BzImageLink != "" {
+		for _, f := range b.Files {
+			if f.Kernel != nil && f.Kernel.GetFilename() == b.BzImageLink {
 				return f
 			}
 		}
@@ -144,6 +173,73 @@ func (b *BootFiles) RetrieveBzImageSelectedKernel() *KernelFiles {
 	return nil
 }
 
+// GetLatestKernel returns the newest kernel with the type and suffix
+// in input. Empty ktype and suffix match any kernel.
+func (b *BootFiles) GetLatestKernel(ktype, suffix string) *KernelFiles {
+	var ans *KernelFiles = nil
+
+	for _, f := range b.Files {
+		if f.Kernel == nil {
+			continue
+		}
+
+		if ktype != "" && f.Kernel.GetType() != ktype {
+			continue
+		}
+
+		if suffix != "" && f.Kernel.GetSuffix() != suffix {
+			continue
+		}
+
+		if ans == nil || CompareKernelVersions(
+			ans.Kernel.GetVersion(), f.Kernel.GetVersion()) < 0 {
+			ans = f
+		}
+	}
+
+	return ans
+}
+
+// GetVersion returns the version of the kernel or of the
+// initrd image if the kernel is not available.
+func (kf *KernelFiles) GetVersion() string {
+	if kf.Kernel != nil {
+		return kf.Kernel.GetVersion()
+	}
+	if kf.Initrd != nil {
+		return kf.Initrd.GetVersion()
+	}
+	return ""
+}
+
+func (kf *KernelFiles) getTypeAndSuffix() (string, string) {
+	if kf.Kernel != nil {
+		return kf.Kernel.GetType(), kf.Kernel.GetSuffix()
+	}
+	if kf.Initrd != nil {
+		return kf.Initrd.GetKernelType(), kf.Initrd.GetSuffix()
+	}
+	return "", ""
+}
+
+// Sort orders the files by kernel type, suffix and version.
+func (b *BootFiles) Sort() {
+	sort.SliceStable(b.Files, func(i, j int) bool {
+		ti, si := b.Files[i].getTypeAndSuffix()
+		tj, sj := b.Files[j].getTypeAndSuffix()
+
+		if ti != tj {
+			return ti < tj
+		}
+		if si != sj {
+			return si < sj
+		}
+
+		return CompareKernelVersions(
+			b.Files[i].GetVersion(), b.Files[j].GetVersion()) < 0
+	})
+}
+
 func (b *BootFiles) GetFile(version, ktype string) (*KernelFiles, error) {
 	var ans *KernelFiles = nil
 
@@ -212,7 +308,7 @@ func (b *BootFiles) PurgeOrphanInitrdImages() error {
 
 	for idx, k := range b.Files {
 
-		if k.Initrd != nil && k.Kernel == nil {
+		if k.Initrd != nil && k.Kernel == nil && !k.Pinned {
 			fmt.Print(fmt.Sprintf(
 				"Removing orphan initrd %s...",
 				k.Initrd.GetFilename(),
@@ -0,0 +1,308 @@
+// Copyright © 2021 Daniele Rondina, geaaru@sabayonlinux.org
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package kernelspecs
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Parameters that could be present multiple times on the
+// kernel command line.
+var RepeatableCmdlineParams = []string{
+	"console",
+	"ip",
+	"rd.luks.name",
+	"rd.luks.uuid",
+	"rd.lvm.lv",
+	"rd.lvm.vg",
+	"rd.md.uuid",
+}
+
+// CmdlineParam is a parameter of the kernel command line.
+type CmdlineParam struct {
+	Key      string `json:"key"`
+	Value    string `json:"value,omitempty"`
+	HasValue bool   `json:"has_value,omitempty"`
+}
+
+// KernelCmdline is the list of the kernel command line parameters.
+// The arguments after -- are passed to init as is.
+type KernelCmdline struct {
+	Params   []*CmdlineParam `json:"params"`
+	InitArgs []string        `json:"init_args,omitempty"`
+}
+
+func isRepeatableParam(key string) bool {
+	for _, k := range RepeatableCmdlineParams {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// unquoteParam removes the double quotes around the string.
+func unquoteParam(s string) string {
+	if len(s) >= 2 && strings.Count(s, `"`) == 2 &&
+		strings.HasPrefix(s, `"`) && strings.HasSuffix(s, `"`) {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// ParseCmdlineParam parses a key or a key=value parameter. The
+// double quotes around the parameter or around the value are removed
+// like the kernel does. The other double quotes are kept and they
+// are refused by Validate.
+func ParseCmdlineParam(s string) (*CmdlineParam, error) {
+	if strings.Count(s, `"`)%2 != 0 {
+		return nil, errors.New(fmt.Sprintf("Unterminated quote on parameter %s", s))
+	}
+	s = unquoteParam(s)
+
+	ans := &CmdlineParam{Key: s}
+	if idx := strings.Index(s, "="); idx >= 0 {
+		ans.Key = s[:idx]
+		ans.Value = unquoteParam(s[idx+1:])
+		ans.HasValue = true
+	}
+
+	if ans.Key == "" {
+		return nil, errors.New(fmt.Sprintf("Invalid parameter %s without key", s))
+	}
+
+	return ans, nil
+}
+
+func (p *CmdlineParam) String() string {
+	if !p.HasValue {
+		return p.Key
+	}
+	v := p.Value
+	if v == "" || strings.ContainsAny(v, " \t") {
+		v = `"` + v + `"`
+	}
+	return p.Key + "=" + v
+}
+
+func (p *CmdlineParam) Equal(o *CmdlineParam) bool {
+	return p.Key == o.Key && p.HasValue == o.HasValue && p.Value == o.Value
+}
+
+func NewKernelCmdline() *KernelCmdline {
+	return &KernelCmdline{
+		Params:   []*CmdlineParam{},
+		InitArgs: []string{},
+	}
+}
+
+// ParseKernelCmdline splits the command line in parameters. The
+// spaces inside double quotes are part of the parameter.
+func ParseKernelCmdline(s string) (*KernelCmdline, error) {
+	ans := NewKernelCmdline()
+	var word strings.Builder
+	quoted := false
+	initArgs := false
+
+	flush := func() error {
+		if word.Len() == 0 {
+			return nil
+		}
+		defer word.Reset()
+
+		if initArgs {
+			ans.InitArgs = append(ans.InitArgs, word.String())
+			return nil
+		} else if word.String() == "--" {
+			initArgs = true
+			return nil
+		}
+
+		p, err := ParseCmdlineParam(word.String())
+		if err != nil {
+			return err
+		}
+		ans.Params = append(ans.Params, p)
+		return nil
+	}
+
+	for _, c := range s {
+		switch {
+		case c == '"':
+			quoted = !quoted
+			word.WriteRune(c)
+		case !quoted && (c == ' ' || c == '\t' || c == '\n' || c == '\r'):
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		default:
+			word.WriteRune(c)
+		}
+	}
+
+	if quoted {
+		return nil, errors.New("Unterminated quote on kernel command line")
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+
+	return ans, nil
+}
+
+func (c *KernelCmdline) String() string {
+	params := []string{}
+	for _, p := range c.Params {
+		params = append(params, p.String())
+	}
+	if len(c.InitArgs) > 0 {
+		params = append(params, "--")
+		params = append(params, c.InitArgs...)
+	}
+	return strings.Join(params, " ")
+}
+
+func (c *KernelCmdline) Clone() *KernelCmdline {
+	ans := NewKernelCmdline()
+	for _, p := range c.Params {
+		cp := *p
+		ans.Params = append(ans.Params, &cp)
+	}
+	ans.InitArgs = append(ans.InitArgs, c.InitArgs...)
+	return ans
+}
+
+// Get returns the parameters with the key in input.
+func (c *KernelCmdline) Get(key string) []*CmdlineParam {
+	ans := []*CmdlineParam{}
+	for _, p := range c.Params {
+		if p.Key == key {
+			ans = append(ans, p)
+		}
+	}
+	return ans
+}
+
+// Set replaces the parameters with the same key or appends the
+// parameter if it's not present.
+func (c *KernelCmdline) Set(p *CmdlineParam) {
+	params := []*CmdlineParam{}
+	found := false
+	for _, e := range c.Params {
+		if e.Key != p.Key {
+			params = append(params, e)
+		} else if !found {
+			params = append(params, p)
+			found = true
+		}
+	}
+	if !found {
+		params = append(params, p)
+	}
+	c.Params = params
+}
+
+// Add appends the parameter. Only the repeatable parameters could
+// be present multiple times.
+func (c *KernelCmdline) Add(p *CmdlineParam) error {
+	for _, e := range c.Params {
+		if e.Equal(p) {
+			return errors.New(fmt.Sprintf("Parameter %s already present", p.String()))
+		}
+		if e.Key == p.Key && !isRepeatableParam(p.Key) {
+			return errors.New(fmt.Sprintf(
+				"Parameter %s already set to %s. Use set to change it.", p.Key, e.String()))
+		}
+	}
+	c.Params = append(c.Params, p)
+	return nil
+}
+
+// Remove removes the parameters with the key of the parameter in
+// input or only the parameter with the same value if defined.
+// It returns the number of parameters removed.
+func (c *KernelCmdline) Remove(p *CmdlineParam) int {
+	params := []*CmdlineParam{}
+	for _, e := range c.Params {
+		if e.Key == p.Key && (!p.HasValue || e.Equal(p)) {
+			continue
+		}
+		params = append(params, e)
+	}
+	ans := len(c.Params) - len(params)
+	c.Params = params
+	return ans
+}
+
+// Validate checks that the parameters not repeatable are defined
+// only one time and that the values could be quoted.
+func (c *KernelCmdline) Validate() error {
+	keys := make(map[string]bool)
+	for _, p := range c.Params {
+		if keys[p.Key] && !isRepeatableParam(p.Key) {
+			return errors.New(fmt.Sprintf("Parameter %s defined multiple times", p.Key))
+		}
+		keys[p.Key] = true
+
+		if strings.Contains(p.Key, `"`) || strings.Contains(p.Value, `"`) {
+			return errors.New(fmt.Sprintf("Parameter %s contains a double quote", p.Key))
+		}
+	}
+	return nil
+}
+
+// Merge returns a new command line with the overrides applied. An
+// override with the - prefix removes the parameter, a repeatable
+// parameter is added and the other parameters replace the existing
+// values.
+func (c *KernelCmdline) Merge(overrides *KernelCmdline) *KernelCmdline {
+	ans := c.Clone()
+	for _, o := range overrides.Params {
+		p := *o
+		if strings.HasPrefix(p.Key, "-") {
+			p.Key = strings.TrimPrefix(p.Key, "-")
+			ans.Remove(&p)
+		} else if isRepeatableParam(p.Key) {
+			ans.Add(&p)
+		} else {
+			ans.Set(&p)
+		}
+	}
+	return ans
+}
+
+// MergeCmdline returns the command line in input with the overrides
+// of the kernel type.
+func (t *KernelType) MergeCmdline(cmdline string) (string, error) {
+	if t == nil || t.Cmdline == "" {
+		return cmdline, nil
+	}
+
+	base, err := ParseKernelCmdline(cmdline)
+	if err != nil {
+		return "", err
+	}
+
+	overrides, err := ParseKernelCmdline(t.Cmdline)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf(
+			"Invalid cmdline of kernel type %s: %s", t.GetName(), err.Error()))
+	}
+
+	return base.Merge(overrides).String(), nil
+}
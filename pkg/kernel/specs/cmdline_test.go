@@ -0,0 +1,142 @@
+// Copyright © 2021 Daniele Rondina, geaaru@sabayonlinux.org
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package kernelspecs
+
+import (
+	"testing"
+)
+
+func TestParseCmdlineParam(t *testing.T) {
+	tests := []struct {
+		param    string
+		key      string
+		value    string
+		hasValue bool
+		invalid  bool
+	}{
+		{"quiet", "quiet", "", false, false},
+		{"root=/dev/sda1", "root", "/dev/sda1", true, false},
+		{"init=", "init", "", true, false},
+		{"rd.luks.name=uuid=root", "rd.luks.name", "uuid=root", true, false},
+		{`acpi_osi="Windows 2020"`, "acpi_osi", "Windows 2020", true, false},
+		{`"acpi_osi=Windows 2020"`, "acpi_osi", "Windows 2020", true, false},
+		{`opt=a"b"c`, "opt", `a"b"c`, true, false},
+		{`opt="a"b"c"`, "opt", `"a"b"c"`, true, false},
+		{`opt="a`, "", "", false, true},
+		{"=value", "", "", false, true},
+	}
+
+	for _, tt := range tests {
+		p, err := ParseCmdlineParam(tt.param)
+		if tt.invalid {
+			if err == nil {
+				t.Errorf("%s: expected error, got %+v", tt.param, p)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.param, err)
+			continue
+		}
+		if p.Key != tt.key || p.Value != tt.value || p.HasValue != tt.hasValue {
+			t.Errorf("%s: unexpected parameter %+v", tt.param, p)
+		}
+	}
+}
+
+func TestParseKernelCmdline(t *testing.T) {
+	tests := []struct {
+		cmdline  string
+		expected string
+		params   int
+		invalid  bool
+	}{
+		{"", "", 0, false},
+		{"root=/dev/sda1 ro  quiet\n", "root=/dev/sda1 ro quiet", 3, false},
+		{`root=/dev/sda1 acpi_osi="Windows 2020"`, `root=/dev/sda1 acpi_osi="Windows 2020"`, 2, false},
+		{"ro -- single debug", "ro -- single debug", 1, false},
+		{"console=tty0 console=ttyS0,115200", "console=tty0 console=ttyS0,115200", 2, false},
+		{`ro opt="a b`, "", 0, true},
+	}
+
+	for _, tt := range tests {
+		c, err := ParseKernelCmdline(tt.cmdline)
+		if tt.invalid {
+			if err == nil {
+				t.Errorf("%q: expected error", tt.cmdline)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", tt.cmdline, err)
+			continue
+		}
+		if c.String() != tt.expected || len(c.Params) != tt.params {
+			t.Errorf("%q: unexpected command line %q (%d params)", tt.cmdline, c.String(), len(c.Params))
+		}
+	}
+}
+
+func TestKernelCmdlineValidate(t *testing.T) {
+	tests := []struct {
+		cmdline string
+		valid   bool
+	}{
+		{"root=/dev/sda1 ro quiet", true},
+		{"console=tty0 console=ttyS0", true},
+		{`acpi_osi="Windows 2020"`, true},
+		{"root=/dev/sda1 root=/dev/sda2", false},
+		{`opt=a"b"c`, false},
+		{`opt="a"b"c"`, false},
+	}
+
+	for _, tt := range tests {
+		c, err := ParseKernelCmdline(tt.cmdline)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.cmdline, err)
+			continue
+		}
+		if err := c.Validate(); (err == nil) != tt.valid {
+			t.Errorf("%s: expected valid %v, got %v", tt.cmdline, tt.valid, err)
+		}
+	}
+}
+
+func TestKernelCmdlineMerge(t *testing.T) {
+	tests := []struct {
+		cmdline   string
+		overrides string
+		expected  string
+	}{
+		{"root=/dev/sda1 quiet", "", "root=/dev/sda1 quiet"},
+		{"root=/dev/sda1 quiet", "-quiet debug", "root=/dev/sda1 debug"},
+		{"root=/dev/sda1 quiet", "root=/dev/sda2", "root=/dev/sda2 quiet"},
+		{"console=tty0", "console=ttyS0", "console=tty0 console=ttyS0"},
+		{"console=tty0 console=ttyS0", "-console=tty0", "console=ttyS0"},
+	}
+
+	for _, tt := range tests {
+		kt := &KernelType{Name: "test", Cmdline: tt.overrides}
+		got, err := kt.MergeCmdline(tt.cmdline)
+		if err != nil {
+			t.Errorf("%s + %s: unexpected error: %s", tt.cmdline, tt.overrides, err)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("%s + %s: expected %q, got %q", tt.cmdline, tt.overrides, tt.expected, got)
+		}
+	}
+}
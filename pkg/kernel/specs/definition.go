@@ -26,6 +26,15 @@ type KernelImage struct {
 	Suffix   string `json:"suffix,omitempty" yaml:"suffix,omitempty"`
 	Version  string `json:"version,omitempty" yaml:"version,omitempty"`
 	Arch     string `json:"arch,omitempty" yaml:"arch,omitempty"`
+
+	// Kernel release read from the kernel image binary.
+	BinaryRelease string `json:"binary_release,omitempty" yaml:"binary_release,omitempty"`
+
+	// Secure Boot signature status of the kernel image.
+	Signature string `json:"signature,omitempty" yaml:"signature,omitempty"`
+
+	// Regex of the filename pattern of the kernel type.
+	Pattern *regexp.Regexp `json:"-" yaml:"-"`
 }
 
 type InitrdImage struct {
@@ -35,6 +44,17 @@ type InitrdImage struct {
 	KernelType string `json:"kernel_type,omitempty" yaml:"kernel_type,omitempty"`
 	Arch       string `json:"arch,omitempty" yaml:"arch,omitempty"`
 	Version    string `json:"version,omitempty" yaml:"version,omitempty"`
+
+	// Regex of the filename pattern of the kernel type.
+	Pattern *regexp.Regexp `json:"-" yaml:"-"`
+}
+
+// UkiImage is a Unified Kernel Image built with the kernel and
+// the initrd image of the KernelFiles.
+type UkiImage struct {
+	Filename  string `json:"filename,omitempty" yaml:"filename,omitempty"`
+	Dir       string `json:"dir,omitempty" yaml:"dir,omitempty"`
+	Signature string `json:"signature,omitempty" yaml:"signature,omitempty"`
 }
 
 type KernelType struct {
@@ -45,13 +65,33 @@ type KernelType struct {
 	Type         string `json:"type,omitempty" yaml:"type,omitempty"`
 	WithArch     bool   `json:"with_arch,omitempty" yaml:"with_arch,omitempty"`
 
-	Regex *regexp.Regexp `json:"-" yaml:"-"`
+	InitrdBuilder string `json:"initrd_builder,omitempty" yaml:"initrd_builder,omitempty"`
+
+	// Filename patterns used instead of the prefix-type-arch-version-suffix
+	// layout. See CompilePattern.
+	KernelPattern string `json:"kernel_pattern,omitempty" yaml:"kernel_pattern,omitempty"`
+	InitrdPattern string `json:"initrd_pattern,omitempty" yaml:"initrd_pattern,omitempty"`
+	// The version of the kernel is read from the kernel image. It's
+	// required by the kernel patterns without the version field.
+	VersionFromImage bool `json:"version_from_image,omitempty" yaml:"version_from_image,omitempty"`
+
+	// Kernel command line overrides of the kernel type merged with
+	// the global command line. See KernelCmdline.Merge.
+	Cmdline string `json:"cmdline,omitempty" yaml:"cmdline,omitempty"`
+
+	Regex       *regexp.Regexp `json:"-" yaml:"-"`
+	KernelRegex *regexp.Regexp `json:"-" yaml:"-"`
+	InitrdRegex *regexp.Regexp `json:"-" yaml:"-"`
 }
 
 type KernelFiles struct {
 	Kernel *KernelImage `json:"kernel,omitempty" yaml:"kernel,omitempty"`
 	Initrd *InitrdImage `json:"initrd,omitempty" yaml:"initrd,omitempty"`
 	Type   *KernelType  `json:"type,omitempty" yaml:"type,omitempty"`
+	Uki    *UkiImage    `json:"uki,omitempty" yaml:"uki,omitempty"`
+
+	// Pinned kernels are never removed or replaced.
+	Pinned bool `json:"pinned,omitempty" yaml:"pinned,omitempty"`
 }
 
 type BootFiles struct {
@@ -0,0 +1,119 @@
+// Copyright © 2021 Daniele Rondina, geaaru@sabayonlinux.org
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package kernelspecs
+
+import (
+	"errors"
+
+	"gopkg.in/yaml.v3"
+)
+
+// KernelFamily describes a kernel package and the packages that
+// must be installed or removed with it. The packages are in the
+// category/name format.
+type KernelFamily struct {
+	Name        string `json:"name" yaml:"name"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+	Package     string `json:"package" yaml:"package"`
+
+	Modules string `json:"modules,omitempty" yaml:"modules,omitempty"`
+	// Initramfs package used by the micro releases.
+	Initramfs string   `json:"initramfs,omitempty" yaml:"initramfs,omitempty"`
+	Sources   string   `json:"sources,omitempty" yaml:"sources,omitempty"`
+	Firmware  []string `json:"firmware,omitempty" yaml:"firmware,omitempty"`
+
+	// Type of the kernel profile of the kernel images.
+	KernelType string `json:"kernel_type,omitempty" yaml:"kernel_type,omitempty"`
+}
+
+func KernelFamilyFromYaml(data []byte) (*KernelFamily, error) {
+	ans := &KernelFamily{}
+	if err := yaml.Unmarshal(data, ans); err != nil {
+		return nil, err
+	}
+
+	return ans, nil
+}
+
+func (f *KernelFamily) Validate() error {
+	if f.Name == "" {
+		return errors.New("Kernel family without name")
+	}
+	if f.Package == "" {
+		return errors.New("Kernel family " + f.Name + " without package")
+	}
+	return nil
+}
+
+// GetCompanions returns the packages of the family except the
+// kernel package.
+func (f *KernelFamily) GetCompanions() []string {
+	ans := []string{}
+	for _, p := range []string{f.Modules, f.Initramfs, f.Sources} {
+		if p != "" {
+			ans = append(ans, p)
+		}
+	}
+	return append(ans, f.Firmware...)
+}
+
+// GetInstallPackages returns the packages to install for the
+// kernel. The micro releases use the initramfs package.
+func (f *KernelFamily) GetInstallPackages(micro bool) []string {
+	ans := []string{f.Package}
+	if f.Modules != "" {
+		ans = append(ans, f.Modules)
+	}
+	if micro && f.Initramfs != "" {
+		ans = append(ans, f.Initramfs)
+	}
+	return append(ans, f.Firmware...)
+}
+
+// HasPackage returns true if the package is the kernel package or
+// one of the companions of the family.
+func (f *KernelFamily) HasPackage(p string) bool {
+	if p == f.Package {
+		return true
+	}
+	for _, c := range f.GetCompanions() {
+		if c == p {
+			return true
+		}
+	}
+	return false
+}
+
+// NewPackageKernelFamily returns a family with only the kernel
+// package in input. It's used to switch to the kernel packages
+// without a family defined.
+func NewPackageKernelFamily(p string) *KernelFamily {
+	return &KernelFamily{
+		Name:    p,
+		Package: p,
+	}
+}
+
+// GetKernelFamily returns the family with the name or the kernel
+// package in input.
+func GetKernelFamily(families []KernelFamily, s string) *KernelFamily {
+	for idx := range families {
+		if families[idx].Name == s || families[idx].Package == s {
+			return &families[idx]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+// Copyright © 2021 Daniele Rondina, geaaru@sabayonlinux.org
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package kernelspecs
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	KernelImageFormatX86   = "x86"
+	KernelImageFormatArm64 = "arm64"
+
+	// x86 boot protocol offsets.
+	x86SetupHeaderMagicOffset = 0x202
+	x86VersionPtrOffset       = 0x20e
+	x86SetupHeaderMagic       = "HdrS"
+
+	// arm64 Image header.
+	arm64HeaderSize  = 64
+	arm64MagicOffset = 0x38
+	arm64Magic       = "ARM\x64"
+
+	linuxBannerPrefix = "Linux version "
+
+	// Max number of bytes of the uncompressed arm64 image read to
+	// search the linux_banner.
+	MaxBannerScanSize = 64 * 1024 * 1024
+)
+
+var ErrNotKernelImage = errors.New("File is not a kernel image")
+
+// KernelImageHeader contains the information read from the
+// header of a kernel image binary.
+type KernelImageHeader struct {
+	Format     string `json:"format"`
+	Compressed bool   `json:"compressed,omitempty"`
+	// Version is the full version string. For example:
+	// 5.10.42-mocaccino (root@builder) #1 SMP Thu Jun 3 10:01:02 UTC 2021
+	Version string `json:"version"`
+}
+
+// GetRelease returns the kernel release of the version string.
+func (h *KernelImageHeader) GetRelease() string {
+	return GetKernelReleaseFromVersion(h.Version)
+}
+
+// ReadKernelImageHeader parses the x86 boot protocol header or the
+// arm64 Image header (also gzip compressed) of the kernel image.
+// It returns ErrNotKernelImage if the file is not a kernel image.
+func ReadKernelImageHeader(file string) (*KernelImageHeader, error) {
+	return readKernelImageHeader(file, true)
+}
+
+// ProbeKernelImageHeader is like ReadKernelImageHeader but the arm64
+// images are not scanned to search the version. The version is
+// empty for arm64 images.
+func ProbeKernelImageHeader(file string) (*KernelImageHeader, error) {
+	return readKernelImageHeader(file, false)
+}
+
+func readKernelImageHeader(file string, withBanner bool) (*KernelImageHeader, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	header := make([]byte, 0x210)
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		if err == io.EOF {
+			return nil, ErrNotKernelImage
+		}
+		return nil, err
+	}
+	header = header[:n]
+
+	if len(header) >= x86VersionPtrOffset+2 &&
+		string(header[x86SetupHeaderMagicOffset:x86SetupHeaderMagicOffset+4]) == x86SetupHeaderMagic {
+		version, err := readX86Version(f, header)
+		if err != nil {
+			return nil, err
+		}
+		return &KernelImageHeader{
+			Format:  KernelImageFormatX86,
+			Version: version,
+		}, nil
+	}
+
+	if isArm64Header(header) {
+		if !withBanner {
+			return &KernelImageHeader{Format: KernelImageFormatArm64}, nil
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		version, err := scanLinuxBanner(f)
+		if err != nil {
+			return nil, err
+		}
+		return &KernelImageHeader{
+			Format:  KernelImageFormatArm64,
+			Version: version,
+		}, nil
+	}
+
+	if len(header) > 2 && header[0] == 0x1f && header[1] == 0x8b {
+		// Compressed arm64 Image (Image.gz)
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		gr, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, ErrNotKernelImage
+		}
+		defer gr.Close()
+
+		br := bufio.NewReaderSize(gr, 64*1024)
+		h, err := br.Peek(arm64HeaderSize)
+		if err != nil || !isArm64Header(h) {
+			return nil, ErrNotKernelImage
+		}
+		if !withBanner {
+			return &KernelImageHeader{Format: KernelImageFormatArm64, Compressed: true}, nil
+		}
+
+		version, err := scanLinuxBanner(br)
+		if err != nil {
+			return nil, err
+		}
+		return &KernelImageHeader{
+			Format:     KernelImageFormatArm64,
+			Compressed: true,
+			Version:    version,
+		}, nil
+	}
+
+	return nil, ErrNotKernelImage
+}
+
+// ReadKernelImageVersion returns the version string embedded
+// on the kernel image.
+func ReadKernelImageVersion(file string) (string, error) {
+	h, err := ReadKernelImageHeader(file)
+	if err != nil {
+		return "", err
+	}
+	return h.Version, nil
+}
+
+// GetKernelReleaseFromVersion returns the kernel release from the
+// embedded version string.
+func GetKernelReleaseFromVersion(version string) string {
+	fields := strings.Fields(version)
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
+func isArm64Header(header []byte) bool {
+	return len(header) >= arm64HeaderSize &&
+		string(header[arm64MagicOffset:arm64MagicOffset+4]) == arm64Magic
+}
+
+func readX86Version(r io.ReaderAt, header []byte) (string, error) {
+	offset := binary.LittleEndian.Uint16(header[x86VersionPtrOffset : x86VersionPtrOffset+2])
+	if offset == 0 {
+		return "", errors.New("Kernel version not available on boot protocol header")
+	}
+
+	buf := make([]byte, 256)
+	n, err := r.ReadAt(buf, int64(offset)+0x200)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	buf = buf[:n]
+
+	if idx := bytes.IndexByte(buf, 0); idx >= 0 {
+		buf = buf[:idx]
+	}
+
+	if len(buf) == 0 {
+		return "", errors.New("Empty kernel version on boot protocol header")
+	}
+
+	return string(buf), nil
+}
+
+// scanLinuxBanner searches the linux_banner string on the first
+// MaxBannerScanSize bytes of the uncompressed kernel image.
+func scanLinuxBanner(r io.Reader) (string, error) {
+	r = io.LimitReader(r, MaxBannerScanSize)
+	prefix := []byte(linuxBannerPrefix)
+	chunk := make([]byte, 64*1024)
+	// Keep the tail of the previous chunk to match a banner
+	// split between two chunks.
+	window := []byte{}
+
+	for {
+		n, err := r.Read(chunk)
+		if n > 0 {
+			window = append(window, chunk[:n]...)
+
+			if idx := bytes.Index(window, prefix); idx >= 0 {
+				banner := window[idx+len(prefix):]
+				end := bytes.IndexAny(banner, "\n\x00")
+				if end >= 0 {
+					return string(banner[:end]), nil
+				}
+				if len(banner) > 512 {
+					return string(banner[:512]), nil
+				}
+				// Wait for more data
+				window = window[idx:]
+				continue
+			}
+
+			if len(window) > len(prefix) {
+				window = append([]byte{}, window[len(window)-len(prefix):]...)
+			}
+		}
+
+		if err == io.EOF {
+			return "", errors.New("Linux version banner not found on kernel image")
+		} else if err != nil {
+			return "", err
+		}
+	}
+}
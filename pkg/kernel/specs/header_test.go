@@ -0,0 +1,114 @@
+// Copyright © 2021 Daniele Rondina, geaaru@sabayonlinux.org
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package kernelspecs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+const testKernelVersion = "5.10.42-mocaccino (root@builder) #1 SMP Thu Jun 3 10:01:02 UTC 2021"
+
+func newTestX86Image() []byte {
+	data := make([]byte, 0x1000)
+	copy(data[x86SetupHeaderMagicOffset:], x86SetupHeaderMagic)
+	binary.LittleEndian.PutUint16(data[x86VersionPtrOffset:], 0x600)
+	copy(data[0x800:], testKernelVersion+"\x00")
+	return data
+}
+
+func newTestArm64Image() []byte {
+	data := make([]byte, 256*1024)
+	copy(data[arm64MagicOffset:], arm64Magic)
+	// The banner is split between two chunks of the scan.
+	copy(data[64*1024-5:], linuxBannerPrefix+testKernelVersion+"\n")
+	return data
+}
+
+func writeTestFile(t *testing.T, data []byte) string {
+	file := filepath.Join(t.TempDir(), "kernel")
+	if err := ioutil.WriteFile(file, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestReadKernelImageHeader(t *testing.T) {
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write(newTestArm64Image())
+	gw.Close()
+
+	tests := []struct {
+		name       string
+		data       []byte
+		format     string
+		compressed bool
+	}{
+		{"x86", newTestX86Image(), KernelImageFormatX86, false},
+		{"arm64", newTestArm64Image(), KernelImageFormatArm64, false},
+		{"arm64 gzip", gz.Bytes(), KernelImageFormatArm64, true},
+	}
+
+	for _, tt := range tests {
+		file := writeTestFile(t, tt.data)
+
+		h, err := ReadKernelImageHeader(file)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", tt.name, err)
+		}
+		if h.Format != tt.format || h.Compressed != tt.compressed || h.Version != testKernelVersion {
+			t.Errorf("%s: unexpected header %+v", tt.name, h)
+		}
+		if h.GetRelease() != "5.10.42-mocaccino" {
+			t.Errorf("%s: unexpected release %s", tt.name, h.GetRelease())
+		}
+
+		// The probe doesn't scan the arm64 images.
+		h, err = ProbeKernelImageHeader(file)
+		if err != nil {
+			t.Fatalf("%s: unexpected error on probe: %s", tt.name, err)
+		}
+		if tt.format == KernelImageFormatArm64 && h.Version != "" {
+			t.Errorf("%s: unexpected version %s on probe", tt.name, h.Version)
+		}
+		if tt.format == KernelImageFormatX86 && h.Version != testKernelVersion {
+			t.Errorf("%s: unexpected version %s on probe", tt.name, h.Version)
+		}
+	}
+}
+
+func TestReadKernelImageHeaderNotKernel(t *testing.T) {
+	for _, data := range [][]byte{
+		{},
+		[]byte("CONFIG_64BIT=y\n"),
+		bytes.Repeat([]byte{0}, 4096),
+	} {
+		if _, err := ReadKernelImageHeader(writeTestFile(t, data)); err != ErrNotKernelImage {
+			t.Errorf("expected ErrNotKernelImage for %q, got %v", data, err)
+		}
+	}
+}
+
+func TestScanLinuxBannerNotFound(t *testing.T) {
+	if _, err := scanLinuxBanner(bytes.NewReader(make([]byte, 1024))); err == nil {
+		t.Error("expected an error without banner")
+	}
+}
@@ -17,7 +17,6 @@ package kernelspecs
 
 import (
 	"encoding/json"
-	"strings"
 )
 
 func NewInitrdImage() *InitrdImage {
@@ -25,38 +24,23 @@ func NewInitrdImage() *InitrdImage {
 }
 
 func NewInitrdImageFromFile(t *KernelType, file string) (*InitrdImage, error) {
-	ans := NewInitrdImage()
-	ans.Filename = file
-
-	iprefix := t.InitrdPrefix
-	if t.InitrdPrefix == "" {
-		iprefix = "initramfs"
+	if err := t.Compile(); err != nil {
+		return nil, err
 	}
 
-	ans.Prefix = iprefix
-
-	// Skip prefix + '-'
-	file = file[len(iprefix)+1:]
-
-	if t.Type != "" {
-		ans.KernelType = t.Type
-		file = file[len(t.Type)+1:]
+	fields, err := parseFilename(t, t.InitrdRegex, t.GetInitrdPrefixSanitized(), file)
+	if err != nil {
+		return nil, err
 	}
 
-	words := strings.Split(file, "-")
-	i := 0
-	if t.WithArch {
-		file = file[len(words[i])+1:]
-		ans.Arch = words[i]
-		i += 1
-	}
-
-	ans.Version = words[i]
-	file = file[len(words[i]):]
-
-	if t.Suffix != "" && file != "" {
-		ans.Suffix = file[1:]
-	}
+	ans := NewInitrdImage()
+	ans.Filename = file
+	ans.Prefix = fields.Prefix
+	ans.KernelType = fields.Type
+	ans.Arch = fields.Arch
+	ans.Version = fields.Version
+	ans.Suffix = fields.Suffix
+	ans.Pattern = t.InitrdRegex
 
 	return ans, nil
 }
@@ -107,23 +91,11 @@ func (i *InitrdImage) GenerateFilename() string {
 		iprefix = "initramfs"
 	}
 
-	ans := iprefix + "-"
-
-	if i.KernelType != "" {
-		ans += i.KernelType
-	}
-
-	if i.Arch != "" {
-		ans += "-" + i.Arch
-	}
-
-	if i.Version != "" {
-		ans += "-" + i.Version
-	}
-
-	if i.Suffix != "" {
-		ans += "-" + i.Suffix
-	}
-
-	return ans
+	return generateFilename(i.Pattern, &fileFields{
+		Prefix:  iprefix,
+		Type:    i.KernelType,
+		Arch:    i.Arch,
+		Version: i.Version,
+		Suffix:  i.Suffix,
+	})
 }
@@ -17,7 +17,6 @@ package kernelspecs
 
 import (
 	"encoding/json"
-	"strings"
 )
 
 func NewKernelImage() *KernelImage {
@@ -25,38 +24,23 @@ func NewKernelImage() *KernelImage {
 }
 
 func NewKernelImageFromFile(t *KernelType, file string) (*KernelImage, error) {
-	ans := NewKernelImage()
-	ans.Filename = file
-
-	kprefix := t.KernelPrefix
-	if t.KernelPrefix == "" {
-		kprefix = "kernel"
+	if err := t.Compile(); err != nil {
+		return nil, err
 	}
 
-	ans.Prefix = kprefix
-
-	// Skip prefix + '-'
-	file = file[len(kprefix)+1:]
-
-	if t.Type != "" {
-		ans.Type = t.Type
-		file = file[len(t.Type)+1:]
-	}
-
-	words := strings.Split(file, "-")
-	i := 0
-	if t.WithArch {
-		file = file[len(words[i])+1:]
-		ans.Arch = words[i]
-		i += 1
+	fields, err := parseFilename(t, t.KernelRegex, t.GetKernelPrefixSanitized(), file)
+	if err != nil {
+		return nil, err
 	}
 
-	ans.Version = words[i]
-	file = file[len(words[i]):]
-
-	if t.Suffix != "" {
-		ans.Suffix = file[1:]
-	}
+	ans := NewKernelImage()
+	ans.Filename = file
+	ans.Prefix = fields.Prefix
+	ans.Type = fields.Type
+	ans.Arch = fields.Arch
+	ans.Version = fields.Version
+	ans.Suffix = fields.Suffix
+	ans.Pattern = t.KernelRegex
 
 	return ans, nil
 }
@@ -75,6 +59,30 @@ func (k *KernelImage) GetArch() string     { return k.Arch }
 func (k *KernelImage) GetType() string     { return k.Type }
 func (k *KernelImage) GetFilename() string { return k.Filename }
 
+// GetKernelRelease returns the kernel release string as reported
+// by uname -r and used under /lib/modules.
+func (k *KernelImage) GetKernelRelease() string {
+	ans := k.Version
+	if k.Suffix != "" {
+		ans += "-" + k.Suffix
+	}
+	return ans
+}
+
+// GetKernelVersion returns the parsed version of the kernel.
+func (k *KernelImage) GetKernelVersion() (*KernelVersion, error) {
+	return ParseKernelVersion(k.Version)
+}
+
+func (k *KernelImage) SetBinaryRelease(r string) { k.BinaryRelease = r }
+func (k *KernelImage) GetBinaryRelease() string  { return k.BinaryRelease }
+
+// HasVersionMismatch returns true if the kernel release embedded on
+// the kernel image is different from the release of the filename.
+func (k *KernelImage) HasVersionMismatch() bool {
+	return k.BinaryRelease != "" && k.BinaryRelease != k.GetKernelRelease()
+}
+
 func (k *KernelImage) String() string {
 	data, _ := json.Marshal(k)
 	return string(data)
@@ -111,23 +119,11 @@ func (k *KernelImage) GenerateFilename() string {
 		kprefix = "kernel"
 	}
 
-	ans := kprefix + "-"
-
-	if k.Type != "" {
-		ans += k.Type
-	}
-
-	if k.Arch != "" {
-		ans += "-" + k.Arch
-	}
-
-	if k.Version != "" {
-		ans += "-" + k.Version
-	}
-
-	if k.Suffix != "" {
-		ans += "-" + k.Suffix
-	}
-
-	return ans
+	return generateFilename(k.Pattern, &fileFields{
+		Prefix:  kprefix,
+		Type:    k.Type,
+		Arch:    k.Arch,
+		Version: k.Version,
+		Suffix:  k.Suffix,
+	})
 }
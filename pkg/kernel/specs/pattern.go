@@ -0,0 +1,319 @@
+// Copyright © 2021 Daniele Rondina, geaaru@sabayonlinux.org
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package kernelspecs
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+)
+
+// Fields of the filename patterns. A pattern could be a template
+// with placeholders, for example:
+//
+//	vmlinuz-{version}-{flavor}
+//	initrd.img-{version}
+//
+// or a regex with named groups:
+//
+//	^vmlinuz-(?P<version>[0-9.]+)-(?P<suffix>.*)$
+const (
+	PatternFieldVersion = "version"
+	PatternFieldArch    = "arch"
+	PatternFieldType    = "type"
+	PatternFieldSuffix  = "suffix"
+	// Alias of suffix used by the templates.
+	PatternFieldFlavor = "flavor"
+)
+
+var patternPlaceholderRegex = regexp.MustCompile(`\{(\w+)\}`)
+
+// IsRegexPattern returns true if the pattern is a regex with
+// named groups and not a template.
+func IsRegexPattern(p string) bool {
+	return strings.Contains(p, "(?P<")
+}
+
+// CompilePattern returns the regex of the pattern in input. The values
+// of type and suffix of the kernel type are used for the placeholders
+// {type} and {suffix} when they are defined.
+func CompilePattern(pattern string, t *KernelType) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, errors.New("Invalid empty pattern")
+	}
+
+	if IsRegexPattern(pattern) {
+		if !strings.HasPrefix(pattern, "^") {
+			pattern = "^(?:" + pattern + ")$"
+		}
+		r, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, errors.New(
+				fmt.Sprintf("Invalid pattern %s: %s", pattern, err.Error()))
+		}
+		return r, nil
+	}
+
+	regstr := "^"
+	pos := 0
+	for _, m := range patternPlaceholderRegex.FindAllStringSubmatchIndex(pattern, -1) {
+		regstr += regexp.QuoteMeta(pattern[pos:m[0]])
+		pos = m[1]
+
+		field := pattern[m[2]:m[3]]
+		switch field {
+		case PatternFieldVersion:
+			regstr += `(?P<version>[0-9][^/]*?)`
+		case PatternFieldArch:
+			regstr += `(?P<arch>[^-./]+)`
+		case PatternFieldType:
+			if t != nil && t.Type != "" {
+				regstr += `(?P<type>` + regexp.QuoteMeta(t.Type) + `)`
+			} else {
+				regstr += `(?P<type>[^-./]+)`
+			}
+		case PatternFieldSuffix, PatternFieldFlavor:
+			if t != nil && t.Suffix != "" {
+				regstr += `(?P<suffix>` + regexp.QuoteMeta(t.Suffix) + `)`
+			} else {
+				regstr += `(?P<suffix>[^/]+?)`
+			}
+		default:
+			return nil, errors.New(
+				fmt.Sprintf("Invalid placeholder {%s} on pattern %s", field, pattern))
+		}
+	}
+	regstr += regexp.QuoteMeta(pattern[pos:]) + "$"
+
+	return regexp.Compile(regstr)
+}
+
+// MatchPattern returns the values of the named groups of the regex
+// or nil if the file doesn't match.
+func MatchPattern(r *regexp.Regexp, file string) map[string]string {
+	m := r.FindStringSubmatch(file)
+	if m == nil {
+		return nil
+	}
+
+	ans := make(map[string]string)
+	for idx, name := range r.SubexpNames() {
+		if name != "" && m[idx] != "" {
+			ans[name] = m[idx]
+		}
+	}
+
+	return ans
+}
+
+// ExpandPattern generates the filename of the pattern replacing
+// the named groups with the values in input. Groups without value
+// are accepted only if they contain a literal string.
+func ExpandPattern(r *regexp.Regexp, values map[string]string) (string, error) {
+	re, err := syntax.Parse(r.String(), syntax.Perl)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	if err := expandRegexp(re, values, &sb); err != nil {
+		return "", errors.New(
+			fmt.Sprintf("Unable to generate filename from pattern %s: %s",
+				r.String(), err.Error()))
+	}
+
+	return sb.String(), nil
+}
+
+func expandRegexp(re *syntax.Regexp, values map[string]string, sb *strings.Builder) error {
+	switch re.Op {
+	case syntax.OpLiteral:
+		sb.WriteString(string(re.Rune))
+	case syntax.OpEmptyMatch, syntax.OpBeginText, syntax.OpEndText,
+		syntax.OpBeginLine, syntax.OpEndLine:
+		// Nothing to write
+	case syntax.OpCapture:
+		if v, ok := values[re.Name]; ok && re.Name != "" {
+			sb.WriteString(v)
+			return nil
+		}
+		return expandRegexp(re.Sub[0], values, sb)
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			if err := expandRegexp(sub, values, sb); err != nil {
+				return err
+			}
+		}
+	default:
+		if re.Op == syntax.OpCharClass && len(re.Rune) == 2 && re.Rune[0] == re.Rune[1] {
+			// A single escaped character
+			sb.WriteRune(re.Rune[0])
+			return nil
+		}
+		return errors.New(fmt.Sprintf("no value for %s", re.String()))
+	}
+
+	return nil
+}
+
+// getPatternPrefix returns the first word of the literal prefix
+// of the pattern.
+func getPatternPrefix(r *regexp.Regexp) string {
+	re, err := syntax.Parse(r.String(), syntax.Perl)
+	if err != nil {
+		return ""
+	}
+
+	prefix := ""
+	subs := []*syntax.Regexp{re}
+	if re.Op == syntax.OpConcat {
+		subs = re.Sub
+	}
+	for _, sub := range subs {
+		if sub.Op == syntax.OpBeginText || sub.Op == syntax.OpBeginLine {
+			continue
+		}
+		if sub.Op != syntax.OpLiteral {
+			break
+		}
+		prefix += string(sub.Rune)
+	}
+
+	return strings.Split(prefix, "-")[0]
+}
+
+// fileFields contains the fields parsed from the filename of a
+// kernel or initrd image.
+type fileFields struct {
+	Prefix  string
+	Type    string
+	Arch    string
+	Version string
+	Suffix  string
+}
+
+func (f *fileFields) toValues() map[string]string {
+	ans := make(map[string]string)
+	for k, v := range map[string]string{
+		PatternFieldType:    f.Type,
+		PatternFieldArch:    f.Arch,
+		PatternFieldVersion: f.Version,
+		PatternFieldSuffix:  f.Suffix,
+	} {
+		if v != "" {
+			ans[k] = v
+		}
+	}
+	return ans
+}
+
+// parseFilename parses the filename with the regex of the pattern
+// or with the prefix-type-arch-version-suffix layout if the regex
+// is nil.
+func parseFilename(t *KernelType, r *regexp.Regexp, prefix, file string) (*fileFields, error) {
+	ans := &fileFields{}
+
+	if r != nil {
+		values := MatchPattern(r, file)
+		if values == nil {
+			return nil, errors.New(
+				fmt.Sprintf("File %s doesn't match the pattern %s", file, r.String()))
+		}
+
+		ans.Prefix = getPatternPrefix(r)
+		ans.Type = t.Type
+		ans.Suffix = t.Suffix
+		if v, ok := values[PatternFieldType]; ok {
+			ans.Type = v
+		}
+		if v, ok := values[PatternFieldSuffix]; ok {
+			ans.Suffix = v
+		}
+		ans.Arch = values[PatternFieldArch]
+		ans.Version = values[PatternFieldVersion]
+
+		return ans, nil
+	}
+
+	ans.Prefix = prefix
+
+	// Skip prefix + '-'
+	if !strings.HasPrefix(file, prefix+"-") {
+		return nil, errors.New(
+			fmt.Sprintf("File %s doesn't start with %s-", file, prefix))
+	}
+	file = file[len(prefix)+1:]
+
+	if t.Type != "" {
+		if !strings.HasPrefix(file, t.Type+"-") {
+			return nil, errors.New(
+				fmt.Sprintf("File doesn't contain the type %s", t.Type))
+		}
+		ans.Type = t.Type
+		file = file[len(t.Type)+1:]
+	}
+
+	words := strings.Split(file, "-")
+	i := 0
+	if t.WithArch {
+		if len(words) < 2 {
+			return nil, errors.New("File without arch and version")
+		}
+		file = file[len(words[i])+1:]
+		ans.Arch = words[i]
+		i += 1
+	}
+
+	if t.Suffix != "" && strings.HasSuffix(file, "-"+t.Suffix) {
+		// The version could contain dashes (for example 5.15.0-rc3).
+		ans.Version = file[:len(file)-len(t.Suffix)-1]
+		ans.Suffix = t.Suffix
+	} else {
+		ans.Version = words[i]
+		file = file[len(words[i]):]
+
+		if t.Suffix != "" && file != "" {
+			ans.Suffix = file[1:]
+		}
+	}
+
+	if ans.Version == "" || (t.Suffix != "" && ans.Suffix == "") {
+		return nil, errors.New("File without version")
+	}
+
+	return ans, nil
+}
+
+// generateFilename returns the filename of the fields through the
+// pattern or with the prefix-type-arch-version-suffix layout.
+func generateFilename(r *regexp.Regexp, f *fileFields) string {
+	if r != nil {
+		if ans, err := ExpandPattern(r, f.toValues()); err == nil {
+			return ans
+		}
+	}
+
+	ans := f.Prefix
+	for _, field := range []string{f.Type, f.Arch, f.Version, f.Suffix} {
+		if field != "" {
+			ans += "-" + field
+		}
+	}
+
+	return ans
+}
@@ -0,0 +1,265 @@
+// Copyright © 2021 Daniele Rondina, geaaru@sabayonlinux.org
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package kernelspecs
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func loadContribProfile(t *testing.T, name string) *KernelType {
+	t.Helper()
+	data, err := ioutil.ReadFile(
+		filepath.Join("..", "..", "..", "contrib", "kernel-profiles", name+".yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	kt, err := KernelTypeFromYaml(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kt
+}
+
+func TestContribProfilesCompile(t *testing.T) {
+	files, err := filepath.Glob(
+		filepath.Join("..", "..", "..", "contrib", "kernel-profiles", "*.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("No kernel profiles found")
+	}
+
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		kt, err := KernelTypeFromYaml(data)
+		if err != nil {
+			t.Errorf("%s: %s", f, err)
+			continue
+		}
+		if err := kt.Compile(); err != nil {
+			t.Errorf("%s: %s", f, err)
+		}
+	}
+}
+
+func TestCompileInvalidPatterns(t *testing.T) {
+	tests := []struct {
+		name string
+		kt   KernelType
+	}{
+		{"unknown placeholder", KernelType{Type: "t", KernelPattern: "vmlinuz-{release}"}},
+		{"invalid regex", KernelType{Type: "t", KernelPattern: "^vmlinuz-(?P<version>[0-9.+)$"}},
+		{"template without version", KernelType{Type: "t", KernelPattern: "vmlinuz-linux-{type}"}},
+		{"regex without version", KernelType{Type: "t", KernelPattern: "^vmlinuz-(?P<suffix>.*)$"}},
+	}
+
+	for _, tt := range tests {
+		kt := tt.kt
+		if err := kt.Compile(); err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+	}
+
+	kt := KernelType{Type: "t", KernelPattern: "vmlinuz-linux-{type}", VersionFromImage: true}
+	if err := kt.Compile(); err != nil {
+		t.Errorf("pattern with version_from_image: unexpected error: %s", err)
+	}
+}
+
+func TestParseFilename(t *testing.T) {
+	sabayon := &KernelType{Type: "genkernel", Suffix: "sabayon", WithArch: true}
+	mocaccino := &KernelType{Type: "vanilla", Suffix: "mocaccino", WithArch: true}
+
+	tests := []struct {
+		name    string
+		kt      *KernelType
+		initrd  bool
+		file    string
+		fields  fileFields
+		invalid bool
+	}{
+		{
+			name:   "prefix layout",
+			kt:     sabayon,
+			file:   "kernel-genkernel-x86_64-5.10.42-sabayon",
+			fields: fileFields{"kernel", "genkernel", "x86_64", "5.10.42", "sabayon"},
+		},
+		{
+			name:   "prefix layout with rc version",
+			kt:     mocaccino,
+			file:   "kernel-vanilla-x86_64-5.15.0-rc3-mocaccino",
+			fields: fileFields{"kernel", "vanilla", "x86_64", "5.15.0-rc3", "mocaccino"},
+		},
+		{
+			name:   "prefix layout initrd",
+			kt:     mocaccino,
+			initrd: true,
+			file:   "initramfs-vanilla-x86_64-5.10.9-mocaccino",
+			fields: fileFields{"initramfs", "vanilla", "x86_64", "5.10.9", "mocaccino"},
+		},
+		{
+			name:    "prefix layout without version",
+			kt:      sabayon,
+			file:    "kernel-genkernel-x86_64",
+			invalid: true,
+		},
+		{
+			name:    "prefix layout with another type",
+			kt:      sabayon,
+			file:    "kernel-vanilla-x86_64-5.10.42-sabayon",
+			invalid: true,
+		},
+		{
+			name:   "debian template",
+			kt:     loadContribProfile(t, "debian"),
+			file:   "vmlinuz-5.10.0-8-amd64",
+			fields: fileFields{"vmlinuz", "debian", "", "5.10.0-8", "amd64"},
+		},
+		{
+			name:   "debian template initrd",
+			kt:     loadContribProfile(t, "debian"),
+			initrd: true,
+			file:   "initrd.img-5.10.0-8-amd64",
+			fields: fileFields{"initrd.img", "debian", "", "5.10.0-8", "amd64"},
+		},
+		{
+			name:    "debian template with another flavor",
+			kt:      loadContribProfile(t, "debian"),
+			file:    "vmlinuz-5.10.0-8-arm64",
+			invalid: true,
+		},
+		{
+			name:   "arch template without version",
+			kt:     loadContribProfile(t, "arch-lts"),
+			file:   "vmlinuz-linux-lts",
+			fields: fileFields{"vmlinuz", "lts", "", "", "lts"},
+		},
+		{
+			name:   "arch template initrd",
+			kt:     loadContribProfile(t, "arch-lts"),
+			initrd: true,
+			file:   "initramfs-linux-lts.img",
+			fields: fileFields{"initramfs", "lts", "", "", "lts"},
+		},
+		{
+			name:   "gentoo regex",
+			kt:     loadContribProfile(t, "gentoo"),
+			file:   "kernel-genkernel-x86_64-5.10.42-gentoo",
+			fields: fileFields{"kernel", "gentoo", "x86_64", "5.10.42", "gentoo"},
+		},
+		{
+			name:   "gentoo regex with revision",
+			kt:     loadContribProfile(t, "gentoo"),
+			file:   "kernel-genkernel-x86_64-5.15.11-gentoo-r1",
+			fields: fileFields{"kernel", "gentoo", "x86_64", "5.15.11", "gentoo-r1"},
+		},
+		{
+			name:   "gentoo regex initrd",
+			kt:     loadContribProfile(t, "gentoo"),
+			initrd: true,
+			file:   "initramfs-genkernel-x86_64-5.10.42-gentoo",
+			fields: fileFields{"initramfs", "gentoo", "x86_64", "5.10.42", "gentoo"},
+		},
+		{
+			name:    "gentoo regex with vmlinuz layout",
+			kt:      loadContribProfile(t, "gentoo"),
+			file:    "vmlinuz-5.10.42-gentoo",
+			invalid: true,
+		},
+	}
+
+	for _, tt := range tests {
+		if err := tt.kt.Compile(); err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+
+		r, prefix := tt.kt.KernelRegex, tt.kt.GetKernelPrefixSanitized()
+		if tt.initrd {
+			r, prefix = tt.kt.InitrdRegex, tt.kt.GetInitrdPrefixSanitized()
+		}
+
+		f, err := parseFilename(tt.kt, r, prefix, tt.file)
+		if tt.invalid {
+			if err == nil {
+				t.Errorf("%s: expected error, got %+v", tt.name, f)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.name, err)
+			continue
+		}
+		if *f != tt.fields {
+			t.Errorf("%s: expected %+v, got %+v", tt.name, tt.fields, *f)
+			continue
+		}
+
+		// The fields generate the same filename.
+		if gen := generateFilename(r, f); gen != tt.file {
+			t.Errorf("%s: generated %s instead of %s", tt.name, gen, tt.file)
+		}
+	}
+}
+
+func TestGenerateFilenameVersionFromImage(t *testing.T) {
+	kt := loadContribProfile(t, "arch-lts")
+	if err := kt.Compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The version read from the image is not part of the filename.
+	f := &fileFields{Prefix: "vmlinuz", Type: "lts", Version: "6.1.10", Suffix: "lts"}
+	if gen := generateFilename(kt.KernelRegex, f); gen != "vmlinuz-linux-lts" {
+		t.Errorf("Unexpected kernel filename %s", gen)
+	}
+	f.Prefix = "initramfs"
+	if gen := generateFilename(kt.InitrdRegex, f); gen != "initramfs-linux-lts.img" {
+		t.Errorf("Unexpected initrd filename %s", gen)
+	}
+}
+
+func TestExpandPattern(t *testing.T) {
+	kt := loadContribProfile(t, "gentoo")
+	if err := kt.Compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	ans, err := ExpandPattern(kt.KernelRegex, map[string]string{
+		PatternFieldArch:    "aarch64",
+		PatternFieldVersion: "6.1.10",
+		PatternFieldSuffix:  "gentoo",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ans != "kernel-genkernel-aarch64-6.1.10-gentoo" {
+		t.Errorf("Unexpected filename %s", ans)
+	}
+
+	// The groups without a literal value require a value.
+	if _, err := ExpandPattern(kt.KernelRegex, map[string]string{
+		PatternFieldArch:   "aarch64",
+		PatternFieldSuffix: "gentoo",
+	}); err == nil {
+		t.Errorf("Expected error without version")
+	}
+}
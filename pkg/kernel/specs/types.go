@@ -24,16 +24,23 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
-func (t *KernelType) SetKernelPrefix(p string) { t.KernelPrefix = p }
-func (t *KernelType) SetInitrdPrefix(p string) { t.InitrdPrefix = p }
-func (t *KernelType) SetSuffix(s string)       { t.Suffix = s }
-func (t *KernelType) SetType(s string)         { t.Type = s }
-
-func (t *KernelType) GetKernelPrefix() string { return t.KernelPrefix }
-func (t *KernelType) GetInitrdPrefix() string { return t.InitrdPrefix }
-func (t *KernelType) GetSuffix() string       { return t.Suffix }
-func (t *KernelType) GetType() string         { return t.Type }
-func (t *KernelType) GetName() string         { return t.Name }
+func (t *KernelType) SetKernelPrefix(p string)  { t.KernelPrefix = p }
+func (t *KernelType) SetInitrdPrefix(p string)  { t.InitrdPrefix = p }
+func (t *KernelType) SetSuffix(s string)        { t.Suffix = s }
+func (t *KernelType) SetType(s string)          { t.Type = s }
+func (t *KernelType) SetInitrdBuilder(b string) { t.InitrdBuilder = b }
+func (t *KernelType) SetKernelPattern(p string) { t.KernelPattern = p }
+func (t *KernelType) SetInitrdPattern(p string) { t.InitrdPattern = p }
+
+func (t *KernelType) GetKernelPrefix() string  { return t.KernelPrefix }
+func (t *KernelType) GetInitrdPrefix() string  { return t.InitrdPrefix }
+func (t *KernelType) GetSuffix() string        { return t.Suffix }
+func (t *KernelType) GetType() string          { return t.Type }
+func (t *KernelType) GetName() string          { return t.Name }
+func (t *KernelType) GetInitrdBuilder() string { return t.InitrdBuilder }
+func (t *KernelType) GetKernelPattern() string { return t.KernelPattern }
+func (t *KernelType) GetInitrdPattern() string { return t.InitrdPattern }
+func (t *KernelType) GetCmdline() string       { return t.Cmdline }
 
 func (t *KernelType) GetInitrdPrefixSanitized() string {
 	initrdprefix := t.InitrdPrefix
@@ -59,6 +66,13 @@ func (t *KernelType) IsInitrdFile(f string) (bool, error) {
 		return ans, errors.New("Invalid file path")
 	}
 
+	if t.InitrdPattern != "" {
+		if err := t.Compile(); err != nil {
+			return ans, err
+		}
+		return t.InitrdRegex.MatchString(f), nil
+	}
+
 	initrdprefix := t.GetInitrdPrefixSanitized()
 
 	if strings.HasPrefix(f, initrdprefix) {
@@ -74,6 +88,13 @@ func (t *KernelType) IsKernelFile(f string) (bool, error) {
 		return ans, errors.New("Invalid kernel file path")
 	}
 
+	if t.KernelPattern != "" {
+		if err := t.Compile(); err != nil {
+			return ans, err
+		}
+		return t.KernelRegex.MatchString(f), nil
+	}
+
 	kprefix := t.GetKernelPrefixSanitized()
 
 	if strings.HasPrefix(f, kprefix) {
@@ -119,14 +140,55 @@ func (t *KernelType) getInitrdRegex() string {
 	return ans
 }
 
-func (t *KernelType) GetRegex() *regexp.Regexp {
-	if t.Regex == nil {
-		regstrk := t.getKernelRegex()
-		regstri := t.getInitrdRegex()
+// Compile creates the regexes used to match the kernel and
+// initrd files of the kernel type.
+func (t *KernelType) Compile() error {
+	if t.Regex != nil {
+		return nil
+	}
+
+	regstrk := t.getKernelRegex()
+	regstri := t.getInitrdRegex()
+
+	if t.KernelPattern != "" {
+		r, err := CompilePattern(t.KernelPattern, t)
+		if err != nil {
+			return err
+		}
+		if r.SubexpIndex(PatternFieldVersion) < 0 && !t.VersionFromImage {
+			return errors.New(
+				fmt.Sprintf("Kernel pattern %s without version. Set version_from_image to read the version from the kernel image",
+					t.KernelPattern))
+		}
+		t.KernelRegex = r
+		regstrk = r.String()
+	}
+
+	if t.InitrdPattern != "" {
+		r, err := CompilePattern(t.InitrdPattern, t)
+		if err != nil {
+			return err
+		}
+		t.InitrdRegex = r
+		regstri = r.String()
+	}
+
+	r, err := regexp.Compile(fmt.Sprintf("%s|%s", regstrk, regstri))
+	if err != nil {
+		return errors.New(
+			fmt.Sprintf("Error on create regex for kernel type %s: %s",
+				t.GetType(), err.Error()))
+	}
+	t.Regex = r
+
+	return nil
+}
 
-		t.Regex = regexp.MustCompile(
-			fmt.Sprintf("%s|%s", regstrk, regstri),
-		)
+// GetRegex returns the regex that matches the kernel and initrd
+// files of the kernel type or nil if the patterns are not valid.
+func (t *KernelType) GetRegex() *regexp.Regexp {
+	if err := t.Compile(); err != nil {
+		return nil
 	}
 
 	return t.Regex
@@ -0,0 +1,55 @@
+// Copyright © 2021 Daniele Rondina, geaaru@sabayonlinux.org
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package kernelspecs
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+const (
+	UkiSuffix = ".efi"
+)
+
+func NewUkiImage(dir, file string) *UkiImage {
+	return &UkiImage{
+		Filename: file,
+		Dir:      dir,
+	}
+}
+
+func (u *UkiImage) GetFilename() string { return u.Filename }
+func (u *UkiImage) GetDir() string      { return u.Dir }
+func (u *UkiImage) GetPath() string     { return filepath.Join(u.Dir, u.Filename) }
+
+// GetUkiFilename returns the filename of the UKI of the kernel.
+func (k *KernelImage) GetUkiFilename() string {
+	return k.Filename + UkiSuffix
+}
+
+// AddUkiImage sets the UKI of the kernel with the same filename
+// without the .efi extension. It returns false if the kernel is
+// not available.
+func (b *BootFiles) AddUkiImage(u *UkiImage) bool {
+	kfile := strings.TrimSuffix(u.GetFilename(), UkiSuffix)
+	for _, f := range b.Files {
+		if f.Kernel != nil && f.Kernel.GetFilename() == kfile {
+			f.Uki = u
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,142 @@
+// Copyright © 2021 Daniele Rondina, geaaru@sabayonlinux.org
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package kernelspecs
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// KernelVersion is a parsed kernel version. Supported formats are:
+//
+//	5.10.42
+//	5.15.0-rc3
+//	5.10.42-r1, 5.10.42_p1 (distro revisions)
+//	5.10.0-8-amd64 (distro revision with local version)
+//	5.10.42-mocaccino (local version)
+type KernelVersion struct {
+	Numbers  []int  `json:"numbers"`
+	Rc       int    `json:"rc,omitempty"`
+	Revision int    `json:"revision,omitempty"`
+	Local    string `json:"local,omitempty"`
+	Raw      string `json:"raw"`
+}
+
+var kernelVersionRegex = regexp.MustCompile(
+	`^(\d+(?:\.\d+){0,3})(?:-rc(\d+))?(?:(?:-r|_p|-)(\d+))?(?:[-+_.~](.+))?$`,
+)
+
+func ParseKernelVersion(v string) (*KernelVersion, error) {
+	m := kernelVersionRegex.FindStringSubmatch(v)
+	if m == nil {
+		return nil, errors.New(fmt.Sprintf("Invalid kernel version %s", v))
+	}
+
+	ans := &KernelVersion{
+		Numbers: []int{},
+		Local:   m[4],
+		Raw:     v,
+	}
+
+	for _, n := range strings.Split(m[1], ".") {
+		i, _ := strconv.Atoi(n)
+		ans.Numbers = append(ans.Numbers, i)
+	}
+
+	if m[2] != "" {
+		ans.Rc, _ = strconv.Atoi(m[2])
+	}
+
+	if m[3] != "" {
+		ans.Revision, _ = strconv.Atoi(m[3])
+	}
+
+	return ans, nil
+}
+
+func (v *KernelVersion) String() string { return v.Raw }
+
+// IsRc returns true for release candidate versions.
+func (v *KernelVersion) IsRc() bool { return v.Rc > 0 }
+
+// Compare returns -1, 0 or 1 if the version is lower, equal
+// or greater than the version in input.
+func (v *KernelVersion) Compare(o *KernelVersion) int {
+	for i := 0; i < len(v.Numbers) || i < len(o.Numbers); i++ {
+		a, b := 0, 0
+		if i < len(v.Numbers) {
+			a = v.Numbers[i]
+		}
+		if i < len(o.Numbers) {
+			b = o.Numbers[i]
+		}
+		if a != b {
+			return compareInt(a, b)
+		}
+	}
+
+	// A release candidate is older than the final release.
+	if v.Rc != o.Rc {
+		if v.Rc == 0 {
+			return 1
+		}
+		if o.Rc == 0 {
+			return -1
+		}
+		return compareInt(v.Rc, o.Rc)
+	}
+
+	if v.Revision != o.Revision {
+		return compareInt(v.Revision, o.Revision)
+	}
+
+	return strings.Compare(v.Local, o.Local)
+}
+
+func (v *KernelVersion) LessThan(o *KernelVersion) bool { return v.Compare(o) < 0 }
+func (v *KernelVersion) EqualTo(o *KernelVersion) bool  { return v.Compare(o) == 0 }
+
+func compareInt(a, b int) int {
+	if a < b {
+		return -1
+	}
+	if a > b {
+		return 1
+	}
+	return 0
+}
+
+// CompareKernelVersions compares two version strings. Versions that
+// couldn't be parsed are older than the valid versions and they are
+// compared as strings.
+func CompareKernelVersions(a, b string) int {
+	va, erra := ParseKernelVersion(a)
+	vb, errb := ParseKernelVersion(b)
+
+	switch {
+	case erra == nil && errb == nil:
+		return va.Compare(vb)
+	case erra != nil && errb != nil:
+		return strings.Compare(a, b)
+	case erra != nil:
+		return -1
+	default:
+		return 1
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright © 2021 Daniele Rondina, geaaru@sabayonlinux.org
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package kernelspecs
+
+import (
+	"testing"
+)
+
+func TestParseKernelVersion(t *testing.T) {
+	tests := []struct {
+		version  string
+		numbers  []int
+		rc       int
+		revision int
+		local    string
+	}{
+		{"5.10.42", []int{5, 10, 42}, 0, 0, ""},
+		{"5.15", []int{5, 15}, 0, 0, ""},
+		{"5.15.0-rc3", []int{5, 15, 0}, 3, 0, ""},
+		{"5.10.42-r1", []int{5, 10, 42}, 0, 1, ""},
+		{"5.10.42_p2", []int{5, 10, 42}, 0, 2, ""},
+		{"5.10.0-8-amd64", []int{5, 10, 0}, 0, 8, "amd64"},
+		{"5.10.42-mocaccino", []int{5, 10, 42}, 0, 0, "mocaccino"},
+	}
+
+	for _, tt := range tests {
+		v, err := ParseKernelVersion(tt.version)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.version, err)
+			continue
+		}
+		if len(v.Numbers) != len(tt.numbers) {
+			t.Errorf("%s: unexpected numbers %v", tt.version, v.Numbers)
+			continue
+		}
+		for i := range tt.numbers {
+			if v.Numbers[i] != tt.numbers[i] {
+				t.Errorf("%s: unexpected numbers %v", tt.version, v.Numbers)
+				break
+			}
+		}
+		if v.Rc != tt.rc || v.Revision != tt.revision || v.Local != tt.local {
+			t.Errorf("%s: unexpected version %+v", tt.version, v)
+		}
+		if v.String() != tt.version {
+			t.Errorf("%s: unexpected string %s", tt.version, v.String())
+		}
+	}
+
+	for _, invalid := range []string{"", "linux", "v5.10", "-rc1"} {
+		if _, err := ParseKernelVersion(invalid); err == nil {
+			t.Errorf("%q: expected an error", invalid)
+		}
+	}
+}
+
+func TestCompareKernelVersions(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"5.10.42", "5.10.42", 0},
+		{"5.10.9", "5.10.42", -1},
+		{"5.15.0", "5.4.100", 1},
+		{"5.10", "5.10.0", 0},
+		{"5.10.1", "5.10", 1},
+		{"5.15.0-rc3", "5.15.0", -1},
+		{"5.15.0-rc3", "5.15.0-rc10", -1},
+		{"5.15.0", "5.15.0-rc1", 1},
+		{"5.10.42-r2", "5.10.42-r1", 1},
+		{"5.10.42", "5.10.42-r1", -1},
+		{"5.10.0-8-amd64", "5.10.0-9-amd64", -1},
+		{"5.10.42-gentoo", "5.10.42-mocaccino", -1},
+		{"linux", "5.10.42", -1},
+		{"5.10.42", "linux", 1},
+		{"abc", "abd", -1},
+	}
+
+	for _, tt := range tests {
+		if got := CompareKernelVersions(tt.a, tt.b); got != tt.expected {
+			t.Errorf("CompareKernelVersions(%s, %s): expected %d, got %d", tt.a, tt.b, tt.expected, got)
+		}
+		// The comparison must be antisymmetric.
+		if got := CompareKernelVersions(tt.b, tt.a); got != -tt.expected {
+			t.Errorf("CompareKernelVersions(%s, %s): expected %d, got %d", tt.b, tt.a, -tt.expected, got)
+		}
+	}
+}
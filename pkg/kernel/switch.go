@@ -0,0 +1,370 @@
+// Copyright © 2021 Daniele Rondina, geaaru@sabayonlinux.org
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package kernel
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	kernelspecs "github.com/MocaccinoOS/mos-cli/pkg/kernel/specs"
+)
+
+const (
+	DefaultSwitchStateFile = "/var/lib/mos/kernel-switch.json"
+
+	SwitchStepPackages   = "packages"
+	SwitchStepInitrd     = "initrd"
+	SwitchStepLinks      = "links"
+	SwitchStepBootloader = "bootloader"
+	SwitchStepFinalize   = "finalize"
+
+	SwitchStepPending = "pending"
+	SwitchStepDone    = "done"
+	SwitchStepFailed  = "failed"
+	SwitchStepSkipped = "skipped"
+)
+
+// SwitchPackage is a package of the switch plan.
+type SwitchPackage struct {
+	Package string `json:"package"`
+	Version string `json:"version,omitempty"`
+}
+
+// SwitchPlan describes the transaction of a kernel switch.
+type SwitchPlan struct {
+	Family  string           `json:"family"`
+	Kernel  string           `json:"kernel"`
+	Version string           `json:"version,omitempty"`
+	Remove  []*SwitchPackage `json:"remove"`
+	Install []*SwitchPackage `json:"install"`
+
+	// Files expected on boot dir after the switch. They are
+	// available only if the kernel type of the family is defined.
+	BootFiles []string `json:"boot_files,omitempty"`
+	// Kernel image that will be used as default kernel.
+	Default string `json:"default,omitempty"`
+	// Type of the kernel files of the family.
+	KernelType string `json:"kernel_type,omitempty"`
+	// The packages to remove are kept until the finalize of the
+	// switch after the boot of the new kernel.
+	KeepCurrent bool `json:"keep_current,omitempty"`
+}
+
+// getPackageVersion returns the version of the package in input or
+// an empty string if the package is not available.
+func getPackageVersion(res SearchResult, p string) string {
+	for _, i := range res.Packages {
+		if i.EqualS(p) {
+			return i.Version
+		}
+	}
+	return ""
+}
+
+// getArch returns the arch of the kernel files of the running arch.
+func getArch() string {
+	switch runtime.GOARCH {
+	case "amd64":
+		return "x86_64"
+	case "arm64":
+		return "aarch64"
+	case "386":
+		return "x86"
+	default:
+		return runtime.GOARCH
+	}
+}
+
+// GetFamilyKernelType returns the kernel type of the family with the
+// name or the type defined by the family.
+func GetFamilyKernelType(f *kernelspecs.KernelFamily, types []kernelspecs.KernelType) *kernelspecs.KernelType {
+	if f.KernelType == "" {
+		return nil
+	}
+	for idx := range types {
+		if strings.EqualFold(types[idx].GetName(), f.KernelType) {
+			return &types[idx]
+		}
+	}
+	for idx := range types {
+		if types[idx].GetType() == f.KernelType {
+			return &types[idx]
+		}
+	}
+	return nil
+}
+
+// NewSwitchPlan returns the plan of the switch to the kernel family
+// in input. The versions of the packages to install are read from
+// the available packages and the expected boot files are generated
+// from the kernel type of the family.
+func NewSwitchPlan(families []kernelspecs.KernelFamily, all, installed SearchResult,
+	target *kernelspecs.KernelFamily, types []kernelspecs.KernelType, micro bool) *SwitchPlan {
+
+	ans := &SwitchPlan{
+		Family:  target.Name,
+		Kernel:  target.Package,
+		Version: getPackageVersion(all, target.Package),
+		Remove:  []*SwitchPackage{},
+		Install: []*SwitchPackage{},
+	}
+
+	remove, install := GetSwitchPackages(families, installed, target, micro)
+	for _, p := range remove {
+		ans.Remove = append(ans.Remove, &SwitchPackage{
+			Package: p,
+			Version: getPackageVersion(installed, p),
+		})
+	}
+	for _, p := range install {
+		ans.Install = append(ans.Install, &SwitchPackage{
+			Package: p,
+			Version: getPackageVersion(all, p),
+		})
+	}
+
+	t := GetFamilyKernelType(target, types)
+	if t == nil || ans.Version == "" {
+		return ans
+	}
+	if err := t.Compile(); err != nil {
+		return ans
+	}
+
+	version := ans.GetKernelVersion()
+	arch := ""
+	if t.WithArch {
+		arch = getArch()
+	}
+
+	k := &kernelspecs.KernelImage{
+		Prefix:  t.GetKernelPrefixSanitized(),
+		Type:    t.GetType(),
+		Arch:    arch,
+		Version: version,
+		Suffix:  t.GetSuffix(),
+		Pattern: t.KernelRegex,
+	}
+	i := &kernelspecs.InitrdImage{
+		Prefix:     t.GetInitrdPrefixSanitized(),
+		KernelType: t.GetType(),
+		Arch:       arch,
+		Version:    version,
+		Suffix:     t.GetSuffix(),
+		Pattern:    t.InitrdRegex,
+	}
+
+	ans.KernelType = t.GetType()
+	ans.Default = k.GenerateFilename()
+	ans.BootFiles = []string{ans.Default, i.GenerateFilename()}
+
+	return ans
+}
+
+// GetKernelVersion returns the kernel version of the package of
+// the plan. The build number of the package is not part of the
+// kernel version.
+func (p *SwitchPlan) GetKernelVersion() string {
+	return strings.SplitN(p.Version, "+", 2)[0]
+}
+
+// GetKernelFiles returns the kernel files of the new kernel of the
+// plan. The kernel image is the default kernel of the plan or the
+// only kernel image with the version of the package.
+func (p *SwitchPlan) GetKernelFiles(bootFiles *kernelspecs.BootFiles) (*kernelspecs.KernelFiles, error) {
+	if p.Default != "" {
+		for _, kf := range bootFiles.Files {
+			if kf.Kernel != nil && kf.Kernel.GetFilename() == p.Default {
+				return kf, nil
+			}
+		}
+	}
+
+	version := p.GetKernelVersion()
+	if version == "" {
+		return nil, errors.New(
+			fmt.Sprintf("Unknown version of the kernel package %s", p.Kernel))
+	}
+
+	// The kernel image name is not known without the kernel type
+	// of the family or it's different from the expected name.
+	ans := []*kernelspecs.KernelFiles{}
+	for _, kf := range bootFiles.Files {
+		if kf.Kernel == nil {
+			continue
+		}
+		if p.KernelType != "" && kf.Kernel.GetType() != p.KernelType {
+			continue
+		}
+		if kernelspecs.CompareKernelVersions(kf.Kernel.GetVersion(), version) == 0 {
+			ans = append(ans, kf)
+		}
+	}
+
+	switch len(ans) {
+	case 0:
+		return nil, errors.New(
+			fmt.Sprintf("No kernel image of %s with version %s found on %s",
+				p.Kernel, version, bootFiles.Dir))
+	case 1:
+		return ans[0], nil
+	default:
+		files := []string{}
+		for _, kf := range ans {
+			files = append(files, kf.Kernel.GetFilename())
+		}
+		return nil, errors.New(
+			fmt.Sprintf("Multiple kernel images of %s with version %s found on %s: %s",
+				p.Kernel, version, bootFiles.Dir, strings.Join(files, ", ")))
+	}
+}
+
+// SwitchStep is a step of the kernel switch.
+type SwitchStep struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// SwitchState is the state of a kernel switch stored on the state
+// file until all steps are completed. A failed switch is resumed
+// from the first step not completed.
+type SwitchState struct {
+	File  string        `json:"-"`
+	Plan  *SwitchPlan   `json:"plan"`
+	Steps []*SwitchStep `json:"steps"`
+}
+
+func NewSwitchState(file string, plan *SwitchPlan) *SwitchState {
+	ans := &SwitchState{
+		File:  file,
+		Plan:  plan,
+		Steps: []*SwitchStep{},
+	}
+
+	for _, s := range []string{
+		SwitchStepPackages,
+		SwitchStepInitrd,
+		SwitchStepLinks,
+		SwitchStepBootloader,
+	} {
+		ans.Steps = append(ans.Steps, &SwitchStep{Name: s, Status: SwitchStepPending})
+	}
+	if plan.KeepCurrent {
+		ans.Steps = append(ans.Steps, &SwitchStep{Name: SwitchStepFinalize, Status: SwitchStepPending})
+	}
+
+	return ans
+}
+
+// LoadSwitchState reads the state file. A not existing file
+// returns nil without error.
+func LoadSwitchState(file string) (*SwitchState, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	ans := &SwitchState{}
+	if err = json.Unmarshal(content, ans); err != nil {
+		return nil, errors.New(
+			fmt.Sprintf("Error on parse switch state file %s: %s", file, err.Error()))
+	}
+	if ans.Plan == nil || len(ans.Steps) == 0 {
+		return nil, errors.New(
+			fmt.Sprintf("Invalid switch state file %s", file))
+	}
+	ans.File = file
+
+	return ans, nil
+}
+
+func (s *SwitchState) Write() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(s.File), 0755)
+	if err != nil {
+		return err
+	}
+
+	tmpFile := s.File + ".tmp"
+	err = ioutil.WriteFile(tmpFile, data, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile, s.File)
+}
+
+// Remove removes the state file of a completed switch.
+func (s *SwitchState) Remove() error {
+	err := os.Remove(s.File)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// GetPendingSteps returns the steps not yet completed.
+func (s *SwitchState) GetPendingSteps() []*SwitchStep {
+	ans := []*SwitchStep{}
+	for _, step := range s.Steps {
+		if step.Status == SwitchStepPending || step.Status == SwitchStepFailed {
+			ans = append(ans, step)
+		}
+	}
+	return ans
+}
+
+// GetStep returns the step with the name in input or nil.
+func (s *SwitchState) GetStep(name string) *SwitchStep {
+	for _, step := range s.Steps {
+		if step.Name == name {
+			return step
+		}
+	}
+	return nil
+}
+
+// IsFinalizePending returns true if the switch is completed except
+// for the finalize step.
+func (s *SwitchState) IsFinalizePending() bool {
+	pending := s.GetPendingSteps()
+	return len(pending) == 1 && pending[0].Name == SwitchStepFinalize
+}
+
+// SetStepStatus updates the status of the step and writes the
+// state file.
+func (s *SwitchState) SetStepStatus(step *SwitchStep, status string, err error) error {
+	step.Status = status
+	step.Error = ""
+	if err != nil {
+		step.Error = err.Error()
+	}
+	return s.Write()
+}
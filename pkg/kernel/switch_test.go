@@ -0,0 +1,470 @@
+// Copyright © 2021 Daniele Rondina, geaaru@sabayonlinux.org
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package kernel
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	kernelspecs "github.com/MocaccinoOS/mos-cli/pkg/kernel/specs"
+	"github.com/MocaccinoOS/mos-cli/pkg/pkgmanager"
+	"github.com/MocaccinoOS/mos-cli/pkg/pkgmanager/pkgmanagertest"
+)
+
+var testFamilies = []kernelspecs.KernelFamily{
+	{
+		Name:       "mocaccino",
+		Package:    "kernel/mocaccino-full",
+		Modules:    "kernel/mocaccino-modules",
+		Initramfs:  "kernel/mocaccino-initramfs",
+		Sources:    "kernel/mocaccino-sources",
+		KernelType: "vanilla",
+	},
+	{
+		Name:      "mocaccino-lts",
+		Package:   "kernel/mocaccino-lts-full",
+		Modules:   "kernel/mocaccino-lts-modules",
+		Initramfs: "kernel/mocaccino-lts-initramfs",
+		Sources:   "kernel/mocaccino-lts-sources",
+		Firmware:  []string{"kernel/linux-firmware"},
+	},
+}
+
+// newTestPackages returns the kernel packages of the pairs
+// name, version in input.
+func newTestPackages(packages ...string) SearchResult {
+	ans := SearchResult{Packages: []pkgmanager.Package{}}
+	for i := 0; i < len(packages); i += 2 {
+		ans.Packages = append(ans.Packages, pkgmanager.Package{
+			Category: "kernel",
+			Name:     strings.TrimPrefix(packages[i], "kernel/"),
+			Version:  packages[i+1],
+		})
+	}
+	return ans
+}
+
+func TestGetSwitchPackages(t *testing.T) {
+	tests := []struct {
+		name      string
+		installed SearchResult
+		target    string
+		micro     bool
+		remove    []string
+		install   []string
+	}{
+		{
+			name:      "switch family",
+			installed: newTestPackages("kernel/mocaccino-full", "5.10.42", "kernel/mocaccino-modules", "5.10.42"),
+			target:    "mocaccino-lts",
+			remove:    []string{"kernel/mocaccino-full", "kernel/mocaccino-modules"},
+			install:   []string{"kernel/mocaccino-lts-full", "kernel/mocaccino-lts-modules", "kernel/linux-firmware"},
+		},
+		{
+			name: "switch family with sources",
+			installed: newTestPackages("kernel/mocaccino-full", "5.10.42",
+				"kernel/mocaccino-sources", "5.10.42"),
+			target: "mocaccino-lts",
+			remove: []string{"kernel/mocaccino-full", "kernel/mocaccino-sources"},
+			install: []string{"kernel/mocaccino-lts-full", "kernel/mocaccino-lts-modules",
+				"kernel/linux-firmware", "kernel/mocaccino-lts-sources"},
+		},
+		{
+			name:      "micro release",
+			installed: newTestPackages("kernel/mocaccino-lts-full", "5.4.120"),
+			target:    "mocaccino",
+			micro:     true,
+			remove:    []string{"kernel/mocaccino-lts-full"},
+			install:   []string{"kernel/mocaccino-full", "kernel/mocaccino-modules", "kernel/mocaccino-initramfs"},
+		},
+		{
+			name: "already installed",
+			installed: newTestPackages("kernel/mocaccino-full", "5.10.42",
+				"kernel/mocaccino-modules", "5.10.42", "kernel/mocaccino-sources", "5.10.42"),
+			target:  "mocaccino",
+			remove:  []string{},
+			install: []string{},
+		},
+		{
+			name:      "no kernel installed",
+			installed: newTestPackages(),
+			target:    "mocaccino",
+			remove:    []string{},
+			install:   []string{"kernel/mocaccino-full", "kernel/mocaccino-modules"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := kernelspecs.GetKernelFamily(testFamilies, tt.target)
+			remove, install := GetSwitchPackages(testFamilies, tt.installed, target, tt.micro)
+			if !reflect.DeepEqual(remove, tt.remove) {
+				t.Errorf("got remove %v, want %v", remove, tt.remove)
+			}
+			if !reflect.DeepEqual(install, tt.install) {
+				t.Errorf("got install %v, want %v", install, tt.install)
+			}
+		})
+	}
+}
+
+func TestNewSwitchPlan(t *testing.T) {
+	pm := pkgmanagertest.NewFakeManager()
+	pm.Available = newTestPackages(
+		"kernel/mocaccino-full", "5.10.42+2",
+		"kernel/mocaccino-modules", "5.10.42+2",
+		"kernel/mocaccino-lts-full", "5.4.120",
+		"kernel/mocaccino-lts-modules", "5.4.120",
+		"kernel/linux-firmware", "20210511",
+		"kernel/vanilla-full", "5.12.1",
+	).Packages
+	pm.Packages = newTestPackages(
+		"kernel/mocaccino-lts-full", "5.4.120",
+		"kernel/mocaccino-lts-modules", "5.4.120",
+	).Packages
+
+	all, err := All(pm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	installed, err := Installed(pm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	types := []kernelspecs.KernelType{
+		{
+			Name:         "Mocaccino",
+			KernelPrefix: "kernel",
+			InitrdPrefix: "initramfs",
+			Type:         "vanilla",
+			Suffix:       "mocaccino",
+			WithArch:     true,
+		},
+	}
+	arch := getArch()
+
+	tests := []struct {
+		name   string
+		target string
+		types  []kernelspecs.KernelType
+		want   *SwitchPlan
+	}{
+		{
+			name:   "with kernel type",
+			target: "mocaccino",
+			types:  types,
+			want: &SwitchPlan{
+				Family:  "mocaccino",
+				Kernel:  "kernel/mocaccino-full",
+				Version: "5.10.42+2",
+				Remove: []*SwitchPackage{
+					{Package: "kernel/mocaccino-lts-full", Version: "5.4.120"},
+					{Package: "kernel/mocaccino-lts-modules", Version: "5.4.120"},
+				},
+				Install: []*SwitchPackage{
+					{Package: "kernel/mocaccino-full", Version: "5.10.42+2"},
+					{Package: "kernel/mocaccino-modules", Version: "5.10.42+2"},
+				},
+				BootFiles: []string{
+					"kernel-vanilla-" + arch + "-5.10.42-mocaccino",
+					"initramfs-vanilla-" + arch + "-5.10.42-mocaccino",
+				},
+				Default:    "kernel-vanilla-" + arch + "-5.10.42-mocaccino",
+				KernelType: "vanilla",
+			},
+		},
+		{
+			name:   "without kernel type",
+			target: "mocaccino",
+			want: &SwitchPlan{
+				Family:  "mocaccino",
+				Kernel:  "kernel/mocaccino-full",
+				Version: "5.10.42+2",
+				Remove: []*SwitchPackage{
+					{Package: "kernel/mocaccino-lts-full", Version: "5.4.120"},
+					{Package: "kernel/mocaccino-lts-modules", Version: "5.4.120"},
+				},
+				Install: []*SwitchPackage{
+					{Package: "kernel/mocaccino-full", Version: "5.10.42+2"},
+					{Package: "kernel/mocaccino-modules", Version: "5.10.42+2"},
+				},
+			},
+		},
+		{
+			name:   "without family",
+			target: "kernel/vanilla-full",
+			types:  types,
+			want: &SwitchPlan{
+				Family:  "kernel/vanilla-full",
+				Kernel:  "kernel/vanilla-full",
+				Version: "5.12.1",
+				Remove: []*SwitchPackage{
+					{Package: "kernel/mocaccino-lts-full", Version: "5.4.120"},
+					{Package: "kernel/mocaccino-lts-modules", Version: "5.4.120"},
+				},
+				Install: []*SwitchPackage{
+					{Package: "kernel/vanilla-full", Version: "5.12.1"},
+				},
+			},
+		},
+		{
+			name:   "installed family",
+			target: "mocaccino-lts",
+			types:  types,
+			want: &SwitchPlan{
+				Family:  "mocaccino-lts",
+				Kernel:  "kernel/mocaccino-lts-full",
+				Version: "5.4.120",
+				Remove:  []*SwitchPackage{},
+				Install: []*SwitchPackage{
+					{Package: "kernel/linux-firmware", Version: "20210511"},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := kernelspecs.GetKernelFamily(testFamilies, tt.target)
+			if target == nil {
+				target = kernelspecs.NewPackageKernelFamily(tt.target)
+			}
+			plan := NewSwitchPlan(testFamilies, all, installed, target, tt.types, false)
+			if !reflect.DeepEqual(plan, tt.want) {
+				t.Errorf("got plan %+v, want %+v", plan, tt.want)
+			}
+		})
+	}
+}
+
+func TestSwitchPlanGetKernelFiles(t *testing.T) {
+	bootFiles := kernelspecs.NewBootFiles("/boot")
+	for _, k := range []*kernelspecs.KernelImage{
+		newTestKernelImage("vanilla", "5.10.42", "mocaccino"),
+		newTestKernelImage("vanilla", "5.10.50", "mocaccino"),
+		newTestKernelImage("genkernel", "5.10.50", "sabayon"),
+		newTestKernelImage("vanilla", "5.12.1", "mocaccino"),
+	} {
+		if err := bootFiles.AddKernelImage(k, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		plan    *SwitchPlan
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "default kernel",
+			plan: &SwitchPlan{Version: "5.10.42", Default: "kernel-vanilla-x86_64-5.10.42-mocaccino"},
+			want: "kernel-vanilla-x86_64-5.10.42-mocaccino",
+		},
+		{
+			name: "package version",
+			plan: &SwitchPlan{Version: "5.10.42+3", Default: "vmlinuz-5.10.42"},
+			want: "kernel-vanilla-x86_64-5.10.42-mocaccino",
+		},
+		{
+			name: "package version and kernel type",
+			plan: &SwitchPlan{Version: "5.10.50", KernelType: "genkernel"},
+			want: "kernel-genkernel-x86_64-5.10.50-sabayon",
+		},
+		{
+			name:    "multiple kernels with the version",
+			plan:    &SwitchPlan{Version: "5.10.50"},
+			wantErr: true,
+		},
+		{
+			name:    "version not found",
+			plan:    &SwitchPlan{Version: "5.13.0"},
+			wantErr: true,
+		},
+		{
+			name:    "without version",
+			plan:    &SwitchPlan{KernelType: "vanilla"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.plan.Kernel = "kernel/test-full"
+			kf, err := tt.plan.GetKernelFiles(bootFiles)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %s", kf.Kernel.GetFilename())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if kf.Kernel.GetFilename() != tt.want {
+				t.Errorf("got %s, want %s", kf.Kernel.GetFilename(), tt.want)
+			}
+		})
+	}
+}
+
+func stepNames(steps []*SwitchStep) []string {
+	ans := []string{}
+	for _, s := range steps {
+		ans = append(ans, s.Name)
+	}
+	return ans
+}
+
+func TestSwitchState(t *testing.T) {
+	allSteps := []string{SwitchStepPackages, SwitchStepInitrd, SwitchStepLinks, SwitchStepBootloader}
+
+	tests := []struct {
+		name            string
+		keepCurrent     bool
+		status          map[string]string
+		pending         []string
+		finalizePending bool
+	}{
+		{
+			name:    "new switch",
+			pending: allSteps,
+		},
+		{
+			name:        "new switch keeping the current kernel",
+			keepCurrent: true,
+			pending:     append(append([]string{}, allSteps...), SwitchStepFinalize),
+		},
+		{
+			name: "failed step",
+			status: map[string]string{
+				SwitchStepPackages: SwitchStepDone,
+				SwitchStepInitrd:   SwitchStepFailed,
+			},
+			pending: []string{SwitchStepInitrd, SwitchStepLinks, SwitchStepBootloader},
+		},
+		{
+			name:        "finalize pending",
+			keepCurrent: true,
+			status: map[string]string{
+				SwitchStepPackages:   SwitchStepDone,
+				SwitchStepInitrd:     SwitchStepSkipped,
+				SwitchStepLinks:      SwitchStepDone,
+				SwitchStepBootloader: SwitchStepDone,
+			},
+			pending:         []string{SwitchStepFinalize},
+			finalizePending: true,
+		},
+		{
+			name:        "finalize pending with failed step",
+			keepCurrent: true,
+			status: map[string]string{
+				SwitchStepPackages:   SwitchStepDone,
+				SwitchStepInitrd:     SwitchStepDone,
+				SwitchStepLinks:      SwitchStepDone,
+				SwitchStepBootloader: SwitchStepFailed,
+			},
+			pending: []string{SwitchStepBootloader, SwitchStepFinalize},
+		},
+		{
+			name: "completed",
+			status: map[string]string{
+				SwitchStepPackages:   SwitchStepDone,
+				SwitchStepInitrd:     SwitchStepDone,
+				SwitchStepLinks:      SwitchStepDone,
+				SwitchStepBootloader: SwitchStepDone,
+			},
+			pending: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewSwitchState("", &SwitchPlan{KeepCurrent: tt.keepCurrent})
+			for name, status := range tt.status {
+				s.GetStep(name).Status = status
+			}
+
+			if got := stepNames(s.GetPendingSteps()); !reflect.DeepEqual(got, tt.pending) {
+				t.Errorf("got pending steps %v, want %v", got, tt.pending)
+			}
+			if got := s.IsFinalizePending(); got != tt.finalizePending {
+				t.Errorf("got finalize pending %v, want %v", got, tt.finalizePending)
+			}
+		})
+	}
+}
+
+func TestSwitchStateFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mos-switch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "lib", "kernel-switch.json")
+
+	s, err := LoadSwitchState(file)
+	if err != nil || s != nil {
+		t.Fatalf("got state %v and error %v for a missing file", s, err)
+	}
+
+	s = NewSwitchState(file, &SwitchPlan{
+		Family:      "mocaccino",
+		Kernel:      "kernel/mocaccino-full",
+		Version:     "5.10.42",
+		Remove:      []*SwitchPackage{},
+		Install:     []*SwitchPackage{{Package: "kernel/mocaccino-full", Version: "5.10.42"}},
+		KeepCurrent: true,
+	})
+	if err := s.SetStepStatus(s.GetStep(SwitchStepPackages), SwitchStepFailed,
+		errors.New("luet error")); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadSwitchState(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, s) {
+		t.Errorf("got state %+v, want %+v", loaded, s)
+	}
+	if step := loaded.GetStep(SwitchStepPackages); step.Error != "luet error" {
+		t.Errorf("got step error %q", step.Error)
+	}
+
+	if err := s.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Remove(); err != nil {
+		t.Errorf("error on remove a missing state file: %s", err)
+	}
+
+	for _, content := range []string{"{", `{"steps": []}`} {
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadSwitchState(file); err == nil {
+			t.Errorf("expected error for %s", content)
+		}
+	}
+}
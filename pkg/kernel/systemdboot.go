@@ -0,0 +1,362 @@
+// Copyright © 2021 Daniele Rondina, geaaru@sabayonlinux.org
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package kernel
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	kernelspecs "github.com/MocaccinoOS/mos-cli/pkg/kernel/specs"
+	. "github.com/MocaccinoOS/mos-cli/pkg/logger"
+	"github.com/MocaccinoOS/mos-cli/pkg/utils"
+)
+
+const (
+	BootloaderGrub        = "grub"
+	BootloaderSystemdBoot = "systemd-boot"
+
+	DefaultEspDir = "/boot/efi"
+
+	// Prefix of the BLS entries managed by mos.
+	BlsEntryPrefix = "mos-"
+	// Directory of the ESP where the kernels are copied when
+	// the boot dir is not the ESP.
+	EspKernelsDir = "mos"
+)
+
+type SystemdBootOptions struct {
+	EspDir    string
+	Title     string
+	MachineId string
+	Cmdline   string
+	// Timeout of loader.conf. A negative value doesn't change the timeout.
+	Timeout int
+	DryRun  bool
+}
+
+// BlsEntry is a Boot Loader Specification Type #1 entry.
+type BlsEntry struct {
+	File      string `json:"file"`
+	Title     string `json:"title"`
+	Version   string `json:"version"`
+	MachineId string `json:"machine_id,omitempty"`
+	Linux     string `json:"linux"`
+	Initrd    string `json:"initrd,omitempty"`
+	Options   string `json:"options,omitempty"`
+
+	// Directory of the ESP with the copy of the kernel files.
+	espDir string
+	// Files to copy on the ESP
+	copies []espCopy
+}
+
+type espCopy struct {
+	src string
+	dst string
+}
+
+func (e *BlsEntry) String() string {
+	ans := fmt.Sprintf("title %s\nversion %s\n", e.Title, e.Version)
+	if e.MachineId != "" {
+		ans += fmt.Sprintf("machine-id %s\n", e.MachineId)
+	}
+	ans += fmt.Sprintf("linux %s\n", e.Linux)
+	if e.Initrd != "" {
+		ans += fmt.Sprintf("initrd %s\n", e.Initrd)
+	}
+	if e.Options != "" {
+		ans += fmt.Sprintf("options %s\n", e.Options)
+	}
+	return ans
+}
+
+// NewSystemdBootOptions returns the options with the values of the
+// system: os-release name, machine-id and kernel command line.
+func NewSystemdBootOptions(espDir string) *SystemdBootOptions {
+	ans := &SystemdBootOptions{
+		EspDir:  espDir,
+		Title:   utils.OsPrettyName(),
+		Timeout: -1,
+	}
+
+	if ans.EspDir == "" {
+		ans.EspDir = utils.RootPath(DefaultEspDir)
+	}
+
+	if ans.Title == "" {
+		ans.Title = "MocaccinoOS"
+	}
+
+	if content, err := ioutil.ReadFile(utils.RootPath("/etc/machine-id")); err == nil {
+		ans.MachineId = strings.TrimSpace(string(content))
+	}
+
+	if cmdline, err := ReadKernelCmdline(""); err == nil {
+		ans.Cmdline = cmdline
+	}
+
+	return ans
+}
+
+func isSameDir(a, b string) bool {
+	ia, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	ib, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(ia, ib)
+}
+
+// getBlsEntryName returns the name of the BLS entry and of the ESP
+// directory of the kernel. The kernel filename is used because the
+// release could be shared by kernels with different arch or type.
+func getBlsEntryName(kf *kernelspecs.KernelFiles) string {
+	return kf.Kernel.GetFilename()
+}
+
+// GetBlsEntries returns the entries of the kernels of the boot dir.
+func GetBlsEntries(bootFiles *kernelspecs.BootFiles, opts *SystemdBootOptions) []*BlsEntry {
+	ans := []*BlsEntry{}
+	bootIsEsp := isSameDir(bootFiles.Dir, opts.EspDir)
+
+	for _, kf := range bootFiles.Files {
+		if kf.Kernel == nil {
+			continue
+		}
+
+		name := getBlsEntryName(kf)
+		release := kf.Kernel.GetKernelRelease()
+		e := &BlsEntry{
+			File:      BlsEntryPrefix + name + ".conf",
+			Title:     opts.Title,
+			Version:   release,
+			MachineId: opts.MachineId,
+			Options:   GetKernelFilesCmdline(opts.Cmdline, kf),
+		}
+
+		files := []string{kf.Kernel.GetFilename()}
+		if kf.Initrd != nil {
+			files = append(files, kf.Initrd.GetFilename())
+		}
+
+		for idx, f := range files {
+			path := "/" + f
+			if !bootIsEsp {
+				e.espDir = name
+				path = fmt.Sprintf("/%s/%s/%s", EspKernelsDir, name, f)
+				e.copies = append(e.copies, espCopy{
+					src: filepath.Join(bootFiles.Dir, f),
+					dst: filepath.Join(opts.EspDir, path),
+				})
+			}
+			if idx == 0 {
+				e.Linux = path
+			} else {
+				e.Initrd = path
+			}
+		}
+
+		ans = append(ans, e)
+	}
+
+	return ans
+}
+
+// copyIfChanged copies the file on the ESP if the destination
+// doesn't exist or it's older than the source.
+func copyIfChanged(src, dst string, dryRun bool) error {
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+
+	if dstInfo, err := os.Stat(dst); err == nil &&
+		dstInfo.Size() == srcInfo.Size() && !dstInfo.ModTime().Before(srcInfo.ModTime()) {
+		return nil
+	}
+
+	if dryRun {
+		fmt.Println(fmt.Sprintf("[dry-run mode] copy %s to %s", src, dst))
+		return nil
+	}
+
+	DebugC("Copying", src, "to", dst)
+	err = os.MkdirAll(filepath.Dir(dst), 0755)
+	if err != nil {
+		return err
+	}
+
+	tmpFile := dst + ".tmp"
+	err = utils.CopyFile(src, tmpFile)
+	if err != nil {
+		os.Remove(tmpFile)
+		return err
+	}
+
+	return os.Rename(tmpFile, dst)
+}
+
+func writeFile(file, content string, dryRun bool) error {
+	if dryRun {
+		fmt.Println(fmt.Sprintf("[dry-run mode] write %s:\n%s", file, content))
+		return nil
+	}
+
+	DebugC("Writing", file)
+	tmpFile := file + ".tmp"
+	err := ioutil.WriteFile(tmpFile, []byte(content), 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile, file)
+}
+
+func removeAll(path string, dryRun bool) error {
+	if dryRun {
+		fmt.Println("[dry-run mode] removing " + path)
+		return nil
+	}
+
+	DebugC("Removing", path)
+	return os.RemoveAll(path)
+}
+
+// UpdateLoaderConf sets the default entry and the timeout of
+// loader.conf. The other options are preserved.
+func UpdateLoaderConf(file, defaultEntry string, timeout int, dryRun bool) error {
+	lines := []string{}
+
+	content, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	values := map[string]string{}
+	if defaultEntry != "" {
+		values["default"] = defaultEntry
+	}
+	if timeout >= 0 {
+		values["timeout"] = fmt.Sprintf("%d", timeout)
+	}
+
+	for _, line := range strings.Split(strings.TrimRight(string(content), "\n"), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 0 {
+			if v, ok := values[fields[0]]; ok {
+				line = fields[0] + " " + v
+				delete(values, fields[0])
+			}
+		}
+		if line != "" || len(lines) > 0 {
+			lines = append(lines, line)
+		}
+	}
+
+	for _, k := range []string{"default", "timeout"} {
+		if v, ok := values[k]; ok {
+			lines = append(lines, k+" "+v)
+		}
+	}
+
+	return writeFile(file, strings.Join(lines, "\n")+"\n", dryRun)
+}
+
+// UpdateSystemdBoot writes the BLS entries of the kernels of the boot
+// dir, copies the kernels on the ESP when needed, removes the stale
+// entries and updates loader.conf.
+func UpdateSystemdBoot(bootFiles *kernelspecs.BootFiles, opts *SystemdBootOptions) error {
+	if _, err := os.Stat(opts.EspDir); err != nil {
+		return errors.New(
+			fmt.Sprintf("ESP directory %s not available: %s", opts.EspDir, err.Error()))
+	}
+
+	entriesDir := filepath.Join(opts.EspDir, "loader", "entries")
+	if !opts.DryRun {
+		err := os.MkdirAll(entriesDir, 0755)
+		if err != nil {
+			return err
+		}
+	}
+
+	entries := GetBlsEntries(bootFiles, opts)
+	entriesFiles := make(map[string]bool)
+	espDirs := make(map[string]bool)
+
+	for _, e := range entries {
+		for _, c := range e.copies {
+			err := copyIfChanged(c.src, c.dst, opts.DryRun)
+			if err != nil {
+				return errors.New(
+					fmt.Sprintf("Error on copy %s on ESP: %s", c.src, err.Error()))
+			}
+		}
+
+		err := writeFile(filepath.Join(entriesDir, e.File), e.String(), opts.DryRun)
+		if err != nil {
+			return err
+		}
+
+		entriesFiles[e.File] = true
+		espDirs[e.espDir] = true
+	}
+
+	// Remove the stale entries and kernels
+	files, _ := ioutil.ReadDir(entriesDir)
+	for _, f := range files {
+		if strings.HasPrefix(f.Name(), BlsEntryPrefix) &&
+			strings.HasSuffix(f.Name(), ".conf") && !entriesFiles[f.Name()] {
+			err := removeAll(filepath.Join(entriesDir, f.Name()), opts.DryRun)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if !isSameDir(bootFiles.Dir, opts.EspDir) {
+		kernelsDir := filepath.Join(opts.EspDir, EspKernelsDir)
+		files, _ = ioutil.ReadDir(kernelsDir)
+		for _, f := range files {
+			if f.IsDir() && !espDirs[f.Name()] {
+				err := removeAll(filepath.Join(kernelsDir, f.Name()), opts.DryRun)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	// The default entry is the kernel of the bzImage link
+	// or the newest kernel.
+	kf := bootFiles.GetBzImageKernel()
+	if kf == nil {
+		kf = bootFiles.GetLatestKernel("", "")
+	}
+	defaultEntry := ""
+	if kf != nil {
+		defaultEntry = BlsEntryPrefix + getBlsEntryName(kf) + ".conf"
+	}
+
+	return UpdateLoaderConf(filepath.Join(opts.EspDir, "loader", "loader.conf"),
+		defaultEntry, opts.Timeout, opts.DryRun)
+}
@@ -0,0 +1,215 @@
+// Copyright © 2021 Daniele Rondina, geaaru@sabayonlinux.org
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package kernel
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// listDir returns the files of the directory with the trailing
+// slash for the directories.
+func listDir(t *testing.T, dir string) []string {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+
+	ans := []string{}
+	for _, f := range files {
+		if f.IsDir() {
+			ans = append(ans, f.Name()+"/")
+		} else {
+			ans = append(ans, f.Name())
+		}
+	}
+	sort.Strings(ans)
+
+	return ans
+}
+
+func readTestFile(t *testing.T, file string) string {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestSystemdBootSameRelease(t *testing.T) {
+	bootFiles := newTestBootDir(t, t.TempDir(),
+		"kernel-vanilla-aarch64-5.10.42-mocaccino",
+		"kernel-vanilla-x86_64-5.10.42-mocaccino",
+		"initramfs-vanilla-x86_64-5.10.42-mocaccino",
+	)
+	esp := t.TempDir()
+	opts := &SystemdBootOptions{EspDir: esp, Title: "MocaccinoOS", Timeout: -1}
+
+	entries := GetBlsEntries(bootFiles, opts)
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(entries))
+	}
+	if entries[0].Version != entries[1].Version {
+		t.Errorf("got versions %s and %s, want the same release",
+			entries[0].Version, entries[1].Version)
+	}
+
+	if err := UpdateSystemdBoot(bootFiles, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	wantEntries := []string{
+		"mos-kernel-vanilla-aarch64-5.10.42-mocaccino.conf",
+		"mos-kernel-vanilla-x86_64-5.10.42-mocaccino.conf",
+	}
+	if got := listDir(t, filepath.Join(esp, "loader", "entries")); !reflect.DeepEqual(got, wantEntries) {
+		t.Errorf("got entries %v, want %v", got, wantEntries)
+	}
+
+	// Every kernel is copied on its directory of the ESP.
+	for _, f := range []string{
+		"kernel-vanilla-aarch64-5.10.42-mocaccino/kernel-vanilla-aarch64-5.10.42-mocaccino",
+		"kernel-vanilla-x86_64-5.10.42-mocaccino/kernel-vanilla-x86_64-5.10.42-mocaccino",
+		"kernel-vanilla-x86_64-5.10.42-mocaccino/initramfs-vanilla-x86_64-5.10.42-mocaccino",
+	} {
+		if got := readTestFile(t, filepath.Join(esp, EspKernelsDir, f)); got != filepath.Base(f) {
+			t.Errorf("got %s with content %s", f, got)
+		}
+	}
+
+	entry := readTestFile(t, filepath.Join(esp, "loader", "entries", wantEntries[1]))
+	want := "title MocaccinoOS\nversion 5.10.42-mocaccino\n" +
+		"linux /mos/kernel-vanilla-x86_64-5.10.42-mocaccino/kernel-vanilla-x86_64-5.10.42-mocaccino\n" +
+		"initrd /mos/kernel-vanilla-x86_64-5.10.42-mocaccino/initramfs-vanilla-x86_64-5.10.42-mocaccino\n"
+	if entry != want {
+		t.Errorf("got entry:\n%s\nwant:\n%s", entry, want)
+	}
+}
+
+func TestUpdateSystemdBoot(t *testing.T) {
+	kernels := []string{
+		"kernel-vanilla-x86_64-5.10.42-mocaccino",
+		"kernel-vanilla-x86_64-5.15.0-mocaccino",
+	}
+	stale := []string{
+		"loader/entries/mos-5.4.0-mocaccino.conf",
+		"loader/entries/mos-kernel-vanilla-x86_64-5.4.0-mocaccino.conf",
+		"loader/entries/arch.conf",
+		"loader/loader.conf",
+		"mos/5.4.0-mocaccino/",
+		"mos/kernel-vanilla-x86_64-5.4.0-mocaccino/",
+		"mos/kernel-vanilla-x86_64-5.10.42-mocaccino/",
+		"EFI/",
+	}
+
+	tests := []struct {
+		name      string
+		espIsBoot bool
+		bzImage   string
+		entries   []string
+		espDirs   []string
+		loader    string
+	}{
+		{
+			name: "separated esp",
+			entries: []string{
+				"arch.conf",
+				"mos-kernel-vanilla-x86_64-5.10.42-mocaccino.conf",
+				"mos-kernel-vanilla-x86_64-5.15.0-mocaccino.conf",
+			},
+			espDirs: []string{
+				"kernel-vanilla-x86_64-5.10.42-mocaccino/",
+				"kernel-vanilla-x86_64-5.15.0-mocaccino/",
+			},
+			loader: "timeout 5\nconsole-mode max\ndefault mos-kernel-vanilla-x86_64-5.15.0-mocaccino.conf\n",
+		},
+		{
+			name:    "separated esp with bzImage",
+			bzImage: "kernel-vanilla-x86_64-5.10.42-mocaccino",
+			entries: []string{
+				"arch.conf",
+				"mos-kernel-vanilla-x86_64-5.10.42-mocaccino.conf",
+				"mos-kernel-vanilla-x86_64-5.15.0-mocaccino.conf",
+			},
+			espDirs: []string{
+				"kernel-vanilla-x86_64-5.10.42-mocaccino/",
+				"kernel-vanilla-x86_64-5.15.0-mocaccino/",
+			},
+			loader: "timeout 5\nconsole-mode max\ndefault mos-kernel-vanilla-x86_64-5.10.42-mocaccino.conf\n",
+		},
+		{
+			// The kernels aren't copied and the directories of the
+			// ESP aren't managed by mos.
+			name:      "boot dir on esp",
+			espIsBoot: true,
+			entries: []string{
+				"arch.conf",
+				"mos-kernel-vanilla-x86_64-5.10.42-mocaccino.conf",
+				"mos-kernel-vanilla-x86_64-5.15.0-mocaccino.conf",
+			},
+			espDirs: []string{
+				"5.4.0-mocaccino/",
+				"kernel-vanilla-x86_64-5.10.42-mocaccino/",
+				"kernel-vanilla-x86_64-5.4.0-mocaccino/",
+			},
+			loader: "timeout 5\nconsole-mode max\ndefault mos-kernel-vanilla-x86_64-5.15.0-mocaccino.conf\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			esp := t.TempDir()
+			bootDir := t.TempDir()
+			if tt.espIsBoot {
+				bootDir = esp
+			}
+
+			newTestBootDir(t, esp, stale...)
+			if err := ioutil.WriteFile(filepath.Join(esp, "loader", "loader.conf"),
+				[]byte("timeout 5\nconsole-mode max\ndefault mos-5.4.0-mocaccino.conf\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if tt.bzImage != "" {
+				if err := os.Symlink(tt.bzImage, filepath.Join(bootDir, BzImageLink)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			bootFiles := newTestBootDir(t, bootDir, kernels...)
+
+			opts := &SystemdBootOptions{EspDir: esp, Title: "MocaccinoOS", Timeout: -1}
+			if err := UpdateSystemdBoot(bootFiles, opts); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := listDir(t, filepath.Join(esp, "loader", "entries")); !reflect.DeepEqual(got, tt.entries) {
+				t.Errorf("got entries %v, want %v", got, tt.entries)
+			}
+			if got := listDir(t, filepath.Join(esp, EspKernelsDir)); !reflect.DeepEqual(got, tt.espDirs) {
+				t.Errorf("got ESP directories %v, want %v", got, tt.espDirs)
+			}
+			if got := readTestFile(t, filepath.Join(esp, "loader", "loader.conf")); got != tt.loader {
+				t.Errorf("got loader.conf:\n%s\nwant:\n%s", got, tt.loader)
+			}
+			if _, err := os.Stat(filepath.Join(esp, "EFI")); err != nil {
+				t.Errorf("directory EFI removed: %s", err)
+			}
+		})
+	}
+}
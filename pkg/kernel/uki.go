@@ -0,0 +1,126 @@
+// Copyright © 2021 Daniele Rondina, geaaru@sabayonlinux.org
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package kernel
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	kernelspecs "github.com/MocaccinoOS/mos-cli/pkg/kernel/specs"
+	"github.com/MocaccinoOS/mos-cli/pkg/uki"
+	"github.com/MocaccinoOS/mos-cli/pkg/utils"
+)
+
+type UkiOptions struct {
+	// Directory where the UKIs are written. Default is <esp>/EFI/Linux.
+	Dir       string
+	Stub      string
+	Cmdline   string
+	OsRelease string
+	Splash    string
+	DryRun    bool
+}
+
+// GetUkiDir returns the directory of the ESP where systemd-boot
+// searches the Unified Kernel Images.
+func GetUkiDir(espDir string) string {
+	if espDir == "" {
+		espDir = utils.RootPath(DefaultEspDir)
+	}
+	return filepath.Join(espDir, "EFI", "Linux")
+}
+
+// NewUkiOptions returns the options with the default stub and
+// the kernel command line of the system.
+func NewUkiOptions(espDir string) *UkiOptions {
+	ans := &UkiOptions{
+		Dir:       GetUkiDir(espDir),
+		Stub:      uki.GetDefaultStub(),
+		OsRelease: utils.RootPath(uki.DefaultOsReleaseFile),
+	}
+
+	if cmdline, err := ReadKernelCmdline(""); err == nil {
+		ans.Cmdline = cmdline
+	}
+
+	return ans
+}
+
+// LoadUkiImages sets the UKIs of the directory in input to the
+// kernel files of the same kernel images.
+func LoadUkiImages(b *kernelspecs.BootFiles, ukiDir string) error {
+	files, err := ioutil.ReadDir(ukiDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), kernelspecs.UkiSuffix) {
+			continue
+		}
+		b.AddUkiImage(kernelspecs.NewUkiImage(ukiDir, f.Name()))
+	}
+
+	return nil
+}
+
+// BuildUki builds the Unified Kernel Image of the kernel files
+// with the kernel, the initrd image and the command line.
+func BuildUki(kf *kernelspecs.KernelFiles, bootDir string, opts *UkiOptions) (*kernelspecs.UkiImage, error) {
+	if kf.Kernel == nil {
+		return nil, errors.New("Kernel image not available")
+	}
+
+	b := uki.NewBuilder(filepath.Join(bootDir, kf.Kernel.GetFilename()), "")
+	if kf.Initrd != nil {
+		b.Initrd = filepath.Join(bootDir, kf.Initrd.GetFilename())
+	}
+	if opts.Stub != "" {
+		b.Stub = opts.Stub
+	}
+	b.OsRelease = opts.OsRelease
+	b.Cmdline = GetKernelFilesCmdline(opts.Cmdline, kf)
+	b.Splash = opts.Splash
+	b.Uname = kf.Kernel.GetBinaryRelease()
+	if b.Uname == "" {
+		b.Uname = kf.Kernel.GetKernelRelease()
+	}
+
+	ans := kernelspecs.NewUkiImage(opts.Dir, kf.Kernel.GetUkiFilename())
+
+	if opts.DryRun {
+		fmt.Println(fmt.Sprintf(
+			"[dry-run mode] build %s with stub %s, kernel %s, initrd %s and cmdline: %s",
+			ans.GetPath(), b.Stub, b.Kernel, b.Initrd, b.Cmdline))
+		return ans, nil
+	}
+
+	err := b.Build(ans.GetPath())
+	if err != nil {
+		return nil, err
+	}
+
+	kf.Uki = ans
+
+	return ans, nil
+}
@@ -0,0 +1,141 @@
+// Copyright © 2021 Daniele Rondina, geaaru@sabayonlinux.org
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package kernel
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/MocaccinoOS/mos-cli/pkg/initrd"
+	kernelspecs "github.com/MocaccinoOS/mos-cli/pkg/kernel/specs"
+	"github.com/MocaccinoOS/mos-cli/pkg/utils"
+)
+
+// Number of modules with a wrong vermagic showed on the error
+// of the initrd image.
+const VerifyMaxModulesReported = 5
+
+type VerifyResult struct {
+	Kernel   string   `json:"kernel,omitempty"`
+	Initrd   string   `json:"initrd,omitempty"`
+	Release  string   `json:"release,omitempty"`
+	Errors   []string `json:"errors"`
+	Warnings []string `json:"warnings"`
+}
+
+func (r *VerifyResult) addError(format string, a ...interface{}) {
+	r.Errors = append(r.Errors, fmt.Sprintf(format, a...))
+}
+
+func (r *VerifyResult) addWarning(format string, a ...interface{}) {
+	r.Warnings = append(r.Warnings, fmt.Sprintf(format, a...))
+}
+
+func (r *VerifyResult) IsValid() bool { return len(r.Errors) == 0 }
+
+// VerifyKernelFiles checks that the kernel image, the initrd image
+// and the modules tree of the kernel are consistent.
+func VerifyKernelFiles(kf *kernelspecs.KernelFiles, bootDir, modulesDir string) *VerifyResult {
+	ans := &VerifyResult{
+		Errors:   []string{},
+		Warnings: []string{},
+	}
+
+	if modulesDir == "" {
+		modulesDir = utils.RootPath(initrd.DefaultModulesDir)
+	}
+
+	if kf.Initrd != nil {
+		ans.Initrd = kf.Initrd.GetFilename()
+	}
+
+	if kf.Kernel == nil {
+		ans.addError("Initrd image without kernel image")
+		return ans
+	}
+
+	ans.Kernel = kf.Kernel.GetFilename()
+	ans.Release = kf.Kernel.GetKernelRelease()
+
+	// Check the modules tree
+	kmodDir := filepath.Join(modulesDir, ans.Release)
+	if info, err := os.Stat(kmodDir); err != nil || !info.IsDir() {
+		ans.addError("Modules directory %s not found", kmodDir)
+	}
+
+	// Check the version embedded on kernel image
+	version, err := kernelspecs.ReadKernelImageVersion(
+		filepath.Join(bootDir, kf.Kernel.GetFilename()))
+	if err != nil {
+		ans.addWarning("Unable to read the version of the kernel image: %s", err.Error())
+	} else {
+		release := kernelspecs.GetKernelReleaseFromVersion(version)
+		if release != ans.Release {
+			ans.addError("Kernel image version %s doesn't match the version %s of the filename",
+				release, ans.Release)
+		}
+	}
+
+	if kf.Initrd == nil {
+		ans.addWarning("No initrd image found")
+		return ans
+	}
+
+	// Check the modules of the initrd image.
+	vermagics, err := initrd.ReadModulesVermagic(filepath.Join(bootDir, kf.Initrd.GetFilename()))
+	if err != nil {
+		ans.addError("Error on read initrd image: %s", err.Error())
+		return ans
+	}
+
+	modules := []string{}
+	for m := range vermagics {
+		modules = append(modules, m)
+	}
+	sort.Strings(modules)
+
+	// A rebuilt kernel could have all modules with a different
+	// vermagic. Only one error with the first modules is reported.
+	mismatched := []string{}
+	for _, m := range modules {
+		release := kernelspecs.GetKernelReleaseFromVersion(vermagics[m])
+		if release != ans.Release {
+			mismatched = append(mismatched, fmt.Sprintf("%s (%s)", m, release))
+		}
+	}
+	if len(mismatched) > 0 {
+		ans.addError("%d of %d modules of the initrd image have a vermagic different from %s: %s",
+			len(mismatched), len(modules), ans.Release, summarizeList(mismatched, VerifyMaxModulesReported))
+	}
+
+	if len(modules) == 0 {
+		ans.addWarning("No kernel modules found on initrd image")
+	}
+
+	return ans
+}
+
+// summarizeList returns the first max elements of the list and the
+// number of the elements not showed.
+func summarizeList(l []string, max int) string {
+	if len(l) <= max {
+		return strings.Join(l, ", ")
+	}
+	return fmt.Sprintf("%s and %d more", strings.Join(l[:max], ", "), len(l)-max)
+}
@@ -0,0 +1,39 @@
+// Copyright © 2021 Daniele Rondina, geaaru@sabayonlinux.org
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package kernel
+
+import (
+	"testing"
+)
+
+func TestSummarizeList(t *testing.T) {
+	tests := []struct {
+		list     []string
+		max      int
+		expected string
+	}{
+		{[]string{}, 5, ""},
+		{[]string{"a.ko", "b.ko"}, 5, "a.ko, b.ko"},
+		{[]string{"a.ko", "b.ko", "c.ko"}, 3, "a.ko, b.ko, c.ko"},
+		{[]string{"a.ko", "b.ko", "c.ko", "d.ko"}, 2, "a.ko, b.ko and 2 more"},
+	}
+
+	for _, tt := range tests {
+		if got := summarizeList(tt.list, tt.max); got != tt.expected {
+			t.Errorf("summarizeList(%v, %d): expected %q, got %q", tt.list, tt.max, tt.expected, got)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+// Copyright © 2021 Daniele Rondina, geaaru@sabayonlinux.org
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package pe
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	peSignature         = "PE\x00\x00"
+	peSectionHeaderSize = 40
+	peMagic32           = 0x10b
+	peMagic64           = 0x20b
+	// Index of the certificate table on the data directories.
+	SecurityDirectory = 4
+
+	scnCntInitializedData = 0x00000040
+	scnMemRead            = 0x40000000
+)
+
+// Section is a section to add to a PE image.
+type Section struct {
+	Name string
+	Data []byte
+}
+
+// SectionHeader contains the fields of a section header used to
+// read the section data.
+type SectionHeader struct {
+	Name             string
+	VirtualSize      uint32
+	VirtualAddress   uint32
+	SizeOfRawData    uint32
+	PointerToRawData uint32
+}
+
+// Image contains the offsets of the PE headers used to
+// add the sections and to compute the image hash.
+type Image struct {
+	Data []byte
+
+	optOffset      uint32
+	dataDirOffset  uint32
+	dataDirNum     uint32
+	sectionsOffset uint32
+	sectionsNum    uint16
+}
+
+var le = binary.LittleEndian
+
+func align(v, a uint32) uint32 {
+	if a == 0 {
+		return v
+	}
+	return (v + a - 1) / a * a
+}
+
+// ParseImage parses the headers of the PE image in input.
+func ParseImage(data []byte) (*Image, error) {
+	if len(data) < 0x40 || data[0] != 'M' || data[1] != 'Z' {
+		return nil, errors.New("Invalid PE image: MZ header not found")
+	}
+
+	peOffset := le.Uint32(data[0x3c:])
+	if uint64(peOffset)+24 > uint64(len(data)) ||
+		string(data[peOffset:peOffset+4]) != peSignature {
+		return nil, errors.New("Invalid PE image: PE signature not found")
+	}
+
+	coffOffset := peOffset + 4
+	ans := &Image{
+		Data:        data,
+		optOffset:   coffOffset + 20,
+		sectionsNum: le.Uint16(data[coffOffset+2:]),
+	}
+	ans.sectionsOffset = ans.optOffset + uint32(le.Uint16(data[coffOffset+16:]))
+
+	if uint64(ans.sectionsOffset)+uint64(ans.sectionsNum)*peSectionHeaderSize > uint64(len(data)) {
+		return nil, errors.New("Invalid PE image: truncated section table")
+	}
+
+	switch le.Uint16(data[ans.optOffset:]) {
+	case peMagic32:
+		ans.dataDirOffset = ans.optOffset + 96
+	case peMagic64:
+		ans.dataDirOffset = ans.optOffset + 112
+	default:
+		return nil, errors.New("Invalid PE image: unsupported optional header")
+	}
+	ans.dataDirNum = le.Uint32(data[ans.dataDirOffset-4:])
+
+	return ans, nil
+}
+
+func (p *Image) sectionHeader(idx int) []byte {
+	off := p.sectionsOffset + uint32(idx)*peSectionHeaderSize
+	return p.Data[off : off+peSectionHeaderSize]
+}
+
+func (p *Image) SectionAlignment() uint32 { return le.Uint32(p.Data[p.optOffset+32:]) }
+func (p *Image) FileAlignment() uint32    { return le.Uint32(p.Data[p.optOffset+36:]) }
+func (p *Image) SizeOfHeaders() uint32    { return le.Uint32(p.Data[p.optOffset+60:]) }
+
+// ChecksumOffset returns the offset of the CheckSum field.
+func (p *Image) ChecksumOffset() uint32 { return p.optOffset + 64 }
+
+// DataDirectoryOffset returns the offset of the data directory
+// entry or 0 if the entry is not available.
+func (p *Image) DataDirectoryOffset(idx int) uint32 {
+	if uint32(idx) >= p.dataDirNum {
+		return 0
+	}
+	return p.dataDirOffset + uint32(idx)*8
+}
+
+// GetSections returns the headers of the sections.
+func (p *Image) GetSections() []SectionHeader {
+	ans := []SectionHeader{}
+	for i := 0; i < int(p.sectionsNum); i++ {
+		h := p.sectionHeader(i)
+		ans = append(ans, SectionHeader{
+			Name:             p.GetSectionName(i),
+			VirtualSize:      le.Uint32(h[8:]),
+			VirtualAddress:   le.Uint32(h[12:]),
+			SizeOfRawData:    le.Uint32(h[16:]),
+			PointerToRawData: le.Uint32(h[20:]),
+		})
+	}
+	return ans
+}
+
+// GetSectionName returns the name of the section with the index in input.
+func (p *Image) GetSectionName(idx int) string {
+	name := p.sectionHeader(idx)[0:8]
+	for i, c := range name {
+		if c == 0 {
+			return string(name[:i])
+		}
+	}
+	return string(name)
+}
+
+// GetDataDirectory returns the address and the size of the data directory.
+func (p *Image) GetDataDirectory(idx int) (uint32, uint32) {
+	off := p.DataDirectoryOffset(idx)
+	if off == 0 {
+		return 0, 0
+	}
+	return le.Uint32(p.Data[off:]), le.Uint32(p.Data[off+4:])
+}
+
+// SetDataDirectory sets the address and the size of the data directory.
+func (p *Image) SetDataDirectory(idx int, addr, size uint32) {
+	off := p.DataDirectoryOffset(idx)
+	if off == 0 {
+		return
+	}
+	le.PutUint32(p.Data[off:], addr)
+	le.PutUint32(p.Data[off+4:], size)
+}
+
+// AddSections returns a new PE image with the sections in input
+// appended after the existing sections. The signature of the image
+// is removed because it's no more valid.
+func AddSections(image []byte, sections []Section) ([]byte, error) {
+	p, err := ParseImage(image)
+	if err != nil {
+		return nil, err
+	}
+
+	sectAlign := p.SectionAlignment()
+	fileAlign := p.FileAlignment()
+	names := make(map[string]bool)
+
+	var virtEnd, fileEnd uint32
+	firstRaw := uint32(len(image))
+	for _, s := range p.GetSections() {
+		names[s.Name] = true
+
+		vsize := s.VirtualSize
+		if vsize < s.SizeOfRawData {
+			vsize = s.SizeOfRawData
+		}
+		if end := align(s.VirtualAddress+vsize, sectAlign); end > virtEnd {
+			virtEnd = end
+		}
+		if s.SizeOfRawData > 0 {
+			if s.PointerToRawData+s.SizeOfRawData > fileEnd {
+				fileEnd = s.PointerToRawData + s.SizeOfRawData
+			}
+			if s.PointerToRawData < firstRaw {
+				firstRaw = s.PointerToRawData
+			}
+		}
+	}
+
+	if fileEnd > uint32(len(image)) {
+		return nil, errors.New("Invalid PE image: truncated sections data")
+	}
+
+	tableEnd := p.sectionsOffset + uint32(int(p.sectionsNum)+len(sections))*peSectionHeaderSize
+	if tableEnd > p.SizeOfHeaders() || tableEnd > firstRaw {
+		return nil, errors.New("No space available on PE headers for the new sections")
+	}
+
+	// The data after the sections (the signature) are dropped.
+	out := make([]byte, fileEnd, int(fileEnd)+len(sections)*int(fileAlign))
+	copy(out, image[:fileEnd])
+	p.Data = out
+	p.SetDataDirectory(SecurityDirectory, 0, 0)
+
+	vaddr := virtEnd
+	initData := le.Uint32(out[p.optOffset+8:])
+	for idx, s := range sections {
+		if len(s.Name) > 8 {
+			return nil, errors.New(fmt.Sprintf("Invalid section name %s", s.Name))
+		}
+		if names[s.Name] {
+			return nil, errors.New(fmt.Sprintf("Section %s already present", s.Name))
+		}
+		names[s.Name] = true
+
+		rawPtr := align(uint32(len(out)), fileAlign)
+		rawSize := align(uint32(len(s.Data)), fileAlign)
+
+		h := make([]byte, peSectionHeaderSize)
+		copy(h[0:8], s.Name)
+		le.PutUint32(h[8:], uint32(len(s.Data)))
+		le.PutUint32(h[12:], vaddr)
+		le.PutUint32(h[16:], rawSize)
+		le.PutUint32(h[20:], rawPtr)
+		le.PutUint32(h[36:], scnCntInitializedData|scnMemRead)
+		copy(out[p.sectionsOffset+uint32(int(p.sectionsNum)+idx)*peSectionHeaderSize:], h)
+
+		out = append(out, make([]byte, rawPtr-uint32(len(out)))...)
+		out = append(out, s.Data...)
+		out = append(out, make([]byte, rawPtr+rawSize-uint32(len(out)))...)
+		p.Data = out
+
+		vaddr = align(vaddr+uint32(len(s.Data)), sectAlign)
+		initData += rawSize
+	}
+
+	le.PutUint16(out[p.optOffset-20+2:], p.sectionsNum+uint16(len(sections)))
+	// SizeOfInitializedData, SizeOfImage and CheckSum
+	le.PutUint32(out[p.optOffset+8:], initData)
+	le.PutUint32(out[p.optOffset+56:], vaddr)
+	le.PutUint32(out[p.optOffset+64:], 0)
+
+	return out, nil
+}
@@ -0,0 +1,161 @@
+// Copyright © 2021 Daniele Rondina, geaaru@sabayonlinux.org
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package pkgmanager
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	. "github.com/MocaccinoOS/mos-cli/pkg/logger"
+	"github.com/MocaccinoOS/mos-cli/pkg/utils"
+)
+
+// LuetManager executes the luet binary. The changes of the packages
+// are interactive: luet asks for the confirmation.
+type LuetManager struct {
+	Binary string
+	// System root of the packages. Default is the --root option.
+	RootDir string
+}
+
+func NewLuetManager() *LuetManager {
+	return &LuetManager{
+		Binary:  "luet",
+		RootDir: utils.GetRootDir(),
+	}
+}
+
+func (l *LuetManager) GetName() string { return LuetManagerName }
+
+func (l *LuetManager) getArgs(args []string) []string {
+	if l.RootDir != "" && l.RootDir != "/" {
+		args = append([]string{args[0], "--system-target", l.RootDir}, args[1:]...)
+	}
+	return args
+}
+
+// output executes luet and returns the stdout. The stderr is
+// returned on the error.
+func (l *LuetManager) output(args ...string) ([]byte, error) {
+	args = l.getArgs(args)
+	DebugC("Running", l.Binary, strings.Join(args, " "))
+
+	var stdout, stderr bytes.Buffer
+	command := exec.Command(l.Binary, args...)
+	command.Stdout = &stdout
+	command.Stderr = &stderr
+
+	err := command.Run()
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = strings.TrimSpace(stdout.String())
+		}
+		if msg == "" {
+			msg = err.Error()
+		}
+		return nil, errors.New(
+			fmt.Sprintf("Error on run %s %s: %s", l.Binary, args[0], msg))
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// run executes luet attached to the terminal.
+func (l *LuetManager) run(args ...string) error {
+	args = l.getArgs(args)
+	DebugC("Running", l.Binary, strings.Join(args, " "))
+
+	command := exec.Command(l.Binary, args...)
+	command.Stdin = os.Stdin
+	command.Stdout = os.Stdout
+	command.Stderr = os.Stderr
+
+	err := command.Run()
+	if err != nil {
+		return errors.New(
+			fmt.Sprintf("Error on run %s %s: %s", l.Binary, args[0], err.Error()))
+	}
+
+	return nil
+}
+
+func (l *LuetManager) search(args ...string) (*SearchResult, error) {
+	out, err := l.output(append([]string{"search"}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	ans := &SearchResult{Packages: []Package{}}
+	if len(bytes.TrimSpace(out)) == 0 {
+		return ans, nil
+	}
+
+	err = json.Unmarshal(out, ans)
+	if err != nil {
+		return nil, errors.New(
+			fmt.Sprintf("Error on parse %s search output: %s", l.Binary, err.Error()))
+	}
+
+	return ans, nil
+}
+
+func (l *LuetManager) Search(pattern string) (*SearchResult, error) {
+	return l.search(pattern, "--output", "json")
+}
+
+func (l *LuetManager) Installed(pattern string) (*SearchResult, error) {
+	return l.search("--installed", pattern, "--output", "json")
+}
+
+func (l *LuetManager) Install(packages []string) error {
+	return l.run(append([]string{"install"}, packages...)...)
+}
+
+func (l *LuetManager) Uninstall(packages []string) error {
+	return l.run(append([]string{"uninstall"}, packages...)...)
+}
+
+func (l *LuetManager) Replace(remove, install []string) error {
+	args := []string{"replace", "--nodeps"}
+	for _, p := range install {
+		args = append(args, "--for", p)
+	}
+	return l.run(append(args, remove...)...)
+}
+
+// Repositories returns the enabled repositories.
+func (l *LuetManager) Repositories() ([]*Repository, error) {
+	out, err := l.output("repo", "list", "--enabled", "--quiet")
+	if err != nil {
+		return nil, err
+	}
+
+	ans := []*Repository{}
+	for _, line := range strings.Split(string(out), "\n") {
+		name := strings.TrimSpace(line)
+		if name != "" {
+			ans = append(ans, &Repository{Name: name, Enabled: true})
+		}
+	}
+
+	return ans, nil
+}
@@ -0,0 +1,183 @@
+// Copyright © 2021 Daniele Rondina, geaaru@sabayonlinux.org
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package pkgmanager
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newTestLuet returns a luet manager that runs a shell script with
+// the body in input instead of the luet binary. The arguments of the
+// script are written on the args file.
+func newTestLuet(t *testing.T, body string) (*LuetManager, string) {
+	dir, err := ioutil.TempDir("", "mos-luet")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	binary := filepath.Join(dir, "luet")
+	args := filepath.Join(dir, "args")
+	script := "#!/bin/sh\necho \"$@\" > " + args + "\n" + body + "\n"
+	if err := ioutil.WriteFile(binary, []byte(script), 0755); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	return &LuetManager{Binary: binary}, dir
+}
+
+func TestLuetSearch(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    []Package
+		wantErr string
+	}{
+		{
+			name: "packages",
+			body: `echo '{"packages":[{"name":"mocaccino-full","category":"kernel","version":"5.10.42"}]}'`,
+			want: []Package{{Name: "mocaccino-full", Category: "kernel", Version: "5.10.42"}},
+		},
+		{
+			name: "empty output",
+			body: "exit 0",
+			want: []Package{},
+		},
+		{
+			name:    "invalid json",
+			body:    "echo 'Searching packages...'",
+			wantErr: "search output",
+		},
+		{
+			name:    "error on stderr",
+			body:    "echo 'some output'; echo 'repository not available' >&2; exit 1",
+			wantErr: "search: repository not available",
+		},
+		{
+			name:    "error on stdout",
+			body:    "echo 'database locked'; exit 1",
+			wantErr: "search: database locked",
+		},
+		{
+			name:    "error without output",
+			body:    "exit 2",
+			wantErr: "search: exit status 2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, dir := newTestLuet(t, tt.body)
+			defer os.RemoveAll(dir)
+
+			res, err := l.Search("kernel")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(res.Packages, tt.want) {
+				t.Errorf("got %v, want %v", res.Packages, tt.want)
+			}
+		})
+	}
+}
+
+func TestLuetArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		rootDir string
+		run     func(l *LuetManager) error
+		want    string
+	}{
+		{
+			name: "installed",
+			run: func(l *LuetManager) error {
+				_, err := l.Installed("kernel")
+				return err
+			},
+			want: "search --installed kernel --output json",
+		},
+		{
+			name:    "installed with root",
+			rootDir: "/mnt/root",
+			run: func(l *LuetManager) error {
+				_, err := l.Installed("kernel")
+				return err
+			},
+			want: "search --system-target /mnt/root --installed kernel --output json",
+		},
+		{
+			name:    "replace",
+			rootDir: "/",
+			run: func(l *LuetManager) error {
+				return l.Replace([]string{"kernel/a", "kernel/b"}, []string{"kernel/c"})
+			},
+			want: "replace --nodeps --for kernel/c kernel/a kernel/b",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, dir := newTestLuet(t, "exit 0")
+			defer os.RemoveAll(dir)
+			l.RootDir = tt.rootDir
+
+			if err := tt.run(l); err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := ioutil.ReadFile(filepath.Join(dir, "args"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.TrimSpace(string(data)); got != tt.want {
+				t.Errorf("got args %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLuetRepositories(t *testing.T) {
+	l, dir := newTestLuet(t, "printf 'mocaccino-repository-index\\n\\nmocaccino-desktop-stable\\n'")
+	defer os.RemoveAll(dir)
+
+	repos, err := l.Repositories()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{}
+	for _, r := range repos {
+		if !r.Enabled {
+			t.Errorf("repository %s not enabled", r.Name)
+		}
+		names = append(names, r.Name)
+	}
+	want := []string{"mocaccino-repository-index", "mocaccino-desktop-stable"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("got %v, want %v", names, want)
+	}
+}
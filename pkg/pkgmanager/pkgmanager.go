@@ -0,0 +1,125 @@
+// Copyright © 2021 Daniele Rondina, geaaru@sabayonlinux.org
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package pkgmanager
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	LuetManagerName = "luet"
+
+	DefaultManagerName = LuetManagerName
+)
+
+// PackageManager is the interface implemented by the package
+// managers used to install and replace the kernel packages.
+type PackageManager interface {
+	GetName() string
+
+	// Search returns the available packages that match the pattern.
+	Search(pattern string) (*SearchResult, error)
+	// Installed returns the installed packages that match the pattern.
+	Installed(pattern string) (*SearchResult, error)
+
+	// Install, Uninstall and Replace receive the packages in the
+	// category/name format.
+	Install(packages []string) error
+	Uninstall(packages []string) error
+	// Replace removes the packages of remove and installs the
+	// packages of install in the same transaction.
+	Replace(remove, install []string) error
+
+	Repositories() ([]*Repository, error)
+}
+
+type SearchResult struct {
+	Packages []Package `json:"packages"`
+}
+
+type Package struct {
+	Name       string `json:"name"`
+	Category   string `json:"category"`
+	Version    string `json:"version"`
+	Repository string `json:"repository,omitempty"`
+}
+
+type Repository struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Enabled     bool   `json:"enabled"`
+}
+
+// NewPackageManager returns the package manager with the name in
+// input.
+func NewPackageManager(name string) (PackageManager, error) {
+	switch name {
+	case "", LuetManagerName:
+		return NewLuetManager(), nil
+	default:
+		return nil, errors.New(
+			fmt.Sprintf("Unsupported package manager %s", name))
+	}
+}
+
+func (p Package) String() string {
+	return fmt.Sprintf("%s/%s", p.Category, p.Name)
+}
+
+func (p Package) Equal(pp Package) bool {
+	if p.Name == pp.Name && p.Category == pp.Category && p.Version == pp.Version {
+		return true
+	}
+	return false
+}
+
+func (p Package) EqualS(s string) bool {
+	if s == fmt.Sprintf("%s/%s", p.Category, p.Name) {
+		return true
+	}
+	return false
+}
+
+func (p Package) EqualNoV(pp Package) bool {
+	if p.Name == pp.Name && p.Category == pp.Category {
+		return true
+	}
+	return false
+}
+
+func (s SearchResult) FilterByCategory(cat string) SearchResult {
+	new := SearchResult{Packages: []Package{}}
+
+	for _, r := range s.Packages {
+		if r.Category == cat {
+			new.Packages = append(new.Packages, r)
+		}
+	}
+	return new
+}
+
+func (s SearchResult) FilterByName(name string) SearchResult {
+	new := SearchResult{Packages: []Package{}}
+
+	for _, r := range s.Packages {
+		if !strings.Contains(r.Name, name) {
+			new.Packages = append(new.Packages, r)
+		}
+	}
+	return new
+}
@@ -0,0 +1,168 @@
+// Copyright © 2021 Daniele Rondina, geaaru@sabayonlinux.org
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+// Package pkgmanagertest provides an in-memory package manager for
+// the tests of the packages that use a pkgmanager.PackageManager.
+package pkgmanagertest
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/MocaccinoOS/mos-cli/pkg/pkgmanager"
+)
+
+const FakeManagerName = "fake"
+
+// FakeManager is an in-memory package manager. The changes are
+// applied only in memory and they are stored on Actions.
+type FakeManager struct {
+	Available []pkgmanager.Package
+	Packages  []pkgmanager.Package
+	Repos     []*pkgmanager.Repository
+
+	Actions []string
+}
+
+func NewFakeManager() *FakeManager {
+	return &FakeManager{
+		Available: []pkgmanager.Package{},
+		Packages:  []pkgmanager.Package{},
+		Repos:     []*pkgmanager.Repository{},
+		Actions:   []string{},
+	}
+}
+
+func (f *FakeManager) GetName() string { return FakeManagerName }
+
+// filter returns the packages with category/name that match the
+// pattern like luet search.
+func filter(packages []pkgmanager.Package, pattern string) (*pkgmanager.SearchResult, error) {
+	r, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	ans := &pkgmanager.SearchResult{Packages: []pkgmanager.Package{}}
+	for _, p := range packages {
+		if r.MatchString(p.String()) {
+			ans.Packages = append(ans.Packages, p)
+		}
+	}
+
+	return ans, nil
+}
+
+func findPackage(packages []pkgmanager.Package, name string) int {
+	for idx, p := range packages {
+		if p.EqualS(name) {
+			return idx
+		}
+	}
+	return -1
+}
+
+// checkDuplicates returns an error if a package is present
+// multiple times.
+func checkDuplicates(packages []string) error {
+	names := make(map[string]bool)
+	for _, name := range packages {
+		if names[name] {
+			return errors.New(fmt.Sprintf("Package %s defined multiple times", name))
+		}
+		names[name] = true
+	}
+	return nil
+}
+
+func (f *FakeManager) Search(pattern string) (*pkgmanager.SearchResult, error) {
+	return filter(f.Available, pattern)
+}
+
+func (f *FakeManager) Installed(pattern string) (*pkgmanager.SearchResult, error) {
+	return filter(f.Packages, pattern)
+}
+
+func (f *FakeManager) Install(packages []string) error {
+	if err := checkDuplicates(packages); err != nil {
+		return err
+	}
+
+	for _, name := range packages {
+		idx := findPackage(f.Available, name)
+		if idx < 0 {
+			return errors.New(fmt.Sprintf("Package %s not found", name))
+		}
+		if findPackage(f.Packages, name) >= 0 {
+			return errors.New(fmt.Sprintf("Package %s already installed", name))
+		}
+	}
+
+	for _, name := range packages {
+		f.Packages = append(f.Packages, f.Available[findPackage(f.Available, name)])
+	}
+	f.Actions = append(f.Actions, "install "+strings.Join(packages, " "))
+
+	return nil
+}
+
+func (f *FakeManager) Uninstall(packages []string) error {
+	if err := checkDuplicates(packages); err != nil {
+		return err
+	}
+
+	for _, name := range packages {
+		if findPackage(f.Packages, name) < 0 {
+			return errors.New(fmt.Sprintf("Package %s not installed", name))
+		}
+	}
+
+	for _, name := range packages {
+		idx := findPackage(f.Packages, name)
+		f.Packages = append(f.Packages[:idx], f.Packages[idx+1:]...)
+	}
+	f.Actions = append(f.Actions, "uninstall "+strings.Join(packages, " "))
+
+	return nil
+}
+
+// Replace removes and installs the packages in the same transaction:
+// on failure the installed packages are restored.
+func (f *FakeManager) Replace(remove, install []string) error {
+	packages := append([]pkgmanager.Package{}, f.Packages...)
+	actions := len(f.Actions)
+
+	err := f.Uninstall(remove)
+	if err == nil {
+		err = f.Install(install)
+	}
+	if err != nil {
+		f.Packages = packages
+		f.Actions = f.Actions[:actions]
+		return err
+	}
+
+	// Uninstall and Install record the single actions.
+	f.Actions = append(f.Actions[:actions], fmt.Sprintf("replace %s with %s",
+		strings.Join(remove, " "), strings.Join(install, " ")))
+
+	return nil
+}
+
+func (f *FakeManager) Repositories() ([]*pkgmanager.Repository, error) {
+	return f.Repos, nil
+}
@@ -0,0 +1,166 @@
+// Copyright © 2021 Daniele Rondina, geaaru@sabayonlinux.org
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package pkgmanagertest
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/MocaccinoOS/mos-cli/pkg/pkgmanager"
+)
+
+func newTestManager() *FakeManager {
+	f := NewFakeManager()
+	f.Available = []pkgmanager.Package{
+		{Category: "kernel", Name: "mocaccino-full", Version: "5.10.42"},
+		{Category: "kernel", Name: "mocaccino-lts-full", Version: "5.4.120"},
+		{Category: "kernel", Name: "mocaccino-lts-modules", Version: "5.4.120"},
+	}
+	f.Packages = []pkgmanager.Package{
+		{Category: "kernel", Name: "mocaccino-full", Version: "5.10.42"},
+	}
+	return f
+}
+
+func TestFakeManager(t *testing.T) {
+	tests := []struct {
+		name     string
+		run      func(f *FakeManager) error
+		wantErr  bool
+		packages []string
+		actions  []string
+	}{
+		{
+			name: "install",
+			run: func(f *FakeManager) error {
+				return f.Install([]string{"kernel/mocaccino-lts-full"})
+			},
+			packages: []string{"kernel/mocaccino-full", "kernel/mocaccino-lts-full"},
+			actions:  []string{"install kernel/mocaccino-lts-full"},
+		},
+		{
+			name: "install duplicate",
+			run: func(f *FakeManager) error {
+				return f.Install([]string{"kernel/mocaccino-lts-full", "kernel/mocaccino-lts-full"})
+			},
+			wantErr:  true,
+			packages: []string{"kernel/mocaccino-full"},
+		},
+		{
+			name: "install already installed",
+			run: func(f *FakeManager) error {
+				return f.Install([]string{"kernel/mocaccino-full"})
+			},
+			wantErr:  true,
+			packages: []string{"kernel/mocaccino-full"},
+		},
+		{
+			name: "install not available",
+			run: func(f *FakeManager) error {
+				return f.Install([]string{"kernel/mocaccino-lts-full", "kernel/unknown"})
+			},
+			wantErr:  true,
+			packages: []string{"kernel/mocaccino-full"},
+		},
+		{
+			name: "uninstall duplicate",
+			run: func(f *FakeManager) error {
+				return f.Uninstall([]string{"kernel/mocaccino-full", "kernel/mocaccino-full"})
+			},
+			wantErr:  true,
+			packages: []string{"kernel/mocaccino-full"},
+		},
+		{
+			name: "uninstall not installed",
+			run: func(f *FakeManager) error {
+				return f.Uninstall([]string{"kernel/mocaccino-lts-full"})
+			},
+			wantErr:  true,
+			packages: []string{"kernel/mocaccino-full"},
+		},
+		{
+			name: "replace",
+			run: func(f *FakeManager) error {
+				return f.Replace([]string{"kernel/mocaccino-full"},
+					[]string{"kernel/mocaccino-lts-full", "kernel/mocaccino-lts-modules"})
+			},
+			packages: []string{"kernel/mocaccino-lts-full", "kernel/mocaccino-lts-modules"},
+			actions: []string{
+				"replace kernel/mocaccino-full with kernel/mocaccino-lts-full kernel/mocaccino-lts-modules",
+			},
+		},
+		{
+			name: "replace rollback",
+			run: func(f *FakeManager) error {
+				return f.Replace([]string{"kernel/mocaccino-full"},
+					[]string{"kernel/mocaccino-lts-full", "kernel/unknown"})
+			},
+			wantErr:  true,
+			packages: []string{"kernel/mocaccino-full"},
+		},
+		{
+			name: "replace rollback duplicate",
+			run: func(f *FakeManager) error {
+				return f.Replace([]string{"kernel/mocaccino-full"},
+					[]string{"kernel/mocaccino-lts-full", "kernel/mocaccino-lts-full"})
+			},
+			wantErr:  true,
+			packages: []string{"kernel/mocaccino-full"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newTestManager()
+			err := tt.run(f)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+
+			packages := []string{}
+			for _, p := range f.Packages {
+				packages = append(packages, p.String())
+			}
+			if !reflect.DeepEqual(packages, tt.packages) {
+				t.Errorf("got packages %v, want %v", packages, tt.packages)
+			}
+
+			actions := tt.actions
+			if actions == nil {
+				actions = []string{}
+			}
+			if !reflect.DeepEqual(f.Actions, actions) {
+				t.Errorf("got actions %v, want %v", f.Actions, actions)
+			}
+		})
+	}
+}
+
+func TestFakeManagerSearch(t *testing.T) {
+	f := newTestManager()
+
+	res, err := f.Search("lts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Packages) != 2 {
+		t.Errorf("got %d packages, want 2", len(res.Packages))
+	}
+
+	if _, err := f.Installed("["); err == nil {
+		t.Error("expected error on invalid pattern")
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright © 2021 Daniele Rondina, geaaru@sabayonlinux.org
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package profile
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"regexp"
+
+	kernelspecs "github.com/MocaccinoOS/mos-cli/pkg/kernel/specs"
+	. "github.com/MocaccinoOS/mos-cli/pkg/logger"
+)
+
+// GetDefaultKernelFamilies returns the families used when no family
+// is available on the families directory. They are the same families
+// of contrib/kernel-families.
+func GetDefaultKernelFamilies() []kernelspecs.KernelFamily {
+	return []kernelspecs.KernelFamily{
+		kernelspecs.KernelFamily{
+			Name:        "mocaccino",
+			Description: "MocaccinoOS kernel",
+			Package:     "kernel/mocaccino-full",
+			Modules:     "kernel/mocaccino-modules",
+			Initramfs:   "kernel/mocaccino-initramfs",
+			Sources:     "kernel/mocaccino-sources",
+			KernelType:  "vanilla",
+		},
+		kernelspecs.KernelFamily{
+			Name:        "mocaccino-lts",
+			Description: "MocaccinoOS LTS kernel",
+			Package:     "kernel/mocaccino-lts-full",
+			Modules:     "kernel/mocaccino-lts-modules",
+			Initramfs:   "kernel/mocaccino-lts-initramfs",
+			Sources:     "kernel/mocaccino-lts-sources",
+			KernelType:  "vanilla",
+		},
+	}
+}
+
+// LoadKernelFamilies reads the kernel families of the YAML files
+// of the directory. The invalid families are skipped.
+func LoadKernelFamilies(dir string) ([]kernelspecs.KernelFamily, error) {
+	ans := []kernelspecs.KernelFamily{}
+
+	var regexRepo = regexp.MustCompile(`.yml$|.yaml$`)
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return ans, err
+	}
+
+	for _, file := range files {
+		if file.IsDir() || !regexRepo.MatchString(file.Name()) {
+			continue
+		}
+
+		content, err := ioutil.ReadFile(path.Join(dir, file.Name()))
+		if err != nil {
+			Warning(fmt.Sprintf("Skipping kernel family %s: %s", file.Name(), err.Error()))
+			continue
+		}
+
+		family, err := kernelspecs.KernelFamilyFromYaml(content)
+		if err != nil {
+			Warning(fmt.Sprintf("Skipping kernel family %s: %s", file.Name(), err.Error()))
+			continue
+		}
+
+		if err := family.Validate(); err != nil {
+			Warning(fmt.Sprintf("Skipping kernel family %s: %s", file.Name(), err.Error()))
+			continue
+		}
+
+		ans = append(ans, *family)
+	}
+
+	return ans, nil
+}
@@ -0,0 +1,75 @@
+// Copyright © 2021 Daniele Rondina <geaaru@sabayonlinux.org>
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package profile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	kernelspecs "github.com/MocaccinoOS/mos-cli/pkg/kernel/specs"
+)
+
+func TestContribKernelFamilies(t *testing.T) {
+	families, err := LoadKernelFamilies(filepath.Join("..", "..", "contrib", "kernel-families"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defaults := GetDefaultKernelFamilies()
+	if len(families) != len(defaults) {
+		t.Fatalf("got %d families, want %d", len(families), len(defaults))
+	}
+	for idx := range defaults {
+		f := kernelspecs.GetKernelFamily(families, defaults[idx].Name)
+		if f == nil {
+			t.Errorf("family %s not found", defaults[idx].Name)
+			continue
+		}
+		if !reflect.DeepEqual(*f, defaults[idx]) {
+			t.Errorf("got family %+v, want %+v", *f, defaults[idx])
+		}
+	}
+}
+
+func TestLoadKernelFamiliesSkipInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mos-families")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"valid.yml":           "name: test\npackage: kernel/test-full\n",
+		"without-package.yml": "name: test\n",
+		"invalid.yaml":        "name: [test\n",
+		"readme.txt":          "name: readme\npackage: kernel/readme\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	families, err := LoadKernelFamilies(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(families) != 1 || families[0].Package != "kernel/test-full" {
+		t.Errorf("got families %+v, want only kernel/test-full", families)
+	}
+}
@@ -15,11 +15,13 @@
 package profile
 
 import (
+	"fmt"
 	"io/ioutil"
 	"path"
 	"regexp"
 
 	kernelspecs "github.com/MocaccinoOS/mos-cli/pkg/kernel/specs"
+	. "github.com/MocaccinoOS/mos-cli/pkg/logger"
 )
 
 func GetDefaultKernelProfiles() []kernelspecs.KernelType {
@@ -61,18 +63,35 @@ func LoadKernelProfiles(dir string) ([]kernelspecs.KernelType, error) {
 
 		content, err := ioutil.ReadFile(path.Join(dir, file.Name()))
 		if err != nil {
-			// TODO: integrate warning logger
+			Warning(fmt.Sprintf("Skipping kernel profile %s: %s", file.Name(), err.Error()))
 			continue
 		}
 
 		ktype, err := kernelspecs.KernelTypeFromYaml(content)
 		if err != nil {
+			Warning(fmt.Sprintf("Skipping kernel profile %s: %s", file.Name(), err.Error()))
 			continue
 		}
 
-		if ktype.GetType() != "" {
-			ans = append(ans, *ktype)
+		// Skip profiles with invalid filename patterns.
+		if err := ktype.Compile(); err != nil {
+			Warning(fmt.Sprintf("Skipping kernel profile %s: %s", file.Name(), err.Error()))
+			continue
+		}
+
+		// Skip profiles with invalid command line overrides.
+		if _, err := kernelspecs.ParseKernelCmdline(ktype.GetCmdline()); err != nil {
+			Warning(fmt.Sprintf("Skipping kernel profile %s: invalid cmdline: %s",
+				file.Name(), err.Error()))
+			continue
 		}
+
+		if ktype.GetType() == "" {
+			Warning(fmt.Sprintf("Skipping kernel profile %s: missing type", file.Name()))
+			continue
+		}
+
+		ans = append(ans, *ktype)
 	}
 
 	return ans, nil
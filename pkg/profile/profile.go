@@ -73,7 +73,8 @@ func (ph ProfileHandler) Activate(p Profile) error {
 	if _, err := os.Stat(ph.ActiveDirectory); err != nil {
 		os.MkdirAll(ph.ActiveDirectory, 600)
 	}
-	return os.Symlink(filepath.Join(ph.ProfileDirectory, filepath.Base(p.Path)), filepath.Join(ph.ActiveDirectory, filepath.Base(p.Path)))
+	// The link must be valid also with a system root.
+	return os.Symlink(utils.ChrootPath(filepath.Join(ph.ProfileDirectory, filepath.Base(p.Path))), filepath.Join(ph.ActiveDirectory, filepath.Base(p.Path)))
 }
 
 func (ph ProfileHandler) Deactivate(p Profile) error {
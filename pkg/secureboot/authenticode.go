@@ -0,0 +1,439 @@
+// Copyright © 2021 Daniele Rondina, geaaru@sabayonlinux.org
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package secureboot
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/MocaccinoOS/mos-cli/pkg/pe"
+)
+
+type SignatureStatus string
+
+const (
+	SignatureUnsigned SignatureStatus = "unsigned"
+	SignatureValid    SignatureStatus = "valid"
+	SignatureInvalid  SignatureStatus = "invalid"
+	// The signature is valid but the signer is not trusted.
+	SignatureUntrusted SignatureStatus = "untrusted"
+	// The file is not a PE image and it can't be signed.
+	SignatureUnsupported SignatureStatus = "unsupported"
+
+	winCertRevision           = 0x0200
+	winCertTypePKCSSignedData = 0x0002
+	winCertHeaderSize         = 8
+	certTableAlignment        = 8
+)
+
+var le = binary.LittleEndian
+
+// ErrImageSigned is returned by Sign for the images with signatures.
+var ErrImageSigned = errors.New("PE image already signed")
+
+func align8(v int) int {
+	return (v + certTableAlignment - 1) / certTableAlignment * certTableAlignment
+}
+
+// ImageHash returns the Authenticode SHA256 digest of the PE image.
+// The checksum, the certificate table entry and the certificate
+// table are excluded from the digest.
+func ImageHash(image []byte) ([]byte, error) {
+	p, err := pe.ParseImage(image)
+	if err != nil {
+		return nil, err
+	}
+
+	secDir := p.DataDirectoryOffset(pe.SecurityDirectory)
+	if secDir == 0 {
+		return nil, errors.New("PE image without certificate table entry")
+	}
+
+	checksum := p.ChecksumOffset()
+	headers := p.SizeOfHeaders()
+	if int(headers) > len(image) || secDir+8 > headers {
+		return nil, errors.New("Invalid PE image: truncated headers")
+	}
+
+	h := sha256.New()
+	h.Write(image[:checksum])
+	h.Write(image[checksum+4 : secDir])
+	h.Write(image[secDir+8 : headers])
+
+	sections := p.GetSections()
+	sort.Slice(sections, func(i, j int) bool {
+		return sections[i].PointerToRawData < sections[j].PointerToRawData
+	})
+
+	hashed := headers
+	for _, s := range sections {
+		if s.SizeOfRawData == 0 {
+			continue
+		}
+		end := s.PointerToRawData + s.SizeOfRawData
+		if int(end) > len(image) {
+			return nil, errors.New(
+				fmt.Sprintf("Invalid PE image: truncated section %s", s.Name))
+		}
+		h.Write(image[s.PointerToRawData:end])
+		if end > hashed {
+			hashed = end
+		}
+	}
+
+	// The data after the sections without the certificate table.
+	end := uint32(len(image))
+	if addr, size := p.GetDataDirectory(pe.SecurityDirectory); size > 0 && addr < end {
+		end = addr
+	}
+	if end > hashed {
+		h.Write(image[hashed:end])
+	}
+
+	return h.Sum(nil), nil
+}
+
+// GetSignatures returns the PKCS#7 signatures of the certificate
+// table of the PE image.
+func GetSignatures(image []byte) ([][]byte, error) {
+	p, err := pe.ParseImage(image)
+	if err != nil {
+		return nil, err
+	}
+
+	ans := [][]byte{}
+	addr, size := p.GetDataDirectory(pe.SecurityDirectory)
+	if size == 0 {
+		return ans, nil
+	}
+	if uint64(addr)+uint64(size) > uint64(len(image)) {
+		return nil, errors.New("Invalid PE image: truncated certificate table")
+	}
+
+	table := image[addr : addr+size]
+	for len(table) >= winCertHeaderSize {
+		length := int(le.Uint32(table[0:]))
+		certType := le.Uint16(table[6:])
+		if length < winCertHeaderSize || length > len(table) {
+			return nil, errors.New("Invalid certificate table entry")
+		}
+
+		if certType == winCertTypePKCSSignedData {
+			ans = append(ans, table[winCertHeaderSize:length])
+		}
+
+		next := align8(length)
+		if next >= len(table) {
+			break
+		}
+		table = table[next:]
+	}
+
+	return ans, nil
+}
+
+func newSignedData(digest []byte, kp *KeyPair) ([]byte, error) {
+	indirect, err := newSpcIndirectDataContent(digest)
+	if err != nil {
+		return nil, err
+	}
+
+	octets, err := contentOctets(indirect)
+	if err != nil {
+		return nil, err
+	}
+	md := sha256.Sum256(octets)
+
+	contentType, err := asn1.Marshal(oidSpcIndirectData)
+	if err != nil {
+		return nil, err
+	}
+	messageDigest, err := asn1.Marshal(md[:])
+	if err != nil {
+		return nil, err
+	}
+
+	attrs, err := marshalAttributes([]attribute{
+		{Type: oidContentType, Values: []asn1.RawValue{{FullBytes: contentType}}},
+		{Type: oidMessageDigest, Values: []asn1.RawValue{{FullBytes: messageDigest}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	signed, err := attributesSet(attrs)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.Sum256(signed)
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, kp.Key, crypto.SHA256, h[:])
+	if err != nil {
+		return nil, err
+	}
+
+	sd, err := asn1.Marshal(signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{sha256Algorithm()},
+		ContentInfo: contentInfo{
+			ContentType: oidSpcIndirectData,
+			Content:     explicitTag(0, indirect),
+		},
+		Certificates: explicitTag(0, kp.Cert.Raw),
+		SignerInfos: []signerInfo{
+			{
+				Version: 1,
+				IssuerAndSerialNumber: issuerAndSerial{
+					Issuer: asn1.RawValue{FullBytes: kp.Cert.RawIssuer},
+					Serial: kp.Cert.SerialNumber,
+				},
+				DigestAlgorithm:         sha256Algorithm(),
+				AuthenticatedAttributes: explicitTag(0, attrs),
+				DigestEncryptionAlgorithm: pkix.AlgorithmIdentifier{
+					Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue,
+				},
+				EncryptedDigest: signature,
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(contentInfo{
+		ContentType: oidSignedData,
+		Content:     explicitTag(0, sd),
+	})
+}
+
+// Sign returns the PE image signed with the key pair. The images
+// already signed (for example by the distribution) are refused with
+// ErrImageSigned: see Resign.
+func Sign(image []byte, kp *KeyPair) ([]byte, error) {
+	signatures, err := GetSignatures(image)
+	if err != nil {
+		return nil, err
+	}
+	if len(signatures) > 0 {
+		return nil, ErrImageSigned
+	}
+
+	return Resign(image, kp)
+}
+
+// Resign returns the PE image signed with the key pair. The existing
+// signatures are removed and replaced by the new signature.
+func Resign(image []byte, kp *KeyPair) ([]byte, error) {
+	p, err := pe.ParseImage(image)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.DataDirectoryOffset(pe.SecurityDirectory) == 0 {
+		return nil, errors.New("PE image without certificate table entry")
+	}
+
+	data := image
+	if addr, size := p.GetDataDirectory(pe.SecurityDirectory); size > 0 && int(addr) <= len(image) {
+		data = image[:addr]
+	}
+
+	// The certificate table must be aligned to 8 bytes. The padding
+	// is part of the image digest.
+	out := make([]byte, align8(len(data)))
+	copy(out, data)
+
+	p, err = pe.ParseImage(out)
+	if err != nil {
+		return nil, err
+	}
+	p.SetDataDirectory(pe.SecurityDirectory, 0, 0)
+
+	digest, err := ImageHash(out)
+	if err != nil {
+		return nil, err
+	}
+
+	p7, err := newSignedData(digest, kp)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error on create signature: %s", err.Error()))
+	}
+
+	length := winCertHeaderSize + len(p7)
+	entry := make([]byte, align8(length))
+	le.PutUint32(entry[0:], uint32(length))
+	le.PutUint16(entry[4:], winCertRevision)
+	le.PutUint16(entry[6:], winCertTypePKCSSignedData)
+	copy(entry[winCertHeaderSize:], p7)
+
+	offset := len(out)
+	out = append(out, entry...)
+	p.Data = out
+	p.SetDataDirectory(pe.SecurityDirectory, uint32(offset), uint32(len(entry)))
+
+	return out, nil
+}
+
+// verifySignedData checks the PKCS#7 signature of the image digest
+// and returns the certificate of the signer.
+func verifySignedData(p7, digest []byte) (*x509.Certificate, error) {
+	var ci contentInfo
+	if _, err := asn1.Unmarshal(p7, &ci); err != nil {
+		return nil, err
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		return nil, errors.New("Signature without PKCS#7 signed data")
+	}
+
+	var sd signedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		return nil, err
+	}
+	if !sd.ContentInfo.ContentType.Equal(oidSpcIndirectData) {
+		return nil, errors.New("Signature without Authenticode data")
+	}
+
+	indirectDer := sd.ContentInfo.Content.Bytes
+	var indirect spcIndirectDataContent
+	if _, err := asn1.Unmarshal(indirectDer, &indirect); err != nil {
+		return nil, err
+	}
+	if !indirect.MessageDigest.DigestAlgorithm.Algorithm.Equal(oidSHA256) {
+		return nil, errors.New("Unsupported digest algorithm")
+	}
+	if !bytes.Equal(indirect.MessageDigest.Digest, digest) {
+		return nil, errors.New("The image digest doesn't match")
+	}
+
+	if len(sd.SignerInfos) != 1 {
+		return nil, errors.New("Signature without a single signer")
+	}
+	si := sd.SignerInfos[0]
+
+	certs, err := x509.ParseCertificates(sd.Certificates.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	var signer *x509.Certificate
+	for _, c := range certs {
+		if bytes.Equal(c.RawIssuer, si.IssuerAndSerialNumber.Issuer.FullBytes) &&
+			c.SerialNumber.Cmp(si.IssuerAndSerialNumber.Serial) == 0 {
+			signer = c
+			break
+		}
+	}
+	if signer == nil {
+		return nil, errors.New("Certificate of the signer not found")
+	}
+
+	signed, err := attributesSet(si.AuthenticatedAttributes.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	var attrs []attribute
+	if _, err := asn1.UnmarshalWithParams(signed, &attrs, "set"); err != nil {
+		return nil, err
+	}
+
+	octets, err := contentOctets(indirectDer)
+	if err != nil {
+		return nil, err
+	}
+	md := sha256.Sum256(octets)
+
+	validDigest := false
+	for _, a := range attrs {
+		if a.Type.Equal(oidMessageDigest) && len(a.Values) == 1 {
+			var v []byte
+			if _, err := asn1.Unmarshal(a.Values[0].FullBytes, &v); err == nil {
+				validDigest = bytes.Equal(v, md[:])
+			}
+		}
+	}
+	if !validDigest {
+		return nil, errors.New("The message digest doesn't match")
+	}
+
+	err = signer.CheckSignature(x509.SHA256WithRSA, signed, si.EncryptedDigest)
+	if err != nil {
+		return nil, err
+	}
+
+	return signer, nil
+}
+
+func isTrusted(signer *x509.Certificate, trusted []*x509.Certificate) bool {
+	for _, t := range trusted {
+		if bytes.Equal(signer.Raw, t.Raw) || signer.CheckSignatureFrom(t) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify checks the signatures of the PE image. Without trusted
+// certificates only the integrity of the signatures is checked.
+// The error describes why the image is not valid.
+func Verify(image []byte, trusted []*x509.Certificate) (SignatureStatus, error) {
+	signatures, err := GetSignatures(image)
+	if err != nil {
+		if _, perr := pe.ParseImage(image); perr != nil {
+			return SignatureUnsupported, perr
+		}
+		return SignatureInvalid, err
+	}
+
+	if len(signatures) == 0 {
+		return SignatureUnsigned, nil
+	}
+
+	digest, err := ImageHash(image)
+	if err != nil {
+		return SignatureInvalid, err
+	}
+
+	signers := []*x509.Certificate{}
+	for _, s := range signatures {
+		signer, err := verifySignedData(s, digest)
+		if err != nil {
+			return SignatureInvalid, err
+		}
+		signers = append(signers, signer)
+	}
+
+	if len(trusted) == 0 {
+		return SignatureValid, nil
+	}
+
+	for _, s := range signers {
+		if isTrusted(s, trusted) {
+			return SignatureValid, nil
+		}
+	}
+
+	return SignatureUntrusted, errors.New(
+		fmt.Sprintf("Signed by %s that is not trusted", signers[0].Subject.String()))
+}
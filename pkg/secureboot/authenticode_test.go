@@ -0,0 +1,279 @@
+// Copyright © 2021 Daniele Rondina, geaaru@sabayonlinux.org
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package secureboot
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/MocaccinoOS/mos-cli/pkg/pe"
+	"github.com/MocaccinoOS/mos-cli/pkg/uki"
+)
+
+// newTestKeyPair returns a throwaway key with a certificate signed
+// by the parent key pair or self-signed if parent is nil.
+func newTestKeyPair(t *testing.T, name string, parent *KeyPair) *KeyPair {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+	}
+
+	parentCert, parentKey := tmpl, key
+	if parent != nil {
+		parentCert, parentKey = parent.Cert, parent.Key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parentCert, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kp, err := NewKeyPair(key, cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kp
+}
+
+// newTestImage returns a minimal PE32+ image with a .text section.
+func newTestImage() []byte {
+	const (
+		peOffset     = 0x40
+		optOffset    = peOffset + 24
+		optSize      = 112 + 16*8
+		sectsOffset  = optOffset + optSize
+		headersSize  = 0x400
+		textSize     = 0x200
+		sectionAlign = 0x1000
+	)
+
+	image := make([]byte, headersSize+textSize)
+	copy(image, "MZ")
+	le.PutUint32(image[0x3c:], peOffset)
+	copy(image[peOffset:], "PE\x00\x00")
+
+	// COFF header: machine x86_64, one section
+	le.PutUint16(image[peOffset+4:], 0x8664)
+	le.PutUint16(image[peOffset+6:], 1)
+	le.PutUint16(image[peOffset+20:], optSize)
+
+	le.PutUint16(image[optOffset:], 0x20b)
+	le.PutUint32(image[optOffset+16:], sectionAlign)
+	le.PutUint32(image[optOffset+32:], sectionAlign)
+	le.PutUint32(image[optOffset+36:], textSize)
+	le.PutUint32(image[optOffset+56:], 2*sectionAlign)
+	le.PutUint32(image[optOffset+60:], headersSize)
+	le.PutUint16(image[optOffset+68:], 10)
+	le.PutUint32(image[optOffset+108:], 16)
+
+	copy(image[sectsOffset:], ".text")
+	le.PutUint32(image[sectsOffset+8:], 16)
+	le.PutUint32(image[sectsOffset+12:], sectionAlign)
+	le.PutUint32(image[sectsOffset+16:], textSize)
+	le.PutUint32(image[sectsOffset+20:], headersSize)
+
+	copy(image[headersSize:], "\xeb\xfeminimal test image")
+
+	return image
+}
+
+func newTestUki(t *testing.T, dir string) []byte {
+	t.Helper()
+
+	files := map[string][]byte{
+		"stub":       newTestImage(),
+		"kernel":     bytes.Repeat([]byte("kernel"), 1000),
+		"initrd":     bytes.Repeat([]byte("initrd"), 500),
+		"os-release": []byte("NAME=\"MocaccinoOS\"\n"),
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b := uki.NewBuilder(filepath.Join(dir, "kernel"), filepath.Join(dir, "initrd"))
+	b.Stub = filepath.Join(dir, "stub")
+	b.OsRelease = filepath.Join(dir, "os-release")
+	b.Cmdline = "root=/dev/sda1 ro"
+
+	output := filepath.Join(dir, "linux.efi")
+	if err := b.Build(output); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// sectionOffset returns the offset of the data of the section.
+func sectionOffset(t *testing.T, image []byte, name string) int {
+	t.Helper()
+
+	p, err := pe.ParseImage(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range p.GetSections() {
+		if s.Name == name {
+			return int(s.PointerToRawData)
+		}
+	}
+	t.Fatalf("Section %s not found", name)
+	return 0
+}
+
+func checkVerify(t *testing.T, name string, image []byte, trusted []*x509.Certificate,
+	expected SignatureStatus) {
+	t.Helper()
+
+	status, err := Verify(image, trusted)
+	if status != expected {
+		t.Errorf("%s: expected status %s, got %s (%v)", name, expected, status, err)
+	}
+	if (expected == SignatureValid || expected == SignatureUnsigned) && err != nil {
+		t.Errorf("%s: unexpected error: %s", name, err)
+	}
+	if expected != SignatureValid && expected != SignatureUnsigned && err == nil {
+		t.Errorf("%s: expected error", name)
+	}
+}
+
+func TestSignVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mos-secureboot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db := newTestKeyPair(t, "Test db key", nil)
+	other := newTestKeyPair(t, "Other key", nil)
+	trusted := []*x509.Certificate{db.Cert}
+
+	images := []struct {
+		name    string
+		image   []byte
+		section string
+	}{
+		{"PE image", newTestImage(), ".text"},
+		{"UKI", newTestUki(t, dir), ".linux"},
+	}
+
+	for _, tt := range images {
+		checkVerify(t, tt.name+" unsigned", tt.image, trusted, SignatureUnsigned)
+
+		signed, err := Sign(tt.image, db)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+		if signatures, _ := GetSignatures(signed); len(signatures) != 1 {
+			t.Errorf("%s: expected one signature, got %d", tt.name, len(signatures))
+		}
+
+		checkVerify(t, tt.name+" signed", signed, trusted, SignatureValid)
+		checkVerify(t, tt.name+" signed without trusted certs", signed, nil, SignatureValid)
+		checkVerify(t, tt.name+" signed with untrusted cert", signed,
+			[]*x509.Certificate{other.Cert}, SignatureUntrusted)
+
+		// The digest covers the sections data.
+		tampered := append([]byte{}, signed...)
+		tampered[sectionOffset(t, tampered, tt.section)] ^= 0xff
+		checkVerify(t, tt.name+" tampered", tampered, trusted, SignatureInvalid)
+
+		// Signed images are not signed again.
+		if _, err := Sign(signed, other); err != ErrImageSigned {
+			t.Errorf("%s: expected ErrImageSigned, got %v", tt.name, err)
+		}
+
+		resigned, err := Resign(signed, other)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+		if signatures, _ := GetSignatures(resigned); len(signatures) != 1 {
+			t.Errorf("%s: expected one signature after resign, got %d", tt.name, len(signatures))
+		}
+		checkVerify(t, tt.name+" resigned", resigned,
+			[]*x509.Certificate{other.Cert}, SignatureValid)
+		checkVerify(t, tt.name+" resigned with the previous cert", resigned, trusted,
+			SignatureUntrusted)
+
+		// Signing is deterministic on the image digest.
+		digest1, _ := ImageHash(signed)
+		digest2, _ := ImageHash(resigned)
+		if !bytes.Equal(digest1, digest2) {
+			t.Errorf("%s: the image digest changes with the signature", tt.name)
+		}
+	}
+}
+
+func TestVerifyCertificateChain(t *testing.T) {
+	ca := newTestKeyPair(t, "Test CA", nil)
+	db := newTestKeyPair(t, "Test db key", ca)
+
+	signed, err := Sign(newTestImage(), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkVerify(t, "signed by the CA", signed, []*x509.Certificate{ca.Cert}, SignatureValid)
+}
+
+func TestVerifyUnsupported(t *testing.T) {
+	kp := newTestKeyPair(t, "Test db key", nil)
+
+	checkVerify(t, "not a PE image", []byte("not a PE image"), nil, SignatureUnsupported)
+
+	if _, err := Sign([]byte("not a PE image"), kp); err == nil {
+		t.Error("Expected error on sign a not PE image")
+	}
+}
+
+func TestNewKeyPairMismatch(t *testing.T) {
+	a := newTestKeyPair(t, "a", nil)
+	b := newTestKeyPair(t, "b", nil)
+
+	if _, err := NewKeyPair(a.Key, b.Cert); err == nil {
+		t.Error("Expected error for a certificate of another key")
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright © 2021 Daniele Rondina, geaaru@sabayonlinux.org
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package secureboot
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// KeyPair is the db key and certificate used to sign the images.
+type KeyPair struct {
+	Key  *rsa.PrivateKey
+	Cert *x509.Certificate
+}
+
+// NewKeyPair returns a key pair after checking that the
+// certificate matches with the key.
+func NewKeyPair(key *rsa.PrivateKey, cert *x509.Certificate) (*KeyPair, error) {
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok || pub.N.Cmp(key.N) != 0 || pub.E != key.E {
+		return nil, errors.New("The certificate doesn't match with the private key")
+	}
+
+	return &KeyPair{Key: key, Cert: cert}, nil
+}
+
+// LoadKeyPair reads the PEM files of the private key and
+// of the certificate.
+func LoadKeyPair(keyFile, certFile string) (*KeyPair, error) {
+	key, err := LoadPrivateKey(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := LoadCertificate(certFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewKeyPair(key, cert)
+}
+
+func readPEM(file, kind string) (*pem.Block, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New(fmt.Sprintf("No PEM %s found on file %s", kind, file))
+	}
+
+	return block, nil
+}
+
+// LoadPrivateKey reads a RSA private key in PKCS#1 or PKCS#8 format.
+// Secure Boot firmwares support only RSA keys.
+func LoadPrivateKey(file string) (*rsa.PrivateKey, error) {
+	block, err := readPEM(file, "private key")
+	if err != nil {
+		return nil, err
+	}
+
+	if block.Type == "RSA PRIVATE KEY" {
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.New(
+			fmt.Sprintf("Error on parse private key %s: %s", file, err.Error()))
+	}
+
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("Private key %s is not a RSA key", file))
+	}
+
+	return rsaKey, nil
+}
+
+// LoadCertificate reads a PEM certificate.
+func LoadCertificate(file string) (*x509.Certificate, error) {
+	block, err := readPEM(file, "certificate")
+	if err != nil {
+		return nil, err
+	}
+
+	return x509.ParseCertificate(block.Bytes)
+}
@@ -0,0 +1,200 @@
+// Copyright © 2021 Daniele Rondina, geaaru@sabayonlinux.org
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package secureboot
+
+import (
+	"bytes"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"sort"
+)
+
+// ASN.1 structures of the PKCS#7 SignedData with the Authenticode
+// SpcIndirectDataContent (RFC 2315 and the Microsoft Authenticode
+// PE signature format).
+
+var (
+	oidSignedData      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidSpcIndirectData = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 1, 4}
+	oidSpcPeImageData  = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 1, 15}
+	oidSHA256          = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidRSAEncryption   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidContentType     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidMessageDigest   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+)
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	// [0] EXPLICIT content. The Bytes contain the DER of the content.
+	Content asn1.RawValue `asn1:"optional,tag:0"`
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      contentInfo
+	// [0] IMPLICIT SET OF Certificate
+	Certificates asn1.RawValue `asn1:"optional,tag:0"`
+	SignerInfos  []signerInfo  `asn1:"set"`
+}
+
+type issuerAndSerial struct {
+	Issuer asn1.RawValue
+	Serial *big.Int
+}
+
+type signerInfo struct {
+	Version               int
+	IssuerAndSerialNumber issuerAndSerial
+	DigestAlgorithm       pkix.AlgorithmIdentifier
+	// [0] IMPLICIT SET OF Attribute
+	AuthenticatedAttributes   asn1.RawValue `asn1:"optional,tag:0"`
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+type spcAttributeTypeAndOptionalValue struct {
+	Type  asn1.ObjectIdentifier
+	Value asn1.RawValue
+}
+
+type digestInfo struct {
+	DigestAlgorithm pkix.AlgorithmIdentifier
+	Digest          []byte
+}
+
+type spcIndirectDataContent struct {
+	Data          spcAttributeTypeAndOptionalValue
+	MessageDigest digestInfo
+}
+
+func sha256Algorithm() pkix.AlgorithmIdentifier {
+	return pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue}
+}
+
+func explicitTag(tag int, content []byte) asn1.RawValue {
+	return asn1.RawValue{
+		Class:      asn1.ClassContextSpecific,
+		Tag:        tag,
+		IsCompound: true,
+		Bytes:      content,
+	}
+}
+
+// spcPeImageData returns the DER of the SpcPeImageData with the
+// obsolete file link used by all the signing tools.
+func spcPeImageData() ([]byte, error) {
+	obsolete := []byte{}
+	for _, c := range "<<<Obsolete>>>" {
+		obsolete = append(obsolete, 0, byte(c))
+	}
+
+	str, err := asn1.Marshal(asn1.RawValue{
+		Class: asn1.ClassContextSpecific, Tag: 0, Bytes: obsolete,
+	})
+	if err != nil {
+		return nil, err
+	}
+	link, err := asn1.Marshal(explicitTag(2, str))
+	if err != nil {
+		return nil, err
+	}
+	file, err := asn1.Marshal(explicitTag(0, link))
+	if err != nil {
+		return nil, err
+	}
+	flags, err := asn1.Marshal(asn1.BitString{})
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(asn1.RawValue{
+		Tag:        asn1.TagSequence,
+		IsCompound: true,
+		Bytes:      append(flags, file...),
+	})
+}
+
+// newSpcIndirectDataContent returns the DER of the
+// SpcIndirectDataContent with the image digest.
+func newSpcIndirectDataContent(digest []byte) ([]byte, error) {
+	peData, err := spcPeImageData()
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(spcIndirectDataContent{
+		Data: spcAttributeTypeAndOptionalValue{
+			Type:  oidSpcPeImageData,
+			Value: asn1.RawValue{FullBytes: peData},
+		},
+		MessageDigest: digestInfo{
+			DigestAlgorithm: sha256Algorithm(),
+			Digest:          digest,
+		},
+	})
+}
+
+// contentOctets returns the content of the DER value without
+// the tag and the length. It's the data hashed on the messageDigest
+// attribute of Authenticode.
+func contentOctets(der []byte) ([]byte, error) {
+	var v asn1.RawValue
+	rest, err := asn1.Unmarshal(der, &v)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, errors.New("Trailing data after ASN.1 value")
+	}
+	return v.Bytes, nil
+}
+
+// marshalAttributes returns the DER of the attributes sorted
+// as a SET OF.
+func marshalAttributes(attrs []attribute) ([]byte, error) {
+	encoded := [][]byte{}
+	for _, a := range attrs {
+		der, err := asn1.Marshal(a)
+		if err != nil {
+			return nil, err
+		}
+		encoded = append(encoded, der)
+	}
+
+	sort.Slice(encoded, func(i, j int) bool {
+		return bytes.Compare(encoded[i], encoded[j]) < 0
+	})
+
+	return bytes.Join(encoded, nil), nil
+}
+
+// attributesSet returns the DER of the attributes encoded as
+// SET OF. It's the data signed by the signer.
+func attributesSet(content []byte) ([]byte, error) {
+	return asn1.Marshal(asn1.RawValue{
+		Tag:        asn1.TagSet,
+		IsCompound: true,
+		Bytes:      content,
+	})
+}
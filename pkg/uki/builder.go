@@ -0,0 +1,144 @@
+// Copyright © 2021 Daniele Rondina, geaaru@sabayonlinux.org
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package uki
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	. "github.com/MocaccinoOS/mos-cli/pkg/logger"
+	"github.com/MocaccinoOS/mos-cli/pkg/pe"
+	"github.com/MocaccinoOS/mos-cli/pkg/utils"
+)
+
+const (
+	DefaultStubsDir      = "/usr/lib/systemd/boot/efi"
+	DefaultOsReleaseFile = "/etc/os-release"
+)
+
+// Builder assembles a Unified Kernel Image with the EFI stub.
+type Builder struct {
+	Stub      string
+	Kernel    string
+	Initrd    string
+	Cmdline   string
+	OsRelease string
+	Splash    string
+	Uname     string
+}
+
+// GetDefaultStub returns the systemd EFI stub of the running arch.
+func GetDefaultStub() string {
+	arch := runtime.GOARCH
+	switch arch {
+	case "amd64":
+		arch = "x64"
+	case "arm64":
+		arch = "aa64"
+	case "386":
+		arch = "ia32"
+	}
+	return utils.RootPath(filepath.Join(DefaultStubsDir, fmt.Sprintf("linux%s.efi.stub", arch)))
+}
+
+func NewBuilder(kernel, initrd string) *Builder {
+	return &Builder{
+		Stub:      GetDefaultStub(),
+		Kernel:    kernel,
+		Initrd:    initrd,
+		OsRelease: utils.RootPath(DefaultOsReleaseFile),
+	}
+}
+
+// GetSections returns the sections of the UKI. The kernel is
+// the last section because it's read by the stub directly.
+func (b *Builder) GetSections() ([]pe.Section, error) {
+	ans := []pe.Section{}
+
+	add := func(name, file string) error {
+		if file == "" {
+			return nil
+		}
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		ans = append(ans, pe.Section{Name: name, Data: data})
+		return nil
+	}
+
+	if err := add(".osrel", b.OsRelease); err != nil {
+		return nil, err
+	}
+	if b.Cmdline != "" {
+		ans = append(ans, pe.Section{Name: ".cmdline", Data: []byte(b.Cmdline + "\x00")})
+	}
+	if b.Uname != "" {
+		ans = append(ans, pe.Section{Name: ".uname", Data: []byte(b.Uname)})
+	}
+	if err := add(".splash", b.Splash); err != nil {
+		return nil, err
+	}
+	if err := add(".initrd", b.Initrd); err != nil {
+		return nil, err
+	}
+	if b.Kernel == "" {
+		return nil, errors.New("No kernel image defined")
+	}
+	if err := add(".linux", b.Kernel); err != nil {
+		return nil, err
+	}
+
+	return ans, nil
+}
+
+// Build writes the UKI on the output file. The file is written
+// on a temporary file and then renamed.
+func (b *Builder) Build(output string) error {
+	stub, err := ioutil.ReadFile(b.Stub)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error on read EFI stub: %s", err.Error()))
+	}
+
+	sections, err := b.GetSections()
+	if err != nil {
+		return err
+	}
+
+	data, err := pe.AddSections(stub, sections)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error on add sections to %s: %s", b.Stub, err.Error()))
+	}
+
+	err = os.MkdirAll(filepath.Dir(output), 0755)
+	if err != nil {
+		return err
+	}
+
+	DebugC("Writing UKI", output)
+	tmpFile := output + ".tmp"
+	err = ioutil.WriteFile(tmpFile, data, 0644)
+	if err != nil {
+		os.Remove(tmpFile)
+		return err
+	}
+
+	return os.Rename(tmpFile, output)
+}
@@ -0,0 +1,159 @@
+// Copyright © 2021 Daniele Rondina, geaaru@sabayonlinux.org
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package utils
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+const (
+	DefaultMountInfoFile = "/proc/self/mountinfo"
+	diskByUuidDir        = "/dev/disk/by-uuid"
+)
+
+// MountInfo is a mount of /proc/self/mountinfo.
+type MountInfo struct {
+	Device     string `json:"device"`
+	Root       string `json:"root"`
+	MountPoint string `json:"mount_point"`
+	FsType     string `json:"fstype"`
+	Source     string `json:"source"`
+}
+
+// unescapeMountPath replaces the octal escapes (\040, etc.) of
+// the paths of mountinfo.
+func unescapeMountPath(s string) string {
+	var ans strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) && isOctalEscape(s[i+1:i+4]) {
+			ans.WriteByte((s[i+1]-'0')<<6 | (s[i+2]-'0')<<3 | (s[i+3] - '0'))
+			i += 3
+			continue
+		}
+		ans.WriteByte(s[i])
+	}
+	return ans.String()
+}
+
+// isOctalEscape returns true for the three octal digits of a byte.
+func isOctalEscape(s string) bool {
+	return s[0] >= '0' && s[0] <= '3' &&
+		s[1] >= '0' && s[1] <= '7' &&
+		s[2] >= '0' && s[2] <= '7'
+}
+
+// ReadMountInfo parses the mountinfo file.
+func ReadMountInfo(file string) ([]*MountInfo, error) {
+	if file == "" {
+		file = DefaultMountInfoFile
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ans := []*MountInfo{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// 36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw
+		fields := strings.Fields(scanner.Text())
+		sep := -1
+		for idx, field := range fields {
+			if field == "-" {
+				sep = idx
+				break
+			}
+		}
+		if sep < 5 || len(fields) < sep+3 {
+			return nil, errors.New(fmt.Sprintf(
+				"Invalid line on file %s: %s", file, scanner.Text()))
+		}
+
+		ans = append(ans, &MountInfo{
+			Device:     fields[2],
+			Root:       unescapeMountPath(fields[3]),
+			MountPoint: unescapeMountPath(fields[4]),
+			FsType:     fields[sep+1],
+			Source:     unescapeMountPath(fields[sep+2]),
+		})
+	}
+
+	return ans, scanner.Err()
+}
+
+// GetMountForPath returns the mount that contains the path. The
+// last mount wins when a mount point is mounted multiple times.
+func GetMountForPath(mounts []*MountInfo, path string) *MountInfo {
+	var ans *MountInfo
+	path = filepath.Clean(path)
+
+	for _, m := range mounts {
+		rel, err := filepath.Rel(m.MountPoint, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+			continue
+		}
+		if ans == nil || len(m.MountPoint) >= len(ans.MountPoint) {
+			ans = m
+		}
+	}
+
+	return ans
+}
+
+// deviceNumber returns the major:minor of the block device.
+func deviceNumber(path string) string {
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return ""
+	}
+	rdev := uint64(st.Rdev)
+	major := ((rdev >> 8) & 0xfff) | ((rdev >> 32) & ^uint64(0xfff))
+	minor := (rdev & 0xff) | ((rdev >> 12) & ^uint64(0xff))
+	return fmt.Sprintf("%d:%d", major, minor)
+}
+
+// GetMountUuid returns the filesystem UUID of the mount searching
+// the device on /dev/disk/by-uuid.
+func GetMountUuid(m *MountInfo) (string, error) {
+	entries, err := ioutil.ReadDir(diskByUuidDir)
+	if err != nil {
+		return "", err
+	}
+
+	source, _ := filepath.EvalSymlinks(m.Source)
+	for _, e := range entries {
+		link := filepath.Join(diskByUuidDir, e.Name())
+		dev, err := filepath.EvalSymlinks(link)
+		if err != nil {
+			continue
+		}
+
+		if (source != "" && dev == source) || deviceNumber(dev) == m.Device {
+			return e.Name(), nil
+		}
+	}
+
+	return "", errors.New(fmt.Sprintf("No UUID found for device %s", m.Source))
+}
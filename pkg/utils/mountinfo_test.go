@@ -0,0 +1,132 @@
+// Copyright © 2021 Daniele Rondina, geaaru@sabayonlinux.org
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUnescapeMountPath(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"/boot", "/boot"},
+		{`/mnt/my\040disk`, "/mnt/my disk"},
+		{`/mnt/tab\011x`, "/mnt/tab\tx"},
+		{`/mnt/back\134slash`, `/mnt/back\slash`},
+		{`/mnt/new\012line\040`, "/mnt/new\nline "},
+		{`/mnt/a\04`, `/mnt/a\04`},
+		{`/mnt/a\04x`, `/mnt/a\04x`},
+		{`/mnt/a\999`, `/mnt/a\999`},
+		{`/mnt/a\400`, `/mnt/a\400`},
+		{`\`, `\`},
+	}
+
+	for _, tt := range tests {
+		if got := unescapeMountPath(tt.path); got != tt.expected {
+			t.Errorf("unescapeMountPath(%q): expected %q, got %q", tt.path, tt.expected, got)
+		}
+	}
+}
+
+func TestReadMountInfo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mos-mountinfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "mountinfo")
+	err = ioutil.WriteFile(file, []byte(
+		"22 1 259:2 / / rw,relatime shared:1 - ext4 /dev/nvme0n1p2 rw\n"+
+			"30 22 259:1 / /boot/efi rw,relatime shared:7 - vfat /dev/nvme0n1p1 rw,fmask=0022\n"+
+			"31 22 0:40 /@home /home\\040dir rw master:2 shared:8 - btrfs /dev/sda\\0401 rw\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mounts, err := ReadMountInfo(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []MountInfo{
+		{"259:2", "/", "/", "ext4", "/dev/nvme0n1p2"},
+		{"259:1", "/", "/boot/efi", "vfat", "/dev/nvme0n1p1"},
+		{"0:40", "/@home", "/home dir", "btrfs", "/dev/sda 1"},
+	}
+	if len(mounts) != len(expected) {
+		t.Fatalf("Expected %d mounts, got %d", len(expected), len(mounts))
+	}
+	for idx, m := range mounts {
+		if *m != expected[idx] {
+			t.Errorf("Mount %d: expected %+v, got %+v", idx, expected[idx], *m)
+		}
+	}
+
+	err = ioutil.WriteFile(file, []byte("22 1 259:2 / / rw,relatime ext4 /dev/sda1 rw\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadMountInfo(file); err == nil {
+		t.Error("Expected error for a line without separator")
+	}
+}
+
+func TestGetMountForPath(t *testing.T) {
+	mounts := []*MountInfo{
+		{Device: "1", MountPoint: "/"},
+		{Device: "2", MountPoint: "/boot"},
+		{Device: "3", MountPoint: "/boot/efi"},
+		{Device: "4", MountPoint: "/home dir"},
+		// /boot mounted again: the last mount wins.
+		{Device: "5", MountPoint: "/boot"},
+	}
+
+	tests := []struct {
+		path   string
+		device string
+	}{
+		{"/", "1"},
+		{"/usr/lib", "1"},
+		{"/boot", "5"},
+		{"/boot/", "5"},
+		{"/boot/grub/grub.cfg", "5"},
+		{"/bootx", "1"},
+		{"/boot/efi/EFI/Linux", "3"},
+		{"/boot/efi/../vmlinuz", "5"},
+		{"/home dir/user", "4"},
+		{"/home", "1"},
+	}
+
+	for _, tt := range tests {
+		m := GetMountForPath(mounts, tt.path)
+		if m == nil || m.Device != tt.device {
+			t.Errorf("%s: expected device %s, got %+v", tt.path, tt.device, m)
+		}
+	}
+
+	if m := GetMountForPath([]*MountInfo{{MountPoint: "/boot"}}, "/usr"); m != nil {
+		t.Errorf("Unexpected mount %+v", m)
+	}
+	if m := GetMountForPath([]*MountInfo{}, "/"); m != nil {
+		t.Errorf("Unexpected mount %+v", m)
+	}
+}
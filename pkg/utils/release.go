@@ -22,7 +22,7 @@ import (
 )
 
 func OsRelease() (string, error) {
-	mosReleaseFile := "/etc/mocaccino/release"
+	mosReleaseFile := RootPath("/etc/mocaccino/release")
 	release := ""
 
 	_, err := os.Stat(mosReleaseFile)
@@ -40,3 +40,25 @@ func OsRelease() (string, error) {
 
 	return release, nil
 }
+
+// OsPrettyName returns the PRETTY_NAME of /etc/os-release or
+// the NAME if PRETTY_NAME is not defined.
+func OsPrettyName() string {
+	content, err := ioutil.ReadFile(RootPath("/etc/os-release"))
+	if err != nil {
+		return ""
+	}
+
+	values := make(map[string]string)
+	for _, line := range strings.Split(string(content), "\n") {
+		kv := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(kv) == 2 {
+			values[kv[0]] = strings.Trim(kv[1], `"'`)
+		}
+	}
+
+	if values["PRETTY_NAME"] != "" {
+		return values["PRETTY_NAME"]
+	}
+	return values["NAME"]
+}
@@ -0,0 +1,83 @@
+// Copyright © 2021 Daniele Rondina, geaaru@sabayonlinux.org
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package utils
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// GetRootDir returns the system root set with the --root option.
+func GetRootDir() string {
+	root := viper.GetString("root")
+	if root == "" {
+		return "/"
+	}
+	return filepath.Clean(root)
+}
+
+// HasRootDir returns true if mos works on a system root different
+// from /.
+func HasRootDir() bool {
+	return GetRootDir() != "/"
+}
+
+// RootPath returns the path in input on the system root. The paths
+// already on the system root are not changed.
+func RootPath(path string) string {
+	root := GetRootDir()
+	if path == "" || root == "/" {
+		return path
+	}
+
+	path = filepath.Clean(path)
+	if path == root || strings.HasPrefix(path, root+"/") {
+		return path
+	}
+
+	return filepath.Join(root, path)
+}
+
+// ChrootPath returns the path seen by the commands executed on the
+// system root.
+func ChrootPath(path string) string {
+	root := GetRootDir()
+	if path == "" || root == "/" {
+		return path
+	}
+
+	path = filepath.Clean(path)
+	if path == root {
+		return "/"
+	}
+	if strings.HasPrefix(path, root+"/") {
+		return strings.TrimPrefix(path, root)
+	}
+
+	return path
+}
+
+// RootCommand returns the command executed with chroot on the
+// system root.
+func RootCommand(binary string, args ...string) *exec.Cmd {
+	if !HasRootDir() {
+		return exec.Command(binary, args...)
+	}
+	return exec.Command("chroot", append([]string{GetRootDir(), binary}, args...)...)
+}